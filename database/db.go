@@ -30,8 +30,11 @@ func initModels() error {
 		&model.User{},
 		&model.Inbound{},
 		&model.OutboundTraffics{},
+		&model.OutboundProtocolTraffics{},
 		&model.Setting{},
 		&model.InboundClientIps{},
+		&model.ClientTrafficSnapshot{},
+		&model.RoutingRule{},
 		&xray.ClientTraffic{},
 	}
 	for _, model := range models {
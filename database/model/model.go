@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"x-ui/util/json_util"
@@ -47,6 +48,8 @@ type Inbound struct {
 	Tag            string   `json:"tag" form:"tag" gorm:"unique"`
 	Sniffing       string   `json:"sniffing" form:"sniffing"`
 	Allocate       string   `json:"allocate" form:"allocate"`
+	// ConfigFragment holds extra keys merged into the settings, streamSettings, sniffing and allocate objects
+	ConfigFragment string `json:"configFragment" form:"configFragment"`
 }
 
 type OutboundTraffics struct {
@@ -57,17 +60,79 @@ type OutboundTraffics struct {
 	Total int64  `json:"total" form:"total" gorm:"default:0"`
 }
 
+type OutboundProtocolTraffics struct {
+	Id       int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag      string `json:"tag" form:"tag" gorm:"uniqueIndex:idx_outbound_tag_protocol"`
+	Protocol string `json:"protocol" form:"protocol" gorm:"uniqueIndex:idx_outbound_tag_protocol"`
+	Up       int64  `json:"up" form:"up" gorm:"default:0"`
+	Down     int64  `json:"down" form:"down" gorm:"default:0"`
+	Total    int64  `json:"total" form:"total" gorm:"default:0"`
+}
+
+type ClientTrafficSnapshot struct {
+	Id    int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email string `json:"email" gorm:"index"`
+	Up    int64  `json:"up"`
+	Down  int64  `json:"down"`
+	Time  int64  `json:"time" gorm:"index"`
+}
+
+// RoutingRule is a routing rule kept by the panel, list fields are comma-separated
+type RoutingRule struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Remark      string `json:"remark" form:"remark"`
+	Enable      bool   `json:"enable" form:"enable"`
+	Priority    int    `json:"priority" form:"priority"`
+	Domain      string `json:"domain" form:"domain"`
+	Ip          string `json:"ip" form:"ip"`
+	Port        string `json:"port" form:"port"`
+	Network     string `json:"network" form:"network"`
+	Protocol    string `json:"protocol" form:"protocol"`
+	InboundTag  string `json:"inboundTag" form:"inboundTag"`
+	OutboundTag string `json:"outboundTag" form:"outboundTag"`
+	BalancerTag string `json:"balancerTag" form:"balancerTag"`
+}
+
 type InboundClientIps struct {
 	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
 	ClientEmail string `json:"clientEmail" form:"clientEmail" gorm:"unique"`
 	Ips         string `json:"ips" form:"ips"`
 }
 
+// Sniffing is the sniffing block of an inbound. RouteOnly uses the sniffed domain for routing but
+// connects to the original address, DomainsExcluded are never sniffed.
+type Sniffing struct {
+	Enabled         bool     `json:"enabled"`
+	DestOverride    []string `json:"destOverride,omitempty"`
+	MetadataOnly    bool     `json:"metadataOnly,omitempty"`
+	RouteOnly       bool     `json:"routeOnly,omitempty"`
+	DomainsExcluded []string `json:"domainsExcluded,omitempty"`
+}
+
+// GetSniffing parses the sniffing settings, nil when the inbound has none
+func (i *Inbound) GetSniffing() (*Sniffing, error) {
+	if i.Sniffing == "" || i.Sniffing == "null" {
+		return nil, nil
+	}
+	sniffing := &Sniffing{}
+	if err := json.Unmarshal([]byte(i.Sniffing), sniffing); err != nil {
+		return nil, err
+	}
+	return sniffing, nil
+}
+
 func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	listen := i.Listen
 	if listen != "" {
 		listen = fmt.Sprintf("\"%v\"", listen)
 	}
+	// Only the known fields go to xray, a sniffing blob that does not parse is left as it is
+	sniffing := json_util.RawMessage(i.Sniffing)
+	if parsed, err := i.GetSniffing(); err == nil && parsed != nil {
+		if data, err := json.MarshalIndent(parsed, "", "  "); err == nil {
+			sniffing = data
+		}
+	}
 	return &xray.InboundConfig{
 		Listen:         json_util.RawMessage(listen),
 		Port:           i.Port,
@@ -75,7 +140,7 @@ func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 		Settings:       json_util.RawMessage(i.Settings),
 		StreamSettings: json_util.RawMessage(i.StreamSettings),
 		Tag:            i.Tag,
-		Sniffing:       json_util.RawMessage(i.Sniffing),
+		Sniffing:       sniffing,
 		Allocate:       json_util.RawMessage(i.Allocate),
 	}
 }
@@ -5,6 +5,8 @@ import (
 	"net"
 	"strings"
 
+	"x-ui/web/service"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,6 +18,7 @@ type SUBController struct {
 
 	subService     *SubService
 	subJsonService *SubJsonService
+	settingService service.SettingService
 }
 
 func NewSUBController(
@@ -81,7 +84,7 @@ func (a *SUBController) subs(c *gin.Context) {
 
 		// Add headers
 		c.Writer.Header().Set("Subscription-Userinfo", header)
-		c.Writer.Header().Set("Profile-Update-Interval", a.updateInterval)
+		c.Writer.Header().Set("Profile-Update-Interval", a.getUpdateInterval(subId))
 		c.Writer.Header().Set("Profile-Title", subId)
 
 		if a.subEncrypt {
@@ -115,13 +118,21 @@ func (a *SUBController) subJsons(c *gin.Context) {
 
 		// Add headers
 		c.Writer.Header().Set("Subscription-Userinfo", header)
-		c.Writer.Header().Set("Profile-Update-Interval", a.updateInterval)
+		c.Writer.Header().Set("Profile-Update-Interval", a.getUpdateInterval(subId))
 		c.Writer.Header().Set("Profile-Title", subId)
 
 		c.String(200, jsonSub)
 	}
 }
 
+func (a *SUBController) getUpdateInterval(subId string) string {
+	interval, err := a.settingService.GetSubUpdateInterval(subId)
+	if err != nil || interval == "" {
+		return a.updateInterval
+	}
+	return interval
+}
+
 func getHostFromXFH(s string) (string, error) {
 	if strings.Contains(s, ":") {
 		realHost, _, err := net.SplitHostPort(s)
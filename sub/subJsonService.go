@@ -95,6 +95,7 @@ func (s *SubJsonService) GetJson(subId string, host string) (string, string, err
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		s.SubService.enforceTls(inbound)
 
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
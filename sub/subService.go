@@ -69,6 +69,7 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, string, error
 				inbound.StreamSettings = streamSettings
 			}
 		}
+		s.enforceTls(inbound)
 		for _, client := range clients {
 			if client.Enable && client.SubID == subId {
 				link := s.getLink(inbound, client.Email)
@@ -153,6 +154,28 @@ func (s *SubService) getFallbackMaster(dest string, streamSettings string) (stri
 	return inbound.Listen, inbound.Port, string(modifiedStream), nil
 }
 
+// enforceTls applies the panel wide ALPN and fingerprint to the stream settings links are built from
+func (s *SubService) enforceTls(inbound *model.Inbound) {
+	enforcement, err := s.settingService.GetTlsEnforcement()
+	if err != nil {
+		logger.Warning("SubService - GetTlsEnforcement:", err)
+		return
+	}
+	if len(enforcement.Alpn) == 0 && enforcement.Fingerprint == "" {
+		return
+	}
+	var stream map[string]interface{}
+	if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+		return
+	}
+	enforcement.Apply(stream)
+	streamSettings, err := json.Marshal(stream)
+	if err != nil {
+		return
+	}
+	inbound.StreamSettings = string(streamSettings)
+}
+
 func (s *SubService) getLink(inbound *model.Inbound, email string) string {
 	switch inbound.Protocol {
 	case "vmess":
@@ -229,8 +252,8 @@ func (s *SubService) genVmessLink(inbound *model.Inbound, email string) string {
 			headers, _ := httpupgrade["headers"].(map[string]interface{})
 			obj["host"] = searchHost(headers)
 		}
-	case "splithttp":
-		splithttp, _ := stream["splithttpSettings"].(map[string]interface{})
+	case "splithttp", "xhttp":
+		splithttp, _ := stream[network+"Settings"].(map[string]interface{})
 		obj["path"] = splithttp["path"].(string)
 		if host, ok := splithttp["host"].(string); ok && len(host) > 0 {
 			obj["host"] = host
@@ -380,8 +403,8 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 			headers, _ := httpupgrade["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
-	case "splithttp":
-		splithttp, _ := stream["splithttpSettings"].(map[string]interface{})
+	case "splithttp", "xhttp":
+		splithttp, _ := stream[streamNetwork+"Settings"].(map[string]interface{})
 		params["path"] = splithttp["path"].(string)
 		if host, ok := splithttp["host"].(string); ok && len(host) > 0 {
 			params["host"] = host
@@ -389,6 +412,9 @@ func (s *SubService) genVlessLink(inbound *model.Inbound, email string) string {
 			headers, _ := splithttp["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
+		if mode, ok := splithttp["mode"].(string); ok && streamNetwork == "xhttp" {
+			params["mode"] = mode
+		}
 	}
 	security, _ := stream["security"].(string)
 	if security == "tls" {
@@ -608,8 +634,8 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 			headers, _ := httpupgrade["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
-	case "splithttp":
-		splithttp, _ := stream["splithttpSettings"].(map[string]interface{})
+	case "splithttp", "xhttp":
+		splithttp, _ := stream[streamNetwork+"Settings"].(map[string]interface{})
 		params["path"] = splithttp["path"].(string)
 		if host, ok := splithttp["host"].(string); ok && len(host) > 0 {
 			params["host"] = host
@@ -617,6 +643,9 @@ func (s *SubService) genTrojanLink(inbound *model.Inbound, email string) string
 			headers, _ := splithttp["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
+		if mode, ok := splithttp["mode"].(string); ok && streamNetwork == "xhttp" {
+			params["mode"] = mode
+		}
 	}
 	security, _ := stream["security"].(string)
 	if security == "tls" {
@@ -837,8 +866,8 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 			headers, _ := httpupgrade["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
-	case "splithttp":
-		splithttp, _ := stream["splithttpSettings"].(map[string]interface{})
+	case "splithttp", "xhttp":
+		splithttp, _ := stream[streamNetwork+"Settings"].(map[string]interface{})
 		params["path"] = splithttp["path"].(string)
 		if host, ok := splithttp["host"].(string); ok && len(host) > 0 {
 			params["host"] = host
@@ -846,6 +875,9 @@ func (s *SubService) genShadowsocksLink(inbound *model.Inbound, email string) st
 			headers, _ := splithttp["headers"].(map[string]interface{})
 			params["host"] = searchHost(headers)
 		}
+		if mode, ok := splithttp["mode"].(string); ok && streamNetwork == "xhttp" {
+			params["mode"] = mode
+		}
 	}
 
 	security, _ := stream["security"].(string)
@@ -1061,3 +1093,29 @@ func searchHost(headers interface{}) string {
 
 	return ""
 }
+
+func init() {
+	service.RegisterShareLinkBuilder(func(inbound *model.Inbound, email string, host string) string {
+		settingService := service.SettingService{}
+		showInfo, err := settingService.GetSubShowInfo()
+		if err != nil {
+			showInfo = false
+		}
+		remarkModel, err := settingService.GetRemarkModel()
+		if err != nil {
+			remarkModel = "-ieo"
+		}
+		s := NewSubService(showInfo, remarkModel)
+		s.address = host
+		if len(inbound.Listen) > 0 && inbound.Listen[0] == '@' {
+			listen, port, streamSettings, err := s.getFallbackMaster(inbound.Listen, inbound.StreamSettings)
+			if err == nil {
+				inbound.Listen = listen
+				inbound.Port = port
+				inbound.StreamSettings = streamSettings
+			}
+		}
+		s.enforceTls(inbound)
+		return s.getLink(inbound, email)
+	})
+}
@@ -4,6 +4,9 @@
 package sys
 
 import (
+	"errors"
+	"syscall"
+
 	"github.com/shirou/gopsutil/v4/net"
 )
 
@@ -22,3 +25,21 @@ func GetUDPCount() (int, error) {
 	}
 	return len(stats), nil
 }
+
+// RaiseOpenFilesLimit raises the soft open files limit to the hard one, child processes inherit it
+func RaiseOpenFilesLimit() error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return err
+	}
+	if limit.Cur >= limit.Max {
+		return nil
+	}
+	limit.Cur = limit.Max
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+}
+
+// ApplyCgroupLimits does nothing, cgroups are only available on Linux
+func ApplyCgroupLimits(name string, pid int, cpuPercent int, memoryBytes int64) error {
+	return errors.New("cgroups are only available on linux")
+}
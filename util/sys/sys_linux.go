@@ -5,11 +5,24 @@ package sys
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroup v2 quotas are in microseconds of CPU time per period
+const cgroupCPUPeriod = 100000
+
+// The leaf group the panel moves into, so the groups of its Xray processes can sit next to it
+const panelCgroup = "x-ui-panel"
+
 func getLinesNum(filename string) (int, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -70,3 +83,101 @@ func GetUDPCount() (int, error) {
 
 	return udp4 + udp6, nil
 }
+
+// RaiseOpenFilesLimit raises the soft open files limit to the hard one, child processes inherit it
+func RaiseOpenFilesLimit() error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return err
+	}
+	if limit.Cur >= limit.Max {
+		return nil
+	}
+	limit.Cur = limit.Max
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+}
+
+// ApplyCgroupLimits moves the process into the cgroup v2 group x-ui-<name> and caps it there, the
+// Xray processes pass "xray" and "xray-<instance>" for x-ui-xray and x-ui-xray-<instance>.
+// cpuPercent is a share of one core and memoryBytes the hard memory limit, 0 lifts a cap. The group
+// is created in the cgroup of the panel, so it stays within the limits of the panel service. A group
+// that enables controllers for its children can not hold processes itself, so the panel moves into
+// the x-ui-panel group next to it.
+func ApplyCgroupLimits(name string, pid int, cpuPercent int, memoryBytes int64) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return errors.New("cgroup v2 is not mounted")
+	}
+	parent, err := panelCgroupParent()
+	if err != nil {
+		return err
+	}
+	// The root group is exempt, it may hold processes next to groups with controllers
+	if parent != cgroupRoot {
+		if err := moveCgroupProcs(parent, filepath.Join(parent, panelCgroup)); err != nil {
+			return fmt.Errorf("move the panel into %s: %w", panelCgroup, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0o644); err != nil {
+		return fmt.Errorf("enable the cpu and memory controllers in %s: %w", parent, err)
+	}
+	dir := filepath.Join(parent, "x-ui-"+name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	cpuMax := fmt.Sprintf("max %d", cgroupCPUPeriod)
+	if cpuPercent > 0 {
+		cpuMax = fmt.Sprintf("%d %d", cpuPercent*cgroupCPUPeriod/100, cgroupCPUPeriod)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(cpuMax), 0o644); err != nil {
+		return fmt.Errorf("set cpu.max: %w", err)
+	}
+	memoryMax := "max"
+	if memoryBytes > 0 {
+		memoryMax = strconv.FormatInt(memoryBytes, 10)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(memoryMax), 0o644); err != nil {
+		return fmt.Errorf("set memory.max: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0o644)
+}
+
+// panelCgroupParent returns the directory of the cgroup the panel was started in, the parent of the
+// x-ui-panel group once the panel moved there
+func panelCgroupParent() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// The cgroup v2 line is 0::<path>
+		path, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		dir := filepath.Join(cgroupRoot, path)
+		if filepath.Base(dir) == panelCgroup {
+			dir = filepath.Dir(dir)
+		}
+		return dir, nil
+	}
+	return "", errors.New("the panel is not in a cgroup v2 group")
+}
+
+// moveCgroupProcs moves every process of the group from into the group to, created when missing
+func moveCgroupProcs(from string, to string) error {
+	data, err := os.ReadFile(filepath.Join(from, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		err := os.WriteFile(filepath.Join(to, "cgroup.procs"), []byte(pid), 0o644)
+		// A process that exited meanwhile has nothing to move
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
@@ -28,3 +28,13 @@ func GetTCPCount() (int, error) {
 func GetUDPCount() (int, error) {
 	return GetConnectionCount("udp")
 }
+
+// RaiseOpenFilesLimit does nothing, Windows has no open files rlimit
+func RaiseOpenFilesLimit() error {
+	return nil
+}
+
+// ApplyCgroupLimits does nothing, cgroups are only available on Linux
+func ApplyCgroupLimits(name string, pid int, cpuPercent int, memoryBytes int64) error {
+	return errors.New("cgroups are only available on linux")
+}
@@ -43,6 +43,16 @@ class AllSetting {
         this.subJsonRules = "";
 
         this.timeLocation = "Asia/Tehran";
+        this.warpMtu = 0;
+        this.warpWorkers = 0;
+        this.geoUpdateRuntime = "";
+        this.geoipUrl = "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat";
+        this.geositeUrl = "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat";
+        this.geoChecksum = true;
+        this.inboundAccessLog = false;
+        this.xrayCpuLimit = 0;
+        this.xrayMemoryLimit = 0;
+        this.raiseOpenFiles = false;
 
         if (data == null) {
             return
@@ -534,7 +534,8 @@ class SplitHTTPStreamSettings extends XrayCommonClass {
             maxConnections: 0,
             cMaxReuseTimes: 0,
             cMaxLifetimeMs: 0
-        }
+        },
+        mode = "auto",
     ) {
         super();
         this.path = path;
@@ -546,6 +547,7 @@ class SplitHTTPStreamSettings extends XrayCommonClass {
         this.noSSEHeader = noSSEHeader;
         this.xPaddingBytes = xPaddingBytes;
         this.xmux = xmux;   
+        this.mode = mode;
     }
 
     addHeader(name, value) {
@@ -567,6 +569,7 @@ class SplitHTTPStreamSettings extends XrayCommonClass {
             json.noSSEHeader,
             json.xPaddingBytes,
             json.xmux,
+            json.mode,
         );
     }
 
@@ -585,7 +588,8 @@ class SplitHTTPStreamSettings extends XrayCommonClass {
                 maxConnections: this.xmux.maxConnections,
                 cMaxReuseTimes: this.xmux.cMaxReuseTimes,
                 cMaxLifetimeMs: this.xmux.cMaxLifetimeMs
-            }
+            },
+            mode: this.mode,
         };
     }
 }
@@ -1156,7 +1160,7 @@ class StreamSettings extends XrayCommonClass {
             HttpStreamSettings.fromJson(json.httpSettings),
             GrpcStreamSettings.fromJson(json.grpcSettings),
             HTTPUpgradeStreamSettings.fromJson(json.httpupgradeSettings),
-            SplitHTTPStreamSettings.fromJson(json.splithttpSettings),
+            SplitHTTPStreamSettings.fromJson(json.splithttpSettings || json.xhttpSettings),
             SockoptStreamSettings.fromJson(json.sockopt),
         );
     }
@@ -1177,6 +1181,7 @@ class StreamSettings extends XrayCommonClass {
             grpcSettings: network === 'grpc' ? this.grpc.toJson() : undefined,
             httpupgradeSettings: network === 'httpupgrade' ? this.httpupgrade.toJson() : undefined,
             splithttpSettings: network === 'splithttp' ? this.splithttp.toJson() : undefined,
+            xhttpSettings: network === 'xhttp' ? this.splithttp.toJson() : undefined,
             sockopt: this.sockopt != undefined ? this.sockopt.toJson() : undefined,
         };
     }
@@ -1187,12 +1192,14 @@ class Sniffing extends XrayCommonClass {
         enabled = true,
         destOverride = ['http', 'tls', 'quic', 'fakedns'],
         metadataOnly = false,
-        routeOnly = false) {
+        routeOnly = false,
+        domainsExcluded = []) {
         super();
         this.enabled = enabled;
         this.destOverride = destOverride;
         this.metadataOnly = metadataOnly;
         this.routeOnly = routeOnly;
+        this.domainsExcluded = domainsExcluded;
     }
 
     static fromJson(json = {}) {
@@ -1207,8 +1214,19 @@ class Sniffing extends XrayCommonClass {
             destOverride,
             json.metadataOnly,
             json.routeOnly,
+            json.domainsExcluded || [],
         );
     }
+
+    toJson() {
+        return {
+            enabled: this.enabled,
+            destOverride: this.destOverride,
+            metadataOnly: this.metadataOnly,
+            routeOnly: this.routeOnly,
+            domainsExcluded: ObjectUtil.isArrEmpty(this.domainsExcluded) ? undefined : this.domainsExcluded,
+        };
+    }
 }
 
 class Allocate extends XrayCommonClass {
@@ -1325,8 +1343,9 @@ class Inbound extends XrayCommonClass {
         return this.network === "httpupgrade";
     }
 
+    // xhttp is what newer cores call splithttp, both keep their settings in stream.splithttp
     get isSplithttp() {
-        return this.network === "splithttp";
+        return ["splithttp", "xhttp"].includes(this.network);
     }
 
     // Shadowsocks
@@ -1410,7 +1429,7 @@ class Inbound extends XrayCommonClass {
 
     canEnableTls() {
         if (![Protocols.VMESS, Protocols.VLESS, Protocols.TROJAN, Protocols.SHADOWSOCKS].includes(this.protocol)) return false;
-        return ["tcp", "ws", "http", "grpc", "httpupgrade", "splithttp"].includes(this.network);
+        return ["tcp", "ws", "http", "grpc", "httpupgrade", "splithttp", "xhttp"].includes(this.network);
     }
 
     //this is used for xtls-rprx-vision
@@ -1423,7 +1442,7 @@ class Inbound extends XrayCommonClass {
 
     canEnableReality() {
         if (![Protocols.VLESS, Protocols.TROJAN].includes(this.protocol)) return false;
-        return ["tcp", "http", "grpc"].includes(this.network);
+        return ["tcp", "http", "grpc", "xhttp"].includes(this.network);
     }
 
     canEnableXtls() {
@@ -1494,7 +1513,7 @@ class Inbound extends XrayCommonClass {
             const httpupgrade = this.stream.httpupgrade;
             obj.path = httpupgrade.path;
             obj.host = httpupgrade.host?.length > 0 ? httpupgrade.host : this.getHeader(httpupgrade, 'host');
-        } else if (network === 'splithttp') {
+        } else if (network === 'splithttp' || network === 'xhttp') {
             const splithttp = this.stream.splithttp;
             obj.path = splithttp.path;
             obj.host = splithttp.host?.length > 0 ? splithttp.host : this.getHeader(splithttp, 'host');
@@ -1567,9 +1586,13 @@ class Inbound extends XrayCommonClass {
                 params.set("host", httpupgrade.host?.length > 0 ? httpupgrade.host : this.getHeader(httpupgrade, 'host'));
                 break;
             case "splithttp":
+            case "xhttp":
                 const splithttp = this.stream.splithttp;
                 params.set("path", splithttp.path);
                 params.set("host", splithttp.host?.length > 0 ? splithttp.host : this.getHeader(splithttp, 'host'));
+                if (type === "xhttp") {
+                    params.set("mode", splithttp.mode);
+                }
                 break;
         }
 
@@ -1682,9 +1705,13 @@ class Inbound extends XrayCommonClass {
                 params.set("host", httpupgrade.host?.length > 0 ? httpupgrade.host : this.getHeader(httpupgrade, 'host'));
                 break;
             case "splithttp":
+            case "xhttp":
                 const splithttp = this.stream.splithttp;
                 params.set("path", splithttp.path);
                 params.set("host", splithttp.host?.length > 0 ? splithttp.host : this.getHeader(splithttp, 'host'));
+                if (type === "xhttp") {
+                    params.set("mode", splithttp.mode);
+                }
                 break;
         }
 
@@ -1764,9 +1791,13 @@ class Inbound extends XrayCommonClass {
                 params.set("host", httpupgrade.host?.length > 0 ? httpupgrade.host : this.getHeader(httpupgrade, 'host'));
                 break;
             case "splithttp":
+            case "xhttp":
                 const splithttp = this.stream.splithttp;
                 params.set("path", splithttp.path);
                 params.set("host", splithttp.host?.length > 0 ? splithttp.host : this.getHeader(splithttp, 'host'));
+                if (type === "xhttp") {
+                    params.set("mode", splithttp.mode);
+                }
                 break;
         }
 
@@ -9,6 +9,7 @@ import (
 type APIController struct {
 	BaseController
 	inboundController *InboundController
+	routingController *RoutingController
 	Tgbot             service.Tgbot
 }
 
@@ -19,6 +20,8 @@ func NewAPIController(g *gin.RouterGroup) *APIController {
 }
 
 func (a *APIController) initRouter(g *gin.RouterGroup) {
+	a.routingController = NewRoutingController(g.Group("/panel/api", a.checkLogin))
+
 	g = g.Group("/panel/api/inbounds")
 	g.Use(a.checkLogin)
 
@@ -47,6 +50,7 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 		{"POST", "/resetAllClientTraffics/:id", a.inboundController.resetAllClientTraffics},
 		{"POST", "/delDepletedClients/:id", a.inboundController.delDepletedClients},
 		{"POST", "/onlines", a.inboundController.onlines},
+		{"POST", "/onlineIps", a.inboundController.onlineIps},
 	}
 
 	for _, route := range inboundRoutes {
@@ -3,7 +3,11 @@ package controller
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"x-ui/database/model"
 	"x-ui/web/service"
@@ -40,7 +44,28 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
 	g.POST("/import", a.importInbound)
+	g.POST("/importXrayConfig", a.importXrayConfig)
 	g.POST("/onlines", a.onlines)
+	g.POST("/onlineIps", a.onlineIps)
+	g.GET("/:id/qr/:email", a.getClientQR)
+	g.POST("/:id/rotateRealityKeys", a.rotateRealityKeys)
+	g.POST("/:id/validateReality", a.validateReality)
+	g.GET("/realityHealth", a.getRealityHealth)
+	g.POST("/clientTimeWindows/:email", a.setClientTimeWindows)
+	g.GET("/effectiveClientConfig/:email", a.getEffectiveClientConfig)
+	g.POST("/:id/configFragment", a.setInboundFragment)
+	g.POST("/:id/accessLogs", a.getInboundAccessLogs)
+	g.POST("/clientTrafficHistory/:email", a.getClientTrafficHistory)
+	g.GET("/clientGroups", a.getClientGroups)
+	g.POST("/clientGroups/:name/:action", a.clientGroupAction)
+	g.POST("/deviceLimit/:email", a.setClientDeviceLimit)
+	g.GET("/deviceLimitViolations", a.getDeviceLimitViolations)
+	g.GET("/bandwidthLimits", a.getBandwidthLimits)
+	g.POST("/bandwidthLimit/client/:email", a.setClientBandwidthLimit)
+	g.POST("/bandwidthLimit/inbound/:tag", a.setInboundBandwidthLimit)
+	g.GET("/bandwidthPauses", a.getBandwidthPauses)
+	g.POST("/billingSummary", a.getBillingSummary)
+	g.POST("/:id/importClients", a.importClients)
 }
 
 func (a *InboundController) getInbounds(c *gin.Context) {
@@ -310,6 +335,11 @@ func (a *InboundController) importInbound(c *gin.Context) {
 	}
 }
 
+func (a *InboundController) importXrayConfig(c *gin.Context) {
+	result, err := a.xrayService.ImportXrayConfig(c.PostForm("config"))
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.create"), result, err)
+}
+
 func (a *InboundController) delDepletedClients(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -327,3 +357,232 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 func (a *InboundController) onlines(c *gin.Context) {
 	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
 }
+
+func (a *InboundController) onlineIps(c *gin.Context) {
+	onlineClients, err := a.xrayService.GetOnlineClients()
+	jsonObj(c, onlineClients, err)
+}
+
+func (a *InboundController) getClientQR(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	png, err := a.xrayService.GenerateClientQR(id, c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+func (a *InboundController) rotateRealityKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	graceFor, err := time.ParseDuration(c.DefaultPostForm("graceFor", "0s"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	keys, err := a.xrayService.RotateRealityKeys(id, graceFor)
+	jsonObj(c, keys, err)
+}
+
+func (a *InboundController) setClientTimeWindows(c *gin.Context) {
+	email := c.Param("email")
+	var windows []service.ClientTimeWindow
+	err := json.Unmarshal([]byte(c.DefaultPostForm("windows", "[]")), &windows)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	err = a.xrayService.SetClientTimeWindows(email, windows)
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) getEffectiveClientConfig(c *gin.Context) {
+	config, err := a.xrayService.EffectiveClientConfig(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, config, nil)
+}
+
+func (a *InboundController) setInboundFragment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+		return
+	}
+	err = a.inboundService.SetInboundFragment(id, c.PostForm("fragment"))
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) getInboundAccessLogs(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.accessLog"), err)
+		return
+	}
+	count, _ := strconv.Atoi(c.DefaultPostForm("count", "100"))
+	inbound, err := a.inboundService.GetInbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.accessLog"), err)
+		return
+	}
+	lines, err := a.xrayService.GetInboundAccessLogs(inbound.Tag, count)
+	jsonObj(c, lines, err)
+}
+
+func (a *InboundController) getClientTrafficHistory(c *gin.Context) {
+	now := time.Now()
+	from, err := strconv.ParseInt(c.DefaultPostForm("from", strconv.FormatInt(now.Add(-24*time.Hour).UnixMilli(), 10)), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	to, err := strconv.ParseInt(c.DefaultPostForm("to", strconv.FormatInt(now.UnixMilli(), 10)), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	points, err := a.xrayService.GetClientTrafficHistory(c.Param("email"), time.UnixMilli(from), time.UnixMilli(to))
+	jsonObj(c, points, err)
+}
+
+func (a *InboundController) validateReality(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	check, err := a.xrayService.ValidateReality(id)
+	jsonObj(c, check, err)
+}
+
+func (a *InboundController) getRealityHealth(c *gin.Context) {
+	jsonObj(c, a.xrayService.GetRealityHealth(), nil)
+}
+
+func (a *InboundController) getClientGroups(c *gin.Context) {
+	groups, err := a.xrayService.GetClientGroups()
+	jsonObj(c, groups, err)
+}
+
+func (a *InboundController) clientGroupAction(c *gin.Context) {
+	name := c.Param("name")
+	var err error
+	switch c.Param("action") {
+	case "set":
+		var members []string
+		err = json.Unmarshal([]byte(c.DefaultPostForm("members", "[]")), &members)
+		if err == nil {
+			err = a.xrayService.SetClientGroup(name, members)
+		}
+	case "del":
+		err = a.xrayService.RemoveClientGroup(name)
+	case "enable":
+		err = a.xrayService.SetClientGroupEnable(name, true)
+	case "disable":
+		err = a.xrayService.SetClientGroupEnable(name, false)
+	case "outbound":
+		err = a.xrayService.SetClientGroupOutbound(name, c.PostForm("outboundTag"))
+	case "resetTraffic":
+		err = a.xrayService.ResetClientGroupTraffic(name)
+	default:
+		err = fmt.Errorf("unknown client group action %s", c.Param("action"))
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) setClientDeviceLimit(c *gin.Context) {
+	limit, err := strconv.Atoi(c.PostForm("limit"))
+	if err == nil {
+		err = a.xrayService.SetClientDeviceLimit(c.Param("email"), limit)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) getDeviceLimitViolations(c *gin.Context) {
+	jsonObj(c, a.xrayService.GetDeviceLimitViolations(), nil)
+}
+
+func (a *InboundController) getBandwidthLimits(c *gin.Context) {
+	limits, err := a.xrayService.GetBandwidthLimits()
+	jsonObj(c, limits, err)
+}
+
+// parseBandwidthLimit reads the rate and the optional burst of the form, both in KB
+func parseBandwidthLimit(c *gin.Context) (service.BandwidthLimit, error) {
+	var limit service.BandwidthLimit
+	rate, err := strconv.ParseInt(c.PostForm("rate"), 10, 64)
+	if err != nil {
+		return limit, err
+	}
+	limit.Rate = rate
+	if burst := c.PostForm("burst"); burst != "" {
+		limit.Burst, err = strconv.ParseInt(burst, 10, 64)
+	}
+	return limit, err
+}
+
+func (a *InboundController) setClientBandwidthLimit(c *gin.Context) {
+	limit, err := parseBandwidthLimit(c)
+	if err == nil {
+		err = a.xrayService.SetClientBandwidthLimit(c.Param("email"), limit)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) setInboundBandwidthLimit(c *gin.Context) {
+	limit, err := parseBandwidthLimit(c)
+	if err == nil {
+		err = a.xrayService.SetInboundBandwidthLimit(c.Param("tag"), limit)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+}
+
+func (a *InboundController) getBandwidthPauses(c *gin.Context) {
+	jsonObj(c, a.xrayService.GetBandwidthPauses(), nil)
+}
+
+func (a *InboundController) getBillingSummary(c *gin.Context) {
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	from, err := strconv.ParseInt(c.DefaultPostForm("from", strconv.FormatInt(monthStart.UnixMilli(), 10)), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	to, err := strconv.ParseInt(c.DefaultPostForm("to", strconv.FormatInt(now.UnixMilli(), 10)), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	billings, err := a.xrayService.BillingSummary(time.UnixMilli(from), time.UnixMilli(to))
+	jsonObj(c, billings, err)
+}
+
+// importClients takes the CSV as an uploaded file or as the csv form value
+func (a *InboundController) importClients(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.update"), err)
+		return
+	}
+	var data io.Reader = strings.NewReader(c.PostForm("csv"))
+	if file, _, err := c.Request.FormFile("csv"); err == nil {
+		defer file.Close()
+		data = file
+	}
+	result, err := a.xrayService.ImportClients(id, data)
+	jsonObj(c, result, err)
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"strconv"
+
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoutingController struct {
+	routingService service.RoutingService
+	xrayService    service.XrayService
+}
+
+func NewRoutingController(g *gin.RouterGroup) *RoutingController {
+	a := &RoutingController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *RoutingController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/routing")
+
+	g.GET("/list", a.getRules)
+	g.GET("/get/:id", a.getRule)
+	g.POST("/add", a.addRule)
+	g.POST("/update/:id", a.updateRule)
+	g.POST("/del/:id", a.delRule)
+}
+
+func (a *RoutingController) getRules(c *gin.Context) {
+	rules, err := a.routingService.GetRules()
+	jsonObj(c, rules, err)
+}
+
+func (a *RoutingController) getRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.edit"), err)
+		return
+	}
+	rule, err := a.routingService.GetRule(id)
+	jsonObj(c, rule, err)
+}
+
+func (a *RoutingController) addRule(c *gin.Context) {
+	rule := &model.RoutingRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.add"), err)
+		return
+	}
+	rule, err = a.routingService.AddRule(rule)
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.add"), rule, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *RoutingController) updateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.edit"), err)
+		return
+	}
+	rule := &model.RoutingRule{}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.edit"), err)
+		return
+	}
+	rule.Id = id
+	rule, err = a.routingService.UpdateRule(rule)
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.edit"), rule, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *RoutingController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "delete"), err)
+		return
+	}
+	err = a.routingService.DelRule(id)
+	jsonMsgObj(c, I18nWeb(c, "delete"), id, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
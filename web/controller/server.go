@@ -43,7 +43,9 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 	g.POST("/getXrayVersion", a.getXrayVersion)
 	g.POST("/stopXrayService", a.stopXrayService)
 	g.POST("/restartXrayService", a.restartXrayService)
+	g.POST("/getInstalledXrayVersions", a.getInstalledXrayVersions)
 	g.POST("/installXray/:version", a.installXray)
+	g.POST("/updateGeofiles", a.updateGeofiles)
 	g.POST("/logs/:count", a.getLogs)
 	g.POST("/getConfigJson", a.getConfigJson)
 	g.GET("/getDb", a.getDb)
@@ -92,12 +94,22 @@ func (a *ServerController) getXrayVersion(c *gin.Context) {
 	jsonObj(c, versions, nil)
 }
 
+func (a *ServerController) getInstalledXrayVersions(c *gin.Context) {
+	versions, err := a.serverService.GetInstalledXrayVersions()
+	jsonObj(c, versions, err)
+}
+
 func (a *ServerController) installXray(c *gin.Context) {
 	version := c.Param("version")
 	err := a.serverService.UpdateXray(version)
 	jsonMsg(c, I18nWeb(c, "install")+" xray", err)
 }
 
+func (a *ServerController) updateGeofiles(c *gin.Context) {
+	results, err := a.serverService.UpdateGeoFiles()
+	jsonMsgObj(c, I18nWeb(c, "pages.index.geofilesUpdate"), results, err)
+}
+
 func (a *ServerController) stopXrayService(c *gin.Context) {
 	a.lastGetStatusTime = time.Now()
 	err := a.serverService.StopXrayService()
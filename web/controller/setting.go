@@ -22,6 +22,11 @@ type updateSecretForm struct {
 	LoginSecret string `json:"loginSecret" form:"loginSecret"`
 }
 
+type subUpdateIntervalForm struct {
+	SubId string `json:"subId" form:"subId"`
+	Hours int    `json:"hours" form:"hours"`
+}
+
 type SettingController struct {
 	settingService service.SettingService
 	userService    service.UserService
@@ -45,6 +50,7 @@ func (a *SettingController) initRouter(g *gin.RouterGroup) {
 	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
 	g.POST("/updateUserSecret", a.updateSecret)
 	g.POST("/getUserSecret", a.getUserSecret)
+	g.POST("/subUpdateInterval", a.setSubUpdateInterval)
 }
 
 func (a *SettingController) getAllSetting(c *gin.Context) {
@@ -137,3 +143,14 @@ func (a *SettingController) getDefaultXrayConfig(c *gin.Context) {
 	}
 	jsonObj(c, defaultJsonConfig, nil)
 }
+
+func (a *SettingController) setSubUpdateInterval(c *gin.Context) {
+	form := &subUpdateIntervalForm{}
+	err := c.ShouldBind(form)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+		return
+	}
+	err = a.settingService.SetSubUpdateInterval(form.SubId, form.Hours)
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
@@ -1,6 +1,10 @@
 package controller
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
 	"x-ui/web/service"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +17,7 @@ type XraySettingController struct {
 	OutboundService    service.OutboundService
 	XrayService        service.XrayService
 	WarpService        service.WarpService
+	DnsService         service.DnsService
 }
 
 func NewXraySettingController(g *gin.RouterGroup) *XraySettingController {
@@ -30,7 +35,77 @@ func (a *XraySettingController) initRouter(g *gin.RouterGroup) {
 	g.GET("/getDefaultJsonConfig", a.getDefaultXrayConfig)
 	g.POST("/warp/:action", a.warp)
 	g.GET("/getOutboundsTraffic", a.getOutboundsTraffic)
+	g.GET("/getOutboundsTrafficByProtocol", a.getOutboundsTrafficByProtocol)
 	g.POST("/resetOutboundsTraffic", a.resetOutboundsTraffic)
+	g.GET("/restartSchedule", a.getRestartSchedule)
+	g.POST("/restartSchedule", a.setRestartSchedule)
+	g.GET("/getStatsAPIWarnings", a.getStatsAPIWarnings)
+	g.GET("/configLock", a.getConfigLock)
+	g.POST("/lockConfig", a.lockConfig)
+	g.POST("/unlockConfig", a.unlockConfig)
+	g.GET("/configStats", a.getConfigStats)
+	g.POST("/tlsEnforcement", a.setTlsEnforcement)
+	g.GET("/deprecatedFields", a.getDeprecatedFields)
+	g.GET("/generationTiming", a.getGenerationTiming)
+	g.GET("/blockRules", a.getBlockRules)
+	g.POST("/blockRules/add", a.addBlockRule)
+	g.POST("/blockRules/del", a.delBlockRule)
+	g.GET("/verifyStability", a.verifyStability)
+	g.POST("/outboundTrafficWebhook", a.setOutboundTrafficWebhook)
+	g.POST("/configPatch", a.setConfigPatch)
+	g.GET("/outboundHealth", a.getOutboundHealth)
+	g.POST("/maxClientsPerInbound", a.setMaxClientsPerInbound)
+	g.GET("/cappedClients", a.getCappedClients)
+	g.GET("/pendingTraffic", a.getPendingTraffic)
+	g.GET("/securityAudit", a.getSecurityAudit)
+	g.GET("/optimizationFootprint", a.getOptimizationFootprint)
+	g.POST("/testOutbound", a.testOutbound)
+	g.GET("/balancers", a.getBalancers)
+	g.POST("/balancers/set", a.setBalancer)
+	g.POST("/balancers/del", a.delBalancer)
+	g.GET("/observatory", a.getObservatory)
+	g.POST("/observatory/set", a.setObservatory)
+	g.GET("/reverse", a.getReverseProxies)
+	g.POST("/reverse/set", a.setReverseProxy)
+	g.POST("/reverse/del", a.delReverseProxy)
+	g.GET("/outboundChains", a.getOutboundChains)
+	g.POST("/outboundChains/set", a.setOutboundChain)
+	g.POST("/outboundChains/del", a.delOutboundChain)
+	g.GET("/freedom", a.getFreedomSettings)
+	g.POST("/freedom/set", a.setFreedomSettings)
+	g.GET("/configDiff", a.getConfigDiff)
+	g.GET("/dns", a.getDnsSettings)
+	g.POST("/dns/set", a.setDnsSettings)
+	g.GET("/configForVersion", a.getConfigForVersion)
+	g.GET("/resourceStatus", a.getResourceStatus)
+	g.GET("/listenPorts", a.getListenPorts)
+	g.POST("/maintenanceMode", a.setMaintenanceMode)
+	g.GET("/trafficConsistency", a.getTrafficConsistency)
+	g.GET("/inboundPolicies", a.getInboundPolicies)
+	g.POST("/inboundPolicies/set", a.setInboundPolicy)
+	g.POST("/inboundPolicies/del", a.delInboundPolicy)
+	g.GET("/policy", a.getPolicySettings)
+	g.POST("/policy/set", a.setPolicySettings)
+	g.POST("/isolated/start", a.startIsolated)
+	g.POST("/isolated/stop", a.stopIsolated)
+	g.POST("/stageConfig", a.stageConfig)
+	g.POST("/emptyInbounds", a.setEmptyInbounds)
+	g.GET("/sniffingExclusions", a.getSniffingExclusions)
+	g.POST("/sniffingExclusions/set", a.setSniffingExclusions)
+	g.POST("/checkConfig", a.checkConfig)
+	g.GET("/crashes", a.getCrashes)
+	g.GET("/profiles", a.getTemplateProfiles)
+	g.GET("/profiles/get/:name", a.getTemplateProfile)
+	g.POST("/profiles/save/:name", a.saveTemplateProfile)
+	g.POST("/profiles/switch/:name", a.switchTemplateProfile)
+	g.POST("/profiles/del/:name", a.delTemplateProfile)
+	g.GET("/instances", a.getXrayInstances)
+	g.POST("/instances/set", a.setXrayInstance)
+	g.POST("/instances/del/:name", a.delXrayInstance)
+	g.POST("/instances/start/:name", a.startXrayInstance)
+	g.POST("/instances/stop/:name", a.stopXrayInstance)
+	g.POST("/instances/restart/:name", a.restartXrayInstance)
+	g.POST("/crashes/clear", a.clearCrashes)
 }
 
 func (a *XraySettingController) getXraySetting(c *gin.Context) {
@@ -85,6 +160,19 @@ func (a *XraySettingController) warp(c *gin.Context) {
 	case "license":
 		license := c.PostForm("license")
 		resp, err = a.WarpService.SetWarpLicense(license)
+	case "breaker":
+		jsonObj(c, a.WarpService.GetWarpBreakerState(), nil)
+		return
+	case "reload":
+		err = a.XrayService.ReloadWarpOutbound()
+	case "verify":
+		a.WarpService.VerifyWarpCredentials()
+		jsonObj(c, a.WarpService.GetWarpCredentialsStatus(), nil)
+		return
+	case "devices":
+		devices, err := a.WarpService.ListWarpDevices()
+		jsonObj(c, devices, err)
+		return
 	}
 
 	jsonObj(c, resp, err)
@@ -99,6 +187,15 @@ func (a *XraySettingController) getOutboundsTraffic(c *gin.Context) {
 	jsonObj(c, outboundsTraffic, nil)
 }
 
+func (a *XraySettingController) getOutboundsTrafficByProtocol(c *gin.Context) {
+	outboundsTraffic, err := a.OutboundService.GetOutboundsTrafficByProtocol()
+	if err != nil {
+		jsonMsg(c, "Error getting traffics", err)
+		return
+	}
+	jsonObj(c, outboundsTraffic, nil)
+}
+
 func (a *XraySettingController) resetOutboundsTraffic(c *gin.Context) {
 	tag := c.PostForm("tag")
 	err := a.OutboundService.ResetOutboundTraffic(tag)
@@ -108,3 +205,444 @@ func (a *XraySettingController) resetOutboundsTraffic(c *gin.Context) {
 	}
 	jsonObj(c, "", nil)
 }
+
+func (a *XraySettingController) getRestartSchedule(c *gin.Context) {
+	spec, err := a.SettingService.GetXrayRestartSchedule()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
+		return
+	}
+	var next int64
+	if t := a.XrayService.NextScheduledRestart(); !t.IsZero() {
+		next = t.Unix() * 1000
+	}
+	drain, err := a.SettingService.GetXrayRestartDrain()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.getSettings"), err)
+		return
+	}
+	jsonObj(c, gin.H{"schedule": spec, "next": next, "drain": drain}, nil)
+}
+
+// setRestartSchedule takes the cron spec, and the seconds a restart drains for when drain is posted
+func (a *XraySettingController) setRestartSchedule(c *gin.Context) {
+	var err error
+	if value, ok := c.GetPostForm("drain"); ok {
+		var drain int
+		drain, err = strconv.Atoi(value)
+		if err == nil {
+			err = a.XrayService.SetRestartDrain(drain)
+		}
+	}
+	if err == nil {
+		err = a.XrayService.SetRestartSchedule(c.PostForm("schedule"))
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getStatsAPIWarnings(c *gin.Context) {
+	jsonObj(c, a.XrayService.GetStatsAPIWarnings(), nil)
+}
+
+func (a *XraySettingController) getConfigLock(c *gin.Context) {
+	jsonObj(c, a.XrayService.IsConfigLocked(), nil)
+}
+
+func (a *XraySettingController) lockConfig(c *gin.Context) {
+	a.XrayService.LockConfig()
+	jsonMsg(c, "Config locked", nil)
+}
+
+func (a *XraySettingController) unlockConfig(c *gin.Context) {
+	err := a.XrayService.UnlockConfig()
+	jsonMsg(c, "Config unlocked", err)
+}
+
+func (a *XraySettingController) getConfigStats(c *gin.Context) {
+	stats, err := a.XrayService.ConfigStats()
+	jsonObj(c, stats, err)
+}
+
+func (a *XraySettingController) setTlsEnforcement(c *gin.Context) {
+	var alpn []string
+	if value := strings.TrimSpace(c.PostForm("alpn")); value != "" {
+		alpn = strings.Split(value, ",")
+	}
+	err := a.XrayService.SetTlsEnforcement(alpn, c.PostForm("fingerprint"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getDeprecatedFields(c *gin.Context) {
+	deprecations, err := a.XrayService.DeprecatedFields()
+	jsonObj(c, deprecations, err)
+}
+
+func (a *XraySettingController) getGenerationTiming(c *gin.Context) {
+	jsonObj(c, a.XrayService.LastGenerationTiming(), nil)
+}
+
+func (a *XraySettingController) getBlockRules(c *gin.Context) {
+	rules, err := a.XrayService.GetBlockRules()
+	jsonObj(c, rules, err)
+}
+
+func (a *XraySettingController) addBlockRule(c *gin.Context) {
+	err := a.XrayService.AddBlockRule(c.PostForm("destination"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delBlockRule(c *gin.Context) {
+	err := a.XrayService.RemoveBlockRule(c.PostForm("destination"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) verifyStability(c *gin.Context) {
+	err := a.XrayService.VerifyEqualsStability()
+	jsonMsg(c, "Config generation is stable", err)
+}
+
+func (a *XraySettingController) setOutboundTrafficWebhook(c *gin.Context) {
+	err := a.OutboundService.SetOutboundTrafficWebhook(c.PostForm("url"))
+	if err == nil {
+		err = a.OutboundService.SetOutboundTrafficWebhookSecret(c.PostForm("secret"))
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) setConfigPatch(c *gin.Context) {
+	err := a.XrayService.SetConfigPatch(c.PostForm("patch"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getOutboundHealth(c *gin.Context) {
+	jsonObj(c, a.XrayService.GetOutboundHealth(), nil)
+}
+
+func (a *XraySettingController) setMaxClientsPerInbound(c *gin.Context) {
+	n, err := strconv.Atoi(c.PostForm("max"))
+	if err == nil {
+		err = a.XrayService.SetMaxClientsPerInbound(n)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getCappedClients(c *gin.Context) {
+	jsonObj(c, a.XrayService.GetCappedClients(), nil)
+}
+
+// getPendingTraffic shows the counters not yet saved to the database, without resetting them.
+// The inboundTag, email and system query parameters narrow or extend the read.
+func (a *XraySettingController) getPendingTraffic(c *gin.Context) {
+	var query service.TrafficQuery
+	err := c.ShouldBind(&query)
+	if err != nil {
+		jsonMsg(c, "Error getting traffics", err)
+		return
+	}
+	result, err := a.XrayService.QueryXrayTraffic(query)
+	if err != nil {
+		jsonMsg(c, "Error getting traffics", err)
+		return
+	}
+	jsonObj(c, result, nil)
+}
+
+func (a *XraySettingController) getSecurityAudit(c *gin.Context) {
+	findings, err := a.XrayService.SecurityAudit()
+	jsonObj(c, findings, err)
+}
+
+func (a *XraySettingController) getOptimizationFootprint(c *gin.Context) {
+	footprint, err := a.XrayService.OptimizationFootprint()
+	jsonObj(c, footprint, err)
+}
+
+func (a *XraySettingController) testOutbound(c *gin.Context) {
+	var outbound map[string]interface{}
+	err := json.Unmarshal([]byte(c.PostForm("outbound")), &outbound)
+	if err != nil {
+		jsonMsg(c, "Invalid outbound", err)
+		return
+	}
+	result, err := a.XrayService.TestOutbound(outbound)
+	jsonObj(c, result, err)
+}
+
+func (a *XraySettingController) getBalancers(c *gin.Context) {
+	balancers, err := a.XrayService.GetBalancers()
+	jsonObj(c, balancers, err)
+}
+
+func (a *XraySettingController) setBalancer(c *gin.Context) {
+	var balancer service.Balancer
+	err := json.Unmarshal([]byte(c.PostForm("balancer")), &balancer)
+	if err == nil {
+		err = a.XrayService.SetBalancer(balancer)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delBalancer(c *gin.Context) {
+	err := a.XrayService.RemoveBalancer(c.PostForm("tag"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getObservatory(c *gin.Context) {
+	observatory, err := a.XrayService.GetObservatory()
+	jsonObj(c, observatory, err)
+}
+
+func (a *XraySettingController) setObservatory(c *gin.Context) {
+	var observatory service.ObservatorySettings
+	err := json.Unmarshal([]byte(c.PostForm("observatory")), &observatory)
+	if err == nil {
+		err = a.XrayService.SetObservatory(observatory)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getReverseProxies(c *gin.Context) {
+	proxies, err := a.XrayService.GetReverseProxies()
+	jsonObj(c, proxies, err)
+}
+
+func (a *XraySettingController) setReverseProxy(c *gin.Context) {
+	var proxy service.ReverseProxy
+	err := json.Unmarshal([]byte(c.PostForm("reverse")), &proxy)
+	if err == nil {
+		err = a.XrayService.SetReverseProxy(proxy)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delReverseProxy(c *gin.Context) {
+	err := a.XrayService.RemoveReverseProxy(c.PostForm("tag"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getOutboundChains(c *gin.Context) {
+	chains, err := a.XrayService.GetOutboundChains()
+	jsonObj(c, chains, err)
+}
+
+func (a *XraySettingController) setOutboundChain(c *gin.Context) {
+	err := a.XrayService.SetOutboundChain(c.PostForm("tag"), c.PostForm("proxyTag"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delOutboundChain(c *gin.Context) {
+	err := a.XrayService.RemoveOutboundChain(c.PostForm("tag"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getFreedomSettings(c *gin.Context) {
+	settings, err := a.XrayService.GetFreedomSettings()
+	jsonObj(c, settings, err)
+}
+
+func (a *XraySettingController) setFreedomSettings(c *gin.Context) {
+	settings := &service.FreedomSettings{}
+	err := json.Unmarshal([]byte(c.PostForm("freedom")), settings)
+	if err == nil {
+		err = a.XrayService.SetFreedomSettings(settings)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getConfigDiff(c *gin.Context) {
+	diff, err := a.XrayService.GetPendingConfigDiff()
+	jsonObj(c, diff, err)
+}
+
+func (a *XraySettingController) getDnsSettings(c *gin.Context) {
+	settings, err := a.DnsService.GetDnsSettings()
+	jsonObj(c, settings, err)
+}
+
+func (a *XraySettingController) setDnsSettings(c *gin.Context) {
+	settings := &service.DnsSettings{}
+	err := json.Unmarshal([]byte(c.PostForm("dns")), settings)
+	if err == nil {
+		err = a.DnsService.SetDnsSettings(settings)
+	}
+	if err == nil {
+		a.XrayService.SetToNeedRestart()
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getConfigForVersion(c *gin.Context) {
+	xrayConfig, err := a.XrayService.GetXrayConfigForVersion(c.Query("version"))
+	jsonObj(c, xrayConfig, err)
+}
+
+func (a *XraySettingController) getResourceStatus(c *gin.Context) {
+	resourceStatus, err := a.XrayService.ResourceStatus()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, resourceStatus, nil)
+}
+
+func (a *XraySettingController) getListenPorts(c *gin.Context) {
+	bindings, err := a.XrayService.ListenPorts()
+	jsonObj(c, bindings, err)
+}
+
+func (a *XraySettingController) setMaintenanceMode(c *gin.Context) {
+	enable, err := strconv.ParseBool(c.PostForm("enable"))
+	if err == nil {
+		err = a.XrayService.SetMaintenanceMode(enable)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getTrafficConsistency(c *gin.Context) {
+	discrepancies, err := a.XrayService.AuditTrafficConsistency()
+	jsonObj(c, discrepancies, err)
+}
+
+func (a *XraySettingController) getInboundPolicies(c *gin.Context) {
+	policies, err := a.XrayService.GetInboundPolicies()
+	jsonObj(c, policies, err)
+}
+
+func (a *XraySettingController) setInboundPolicy(c *gin.Context) {
+	var policy service.InboundPolicy
+	err := json.Unmarshal([]byte(c.PostForm("policy")), &policy)
+	if err == nil {
+		err = a.XrayService.SetInboundPolicy(c.PostForm("tag"), &policy)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delInboundPolicy(c *gin.Context) {
+	err := a.XrayService.RemoveInboundPolicy(c.PostForm("tag"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getPolicySettings(c *gin.Context) {
+	settings, err := a.XrayService.GetPolicySettings()
+	jsonObj(c, settings, err)
+}
+
+func (a *XraySettingController) setPolicySettings(c *gin.Context) {
+	var settings service.PolicySettings
+	err := json.Unmarshal([]byte(c.PostForm("policy")), &settings)
+	if err == nil {
+		err = a.XrayService.SetPolicySettings(&settings)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) startIsolated(c *gin.Context) {
+	instance, err := a.XrayService.StartIsolated(c.PostForm("config"))
+	jsonObj(c, instance, err)
+}
+
+func (a *XraySettingController) stopIsolated(c *gin.Context) {
+	output, err := a.XrayService.StopIsolated(c.PostForm("id"))
+	jsonObj(c, output, err)
+}
+
+func (a *XraySettingController) stageConfig(c *gin.Context) {
+	err := a.XrayService.StageConfig()
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) setEmptyInbounds(c *gin.Context) {
+	err := a.XrayService.SetEmptyInbounds(c.PostForm("mode"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getSniffingExclusions(c *gin.Context) {
+	exclusions, err := a.XrayService.GetSniffingExclusions()
+	jsonObj(c, exclusions, err)
+}
+
+func (a *XraySettingController) setSniffingExclusions(c *gin.Context) {
+	var domains []string
+	if value := c.PostForm("domains"); value != "" {
+		domains = strings.Split(value, ",")
+	}
+	err := a.XrayService.SetSniffingExclusions(c.PostForm("tag"), domains)
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) checkConfig(c *gin.Context) {
+	result, err := a.XrayService.CheckXrayConfig()
+	jsonObj(c, result, err)
+}
+
+func (a *XraySettingController) getCrashes(c *gin.Context) {
+	jsonObj(c, a.XrayService.GetXrayWatchdog(), nil)
+}
+
+func (a *XraySettingController) clearCrashes(c *gin.Context) {
+	a.XrayService.ClearXrayCrashes()
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), nil)
+}
+
+func (a *XraySettingController) getTemplateProfiles(c *gin.Context) {
+	profiles, err := a.XraySettingService.GetTemplateProfiles()
+	jsonObj(c, profiles, err)
+}
+
+func (a *XraySettingController) getTemplateProfile(c *gin.Context) {
+	template, err := a.XraySettingService.GetTemplateProfile(c.Param("name"))
+	jsonObj(c, template, err)
+}
+
+func (a *XraySettingController) saveTemplateProfile(c *gin.Context) {
+	err := a.XraySettingService.SaveTemplateProfile(c.Param("name"), c.PostForm("xraySetting"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+// switchTemplateProfile restarts xray right away, so the live profile follows the switch
+func (a *XraySettingController) switchTemplateProfile(c *gin.Context) {
+	err := a.XraySettingService.SwitchTemplateProfile(c.Param("name"))
+	if err == nil {
+		err = a.XrayService.RestartXray(false)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delTemplateProfile(c *gin.Context) {
+	err := a.XraySettingService.DelTemplateProfile(c.Param("name"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) getXrayInstances(c *gin.Context) {
+	statuses, err := a.XrayService.GetXrayInstanceStatuses()
+	jsonObj(c, statuses, err)
+}
+
+func (a *XraySettingController) setXrayInstance(c *gin.Context) {
+	var instance service.XrayInstance
+	err := json.Unmarshal([]byte(c.PostForm("instance")), &instance)
+	if err == nil {
+		err = a.XrayService.SetXrayInstance(instance)
+	}
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) delXrayInstance(c *gin.Context) {
+	err := a.XrayService.RemoveXrayInstance(c.Param("name"))
+	jsonMsg(c, I18nWeb(c, "pages.settings.toasts.modifySettings"), err)
+}
+
+func (a *XraySettingController) startXrayInstance(c *gin.Context) {
+	err := a.XrayService.StartXrayInstance(c.Param("name"))
+	jsonMsg(c, I18nWeb(c, "pages.index.xrayStatus"), err)
+}
+
+func (a *XraySettingController) stopXrayInstance(c *gin.Context) {
+	err := a.XrayService.StopXrayInstance(c.Param("name"))
+	jsonMsg(c, I18nWeb(c, "pages.index.stopXray"), err)
+}
+
+func (a *XraySettingController) restartXrayInstance(c *gin.Context) {
+	err := a.XrayService.RestartXrayInstance(c.Param("name"))
+	jsonMsg(c, I18nWeb(c, "pages.index.restartXray"), err)
+}
@@ -3,6 +3,7 @@ package entity
 import (
 	"crypto/tls"
 	"net"
+	"net/url"
 	"strings"
 	"time"
 
@@ -37,6 +38,16 @@ type AllSetting struct {
 	TgCpu            int    `json:"tgCpu" form:"tgCpu"`
 	TgLang           string `json:"tgLang" form:"tgLang"`
 	TimeLocation     string `json:"timeLocation" form:"timeLocation"`
+	WarpMtu          int    `json:"warpMtu" form:"warpMtu"`
+	WarpWorkers      int    `json:"warpWorkers" form:"warpWorkers"`
+	GeoUpdateRuntime string `json:"geoUpdateRuntime" form:"geoUpdateRuntime"`
+	GeoipUrl         string `json:"geoipUrl" form:"geoipUrl"`
+	GeositeUrl       string `json:"geositeUrl" form:"geositeUrl"`
+	GeoChecksum      bool   `json:"geoChecksum" form:"geoChecksum"`
+	InboundAccessLog bool   `json:"inboundAccessLog" form:"inboundAccessLog"`
+	XrayCpuLimit     int    `json:"xrayCpuLimit" form:"xrayCpuLimit"`
+	XrayMemoryLimit  int    `json:"xrayMemoryLimit" form:"xrayMemoryLimit"`
+	RaiseOpenFiles   bool   `json:"raiseOpenFiles" form:"raiseOpenFiles"`
 	SecretEnable     bool   `json:"secretEnable" form:"secretEnable"`
 	SubEnable        bool   `json:"subEnable" form:"subEnable"`
 	SubListen        string `json:"subListen" form:"subListen"`
@@ -119,6 +130,32 @@ func (s *AllSetting) CheckValid() error {
 		s.SubJsonPath += "/"
 	}
 
+	// 0 keeps the value of the outbound
+	if s.WarpMtu != 0 && (s.WarpMtu < 576 || s.WarpMtu > 65535) {
+		return common.NewError("warp mtu must be 0 or between 576 and 65535:", s.WarpMtu)
+	}
+	if s.WarpWorkers < 0 {
+		return common.NewError("warp workers must not be negative:", s.WarpWorkers)
+	}
+
+	if s.XrayCpuLimit < 0 || s.XrayMemoryLimit < 0 {
+		return common.NewError("xray resource limits must not be negative")
+	}
+	// Below this Xray hardly starts, the cgroup would kill it right away
+	if s.XrayMemoryLimit > 0 && s.XrayMemoryLimit < 32 {
+		return common.NewError("xray memory limit must be at least 32 MB:", s.XrayMemoryLimit)
+	}
+
+	// Comma-separated mirrors, tried in order
+	for _, mirrors := range []string{s.GeoipUrl, s.GeositeUrl} {
+		for _, mirror := range strings.Split(mirrors, ",") {
+			u, err := url.Parse(strings.TrimSpace(mirror))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return common.NewError("invalid geo file url:", mirror)
+			}
+		}
+	}
+
 	_, err := time.LoadLocation(s.TimeLocation)
 	if err != nil {
 		return common.NewError("time location not exist:", s.TimeLocation)
@@ -15,10 +15,12 @@ import (
 	"x-ui/database"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/web/service"
 	"x-ui/xray"
 )
 
 type CheckClientIpJob struct {
+	xrayService   service.XrayService
 	lastClear     int64
 	disAllowedIps []string
 }
@@ -57,6 +59,9 @@ func (j *CheckClientIpJob) clearAccessLog() {
 	accessLogPath, err := xray.GetAccessLogPath()
 	j.checkError(err)
 
+	// keep the lines of each inbound before they are cleared
+	j.checkError(j.xrayService.SplitAccessLog(accessLogPath))
+
 	// reopen the access log file for reading
 	file, err := os.Open(accessLogPath)
 	j.checkError(err)
@@ -0,0 +1,22 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type ClientTimeWindowJob struct {
+	xrayService service.XrayService
+}
+
+func NewClientTimeWindowJob() *ClientTimeWindowJob {
+	return new(ClientTimeWindowJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *ClientTimeWindowJob) Run() {
+	err := j.xrayService.CheckClientTimeWindows()
+	if err != nil {
+		logger.Warning("check client time windows failed:", err)
+	}
+}
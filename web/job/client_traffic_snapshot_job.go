@@ -0,0 +1,22 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type ClientTrafficSnapshotJob struct {
+	xrayService service.XrayService
+}
+
+func NewClientTrafficSnapshotJob() *ClientTrafficSnapshotJob {
+	return new(ClientTrafficSnapshotJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *ClientTrafficSnapshotJob) Run() {
+	err := j.xrayService.SnapshotClientTraffics()
+	if err != nil {
+		logger.Warning("snapshot client traffics failed:", err)
+	}
+}
@@ -0,0 +1,25 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type DeviceLimitJob struct {
+	xrayService service.XrayService
+}
+
+func NewDeviceLimitJob() *DeviceLimitJob {
+	return new(DeviceLimitJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *DeviceLimitJob) Run() {
+	if !j.xrayService.IsXrayRunning() {
+		return
+	}
+	err := j.xrayService.CheckDeviceLimits()
+	if err != nil {
+		logger.Warning("check device limits failed:", err)
+	}
+}
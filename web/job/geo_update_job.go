@@ -0,0 +1,27 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type GeoUpdateJob struct {
+	xrayService service.XrayService
+}
+
+func NewGeoUpdateJob() *GeoUpdateJob {
+	return new(GeoUpdateJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *GeoUpdateJob) Run() {
+	results, err := j.xrayService.UpdateGeoFiles()
+	if err != nil {
+		logger.Warning("update geo files failed:", err)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			logger.Warningf("update %s failed: %s", result.Name, result.Error)
+		}
+	}
+}
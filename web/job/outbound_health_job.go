@@ -0,0 +1,22 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type OutboundHealthJob struct {
+	xrayService service.XrayService
+}
+
+func NewOutboundHealthJob() *OutboundHealthJob {
+	return new(OutboundHealthJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *OutboundHealthJob) Run() {
+	err := j.xrayService.ProbeOutbounds()
+	if err != nil {
+		logger.Warning("probe outbounds failed:", err)
+	}
+}
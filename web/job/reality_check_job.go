@@ -0,0 +1,22 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type RealityCheckJob struct {
+	xrayService service.XrayService
+}
+
+func NewRealityCheckJob() *RealityCheckJob {
+	return new(RealityCheckJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *RealityCheckJob) Run() {
+	err := j.xrayService.CheckRealityDests()
+	if err != nil {
+		logger.Warning("check reality dests failed:", err)
+	}
+}
@@ -0,0 +1,22 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type RealityRotationJob struct {
+	xrayService service.XrayService
+}
+
+func NewRealityRotationJob() *RealityRotationJob {
+	return new(RealityRotationJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *RealityRotationJob) Run() {
+	err := j.xrayService.ApplyDueRealityRotations()
+	if err != nil {
+		logger.Warning("apply reality key rotations failed:", err)
+	}
+}
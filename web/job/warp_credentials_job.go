@@ -0,0 +1,26 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type WarpCredentialsJob struct {
+	warpService service.WarpService
+}
+
+func NewWarpCredentialsJob() *WarpCredentialsJob {
+	return new(WarpCredentialsJob)
+}
+
+// Here Run is an interface method of the Job interface
+func (j *WarpCredentialsJob) Run() {
+	warp, err := j.warpService.GetWarpData()
+	if err != nil || warp == "" {
+		return
+	}
+	_, err = j.warpService.VerifyWarpCredentials()
+	if err != nil {
+		logger.Warning("verify warp credentials failed:", err)
+	}
+}
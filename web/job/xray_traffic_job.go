@@ -1,8 +1,11 @@
 package job
 
 import (
+	"errors"
+
 	"x-ui/logger"
 	"x-ui/web/service"
+	"x-ui/xray"
 )
 
 type XrayTrafficJob struct {
@@ -16,12 +19,19 @@ func NewXrayTrafficJob() *XrayTrafficJob {
 }
 
 func (j *XrayTrafficJob) Run() {
-	if !j.xrayService.IsXrayRunning() {
-		return
+	// The extra instances count towards the same inbounds and clients as the main Xray
+	traffics, clientTraffics, err := j.xrayService.GetXrayTraffic(true)
+	if err != nil {
+		// What was read is already reset in Xray, save it
+		var partial *xray.PartialTrafficError
+		if !errors.As(err, &partial) {
+			return
+		}
 	}
-	traffics, clientTraffics, err := j.xrayService.GetXrayTraffic()
+
+	err = j.xrayService.ApplyBandwidthLimits(traffics, clientTraffics)
 	if err != nil {
-		return
+		logger.Warning("apply bandwidth limits failed:", err)
 	}
 	err, needRestart0 := j.inboundService.AddTraffic(traffics, clientTraffics)
 	if err != nil {
@@ -34,4 +44,8 @@ func (j *XrayTrafficJob) Run() {
 	if needRestart0 || needRestart1 {
 		j.xrayService.SetToNeedRestart()
 	}
+	err = j.xrayService.NotifyExpiringClients()
+	if err != nil {
+		logger.Warning("notify expiring clients failed:", err)
+	}
 }
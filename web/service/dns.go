@@ -0,0 +1,168 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// DnsServer is one entry of the dns servers, written as a plain address when it only has one
+type DnsServer struct {
+	Address      string   `json:"address"`
+	Port         int      `json:"port,omitempty"`
+	Domains      []string `json:"domains,omitempty"`
+	ExpectIPs    []string `json:"expectIPs,omitempty"`
+	SkipFallback bool     `json:"skipFallback,omitempty"`
+}
+
+// DnsSettings is the dns section managed by the panel. When enabled its keys replace the
+// ones of the template dns section, hosts are merged with the template ones.
+type DnsSettings struct {
+	Enable        bool                `json:"enable"`
+	Servers       []DnsServer         `json:"servers"`
+	Hosts         map[string][]string `json:"hosts,omitempty"`
+	ClientIP      string              `json:"clientIp,omitempty"`
+	QueryStrategy string              `json:"queryStrategy,omitempty"`
+}
+
+var dnsQueryStrategies = map[string]bool{"": true, "UseIP": true, "UseIPv4": true, "UseIPv6": true}
+
+type DnsService struct {
+	settingService SettingService
+}
+
+func (s *DnsService) GetDnsSettings() (*DnsSettings, error) {
+	settings := &DnsSettings{}
+	data, err := s.settingService.GetDnsSettings()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return settings, nil
+	}
+	err = json.Unmarshal([]byte(data), settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// SetDnsSettings validates and saves the dns section, the caller restarts xray
+func (s *DnsService) SetDnsSettings(settings *DnsSettings) error {
+	err := checkDnsSettings(settings)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.settingService.SetDnsSettings(string(data))
+}
+
+func checkDnsSettings(settings *DnsSettings) error {
+	if settings.Enable && len(settings.Servers) == 0 {
+		return common.NewError("dns needs at least one server")
+	}
+	for i := range settings.Servers {
+		server := &settings.Servers[i]
+		server.Address = strings.TrimSpace(server.Address)
+		if server.Address != "localhost" && server.Address != "fakedns" {
+			if _, _, err := parseDnsServer(server.Address); err != nil {
+				return err
+			}
+		}
+		if server.Port < 0 || server.Port > 65535 {
+			return common.NewErrorf("invalid port %d of dns server %s", server.Port, server.Address)
+		}
+		for _, domain := range server.Domains {
+			if err := checkDomainMatcher(domain); err != nil {
+				return err
+			}
+		}
+		for _, ip := range server.ExpectIPs {
+			if err := checkRuleIP(ip); err != nil {
+				return err
+			}
+		}
+	}
+	for domain, addresses := range settings.Hosts {
+		if err := checkDomainMatcher(domain); err != nil {
+			return err
+		}
+		if len(addresses) == 0 {
+			return common.NewErrorf("host %s has no address", domain)
+		}
+		for _, address := range addresses {
+			if net.ParseIP(address) == nil && !plainDomainRegex.MatchString(address) {
+				return common.NewErrorf("invalid address %q of host %s", address, domain)
+			}
+		}
+	}
+	if settings.ClientIP != "" && net.ParseIP(settings.ClientIP) == nil {
+		return common.NewErrorf("invalid client ip %q", settings.ClientIP)
+	}
+	if !dnsQueryStrategies[settings.QueryStrategy] {
+		return common.NewErrorf("unsupported query strategy %q", settings.QueryStrategy)
+	}
+	return nil
+}
+
+// applyDnsSettings writes the panel dns settings over the template dns section
+func (s *XrayService) applyDnsSettings(xrayConfig *xray.Config) error {
+	dnsService := DnsService{}
+	settings, err := dnsService.GetDnsSettings()
+	if err != nil || !settings.Enable {
+		return err
+	}
+
+	dns := map[string]interface{}{}
+	if len(xrayConfig.DNSConfig) > 0 && string(xrayConfig.DNSConfig) != "null" {
+		if err := json.Unmarshal(xrayConfig.DNSConfig, &dns); err != nil {
+			return err
+		}
+	}
+
+	servers := make([]interface{}, 0, len(settings.Servers))
+	for _, server := range settings.Servers {
+		if server.Port == 0 && len(server.Domains) == 0 && len(server.ExpectIPs) == 0 && !server.SkipFallback {
+			servers = append(servers, server.Address)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	dns["servers"] = servers
+
+	if len(settings.Hosts) > 0 {
+		hosts, _ := dns["hosts"].(map[string]interface{})
+		if hosts == nil {
+			hosts = map[string]interface{}{}
+		}
+		domains := make([]string, 0, len(settings.Hosts))
+		for domain := range settings.Hosts {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			if addresses := settings.Hosts[domain]; len(addresses) == 1 {
+				hosts[domain] = addresses[0]
+			} else {
+				hosts[domain] = addresses
+			}
+		}
+		dns["hosts"] = hosts
+	}
+	if settings.ClientIP != "" {
+		dns["clientIp"] = settings.ClientIP
+	}
+	if settings.QueryStrategy != "" {
+		dns["queryStrategy"] = settings.QueryStrategy
+	}
+
+	xrayConfig.DNSConfig, err = json.MarshalIndent(dns, "", "  ")
+	return err
+}
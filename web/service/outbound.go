@@ -7,31 +7,35 @@ import (
     "x-ui/xray"
 
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
 type OutboundService struct{}
 
-func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
-    var err error
+func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (err error, needRestart bool) {
     db := database.GetDB()
     tx := db.Begin()
 
     defer func() {
         if err != nil {
             tx.Rollback()
-        } else {
-            tx.Commit()
+            return
+        }
+        // Only stored deltas go to the webhook, a failed commit is returned to the caller
+        if err = tx.Commit().Error; err != nil {
+            return
         }
+        s.sendOutboundTrafficWebhook(traffics)
     }()
 
     err = s.addOutboundTraffic(tx, traffics)
     if err != nil {
-        return err
+        return err, false
     }
 
     // If needed, process clientTraffics here
 
-    return nil
+    return nil, false
 }
 
 func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) error {
@@ -39,25 +43,58 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
         return nil
     }
 
-    for _, traffic := range traffics {
+    for _, traffic := range xray.TagTraffics(traffics) {
         if traffic.IsOutbound {
-            err := tx.Model(&model.OutboundTraffics{}).
-                Where("tag = ?", traffic.Tag).
-                Updates(map[string]interface{}{
-                    "tag":   traffic.Tag,
+            // Upsert, so the first traffic of a tag without a row is not lost
+            err := tx.Clauses(clause.OnConflict{
+                Columns: []clause.Column{{Name: "tag"}},
+                DoUpdates: clause.Assignments(map[string]interface{}{
                     "up":    gorm.Expr("up + ?", traffic.Up),
                     "down":  gorm.Expr("down + ?", traffic.Down),
                     "total": gorm.Expr("total + ? + ?", traffic.Up, traffic.Down),
-                }).Error
+                }),
+            }).Create(&model.OutboundTraffics{
+                Tag:   traffic.Tag,
+                Up:    traffic.Up,
+                Down:  traffic.Down,
+                Total: traffic.Up + traffic.Down,
+            }).Error
             if err != nil {
                 logger.Error("Failed to update outbound traffic: ", err)
                 return err
             }
         }
     }
+    // The protocol counters only go to the breakdown, the tag totals above already have them
+    for _, traffic := range traffics {
+        if traffic.IsOutbound && traffic.Protocol != "" {
+            err := s.addOutboundProtocolTraffic(tx, traffic)
+            if err != nil {
+                logger.Error("Failed to update outbound protocol traffic: ", err)
+                return err
+            }
+        }
+    }
     return nil
 }
 
+func (s *OutboundService) addOutboundProtocolTraffic(tx *gorm.DB, traffic *xray.Traffic) error {
+    return tx.Clauses(clause.OnConflict{
+        Columns: []clause.Column{{Name: "tag"}, {Name: "protocol"}},
+        DoUpdates: clause.Assignments(map[string]interface{}{
+            "up":    gorm.Expr("up + ?", traffic.Up),
+            "down":  gorm.Expr("down + ?", traffic.Down),
+            "total": gorm.Expr("total + ? + ?", traffic.Up, traffic.Down),
+        }),
+    }).Create(&model.OutboundProtocolTraffics{
+        Tag:      traffic.Tag,
+        Protocol: traffic.Protocol,
+        Up:       traffic.Up,
+        Down:     traffic.Down,
+        Total:    traffic.Up + traffic.Down,
+    }).Error
+}
+
 func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, error) {
     db := database.GetDB()
     var traffics []*model.OutboundTraffics
@@ -71,17 +108,47 @@ func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, erro
     return traffics, nil
 }
 
+// GetOutboundsTrafficByProtocol returns the per protocol breakdown grouped by outbound tag
+func (s *OutboundService) GetOutboundsTrafficByProtocol() (map[string][]*model.OutboundProtocolTraffics, error) {
+    db := database.GetDB()
+    var traffics []*model.OutboundProtocolTraffics
+
+    err := db.Model(&model.OutboundProtocolTraffics{}).Order("tag, protocol").Find(&traffics).Error
+    if err != nil {
+        logger.Warning("Error retrieving OutboundProtocolTraffics: ", err)
+        return nil, err
+    }
+
+    grouped := make(map[string][]*model.OutboundProtocolTraffics)
+    for _, traffic := range traffics {
+        grouped[traffic.Tag] = append(grouped[traffic.Tag], traffic)
+    }
+    return grouped, nil
+}
+
 func (s *OutboundService) ResetOutboundTraffic(tag string) error {
     db := database.GetDB()
     var err error
 
     if tag == "-alltags-" {
+        forgetOutboundTrafficLedger()
         err = db.Model(&model.OutboundTraffics{}).
             Updates(map[string]interface{}{"up": 0, "down": 0, "total": 0}).Error
+        if err == nil {
+            err = db.Model(&model.OutboundProtocolTraffics{}).
+                Where("1 = 1").
+                Updates(map[string]interface{}{"up": 0, "down": 0, "total": 0}).Error
+        }
     } else {
+        forgetOutboundTrafficLedger(tag)
         err = db.Model(&model.OutboundTraffics{}).
             Where("tag = ?", tag).
             Updates(map[string]interface{}{"up": 0, "down": 0, "total": 0}).Error
+        if err == nil {
+            err = db.Model(&model.OutboundProtocolTraffics{}).
+                Where("tag = ?", tag).
+                Updates(map[string]interface{}{"up": 0, "down": 0, "total": 0}).Error
+        }
     }
     if err != nil {
         logger.Error("Failed to reset outbound traffic: ", err)
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+type metricPoint struct {
+	name   string
+	label  string // attribute name of the series, tag or email
+	series string
+	value  int64
+}
+
+var statsdNameRegex = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// StartMetricsPusher periodically pushes outbound traffic, and client traffic when the
+// metricsPushClients setting is on, to a statsd (statsd:// or udp://) or OTLP/HTTP (http:// or https://) endpoint.
+// It stops when ctx is canceled. Push failures are logged and the sample is dropped.
+func (s *OutboundService) StartMetricsPusher(ctx context.Context, endpoint string, interval time.Duration) error {
+	if interval <= 0 {
+		return common.NewError("metrics push interval must be positive")
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	var push func(points []metricPoint) error
+	switch target.Scheme {
+	case "statsd", "udp":
+		if target.Host == "" {
+			return common.NewErrorf("statsd endpoint has no host: %s", endpoint)
+		}
+		push = func(points []metricPoint) error {
+			return pushStatsd(target.Host, points)
+		}
+	case "http", "https":
+		client := &http.Client{Timeout: 10 * time.Second}
+		push = func(points []metricPoint) error {
+			return pushOTLP(ctx, client, endpoint, points)
+		}
+	default:
+		return common.NewErrorf("unsupported metrics endpoint scheme: %s", target.Scheme)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Debug("Metrics pusher stopped.")
+				return
+			case <-ticker.C:
+				points, err := s.collectMetrics()
+				if err != nil {
+					logger.Warning("Failed to collect metrics:", err)
+					continue
+				}
+				if err = push(points); err != nil {
+					logger.Warning("Failed to push metrics, dropping sample:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *OutboundService) collectMetrics() ([]metricPoint, error) {
+	traffics, err := s.GetOutboundsTraffic()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]metricPoint, 0, len(traffics)*3)
+	for _, traffic := range traffics {
+		points = append(points,
+			metricPoint{"xui.outbound.up", "tag", traffic.Tag, traffic.Up},
+			metricPoint{"xui.outbound.down", "tag", traffic.Tag, traffic.Down},
+			metricPoint{"xui.outbound.total", "tag", traffic.Tag, traffic.Total},
+		)
+	}
+
+	settingService := SettingService{}
+	withClients, err := settingService.GetMetricsPushClients()
+	if err != nil || !withClients {
+		return points, nil
+	}
+	var clientTraffics []*xray.ClientTraffic
+	err = database.GetDB().Model(xray.ClientTraffic{}).Find(&clientTraffics).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, traffic := range clientTraffics {
+		points = append(points,
+			metricPoint{"xui.client.up", "email", traffic.Email, traffic.Up},
+			metricPoint{"xui.client.down", "email", traffic.Email, traffic.Down},
+		)
+	}
+	return points, nil
+}
+
+// pushStatsd sends every point as a gauge named <name>.<series>
+func pushStatsd(host string, points []metricPoint) error {
+	conn, err := net.DialTimeout("udp", host, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Keep datagrams under a safe MTU
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+	for _, point := range points {
+		line := fmt.Sprintf("%s.%s:%d|g\n", point.name, statsdNameRegex.ReplaceAllString(point.series, "_"), point.value)
+		if buf.Len()+len(line) > 1400 {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(line)
+	}
+	return flush()
+}
+
+// pushOTLP posts the points as cumulative sums using the OTLP/HTTP JSON encoding
+func pushOTLP(ctx context.Context, client *http.Client, endpoint string, points []metricPoint) error {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	dataPoints := map[string][]interface{}{}
+	names := []string{}
+	for _, point := range points {
+		if _, ok := dataPoints[point.name]; !ok {
+			names = append(names, point.name)
+		}
+		dataPoints[point.name] = append(dataPoints[point.name], map[string]interface{}{
+			"asInt":        strconv.FormatInt(point.value, 10),
+			"timeUnixNano": now,
+			"attributes": []interface{}{
+				map[string]interface{}{"key": point.label, "value": map[string]interface{}{"stringValue": point.series}},
+			},
+		})
+	}
+	metrics := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, map[string]interface{}{
+			"name": name,
+			"unit": "By",
+			"sum": map[string]interface{}{
+				"dataPoints":             dataPoints[name],
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+			},
+		})
+	}
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "x-ui"}},
+					},
+				},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope":   map[string]interface{}{"name": "x-ui"},
+						"metrics": metrics,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return common.NewErrorf("otlp endpoint returned %s", strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
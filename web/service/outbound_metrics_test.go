@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+)
+
+func TestStartMetricsPusherEndpoints(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		interval time.Duration
+		wantErr  string
+	}{
+		{"statsd://127.0.0.1:8125", 0, "interval must be positive"},
+		{"statsd://", time.Second, "has no host"},
+		{"ftp://example.com", time.Second, "unsupported metrics endpoint scheme"},
+		{"statsd://127.0.0.1:8125", time.Second, ""},
+		{"udp://127.0.0.1:8125", time.Second, ""},
+		{"https://otlp.example.com/v1/metrics", time.Second, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := &OutboundService{}
+			err := s.StartMetricsPusher(ctx, tt.endpoint, tt.interval)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricsPusherStatsd(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	if err := db.Create(&model.OutboundTraffics{Tag: "warp", Up: 10, Down: 20, Total: 30}).Error; err != nil {
+		t.Fatal(err)
+	}
+	addTestClient(t, 1, "alice@example.com")
+	settingService := SettingService{}
+	if err := settingService.saveSetting("metricsPushClients", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &OutboundService{}
+	if err := s.StartMetricsPusher(ctx, "statsd://"+sink.LocalAddr().String(), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := sink.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+	want := []string{
+		"xui.outbound.up.warp:10|g",
+		"xui.outbound.down.warp:20|g",
+		"xui.outbound.total.warp:30|g",
+		"xui.client.up.alice_example.com:0|g",
+		"xui.client.down.alice_example.com:0|g",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMetricsPusherOTLP(t *testing.T) {
+	setupTestDB(t)
+	if err := database.GetDB().Create(&model.OutboundTraffics{Tag: "direct", Up: 1, Down: 2, Total: 3}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := make(chan []byte, 10)
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if fail.Swap(false) {
+			// The pusher drops the sample and keeps going
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies <- body
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &OutboundService{}
+	if err := s.StartMetricsPusher(ctx, server.URL+"/v1/metrics", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no metrics pushed")
+	}
+	cancel()
+
+	var payload struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name string `json:"name"`
+					Sum  struct {
+						DataPoints []struct {
+							AsInt      string `json:"asInt"`
+							Attributes []struct {
+								Key   string `json:"key"`
+								Value struct {
+									StringValue string `json:"stringValue"`
+								} `json:"value"`
+							} `json:"attributes"`
+						} `json:"dataPoints"`
+						AggregationTemporality int  `json:"aggregationTemporality"`
+						IsMonotonic            bool `json:"isMonotonic"`
+					} `json:"sum"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	metrics := payload.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	want := map[string]string{"xui.outbound.up": "1", "xui.outbound.down": "2", "xui.outbound.total": "3"}
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d", len(metrics), len(want))
+	}
+	for _, metric := range metrics {
+		point := metric.Sum.DataPoints[0]
+		if point.AsInt != want[metric.Name] || point.Attributes[0].Key != "tag" || point.Attributes[0].Value.StringValue != "direct" {
+			t.Errorf("%s: got %+v", metric.Name, point)
+		}
+		if metric.Sum.AggregationTemporality != 2 || !metric.Sum.IsMonotonic {
+			t.Errorf("%s is not a cumulative sum", metric.Name)
+		}
+	}
+}
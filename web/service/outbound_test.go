@@ -0,0 +1,148 @@
+package service
+
+import (
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestOutboundAddTrafficByProtocol(t *testing.T) {
+	setupTestDB(t)
+	s := &OutboundService{}
+
+	traffics := []*xray.Traffic{
+		{IsOutbound: true, Tag: "proxy", Up: 100, Down: 1000},
+		{IsOutbound: true, Tag: "proxy", Protocol: "tcp", Up: 70, Down: 900},
+		{IsOutbound: true, Tag: "proxy", Protocol: "udp", Up: 30, Down: 100},
+		{IsOutbound: true, Tag: "warp", Protocol: "udp", Up: 5, Down: 50},
+		{IsInbound: true, Tag: "inbound-443", Up: 1, Down: 1},
+	}
+	if err, _ := s.AddTraffic(traffics, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A second read adds up instead of replacing
+	if err, _ := s.AddTraffic(traffics, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var totals []*model.OutboundTraffics
+	if err := database.GetDB().Order("tag").Find(&totals).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantTotals := map[string][2]int64{
+		"proxy": {200, 2000},
+		"warp":  {10, 100},
+	}
+	if len(totals) != len(wantTotals) {
+		t.Fatalf("got %d outbound rows, want %d", len(totals), len(wantTotals))
+	}
+	for _, total := range totals {
+		want := wantTotals[total.Tag]
+		if total.Up != want[0] || total.Down != want[1] || total.Total != want[0]+want[1] {
+			t.Errorf("%s: got up %d down %d total %d, want %v", total.Tag, total.Up, total.Down, total.Total, want)
+		}
+	}
+
+	grouped, err := s.GetOutboundsTrafficByProtocol()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantProtocols := []struct {
+		tag, protocol string
+		up, down      int64
+	}{
+		{"proxy", "tcp", 140, 1800},
+		{"proxy", "udp", 60, 200},
+		{"warp", "udp", 10, 100},
+	}
+	for _, want := range wantProtocols {
+		var found *model.OutboundProtocolTraffics
+		for _, traffic := range grouped[want.tag] {
+			if traffic.Protocol == want.protocol {
+				found = traffic
+			}
+		}
+		if found == nil {
+			t.Errorf("no %s row for %s", want.protocol, want.tag)
+			continue
+		}
+		if found.Up != want.up || found.Down != want.down {
+			t.Errorf("%s/%s: got up %d down %d, want %d %d", want.tag, want.protocol, found.Up, found.Down, want.up, want.down)
+		}
+	}
+	if len(grouped["proxy"]) != 2 || grouped["proxy"][0].Protocol != "tcp" {
+		t.Errorf("proxy breakdown is not ordered by protocol: %+v", grouped["proxy"])
+	}
+
+	if err := s.ResetOutboundTraffic("proxy"); err != nil {
+		t.Fatal(err)
+	}
+	grouped, err = s.GetOutboundsTrafficByProtocol()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, traffic := range grouped["proxy"] {
+		if traffic.Total != 0 {
+			t.Errorf("proxy/%s was not reset", traffic.Protocol)
+		}
+	}
+	if grouped["warp"][0].Total == 0 {
+		t.Error("resetting proxy reset warp as well")
+	}
+}
+
+func TestOutboundAddTrafficNewTag(t *testing.T) {
+	setupTestDB(t)
+	s := &OutboundService{}
+	db := database.GetDB()
+	if err := db.Create(&model.OutboundTraffics{Tag: "direct", Up: 5, Down: 5, Total: 10}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		traffics []*xray.Traffic
+		want     map[string][2]int64
+	}{
+		{
+			name:     "never seen tag gets a row",
+			traffics: []*xray.Traffic{{IsOutbound: true, Tag: "fresh", Up: 7, Down: 11}},
+			want:     map[string][2]int64{"direct": {5, 5}, "fresh": {7, 11}},
+		},
+		{
+			name: "existing rows add up",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "fresh", Up: 1, Down: 2},
+				{IsOutbound: true, Tag: "direct", Up: 3, Down: 4},
+			},
+			want: map[string][2]int64{"direct": {8, 9}, "fresh": {8, 13}},
+		},
+		{
+			name:     "inbound traffic is not an outbound row",
+			traffics: []*xray.Traffic{{IsInbound: true, Tag: "inbound-443", Up: 1, Down: 1}},
+			want:     map[string][2]int64{"direct": {8, 9}, "fresh": {8, 13}},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err, _ := s.AddTraffic(step.traffics, nil); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := s.GetOutboundsTraffic()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(step.want) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(step.want))
+			}
+			for _, row := range rows {
+				want, ok := step.want[row.Tag]
+				if !ok || row.Up != want[0] || row.Down != want[1] || row.Total != want[0]+want[1] {
+					t.Errorf("%s: got up %d down %d total %d, want %v", row.Tag, row.Up, row.Down, row.Total, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+var outboundWebhookRetry = RetryPolicy{
+	MaxRetries:  3,
+	BaseBackoff: time.Second,
+	MaxBackoff:  10 * time.Second,
+}
+
+var outboundWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type outboundTrafficDelta struct {
+	Tag  string `json:"tag"`
+	Up   int64  `json:"up"`
+	Down int64  `json:"down"`
+}
+
+type outboundTrafficPayload struct {
+	Timestamp int64                  `json:"timestamp"`
+	Outbounds []outboundTrafficDelta `json:"outbounds"`
+}
+
+// SetOutboundTrafficWebhook makes every traffic flush post the outbound deltas to url, empty disables it.
+// With a secret set, the body is signed in the X-Signature-256 header as sha256=<hex hmac>.
+func (s *OutboundService) SetOutboundTrafficWebhook(webhookUrl string) error {
+	if webhookUrl != "" {
+		target, err := url.Parse(webhookUrl)
+		if err != nil {
+			return err
+		}
+		if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return common.NewErrorf("invalid webhook url: %s", webhookUrl)
+		}
+	}
+	settingService := SettingService{}
+	return settingService.SetOutboundTrafficWebhook(webhookUrl)
+}
+
+func (s *OutboundService) SetOutboundTrafficWebhookSecret(secret string) error {
+	settingService := SettingService{}
+	return settingService.SetOutboundTrafficWebhookSecret(secret)
+}
+
+func outboundTrafficDeltas(traffics []*xray.Traffic) []outboundTrafficDelta {
+	byTag := make(map[string]*outboundTrafficDelta)
+	for _, traffic := range xray.TagTraffics(traffics) {
+		if !traffic.IsOutbound || traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		delta, ok := byTag[traffic.Tag]
+		if !ok {
+			delta = &outboundTrafficDelta{Tag: traffic.Tag}
+			byTag[traffic.Tag] = delta
+		}
+		delta.Up += traffic.Up
+		delta.Down += traffic.Down
+	}
+	deltas := make([]outboundTrafficDelta, 0, len(byTag))
+	for _, delta := range byTag {
+		deltas = append(deltas, *delta)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Tag < deltas[j].Tag
+	})
+	return deltas
+}
+
+// sendOutboundTrafficWebhook posts in the background, the traffic flush never waits for the endpoint
+func (s *OutboundService) sendOutboundTrafficWebhook(traffics []*xray.Traffic) {
+	settingService := SettingService{}
+	webhookUrl, err := settingService.GetOutboundTrafficWebhook()
+	if err != nil || webhookUrl == "" {
+		return
+	}
+	deltas := outboundTrafficDeltas(traffics)
+	if len(deltas) == 0 {
+		return
+	}
+	secret, err := settingService.GetOutboundTrafficWebhookSecret()
+	if err != nil {
+		logger.Warning("Failed to get outbound traffic webhook secret:", err)
+		return
+	}
+	body, err := json.Marshal(outboundTrafficPayload{
+		Timestamp: time.Now().Unix() * 1000,
+		Outbounds: deltas,
+	})
+	if err != nil {
+		logger.Warning("Failed to marshal outbound traffic webhook:", err)
+		return
+	}
+
+	go func() {
+		err := outboundWebhookRetry.Do(func() error {
+			return postOutboundTrafficWebhook(webhookUrl, secret, body)
+		})
+		if err != nil {
+			logger.Warningf("Failed to send outbound traffic to webhook %s, dropping deltas: %v", webhookUrl, err)
+		}
+	}()
+}
+
+func postOutboundTrafficWebhook(webhookUrl string, secret string, body []byte) error {
+	req, err := http.NewRequest("POST", webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := outboundWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
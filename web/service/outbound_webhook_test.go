@@ -0,0 +1,207 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+type webhookRequest struct {
+	body      []byte
+	signature string
+}
+
+// startWebhookServer answers with the given statuses in turn, then 200, and hands over every request
+func startWebhookServer(t *testing.T, statuses ...int) (string, chan webhookRequest) {
+	t.Helper()
+	requests := make(chan webhookRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{body: body, signature: r.Header.Get("X-Signature-256")}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+func receiveWebhook(t *testing.T, requests chan webhookRequest) webhookRequest {
+	t.Helper()
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+		return webhookRequest{}
+	}
+}
+
+func TestSetOutboundTrafficWebhook(t *testing.T) {
+	setupTestDB(t)
+	s := &OutboundService{}
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://billing.example.com/hook"},
+		{url: "http://127.0.0.1:8080/hook"},
+		{url: ""},
+		{url: "ftp://billing.example.com/hook", wantErr: true},
+		{url: "billing.example.com/hook", wantErr: true},
+		{url: "https://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := s.SetOutboundTrafficWebhook(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOutboundTrafficDeltas(t *testing.T) {
+	tests := []struct {
+		name     string
+		traffics []*xray.Traffic
+		want     []outboundTrafficDelta
+	}{
+		{name: "nothing", want: []outboundTrafficDelta{}},
+		{
+			name: "sorted by tag, inbounds and idle outbounds left out",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "warp", Up: 5, Down: 50},
+				{IsOutbound: true, Tag: "direct", Up: 1, Down: 2},
+				{IsOutbound: true, Tag: "blocked"},
+				{IsInbound: true, Tag: "inbound-443", Up: 9, Down: 9},
+			},
+			want: []outboundTrafficDelta{{Tag: "direct", Up: 1, Down: 2}, {Tag: "warp", Up: 5, Down: 50}},
+		},
+		{
+			name: "protocol counters are not counted twice",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "proxy", Up: 100, Down: 1000},
+				{IsOutbound: true, Tag: "proxy", Protocol: "tcp", Up: 70, Down: 900},
+				{IsOutbound: true, Tag: "proxy", Protocol: "udp", Up: 30, Down: 100},
+			},
+			want: []outboundTrafficDelta{{Tag: "proxy", Up: 100, Down: 1000}},
+		},
+		{
+			name: "protocol counters only",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "proxy", Protocol: "tcp", Up: 70, Down: 900},
+				{IsOutbound: true, Tag: "proxy", Protocol: "udp", Up: 30, Down: 100},
+			},
+			want: []outboundTrafficDelta{{Tag: "proxy", Up: 100, Down: 1000}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outboundTrafficDeltas(tt.traffics); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboundTrafficWebhook(t *testing.T) {
+	oldRetry := outboundWebhookRetry
+	outboundWebhookRetry.BaseBackoff = time.Millisecond
+	outboundWebhookRetry.MaxBackoff = time.Millisecond
+	t.Cleanup(func() { outboundWebhookRetry = oldRetry })
+
+	traffics := []*xray.Traffic{
+		{IsOutbound: true, Tag: "warp", Up: 5, Down: 50},
+		{IsOutbound: true, Tag: "direct", Up: 1, Down: 2},
+	}
+	wantDeltas := []outboundTrafficDelta{{Tag: "direct", Up: 1, Down: 2}, {Tag: "warp", Up: 5, Down: 50}}
+	tests := []struct {
+		name     string
+		secret   string
+		statuses []int
+	}{
+		{name: "unsigned"},
+		{name: "signed", secret: "s3cret"},
+		{name: "retried after a failure", statuses: []int{http.StatusBadGateway}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			s := &OutboundService{}
+			webhookUrl, requests := startWebhookServer(t, tt.statuses...)
+			if err := s.SetOutboundTrafficWebhook(webhookUrl); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetOutboundTrafficWebhookSecret(tt.secret); err != nil {
+				t.Fatal(err)
+			}
+			if err, _ := s.AddTraffic(traffics, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			req := receiveWebhook(t, requests)
+			for range tt.statuses {
+				req = receiveWebhook(t, requests)
+			}
+			var payload outboundTrafficPayload
+			if err := json.Unmarshal(req.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(payload.Outbounds, wantDeltas) {
+				t.Fatalf("got deltas %+v, want %+v", payload.Outbounds, wantDeltas)
+			}
+			if payload.Timestamp == 0 {
+				t.Fatal("payload has no timestamp")
+			}
+
+			wantSignature := ""
+			if tt.secret != "" {
+				mac := hmac.New(sha256.New, []byte(tt.secret))
+				mac.Write(req.body)
+				wantSignature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			}
+			if req.signature != wantSignature {
+				t.Fatalf("got signature %q, want %q", req.signature, wantSignature)
+			}
+		})
+	}
+}
+
+func TestOutboundTrafficWebhookSkipped(t *testing.T) {
+	setupTestDB(t)
+	s := &OutboundService{}
+	webhookUrl, requests := startWebhookServer(t)
+	if err := s.SetOutboundTrafficWebhook(webhookUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	// No outbound traffic, nothing to post
+	if err, _ := s.AddTraffic([]*xray.Traffic{{IsInbound: true, Tag: "inbound-443", Up: 1, Down: 1}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The flush fails, nothing was stored
+	if err := database.GetDB().Migrator().DropTable(&model.OutboundTraffics{}); err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := s.AddTraffic([]*xray.Traffic{{IsOutbound: true, Tag: "warp", Up: 1, Down: 1}}, nil); err == nil {
+		t.Fatal("flush without the traffic table succeeded")
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("webhook called with %s", req.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy is a bounded exponential backoff with jitter
+type RetryPolicy struct {
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Retryable reports whether an error is worth another attempt, all are when nil
+	Retryable func(err error) bool
+}
+
+// Backoff returns how long to wait after the given failed attempt, counted from 0
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	sleep := time.Duration(float64(p.BaseBackoff) * math.Pow(2, float64(attempt)))
+	if p.BaseBackoff > 0 {
+		sleep += time.Duration(rand.Int63n(int64(p.BaseBackoff)))
+	}
+	if sleep > p.MaxBackoff {
+		sleep = p.MaxBackoff
+	}
+	return sleep
+}
+
+// Do calls fn until it succeeds or the retries are used up, and returns the last error
+func (p RetryPolicy) Do(fn func() error) error {
+	var err error
+	for i := 0; i <= p.MaxRetries; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		if i < p.MaxRetries {
+			time.Sleep(p.Backoff(i))
+		}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"x-ui/xray"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 1, min: 200 * time.Millisecond, max: 300 * time.Millisecond},
+		{attempt: 2, min: 400 * time.Millisecond, max: 500 * time.Millisecond},
+		{attempt: 3, min: 800 * time.Millisecond, max: time.Second},
+		{attempt: 4, min: time.Second, max: time.Second},
+		{attempt: 20, min: time.Second, max: time.Second},
+	}
+	for _, tt := range tests {
+		// Jitter makes every call different, so look at a few
+		for i := 0; i < 20; i++ {
+			got := policy.Backoff(tt.attempt)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("attempt %d: got backoff %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+
+	if got := (RetryPolicy{MaxBackoff: time.Second}).Backoff(3); got != 0 {
+		t.Fatalf("got backoff %v without a base backoff, want 0", got)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+	tests := []struct {
+		name      string
+		failures  []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "first try", wantCalls: 1},
+		{name: "fails once then succeeds", failures: []error{errTransient}, wantCalls: 2},
+		{name: "retries used up", failures: []error{errTransient, errTransient, errTransient, errTransient}, wantCalls: 3, wantErr: errTransient},
+		{name: "not retryable", failures: []error{errFatal}, wantCalls: 1, wantErr: errFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := RetryPolicy{
+				MaxRetries:  2,
+				BaseBackoff: time.Millisecond,
+				MaxBackoff:  time.Millisecond,
+				Retryable:   func(err error) bool { return err != errFatal },
+			}
+			calls := 0
+			err := policy.Do(func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestXrayAPIRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"api not listening", status.Error(codes.Unavailable, "connection refused"), true},
+		{"other api error", status.Error(codes.Internal, "broken"), false},
+		{"partial traffic", &xray.PartialTrafficError{Err: status.Error(codes.Unavailable, "connection refused")}, false},
+		{"not a grpc error", errors.New("xray api is not initialized"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := xrayAPIRetry.Retryable(tt.err); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrafficRetryAfterRestart(t *testing.T) {
+	// The first query finds the api still starting
+	calls := 0
+	port := startStubAPI(t, func(method string, data []byte) ([]byte, error) {
+		if method != "/xray.app.stats.command.StatsService/QueryStats" {
+			return nil, status.Error(codes.Unimplemented, method)
+		}
+		calls++
+		if calls == 1 {
+			return nil, status.Error(codes.Unavailable, "connection refused")
+		}
+		var req statsService.QueryStatsRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		resp := &statsService.QueryStatsResponse{}
+		switch req.Pattern {
+		case "bound>>>":
+			resp.Stat = []*statsService.Stat{{Name: "inbound>>>inbound-20001>>>traffic>>>uplink", Value: 100}}
+		case "user>>>":
+			resp.Stat = []*statsService.Stat{{Name: "user>>>alice>>>traffic>>>downlink", Value: 200}}
+		}
+		return proto.Marshal(resp)
+	})
+	var api xray.XrayAPI
+	if err := api.Init(port); err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	var traffics []*xray.Traffic
+	var clientTraffics []*xray.ClientTraffic
+	err := xrayAPIRetry.Do(func() error {
+		var err error
+		traffics, clientTraffics, err = api.GetTraffic(true)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One failed query, then one per pattern
+	if calls != 3 {
+		t.Fatalf("got %d queries, want 3", calls)
+	}
+	if len(traffics) != 1 || traffics[0].Tag != "inbound-20001" || traffics[0].Up != 100 {
+		t.Fatalf("got traffics %+v", traffics)
+	}
+	if len(clientTraffics) != 1 || clientTraffics[0].Email != "alice" || clientTraffics[0].Down != 200 {
+		t.Fatalf("got client traffics %+v", clientTraffics)
+	}
+}
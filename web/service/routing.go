@@ -0,0 +1,239 @@
+package service
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+type RoutingService struct{}
+
+var routingProtocols = map[string]bool{"http": true, "tls": true, "quic": true, "bittorrent": true}
+
+// splitRuleList turns a comma-separated rule field into its items, skipping empty ones
+func splitRuleList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetRules returns the routing rules in the order they are added to the config
+func (s *RoutingService) GetRules() ([]*model.RoutingRule, error) {
+	db := database.GetDB()
+	var rules []*model.RoutingRule
+	err := db.Model(model.RoutingRule{}).Order("priority, id").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *RoutingService) GetRule(id int) (*model.RoutingRule, error) {
+	db := database.GetDB()
+	rule := &model.RoutingRule{}
+	err := db.Model(model.RoutingRule{}).First(rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *RoutingService) AddRule(rule *model.RoutingRule) (*model.RoutingRule, error) {
+	err := s.checkRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.Id = 0
+	db := database.GetDB()
+	err = db.Create(rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *RoutingService) UpdateRule(rule *model.RoutingRule) (*model.RoutingRule, error) {
+	err := s.checkRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.GetRule(rule.Id); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	err = db.Save(rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *RoutingService) DelRule(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.RoutingRule{}, id).Error
+}
+
+// checkRule normalizes the list fields and validates them the way xray reads a field rule
+func (s *RoutingService) checkRule(rule *model.RoutingRule) error {
+	rule.OutboundTag = strings.TrimSpace(rule.OutboundTag)
+	rule.BalancerTag = strings.TrimSpace(rule.BalancerTag)
+	if (rule.OutboundTag == "") == (rule.BalancerTag == "") {
+		return common.NewError("a rule needs either an outbound tag or a balancer tag")
+	}
+
+	domains := splitRuleList(rule.Domain)
+	for _, domain := range domains {
+		if err := checkDomainMatcher(domain); err != nil {
+			return err
+		}
+	}
+	ips := splitRuleList(rule.Ip)
+	for _, ip := range ips {
+		if err := checkRuleIP(ip); err != nil {
+			return err
+		}
+	}
+	ports := splitRuleList(rule.Port)
+	for _, port := range ports {
+		if err := checkRulePort(port); err != nil {
+			return err
+		}
+	}
+	networks := splitRuleList(strings.ToLower(rule.Network))
+	for _, network := range networks {
+		if network != "tcp" && network != "udp" {
+			return common.NewErrorf("invalid network %q", network)
+		}
+	}
+	protocols := splitRuleList(strings.ToLower(rule.Protocol))
+	for _, protocol := range protocols {
+		if !routingProtocols[protocol] {
+			return common.NewErrorf("invalid protocol %q", protocol)
+		}
+	}
+	inboundTags := splitRuleList(rule.InboundTag)
+	if len(domains)+len(ips)+len(ports)+len(networks)+len(protocols)+len(inboundTags) == 0 {
+		return common.NewError("a rule needs at least one condition")
+	}
+
+	rule.Domain = strings.Join(domains, ",")
+	rule.Ip = strings.Join(ips, ",")
+	rule.Port = strings.Join(ports, ",")
+	rule.Network = strings.Join(networks, ",")
+	rule.Protocol = strings.Join(protocols, ",")
+	rule.InboundTag = strings.Join(inboundTags, ",")
+	return nil
+}
+
+// checkRuleIP accepts an ip, a cidr and the geoip: and ext: forms
+func checkRuleIP(ip string) error {
+	for _, prefix := range []string{"geoip:", "ext:"} {
+		if strings.HasPrefix(ip, prefix) {
+			if strings.TrimPrefix(ip, prefix) == "" {
+				return common.NewErrorf("invalid ip %q", ip)
+			}
+			return nil
+		}
+	}
+	if _, _, err := net.ParseCIDR(ip); err == nil {
+		return nil
+	}
+	if net.ParseIP(ip) == nil {
+		return common.NewErrorf("invalid ip %q", ip)
+	}
+	return nil
+}
+
+// checkRulePort accepts a port or a from-to range
+func checkRulePort(port string) error {
+	from, to, isRange := strings.Cut(port, "-")
+	if !isRange {
+		to = from
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(from))
+	end, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+		return common.NewErrorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// applyRoutingRules adds the enabled panel rules after the template ones. A rule whose outbound
+// or balancer is not in the config is skipped, xray would refuse it.
+func (s *XrayService) applyRoutingRules(xrayConfig *xray.Config) error {
+	routingService := RoutingService{}
+	dbRules, err := routingService.GetRules()
+	if err != nil {
+		return err
+	}
+	if len(dbRules) == 0 {
+		return nil
+	}
+	tags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	balancerTags := map[string]bool{}
+	balancers, _ := routing["balancers"].([]interface{})
+	for _, b := range balancers {
+		if m, ok := b.(map[string]interface{}); ok {
+			tag, _ := m["tag"].(string)
+			balancerTags[tag] = true
+		}
+	}
+
+	var rules []interface{}
+	for _, dbRule := range dbRules {
+		if !dbRule.Enable {
+			continue
+		}
+		rule := map[string]interface{}{"type": "field"}
+		if dbRule.BalancerTag != "" {
+			if !balancerTags[dbRule.BalancerTag] {
+				logger.Warningf("Skip routing rule %d, the config has no balancer %s", dbRule.Id, dbRule.BalancerTag)
+				continue
+			}
+			rule["balancerTag"] = dbRule.BalancerTag
+		} else {
+			if !tags[dbRule.OutboundTag] {
+				logger.Warningf("Skip routing rule %d, the config has no outbound %s", dbRule.Id, dbRule.OutboundTag)
+				continue
+			}
+			rule["outboundTag"] = dbRule.OutboundTag
+		}
+		if domains := splitRuleList(dbRule.Domain); len(domains) > 0 {
+			rule["domain"] = domains
+		}
+		if ips := splitRuleList(dbRule.Ip); len(ips) > 0 {
+			rule["ip"] = ips
+		}
+		if dbRule.Port != "" {
+			rule["port"] = dbRule.Port
+		}
+		if dbRule.Network != "" {
+			rule["network"] = dbRule.Network
+		}
+		if protocols := splitRuleList(dbRule.Protocol); len(protocols) > 0 {
+			rule["protocol"] = protocols
+		}
+		if inboundTags := splitRuleList(dbRule.InboundTag); len(inboundTags) > 0 {
+			rule["inboundTag"] = inboundTags
+		}
+		rules = append(rules, rule)
+	}
+	return appendRoutingRules(xrayConfig, rules)
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +13,8 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -37,6 +41,9 @@ const (
 	Running ProcessState = "running"
 	Stop    ProcessState = "stop"
 	Error   ProcessState = "error"
+	Standby ProcessState = "standby"
+	// No inbound is enabled, xray runs with the api inbound only or is stopped
+	NoInbounds ProcessState = "noInbounds"
 )
 
 type Status struct {
@@ -61,6 +68,7 @@ type Status struct {
 		State    ProcessState `json:"state"`
 		ErrorMsg string       `json:"errorMsg"`
 		Version  string       `json:"version"`
+		Usage    *XrayUsage   `json:"usage"`
 	} `json:"xray"`
 	Uptime   uint64    `json:"uptime"`
 	Loads    []float64 `json:"loads"`
@@ -133,8 +141,8 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	}
 
 	status.LogicalPro = runtime.NumCPU()
-	if p != nil && p.IsRunning() {
-		status.AppStats.Uptime = p.GetUptime()
+	if process := mainXrayProcess(); process != nil && process.IsRunning() {
+		status.AppStats.Uptime = process.GetUptime()
 	} else {
 		status.AppStats.Uptime = 0
 	}
@@ -220,26 +228,23 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	status.PublicIP.IPv4 = getPublicIP("https://api.ipify.org")
 	status.PublicIP.IPv6 = getPublicIP("https://api6.ipify.org")
 
-	if s.xrayService.IsXrayRunning() {
-		status.Xray.State = Running
+	status.Xray.State = s.xrayService.Status()
+	if status.Xray.State == Running || status.Xray.State == Standby {
 		status.Xray.ErrorMsg = ""
 	} else {
-		err := s.xrayService.GetXrayErr()
-		if err != nil {
-			status.Xray.State = Error
-		} else {
-			status.Xray.State = Stop
-		}
 		status.Xray.ErrorMsg = s.xrayService.GetXrayResult()
 	}
 	status.Xray.Version = s.xrayService.GetXrayVersion()
+	if usage, err := s.xrayService.GetXrayUsage(); err == nil {
+		status.Xray.Usage = usage
+	}
 	var rtm runtime.MemStats
 	runtime.ReadMemStats(&rtm)
 
 	status.AppStats.Mem = rtm.Sys
 	status.AppStats.Threads = uint32(runtime.NumGoroutine())
-	if p != nil && p.IsRunning() {
-		status.AppStats.Uptime = p.GetUptime()
+	if process := mainXrayProcess(); process != nil && process.IsRunning() {
+		status.AppStats.Uptime = process.GetUptime()
 	} else {
 		status.AppStats.Uptime = 0
 	}
@@ -298,6 +303,21 @@ func (s *ServerService) RestartXrayService() (string error) {
 	return nil
 }
 
+// Release tags look like v1.8.24, anything else must not reach a file path
+var xrayVersionRegex = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// How long a switched core must keep running before the switch counts as done
+const xraySwitchCheck = 3 * time.Second
+
+func getXrayVersionsFolder() string {
+	return config.GetBinFolderPath() + "/versions"
+}
+
+// getStoredXrayPath is where a downloaded version is kept, next to the others
+func getStoredXrayPath(version string) string {
+	return filepath.Join(getXrayVersionsFolder(), version, xray.GetBinaryName())
+}
+
 func (s *ServerService) downloadXRay(version string) (string, error) {
 	osName := runtime.GOOS
 	arch := runtime.GOARCH
@@ -326,11 +346,18 @@ func (s *ServerService) downloadXRay(version string) (string, error) {
 
 	fileName := fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
 	url := fmt.Sprintf("https://github.com/XTLS/Xray-core/releases/download/%s/%s", version, fileName)
+	checksum, err := s.getXrayChecksum(url + ".dgst")
+	if err != nil {
+		return "", err
+	}
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("download %s failed: %s", fileName, resp.Status)
+	}
 
 	os.Remove(fileName)
 	file, err := os.Create(fileName)
@@ -339,67 +366,193 @@ func (s *ServerService) downloadXRay(version string) (string, error) {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
 	if err != nil {
+		os.Remove(fileName)
 		return "", err
 	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		os.Remove(fileName)
+		return "", common.NewErrorf("checksum mismatch of %s: got %s, want %s", fileName, sum, checksum)
+	}
 
 	return fileName, nil
 }
 
-func (s *ServerService) UpdateXray(version string) error {
-	zipFileName, err := s.downloadXRay(version)
+// getXrayChecksum reads the SHA2-256 line of the digest file published with every release asset
+func (s *ServerService) getXrayChecksum(url string) (string, error) {
+	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("download checksum failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if sum, found := strings.CutPrefix(strings.TrimSpace(line), "SHA2-256="); found {
+			return strings.ToLower(strings.TrimSpace(sum)), nil
+		}
+	}
+	return "", common.NewError("no SHA2-256 checksum in", url)
+}
+
+// installXrayVersion downloads a version into the versions folder, unless it is already there
+func (s *ServerService) installXrayVersion(version string) (string, error) {
+	storedPath := getStoredXrayPath(version)
+	if _, err := os.Stat(storedPath); err == nil {
+		return storedPath, nil
 	}
 
-	zipFile, err := os.Open(zipFileName)
+	zipFileName, err := s.downloadXRay(version)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer func() {
-		zipFile.Close()
-		os.Remove(zipFileName)
-	}()
+	defer os.Remove(zipFileName)
+	reader, err := zip.OpenReader(zipFileName)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
 
-	stat, err := zipFile.Stat()
+	zipFile, err := reader.Open("xray")
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer zipFile.Close()
+	if err = os.MkdirAll(filepath.Dir(storedPath), 0o755); err != nil {
+		return "", err
 	}
-	reader, err := zip.NewReader(zipFile, stat.Size())
+	// Written under another name first, a half copied binary is never picked up as installed
+	tmpPath := storedPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fs.ModePerm)
 	if err != nil {
-		return err
+		return "", err
+	}
+	_, err = io.Copy(file, zipFile)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
 	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return storedPath, os.Rename(tmpPath, storedPath)
+}
 
-	s.xrayService.StopXray()
-	defer func() {
-		err := s.xrayService.RestartXray(true)
-		if err != nil {
-			logger.Error("start xray failed:", err)
+// GetInstalledXrayVersions returns the versions kept in the versions folder
+func (s *ServerService) GetInstalledXrayVersions() ([]string, error) {
+	entries, err := os.ReadDir(getXrayVersionsFolder())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !xrayVersionRegex.MatchString(entry.Name()) {
+			continue
 		}
-	}()
-
-	copyZipFile := func(zipName string, fileName string) error {
-		zipFile, err := reader.Open(zipName)
-		if err != nil {
-			return err
+		if _, err := os.Stat(getStoredXrayPath(entry.Name())); err == nil {
+			versions = append(versions, entry.Name())
 		}
-		os.Remove(fileName)
-		file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fs.ModePerm)
-		if err != nil {
+	}
+	return versions, nil
+}
+
+// activateXrayBinary copies a binary over the active one. The active binary is renamed to the
+// backup path, so it can be restored.
+func activateXrayBinary(source string, backupPath string) error {
+	activePath := xray.GetBinaryPath()
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmpPath := activePath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if backupPath != "" {
+		os.Remove(backupPath)
+		if err = os.Rename(activePath, backupPath); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
 			return err
 		}
-		defer file.Close()
-		_, err = io.Copy(file, zipFile)
+	}
+	return os.Rename(tmpPath, activePath)
+}
+
+// startSwitchedXray restarts Xray on the new binary and checks it keeps running for a moment
+func (s *ServerService) startSwitchedXray() error {
+	err := s.xrayService.RestartXray(true)
+	if err != nil || s.xrayService.isStandby() {
 		return err
 	}
+	for waited := time.Duration(0); waited < xraySwitchCheck; waited += 500 * time.Millisecond {
+		time.Sleep(500 * time.Millisecond)
+		if !s.xrayService.IsXrayRunning() {
+			if err := s.xrayService.GetXrayErr(); err != nil {
+				return err
+			}
+			return common.NewError("xray exited after the switch")
+		}
+	}
+	return nil
+}
 
-	err = copyZipFile("xray", xray.GetBinaryPath())
+// UpdateXray switches to the version, downloading it first when it is not kept yet. When the new
+// core does not start, the previous binary is put back and started again.
+func (s *ServerService) UpdateXray(version string) error {
+	if !xrayVersionRegex.MatchString(version) {
+		return common.NewErrorf("invalid xray version %q", version)
+	}
+	storedPath, err := s.installXrayVersion(version)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	backupPath := xray.GetBinaryPath() + ".prev"
+	if err = activateXrayBinary(storedPath, backupPath); err != nil {
+		return err
+	}
+	err = s.startSwitchedXray()
+	if err == nil {
+		os.Remove(backupPath)
+		logger.Infof("Switched xray to %s", version)
+		return nil
+	}
+
+	logger.Errorf("Xray %s failed to start, rolling back: %v", version, err)
+	if _, statErr := os.Stat(backupPath); statErr != nil {
+		return common.NewErrorf("xray %s failed to start and there is no previous binary: %v", version, err)
+	}
+	if renameErr := os.Rename(backupPath, xray.GetBinaryPath()); renameErr != nil {
+		return common.NewErrorf("xray %s failed to start, rollback failed: %v", version, renameErr)
+	}
+	if restartErr := s.xrayService.RestartXray(true); restartErr != nil {
+		logger.Error("start xray failed after rollback:", restartErr)
+	}
+	return common.NewErrorf("xray %s failed to start, rolled back: %v", version, err)
+}
+
+func (s *ServerService) UpdateGeoFiles() ([]GeoFileResult, error) {
+	return s.xrayService.UpdateGeoFiles()
 }
 
 func (s *ServerService) GetLogs(count string, level string, syslog string) []string {
@@ -565,27 +718,22 @@ func (s *ServerService) ImportDB(file multipart.File) error {
 	return nil
 }
 
+// GetNewX25519Cert returns a reality key pair, generated the way xray x25519 does it so the
+// xray binary is not needed, with a set of random short ids
 func (s *ServerService) GetNewX25519Cert() (interface{}, error) {
-	// Run the command
-	cmd := exec.Command(xray.GetBinaryPath(), "x25519")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	privateKey, publicKey, err := generateRealityKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	shortIds, err := generateRealityShortIds(8)
 	if err != nil {
 		return nil, err
 	}
-
-	lines := strings.Split(out.String(), "\n")
-
-	privateKeyLine := strings.Split(lines[0], ":")
-	publicKeyLine := strings.Split(lines[1], ":")
-
-	privateKey := strings.TrimSpace(privateKeyLine[1])
-	publicKey := strings.TrimSpace(publicKeyLine[1])
 
 	keyPair := map[string]interface{}{
 		"privateKey": privateKey,
 		"publicKey":  publicKey,
+		"shortIds":   shortIds,
 	}
 
 	return keyPair, nil
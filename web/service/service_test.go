@@ -0,0 +1,47 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+// setupTestDB opens a fresh database for the test and closes it when the test ends
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	if err := database.InitDB(filepath.Join(t.TempDir(), "x-ui.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.CloseDB()
+	})
+}
+
+// addTestInbound stores a VLESS inbound with one client named after the tag
+func addTestInbound(t *testing.T, port int, tag string, enable bool) *model.Inbound {
+	t.Helper()
+	inbound := &model.Inbound{
+		Port:     port,
+		Tag:      tag,
+		Enable:   enable,
+		Protocol: model.VLESS,
+		Settings: `{"clients": [{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "` + tag + `@test", "enable": true}], "decryption": "none"}`,
+		Sniffing: `{"enabled": false}`,
+	}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	return inbound
+}
+
+// addTestClient stores the traffic row that makes the email a known client
+func addTestClient(t *testing.T, inboundId int, email string) {
+	t.Helper()
+	err := database.GetDB().Create(&xray.ClientTraffic{InboundId: inboundId, Email: email, Enable: true}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
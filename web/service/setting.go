@@ -24,49 +24,110 @@ import (
 var xrayTemplateConfig string
 
 var defaultValueMap = map[string]string{
-	"xrayTemplateConfig": xrayTemplateConfig,
-	"webListen":          "",
-	"webDomain":          "",
-	"webPort":            "2053",
-	"webCertFile":        "",
-	"webKeyFile":         "",
-	"secret":             random.Seq(32),
-	"webBasePath":        "/",
-	"sessionMaxAge":      "60",
-	"pageSize":           "50",
-	"expireDiff":         "0",
-	"trafficDiff":        "0",
-	"remarkModel":        "-ieo",
-	"timeLocation":       "Asia/Tehran",
-	"tgBotEnable":        "false",
-	"tgBotToken":         "",
-	"tgBotProxy":         "",
-	"tgBotChatId":        "",
-	"tgRunTime":          "@daily",
-	"tgBotBackup":        "false",
-	"tgBotLoginNotify":   "true",
-	"tgCpu":              "80",
-	"tgLang":             "en-US",
-	"secretEnable":       "false",
-	"subEnable":          "false",
-	"subListen":          "",
-	"subPort":            "2096",
-	"subPath":            "/sub/",
-	"subDomain":          "",
-	"subCertFile":        "",
-	"subKeyFile":         "",
-	"subUpdates":         "12",
-	"subEncrypt":         "true",
-	"subShowInfo":        "true",
-	"subURI":             "",
-	"subJsonPath":        "/json/",
-	"subJsonURI":         "",
-	"subJsonFragment":    "",
-	"subJsonNoises":      "",
-	"subJsonMux":         "",
-	"subJsonRules":       "",
-	"datepicker":         "gregorian",
-	"warp":               "",
+	"xrayTemplateConfig":           xrayTemplateConfig,
+	"webListen":                    "",
+	"webDomain":                    "",
+	"webPort":                      "2053",
+	"webCertFile":                  "",
+	"webKeyFile":                   "",
+	"secret":                       random.Seq(32),
+	"webBasePath":                  "/",
+	"sessionMaxAge":                "60",
+	"pageSize":                     "50",
+	"expireDiff":                   "0",
+	"trafficDiff":                  "0",
+	"remarkModel":                  "-ieo",
+	"timeLocation":                 "Asia/Tehran",
+	"tgBotEnable":                  "false",
+	"tgBotToken":                   "",
+	"tgBotProxy":                   "",
+	"tgBotChatId":                  "",
+	"tgRunTime":                    "@daily",
+	"tgBotBackup":                  "false",
+	"tgBotLoginNotify":             "true",
+	"tgCpu":                        "80",
+	"tgLang":                       "en-US",
+	"secretEnable":                 "false",
+	"subEnable":                    "false",
+	"subListen":                    "",
+	"subPort":                      "2096",
+	"subPath":                      "/sub/",
+	"subDomain":                    "",
+	"subCertFile":                  "",
+	"subKeyFile":                   "",
+	"subUpdates":                   "12",
+	"subEncrypt":                   "true",
+	"subShowInfo":                  "true",
+	"subURI":                       "",
+	"subJsonPath":                  "/json/",
+	"subJsonURI":                   "",
+	"subJsonFragment":              "",
+	"subJsonNoises":                "",
+	"subJsonMux":                   "",
+	"subJsonRules":                 "",
+	"datepicker":                   "gregorian",
+	"warp":                         "",
+	"outboundChains":               "",
+	"publicAddress":                "",
+	"publicAddressMode":            "http",
+	"publicAddressEcho":            "https://api.ipify.org",
+	"publicAddressStun":            "stun.l.google.com:19302",
+	"sockoptKeepAlive":             "0",
+	"sockoptFastOpen":              "",
+	"clientOutbounds":              "",
+	"inboundValidation":            "warn",
+	"metricsPushClients":           "false",
+	"xrayStandby":                  "false",
+	"xrayRestartSchedule":          "",
+	"statsAutoInject":              "false",
+	"subUpdateIntervals":           "",
+	"tlsAlpn":                      "",
+	"tlsFingerprint":               "",
+	"realityRotations":             "",
+	"outboundDns":                  "",
+	"domainOutbounds":              "",
+	"clientTimeWindows":            "",
+	"blockRules":                   "",
+	"outboundTrafficWebhook":       "",
+	"outboundTrafficWebhookSecret": "",
+	"configPatch":                  "",
+	"outboundHealthProbe":          "false",
+	"outboundHealthExclude":        "false",
+	"maxClientsPerInbound":         "0",
+	"balancers":                    "",
+	"outboundSendThrough":          "",
+	"clientGroups":                 "",
+	"realityConflicts":             "warn",
+	"maintenanceMode":              "false",
+	"inboundPolicies":              "",
+	"deviceLimits":                 "",
+	"deviceLimitAction":            "remove",
+	"deviceLimitCooldown":          "10",
+	"emptyInbounds":                "noop",
+	"sniffingExclusions":           "",
+	"warpMtu":                      "0",
+	"warpWorkers":                  "0",
+	"xrayConfigCheck":              "false",
+	"xrayHotReload":                "false",
+	"observatory":                  "",
+	"dnsSettings":                  "",
+	"geoUpdateRuntime":             "",
+	"geoipUrl":                     "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat",
+	"geositeUrl":                   "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat",
+	"geoChecksum":                  "true",
+	"freedomSettings":              "",
+	"inboundAccessLog":             "false",
+	"xrayTemplateProfile":          "default",
+	"xrayTemplateProfiles":         "",
+	"reverseProxies":               "",
+	"policySettings":               "",
+	"xrayApiPortFallback":          "",
+	"bandwidthLimits":              "",
+	"xrayInstances":                "",
+	"xrayCpuLimit":                 "0",
+	"xrayMemoryLimit":              "0",
+	"raiseOpenFiles":               "false",
+	"xrayRestartDrain":             "0",
 }
 
 type SettingService struct{}
@@ -180,16 +241,25 @@ func (s *SettingService) saveSetting(key string, value string) error {
 	setting, err := s.getSetting(key)
 	db := database.GetDB()
 	if database.IsNotFound(err) {
-		return db.Create(&model.Setting{
+		err = db.Create(&model.Setting{
 			Key:   key,
 			Value: value,
 		}).Error
+		if err == nil {
+			notifySettingChanged(key)
+		}
+		return err
 	} else if err != nil {
 		return err
 	}
+	changed := setting.Value != value
 	setting.Key = key
 	setting.Value = value
-	return db.Save(setting).Error
+	err = db.Save(setting).Error
+	if err == nil && changed {
+		notifySettingChanged(key)
+	}
+	return err
 }
 
 func (s *SettingService) getString(key string) (string, error) {
@@ -435,6 +505,59 @@ func (s *SettingService) GetSubUpdates() (string, error) {
 	return s.getString("subUpdates")
 }
 
+// GetSubUpdateIntervals returns the update interval in hours configured per subscription id
+func (s *SettingService) GetSubUpdateIntervals() (map[string]int, error) {
+	data, err := s.getString("subUpdateIntervals")
+	if err != nil {
+		return nil, err
+	}
+	intervals := map[string]int{}
+	if data == "" {
+		return intervals, nil
+	}
+	err = json.Unmarshal([]byte(data), &intervals)
+	if err != nil {
+		return nil, err
+	}
+	return intervals, nil
+}
+
+// GetSubUpdateInterval returns the update interval of a subscription, the global subUpdates if it has none
+func (s *SettingService) GetSubUpdateInterval(subId string) (string, error) {
+	intervals, err := s.GetSubUpdateIntervals()
+	if err != nil {
+		return "", err
+	}
+	if hours, ok := intervals[subId]; ok {
+		return strconv.Itoa(hours), nil
+	}
+	return s.GetSubUpdates()
+}
+
+// SetSubUpdateInterval sets the update interval of a subscription in hours, 0 falls back to the global one
+func (s *SettingService) SetSubUpdateInterval(subId string, hours int) error {
+	if subId == "" {
+		return common.NewError("subscription id is empty")
+	}
+	if hours < 0 {
+		return common.NewErrorf("invalid update interval: %d", hours)
+	}
+	intervals, err := s.GetSubUpdateIntervals()
+	if err != nil {
+		return err
+	}
+	if hours == 0 {
+		delete(intervals, subId)
+	} else {
+		intervals[subId] = hours
+	}
+	data, err := json.Marshal(intervals)
+	if err != nil {
+		return err
+	}
+	return s.setString("subUpdateIntervals", string(data))
+}
+
 func (s *SettingService) GetSubEncrypt() (bool, error) {
 	return s.getBool("subEncrypt")
 }
@@ -483,6 +606,414 @@ func (s *SettingService) SetWarp(data string) error {
 	return s.setString("warp", data)
 }
 
+func (s *SettingService) GetOutboundChains() (string, error) {
+	return s.getString("outboundChains")
+}
+
+func (s *SettingService) SetOutboundChains(data string) error {
+	return s.setString("outboundChains", data)
+}
+
+func (s *SettingService) GetPublicAddress() (string, error) {
+	return s.getString("publicAddress")
+}
+
+func (s *SettingService) SetPublicAddress(address string) error {
+	return s.setString("publicAddress", address)
+}
+
+func (s *SettingService) GetPublicAddressMode() (string, error) {
+	return s.getString("publicAddressMode")
+}
+
+func (s *SettingService) GetPublicAddressEcho() (string, error) {
+	return s.getString("publicAddressEcho")
+}
+
+func (s *SettingService) GetPublicAddressStun() (string, error) {
+	return s.getString("publicAddressStun")
+}
+
+func (s *SettingService) GetSockoptKeepAlive() (int, error) {
+	return s.getInt("sockoptKeepAlive")
+}
+
+func (s *SettingService) SetSockoptKeepAlive(interval int) error {
+	return s.setInt("sockoptKeepAlive", interval)
+}
+
+func (s *SettingService) GetSockoptFastOpen() (string, error) {
+	return s.getString("sockoptFastOpen")
+}
+
+func (s *SettingService) SetSockoptFastOpen(value string) error {
+	return s.setString("sockoptFastOpen", value)
+}
+
+func (s *SettingService) GetClientOutbounds() (string, error) {
+	return s.getString("clientOutbounds")
+}
+
+func (s *SettingService) SetClientOutbounds(data string) error {
+	return s.setString("clientOutbounds", data)
+}
+
+func (s *SettingService) GetInboundValidation() (string, error) {
+	return s.getString("inboundValidation")
+}
+
+func (s *SettingService) SetInboundValidation(mode string) error {
+	return s.setString("inboundValidation", mode)
+}
+
+func (s *SettingService) GetMetricsPushClients() (bool, error) {
+	return s.getBool("metricsPushClients")
+}
+
+func (s *SettingService) GetXrayStandby() (bool, error) {
+	return s.getBool("xrayStandby")
+}
+
+func (s *SettingService) SetXrayStandby(standby bool) error {
+	return s.setBool("xrayStandby", standby)
+}
+
+func (s *SettingService) GetXrayRestartSchedule() (string, error) {
+	return s.getString("xrayRestartSchedule")
+}
+
+func (s *SettingService) SetXrayRestartSchedule(spec string) error {
+	return s.setString("xrayRestartSchedule", spec)
+}
+
+func (s *SettingService) GetStatsAutoInject() (bool, error) {
+	return s.getBool("statsAutoInject")
+}
+
+func (s *SettingService) SetStatsAutoInject(enable bool) error {
+	return s.setBool("statsAutoInject", enable)
+}
+
+func (s *SettingService) GetTlsAlpn() (string, error) {
+	return s.getString("tlsAlpn")
+}
+
+func (s *SettingService) SetTlsAlpn(alpn string) error {
+	return s.setString("tlsAlpn", alpn)
+}
+
+func (s *SettingService) GetTlsFingerprint() (string, error) {
+	return s.getString("tlsFingerprint")
+}
+
+func (s *SettingService) SetTlsFingerprint(fingerprint string) error {
+	return s.setString("tlsFingerprint", fingerprint)
+}
+
+func (s *SettingService) GetRealityRotations() (string, error) {
+	return s.getString("realityRotations")
+}
+
+func (s *SettingService) SetRealityRotations(data string) error {
+	return s.setString("realityRotations", data)
+}
+
+func (s *SettingService) GetOutboundDns() (string, error) {
+	return s.getString("outboundDns")
+}
+
+func (s *SettingService) SetOutboundDns(data string) error {
+	return s.setString("outboundDns", data)
+}
+
+func (s *SettingService) GetDomainOutbounds() (string, error) {
+	return s.getString("domainOutbounds")
+}
+
+func (s *SettingService) SetDomainOutbounds(data string) error {
+	return s.setString("domainOutbounds", data)
+}
+
+func (s *SettingService) GetBlockRules() (string, error) {
+	return s.getString("blockRules")
+}
+
+func (s *SettingService) SetBlockRules(data string) error {
+	return s.setString("blockRules", data)
+}
+
+func (s *SettingService) GetClientTimeWindows() (string, error) {
+	return s.getString("clientTimeWindows")
+}
+
+func (s *SettingService) SetClientTimeWindows(data string) error {
+	return s.setString("clientTimeWindows", data)
+}
+
+func (s *SettingService) GetOutboundTrafficWebhook() (string, error) {
+	return s.getString("outboundTrafficWebhook")
+}
+
+func (s *SettingService) SetOutboundTrafficWebhook(url string) error {
+	return s.setString("outboundTrafficWebhook", url)
+}
+
+func (s *SettingService) GetOutboundTrafficWebhookSecret() (string, error) {
+	return s.getString("outboundTrafficWebhookSecret")
+}
+
+func (s *SettingService) SetOutboundTrafficWebhookSecret(secret string) error {
+	return s.setString("outboundTrafficWebhookSecret", secret)
+}
+
+func (s *SettingService) GetConfigPatch() (string, error) {
+	return s.getString("configPatch")
+}
+
+func (s *SettingService) SetConfigPatch(patch string) error {
+	return s.setString("configPatch", patch)
+}
+
+func (s *SettingService) GetOutboundHealthProbe() (bool, error) {
+	return s.getBool("outboundHealthProbe")
+}
+
+func (s *SettingService) SetOutboundHealthProbe(value bool) error {
+	return s.setBool("outboundHealthProbe", value)
+}
+
+func (s *SettingService) GetOutboundHealthExclude() (bool, error) {
+	return s.getBool("outboundHealthExclude")
+}
+
+func (s *SettingService) SetOutboundHealthExclude(value bool) error {
+	return s.setBool("outboundHealthExclude", value)
+}
+
+func (s *SettingService) GetMaxClientsPerInbound() (int, error) {
+	return s.getInt("maxClientsPerInbound")
+}
+
+func (s *SettingService) SetMaxClientsPerInbound(n int) error {
+	return s.setInt("maxClientsPerInbound", n)
+}
+
+func (s *SettingService) GetBalancers() (string, error) {
+	return s.getString("balancers")
+}
+
+func (s *SettingService) SetBalancers(data string) error {
+	return s.setString("balancers", data)
+}
+
+func (s *SettingService) GetOutboundSendThrough() (string, error) {
+	return s.getString("outboundSendThrough")
+}
+
+func (s *SettingService) SetOutboundSendThrough(data string) error {
+	return s.setString("outboundSendThrough", data)
+}
+
+func (s *SettingService) GetClientGroups() (string, error) {
+	return s.getString("clientGroups")
+}
+
+func (s *SettingService) SetClientGroups(data string) error {
+	return s.setString("clientGroups", data)
+}
+
+func (s *SettingService) GetRealityConflicts() (string, error) {
+	return s.getString("realityConflicts")
+}
+
+func (s *SettingService) SetRealityConflicts(mode string) error {
+	return s.setString("realityConflicts", mode)
+}
+
+func (s *SettingService) GetMaintenanceMode() (bool, error) {
+	return s.getBool("maintenanceMode")
+}
+
+func (s *SettingService) SetMaintenanceMode(enable bool) error {
+	return s.setBool("maintenanceMode", enable)
+}
+
+func (s *SettingService) GetInboundPolicies() (string, error) {
+	return s.getString("inboundPolicies")
+}
+
+func (s *SettingService) SetInboundPolicies(data string) error {
+	return s.setString("inboundPolicies", data)
+}
+
+func (s *SettingService) GetDeviceLimits() (string, error) {
+	return s.getString("deviceLimits")
+}
+
+func (s *SettingService) SetDeviceLimits(data string) error {
+	return s.setString("deviceLimits", data)
+}
+
+func (s *SettingService) GetDeviceLimitAction() (string, error) {
+	return s.getString("deviceLimitAction")
+}
+
+func (s *SettingService) SetDeviceLimitAction(action string) error {
+	return s.setString("deviceLimitAction", action)
+}
+
+func (s *SettingService) GetDeviceLimitCooldown() (int, error) {
+	return s.getInt("deviceLimitCooldown")
+}
+
+func (s *SettingService) SetDeviceLimitCooldown(minutes int) error {
+	return s.setInt("deviceLimitCooldown", minutes)
+}
+
+func (s *SettingService) GetEmptyInbounds() (string, error) {
+	return s.getString("emptyInbounds")
+}
+
+func (s *SettingService) SetEmptyInbounds(mode string) error {
+	return s.setString("emptyInbounds", mode)
+}
+
+func (s *SettingService) GetSniffingExclusions() (string, error) {
+	return s.getString("sniffingExclusions")
+}
+
+func (s *SettingService) SetSniffingExclusions(data string) error {
+	return s.setString("sniffingExclusions", data)
+}
+
+func (s *SettingService) GetWarpMtu() (int, error) {
+	return s.getInt("warpMtu")
+}
+
+func (s *SettingService) GetWarpWorkers() (int, error) {
+	return s.getInt("warpWorkers")
+}
+
+func (s *SettingService) GetXrayConfigCheck() (bool, error) {
+	return s.getBool("xrayConfigCheck")
+}
+
+func (s *SettingService) SetXrayConfigCheck(enable bool) error {
+	return s.setBool("xrayConfigCheck", enable)
+}
+
+func (s *SettingService) GetXrayHotReload() (bool, error) {
+	return s.getBool("xrayHotReload")
+}
+
+func (s *SettingService) SetXrayHotReload(enable bool) error {
+	return s.setBool("xrayHotReload", enable)
+}
+
+func (s *SettingService) GetObservatory() (string, error) {
+	return s.getString("observatory")
+}
+
+func (s *SettingService) SetObservatory(data string) error {
+	return s.setString("observatory", data)
+}
+
+func (s *SettingService) GetDnsSettings() (string, error) {
+	return s.getString("dnsSettings")
+}
+
+func (s *SettingService) SetDnsSettings(data string) error {
+	return s.setString("dnsSettings", data)
+}
+
+func (s *SettingService) GetGeoUpdateRuntime() (string, error) {
+	return s.getString("geoUpdateRuntime")
+}
+
+func (s *SettingService) GetGeoipUrl() (string, error) {
+	return s.getString("geoipUrl")
+}
+
+func (s *SettingService) GetGeositeUrl() (string, error) {
+	return s.getString("geositeUrl")
+}
+
+func (s *SettingService) GetGeoChecksum() (bool, error) {
+	return s.getBool("geoChecksum")
+}
+
+func (s *SettingService) GetFreedomSettings() (string, error) {
+	return s.getString("freedomSettings")
+}
+
+func (s *SettingService) SetFreedomSettings(data string) error {
+	return s.setString("freedomSettings", data)
+}
+
+func (s *SettingService) GetInboundAccessLog() (bool, error) {
+	return s.getBool("inboundAccessLog")
+}
+
+func (s *SettingService) GetReverseProxies() (string, error) {
+	return s.getString("reverseProxies")
+}
+
+func (s *SettingService) SetReverseProxies(data string) error {
+	return s.setString("reverseProxies", data)
+}
+
+func (s *SettingService) GetPolicySettings() (string, error) {
+	return s.getString("policySettings")
+}
+
+func (s *SettingService) SetPolicySettings(data string) error {
+	return s.setString("policySettings", data)
+}
+
+func (s *SettingService) GetXrayAPIPortFallback() (string, error) {
+	return s.getString("xrayApiPortFallback")
+}
+
+func (s *SettingService) SetXrayAPIPortFallback(data string) error {
+	return s.setString("xrayApiPortFallback", data)
+}
+
+func (s *SettingService) GetBandwidthLimits() (string, error) {
+	return s.getString("bandwidthLimits")
+}
+
+func (s *SettingService) SetBandwidthLimits(data string) error {
+	return s.setString("bandwidthLimits", data)
+}
+
+func (s *SettingService) GetXrayInstances() (string, error) {
+	return s.getString("xrayInstances")
+}
+
+func (s *SettingService) SetXrayInstances(value string) error {
+	return s.setString("xrayInstances", value)
+}
+
+func (s *SettingService) GetXrayCpuLimit() (int, error) {
+	return s.getInt("xrayCpuLimit")
+}
+
+func (s *SettingService) GetXrayMemoryLimit() (int, error) {
+	return s.getInt("xrayMemoryLimit")
+}
+
+func (s *SettingService) GetRaiseOpenFiles() (bool, error) {
+	return s.getBool("raiseOpenFiles")
+}
+
+func (s *SettingService) GetXrayRestartDrain() (int, error) {
+	return s.getInt("xrayRestartDrain")
+}
+
+func (s *SettingService) SetXrayRestartDrain(seconds int) error {
+	return s.setInt("xrayRestartDrain", seconds)
+}
+
 func (s *SettingService) GetIpLimitEnable() (bool, error) {
 	accessLogPath, err := xray.GetAccessLogPath()
 	if err != nil {
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"sync"
+)
+
+var (
+	settingsListenersLock sync.RWMutex
+	settingsListeners     []func(key string)
+)
+
+// OnSettingsChanged registers fn to be called with the key of every setting saved with a new value.
+// Listeners run synchronously in the saving goroutine and must not block.
+func OnSettingsChanged(fn func(key string)) {
+	settingsListenersLock.Lock()
+	defer settingsListenersLock.Unlock()
+	settingsListeners = append(settingsListeners, fn)
+}
+
+func notifySettingChanged(key string) {
+	settingsListenersLock.RLock()
+	listeners := settingsListeners
+	settingsListenersLock.RUnlock()
+	for _, fn := range listeners {
+		fn(key)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubUpdateInterval(t *testing.T) {
+	setupTestDB(t)
+	s := &SettingService{}
+	if err := s.saveSetting("subUpdates", "12"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		subId   string
+		set     bool
+		hours   int
+		want    string
+		wantErr string
+	}{
+		{name: "own interval", subId: "sub-a", set: true, hours: 6, want: "6"},
+		{name: "other subscription keeps the global one", subId: "sub-b", want: "12"},
+		{name: "changed interval", subId: "sub-a", set: true, hours: 24, want: "24"},
+		{name: "zero falls back to the global one", subId: "sub-a", set: true, hours: 0, want: "12"},
+		{name: "negative interval", subId: "sub-a", set: true, hours: -5, wantErr: "invalid update interval"},
+		{name: "empty subscription id", subId: "", set: true, hours: 6, wantErr: "subscription id is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set {
+				err := s.SetSubUpdateInterval(tt.subId, tt.hours)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := s.GetSubUpdateInterval(tt.subId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got interval %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,16 +1,20 @@
-package warp
+package service
 
 import (
 	"bytes"
 	"context"
+	"crypto/ecdh"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 	"x-ui/logger"
 )
@@ -46,6 +50,173 @@ func (s *WarpService) getHttpClient() *http.Client {
 	return s.httpClient
 }
 
+// ErrWarpCircuitOpen is returned while the Warp API is considered down
+var ErrWarpCircuitOpen = errors.New("warp api circuit is open, cloudflare looks unreachable")
+
+const (
+	warpBreakerThreshold = 3               // consecutive failed calls before opening
+	warpBreakerCooldown  = 2 * time.Minute // time before a probe call is allowed
+)
+
+const (
+	WarpBreakerClosed   = "closed"
+	WarpBreakerOpen     = "open"
+	WarpBreakerHalfOpen = "half-open"
+)
+
+type WarpBreakerState struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt"`
+}
+
+// The breaker is shared by every WarpService value
+var warpBreaker struct {
+	sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allowWarpCall reports whether a call may go out, letting a single probe through after the cooldown
+func allowWarpCall() bool {
+	warpBreaker.Lock()
+	defer warpBreaker.Unlock()
+	if warpBreaker.failures < warpBreakerThreshold {
+		return true
+	}
+	if warpBreaker.probing || time.Since(warpBreaker.openedAt) < warpBreakerCooldown {
+		return false
+	}
+	warpBreaker.probing = true
+	return true
+}
+
+func recordWarpCall(success bool) {
+	warpBreaker.Lock()
+	defer warpBreaker.Unlock()
+	warpBreaker.probing = false
+	if success {
+		warpBreaker.failures = 0
+		warpBreaker.openedAt = time.Time{}
+		return
+	}
+	warpBreaker.failures++
+	if warpBreaker.failures >= warpBreakerThreshold {
+		if warpBreaker.openedAt.IsZero() {
+			logger.Warning("Warp API failed", warpBreaker.failures, "times in a row, opening circuit")
+		}
+		warpBreaker.openedAt = time.Now()
+	}
+}
+
+func (s *WarpService) GetWarpBreakerState() WarpBreakerState {
+	warpBreaker.Lock()
+	defer warpBreaker.Unlock()
+	state := WarpBreakerState{
+		State:    WarpBreakerClosed,
+		Failures: warpBreaker.failures,
+		OpenedAt: warpBreaker.openedAt,
+	}
+	if warpBreaker.failures >= warpBreakerThreshold {
+		state.State = WarpBreakerOpen
+		if warpBreaker.probing || time.Since(warpBreaker.openedAt) >= warpBreakerCooldown {
+			state.State = WarpBreakerHalfOpen
+		}
+	}
+	return state
+}
+
+// WarpLocker serializes the Warp operations that change the registration (RegWarp and
+// SetWarpLicense). Panels sharing one Cloudflare account can set a locker backed by an
+// external lock, such as Redis, so only one instance registers at a time. Lock blocks until
+// the lock is held or ctx is done, and Unlock releases it. The default only serializes
+// calls within this process.
+type WarpLocker interface {
+	Lock(ctx context.Context) error
+	Unlock() error
+}
+
+// warpLockTimeout bounds the wait for the lock, a stuck holder should not hang the panel
+const warpLockTimeout = time.Minute
+
+// mutexWarpLocker is a mutex that gives up waiting when ctx is done
+type mutexWarpLocker struct {
+	held chan struct{}
+}
+
+func newMutexWarpLocker() *mutexWarpLocker {
+	return &mutexWarpLocker{held: make(chan struct{}, 1)}
+}
+
+func (l *mutexWarpLocker) Lock(ctx context.Context) error {
+	select {
+	case l.held <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *mutexWarpLocker) Unlock() error {
+	<-l.held
+	return nil
+}
+
+var (
+	warpLockerMu sync.RWMutex
+	warpLocker   WarpLocker = newMutexWarpLocker()
+)
+
+// SetWarpLocker replaces the locker of every WarpService value, nil restores the in process one
+func SetWarpLocker(locker WarpLocker) {
+	warpLockerMu.Lock()
+	defer warpLockerMu.Unlock()
+	if locker == nil {
+		locker = newMutexWarpLocker()
+	}
+	warpLocker = locker
+}
+
+// lockWarp takes the Warp lock and returns the function releasing it
+func lockWarp() (func(), error) {
+	warpLockerMu.RLock()
+	locker := warpLocker
+	warpLockerMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), warpLockTimeout)
+	defer cancel()
+	if err := locker.Lock(ctx); err != nil {
+		return nil, fmt.Errorf("failed to acquire warp lock: %w", err)
+	}
+	return func() {
+		if err := locker.Unlock(); err != nil {
+			logger.Warning("Failed to release warp lock:", err)
+		}
+	}, nil
+}
+
+// cancelOnClose releases the request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// callWarpAPI sends a request to the Cloudflare API with retries, guarded by the circuit breaker
+func (s *WarpService) callWarpAPI(req *http.Request) (*http.Response, error) {
+	if !allowWarpCall() {
+		return nil, ErrWarpCircuitOpen
+	}
+	resp, err := s.doWithRetry(req)
+	recordWarpCall(err == nil)
+	return resp, err
+}
+
 // Retry mechanism with exponential backoff and jitter
 func (s *WarpService) doWithRetry(req *http.Request) (*http.Response, error) {
 	client := s.getHttpClient()
@@ -55,44 +226,83 @@ func (s *WarpService) doWithRetry(req *http.Request) (*http.Response, error) {
 	if s.maxRetries == 0 {
 		s.maxRetries = 5 // Increased max retries
 	}
-
-	baseBackoff := 500 * time.Millisecond
-	maxBackoff := 10 * time.Second
+	policy := RetryPolicy{
+		MaxRetries:  s.maxRetries,
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  10 * time.Second,
+	}
 
 	for i := 0; i <= s.maxRetries; i++ {
 		// Create a new context with timeout for each attempt
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
 
 		// Clone the request with the new context
 		reqClone := req.Clone(ctx)
 
 		resp, err = client.Do(reqClone)
-		cancel() // Ensure context is canceled to prevent leaks
 
 		if err == nil && resp.StatusCode < 500 {
+			// Keep the context alive until the caller has read the body
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
 			return resp, nil
 		}
 		if resp != nil {
 			resp.Body.Close()
 		}
+		cancel() // Ensure context is canceled to prevent leaks
 		logger.Error(fmt.Sprintf("Attempt %d failed: %v. Retrying...", i+1, err))
 
 		if i < s.maxRetries {
 			// Exponential backoff with jitter
-			sleep := time.Duration(float64(baseBackoff) * math.Pow(2, float64(i)))
-			jitter := time.Duration(rand.Int63n(int64(baseBackoff)))
-			sleep = sleep + jitter
-			if sleep > maxBackoff {
-				sleep = maxBackoff
-			}
-			time.Sleep(sleep)
+			time.Sleep(policy.Backoff(i))
 		}
 	}
 
 	return nil, fmt.Errorf("all retry attempts failed: %v", err)
 }
 
+func (s *WarpService) GetWarpData() (string, error) {
+	return s.SettingService.GetWarp()
+}
+
+func (s *WarpService) DelWarpData() error {
+	return s.SettingService.SetWarp("")
+}
+
+// Decode a base64 WireGuard key and make sure it is a 32-byte Curve25519 key
+func decodeWireGuardKey(name string, key string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: not valid base64: %v", name, err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("invalid %s: expected 32 bytes, got %d", name, len(raw))
+	}
+	return raw, nil
+}
+
+// ValidateWireGuardKeypair checks that both keys are valid Curve25519 keys and that
+// the public key is derived from the private key
+func ValidateWireGuardKeypair(priv, pub string) error {
+	privBytes, err := decodeWireGuardKey("private key", priv)
+	if err != nil {
+		return err
+	}
+	pubBytes, err := decodeWireGuardKey("public key", pub)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(privBytes)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %v", err)
+	}
+	if !bytes.Equal(privateKey.PublicKey().Bytes(), pubBytes) {
+		return fmt.Errorf("public key does not match private key")
+	}
+	return nil
+}
+
 func (s *WarpService) GetWarpConfig() (string, error) {
 	var warpData map[string]string
 	warp, err := s.SettingService.GetWarp()
@@ -113,7 +323,7 @@ func (s *WarpService) GetWarpConfig() (string, error) {
 	req.Header.Set("Authorization", "Bearer "+warpData["access_token"])
 
 	// Make the request with retries
-	resp, err := s.doWithRetry(req)
+	resp, err := s.callWarpAPI(req)
 	if err != nil {
 		return "", err
 	}
@@ -128,17 +338,98 @@ func (s *WarpService) GetWarpConfig() (string, error) {
 	return string(body), nil
 }
 
+// Known locations of the registration fields, the current schema first
+var warpRegistrationFields = []struct {
+	name  string
+	paths []string
+}{
+	{"id", []string{"id", "result.id", "device.id"}},
+	{"token", []string{"token", "result.token", "auth.token"}},
+	{"license", []string{"account.license", "result.account.license", "license"}},
+}
+
+// WarpFieldError names the field a Warp response was missing, with the start of the response
+type WarpFieldError struct {
+	Field   string
+	Tried   []string
+	Snippet string
+}
+
+func (e *WarpFieldError) Error() string {
+	return fmt.Sprintf("warp response has no '%s' (tried %s): %s", e.Field, strings.Join(e.Tried, ", "), e.Snippet)
+}
+
+func warpResponseSnippet(body []byte) string {
+	const maxSnippet = 256
+	if len(body) > maxSnippet {
+		return string(body[:maxSnippet]) + "..."
+	}
+	return string(body)
+}
+
+func lookupWarpField(data map[string]interface{}, path string) (string, bool) {
+	keys := strings.Split(path, ".")
+	current := data
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		current = next
+	}
+	value, ok := current[keys[len(keys)-1]].(string)
+	return value, ok && value != ""
+}
+
+// parseWarpRegistration finds the device id, token and license wherever a known schema puts them
+func parseWarpRegistration(body []byte) (deviceId, token, license string, err error) {
+	var rspData map[string]interface{}
+	err = json.Unmarshal(body, &rspData)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid warp response: %v: %s", err, warpResponseSnippet(body))
+	}
+
+	values := make(map[string]string, len(warpRegistrationFields))
+	for _, field := range warpRegistrationFields {
+		for _, path := range field.paths {
+			if value, ok := lookupWarpField(rspData, path); ok {
+				values[field.name] = value
+				break
+			}
+		}
+		if values[field.name] == "" {
+			return "", "", "", &WarpFieldError{
+				Field:   field.name,
+				Tried:   field.paths,
+				Snippet: warpResponseSnippet(body),
+			}
+		}
+	}
+	return values["id"], values["token"], values["license"], nil
+}
+
 func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error) {
+	// Catch malformed keys before Cloudflare returns a confusing error
+	if err := ValidateWireGuardKeypair(secretKey, publicKey); err != nil {
+		return "", err
+	}
+
+	unlock, err := lockWarp()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
 	tos := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
 	hostName, _ := os.Hostname()
 
 	// Use a struct and JSON marshalling
 	regData := map[string]interface{}{
-		"key":      publicKey,
-		"tos":      tos,
-		"type":     "PC",
-		"model":    "x-ui",
-		"name":     hostName,
+		"key":       publicKey,
+		"tos":       tos,
+		"type":      "PC",
+		"model":     "x-ui",
+		"name":      hostName,
 		"fcm_token": "", // Add empty fcm_token to reduce response size
 	}
 	dataBytes, err := json.Marshal(regData)
@@ -157,7 +448,7 @@ func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error
 	req.Header.Add("Content-Type", "application/json")
 
 	// Make the request with retries
-	resp, err := s.doWithRetry(req)
+	resp, err := s.callWarpAPI(req)
 	if err != nil {
 		return "", err
 	}
@@ -169,31 +460,11 @@ func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error
 		return "", err
 	}
 
-	var rspData map[string]interface{}
-	err = json.Unmarshal(body, &rspData)
+	deviceId, token, license, err := parseWarpRegistration(body)
 	if err != nil {
 		return "", err
 	}
 
-	deviceId, ok := rspData["id"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'id' in response data")
-	}
-
-	token, ok := rspData["token"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'token' in response data")
-	}
-
-	accountMap, ok := rspData["account"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'account' in response data")
-	}
-	license, ok := accountMap["license"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'license' in account data")
-	}
-
 	warpData := map[string]string{
 		"access_token": token,
 		"device_id":    deviceId,
@@ -216,6 +487,12 @@ func (s *WarpService) RegWarp(secretKey string, publicKey string) (string, error
 }
 
 func (s *WarpService) SetWarpLicense(license string) (string, error) {
+	unlock, err := lockWarp()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
 	var warpData map[string]string
 	warp, err := s.SettingService.GetWarp()
 	if err != nil {
@@ -245,7 +522,7 @@ func (s *WarpService) SetWarpLicense(license string) (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Make the request with retries
-	resp, err := s.doWithRetry(req)
+	resp, err := s.callWarpAPI(req)
 	if err != nil {
 		return "", err
 	}
@@ -270,3 +547,141 @@ func (s *WarpService) SetWarpLicense(license string) (string, error) {
 
 	return string(newWarpData), nil
 }
+
+// WarpDevice is a device registered under the Warp account
+type WarpDevice struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Model     string `json:"model"`
+	Created   string `json:"created"`
+	Activated string `json:"activated"`
+	Active    bool   `json:"active"`
+	Role      string `json:"role"`
+}
+
+// ListWarpDevices returns the devices registered under the account of the stored registration
+func (s *WarpService) ListWarpDevices() ([]WarpDevice, error) {
+	var warpData map[string]string
+	warp, err := s.SettingService.GetWarp()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(warp), &warpData)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("https://api.cloudflareclient.com/v0a2158/reg/%s/account/devices", warpData["device_id"])
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+warpData["access_token"])
+
+	// Make the request with retries
+	resp, err := s.callWarpAPI(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing warp devices failed: %s", resp.Status)
+	}
+
+	var devices []WarpDevice
+	err = json.Unmarshal(body, &devices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warp device list: %v", err)
+	}
+	return devices, nil
+}
+
+// WarpCredentialsStatus is the result of the last credentials check
+type WarpCredentialsStatus struct {
+	Valid     bool   `json:"valid"`
+	Revoked   bool   `json:"revoked"`
+	Error     string `json:"error,omitempty"`
+	CheckedAt int64  `json:"checkedAt"`
+}
+
+var warpCredentials struct {
+	sync.Mutex
+	status WarpCredentialsStatus
+}
+
+// GetWarpCredentialsStatus returns the result of the last VerifyWarpCredentials call
+func (s *WarpService) GetWarpCredentialsStatus() WarpCredentialsStatus {
+	warpCredentials.Lock()
+	defer warpCredentials.Unlock()
+	return warpCredentials.status
+}
+
+// VerifyWarpCredentials reads the registration with the stored token. It returns false with no
+// error when Cloudflare rejects the token, and an error when it could not tell, such as on
+// network failures or while the circuit is open.
+func (s *WarpService) VerifyWarpCredentials() (bool, error) {
+	valid, err := s.verifyWarpCredentials()
+	status := WarpCredentialsStatus{
+		Valid:     valid,
+		Revoked:   !valid && err == nil,
+		CheckedAt: time.Now().Unix() * 1000,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	warpCredentials.Lock()
+	wasRevoked := warpCredentials.status.Revoked
+	warpCredentials.status = status
+	warpCredentials.Unlock()
+	if status.Revoked && !wasRevoked {
+		logger.Warning("Warp credentials were rejected, the warp outbound will stop working")
+	}
+	return valid, err
+}
+
+func (s *WarpService) verifyWarpCredentials() (bool, error) {
+	var warpData map[string]string
+	warp, err := s.SettingService.GetWarp()
+	if err != nil {
+		return false, err
+	}
+	if warp == "" {
+		return false, errors.New("warp is not registered")
+	}
+	err = json.Unmarshal([]byte(warp), &warpData)
+	if err != nil {
+		return false, err
+	}
+
+	url := fmt.Sprintf("https://api.cloudflareclient.com/v0a2158/reg/%s", warpData["device_id"])
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+warpData["access_token"])
+
+	resp, err := s.callWarpAPI(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("verifying warp credentials failed: %s", resp.Status)
+	}
+}
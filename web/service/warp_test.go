@@ -0,0 +1,481 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newWireGuardKeypair(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func TestValidateWireGuardKeypair(t *testing.T) {
+	priv, pub := newWireGuardKeypair(t)
+	_, otherPub := newWireGuardKeypair(t)
+	short := base64.StdEncoding.EncodeToString(make([]byte, 16))
+
+	tests := []struct {
+		name    string
+		priv    string
+		pub     string
+		wantErr string
+	}{
+		{"valid", priv, pub, ""},
+		{"valid with spaces", " " + priv + "\n", pub, ""},
+		{"private key not base64", "not-base64!", pub, "invalid private key: not valid base64"},
+		{"public key not base64", priv, "not-base64!", "invalid public key: not valid base64"},
+		{"private key wrong length", short, pub, "invalid private key: expected 32 bytes, got 16"},
+		{"public key wrong length", priv, short, "invalid public key: expected 32 bytes, got 16"},
+		{"mismatched keypair", priv, otherPub, "public key does not match private key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWireGuardKeypair(tt.priv, tt.pub)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegWarpRejectsInvalidKeypair(t *testing.T) {
+	priv, _ := newWireGuardKeypair(t)
+	_, otherPub := newWireGuardKeypair(t)
+	s := &WarpService{}
+	// The keys are checked before any request to Cloudflare is made
+	if _, err := s.RegWarp(priv, otherPub); err == nil {
+		t.Fatal("RegWarp accepted a mismatched keypair")
+	}
+}
+
+func resetWarpBreaker() {
+	warpBreaker.Lock()
+	warpBreaker.failures = 0
+	warpBreaker.openedAt = time.Time{}
+	warpBreaker.probing = false
+	warpBreaker.Unlock()
+}
+
+func TestWarpBreaker(t *testing.T) {
+	resetWarpBreaker()
+	defer resetWarpBreaker()
+	s := &WarpService{}
+	cooldownPassed := func() {
+		warpBreaker.Lock()
+		warpBreaker.openedAt = time.Now().Add(-warpBreakerCooldown - time.Second)
+		warpBreaker.Unlock()
+	}
+
+	steps := []struct {
+		name      string
+		do        func()
+		wantAllow bool
+		wantState string
+	}{
+		{"starts closed", func() {}, true, WarpBreakerClosed},
+		{"failures below the threshold", func() { recordWarpCall(false); recordWarpCall(false) }, true, WarpBreakerClosed},
+		{"a success resets the count", func() { recordWarpCall(true); recordWarpCall(false); recordWarpCall(false) }, true, WarpBreakerClosed},
+		{"threshold opens", func() { recordWarpCall(false) }, false, WarpBreakerOpen},
+		{"cooldown lets a probe through", cooldownPassed, true, WarpBreakerHalfOpen},
+		{"only one probe at a time", func() {}, false, WarpBreakerHalfOpen},
+		{"failed probe opens again", func() { recordWarpCall(false) }, false, WarpBreakerOpen},
+		{"second probe", cooldownPassed, true, WarpBreakerHalfOpen},
+		{"successful probe closes", func() { recordWarpCall(true) }, true, WarpBreakerClosed},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.do()
+			// The state is read before allowWarpCall, which takes the probe
+			if state := s.GetWarpBreakerState(); state.State != step.wantState {
+				t.Fatalf("state %s, want %s", state.State, step.wantState)
+			}
+			if allowed := allowWarpCall(); allowed != step.wantAllow {
+				t.Fatalf("allowed %v, want %v", allowed, step.wantAllow)
+			}
+		})
+	}
+}
+
+func TestCallWarpAPIShortCircuits(t *testing.T) {
+	resetWarpBreaker()
+	defer resetWarpBreaker()
+	for i := 0; i < warpBreakerThreshold; i++ {
+		recordWarpCall(false)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	s := &WarpService{}
+	if _, err := s.callWarpAPI(req); !errors.Is(err, ErrWarpCircuitOpen) {
+		t.Fatalf("got %v, want ErrWarpCircuitOpen", err)
+	}
+	if requests.Load() != 0 {
+		t.Fatal("a request went out while the circuit was open")
+	}
+}
+
+// rewriteTransport sends every request to the test server, keeping the path
+type rewriteTransport struct {
+	target string
+}
+
+func (r *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(r.target)
+	req = req.Clone(req.Context())
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+const sampleWarpDevices = `[
+  {"id": "a1b2", "type": "a", "model": "x-ui", "name": "panel", "created": "2024-01-02T03:04:05.000Z",
+   "activated": "2024-01-02T03:04:06.000Z", "active": true, "role": "parent"},
+  {"id": "c3d4", "type": "i", "model": "iPhone", "name": "phone", "created": "2023-05-06T07:08:09.000Z",
+   "activated": "2023-05-06T07:08:10.000Z", "active": false, "role": "child"}
+]`
+
+func TestListWarpDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    []WarpDevice
+		wantErr string
+	}{
+		{
+			name:   "device list",
+			status: http.StatusOK,
+			body:   sampleWarpDevices,
+			want: []WarpDevice{
+				{Id: "a1b2", Name: "panel", Type: "a", Model: "x-ui", Created: "2024-01-02T03:04:05.000Z", Activated: "2024-01-02T03:04:06.000Z", Active: true, Role: "parent"},
+				{Id: "c3d4", Name: "phone", Type: "i", Model: "iPhone", Created: "2023-05-06T07:08:09.000Z", Activated: "2023-05-06T07:08:10.000Z", Active: false, Role: "child"},
+			},
+		},
+		{name: "empty account", status: http.StatusOK, body: `[]`, want: []WarpDevice{}},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"success": false}`, wantErr: "401"},
+		{name: "invalid body", status: http.StatusOK, body: `{"result": []}`, wantErr: "invalid warp device list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			resetWarpBreaker()
+			defer resetWarpBreaker()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v0a2158/reg/device-1/account/devices" || r.Header.Get("Authorization") != "Bearer token-1" {
+					t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			s := &WarpService{httpClient: &http.Client{Transport: &rewriteTransport{server.URL}}}
+			if err := s.SettingService.SetWarp(`{"device_id": "device-1", "access_token": "token-1"}`); err != nil {
+				t.Fatal(err)
+			}
+			devices, err := s.ListWarpDevices()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(devices) != len(tt.want) {
+				t.Fatalf("got %d devices, want %d", len(devices), len(tt.want))
+			}
+			for i := range devices {
+				if devices[i] != tt.want[i] {
+					t.Errorf("device %d: got %+v, want %+v", i, devices[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseWarpRegistration(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantId      string
+		wantToken   string
+		wantLicense string
+		wantField   string
+		wantErr     string
+	}{
+		{
+			name:        "current schema",
+			body:        `{"id": "dev-1", "type": "a", "token": "tok-1", "account": {"id": "acc-1", "license": "lic-1", "warp_plus": false}}`,
+			wantId:      "dev-1",
+			wantToken:   "tok-1",
+			wantLicense: "lic-1",
+		},
+		{
+			name:        "wrapped in result",
+			body:        `{"success": true, "result": {"id": "dev-2", "token": "tok-2", "account": {"license": "lic-2"}}}`,
+			wantId:      "dev-2",
+			wantToken:   "tok-2",
+			wantLicense: "lic-2",
+		},
+		{
+			name:        "moved fields",
+			body:        `{"device": {"id": "dev-3"}, "auth": {"token": "tok-3"}, "license": "lic-3"}`,
+			wantId:      "dev-3",
+			wantToken:   "tok-3",
+			wantLicense: "lic-3",
+		},
+		{
+			name:      "missing license",
+			body:      `{"id": "dev-4", "token": "tok-4", "account": {"id": "acc-4"}}`,
+			wantField: "license",
+		},
+		{
+			name:      "empty token",
+			body:      `{"id": "dev-5", "token": "", "account": {"license": "lic-5"}}`,
+			wantField: "token",
+		},
+		{
+			name:      "id of the wrong type",
+			body:      `{"id": 5, "token": "tok-6", "account": {"license": "lic-6"}}`,
+			wantField: "id",
+		},
+		{
+			name:    "not json",
+			body:    `<html>Service Unavailable</html>`,
+			wantErr: "invalid warp response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, token, license, err := parseWarpRegistration([]byte(tt.body))
+			if tt.wantField != "" {
+				var fieldErr *WarpFieldError
+				if !errors.As(err, &fieldErr) {
+					t.Fatalf("got error %v, want a missing field error", err)
+				}
+				if fieldErr.Field != tt.wantField || fieldErr.Snippet != tt.body {
+					t.Fatalf("got missing field %q with snippet %q, want %q", fieldErr.Field, fieldErr.Snippet, tt.wantField)
+				}
+				return
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantId || token != tt.wantToken || license != tt.wantLicense {
+				t.Fatalf("got %q, %q, %q", id, token, license)
+			}
+		})
+	}
+}
+
+func TestWarpResponseSnippet(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	if got := warpResponseSnippet([]byte(long)); got != long[:256]+"..." {
+		t.Fatalf("got snippet of %d bytes", len(got))
+	}
+	if got := warpResponseSnippet([]byte("short")); got != "short" {
+		t.Fatalf("got snippet %q", got)
+	}
+}
+
+// stubWarpLocker stands in for an external lock and counts its use
+type stubWarpLocker struct {
+	mu      sync.Mutex
+	locks   atomic.Int32
+	unlocks atomic.Int32
+	fail    error
+}
+
+func (l *stubWarpLocker) Lock(ctx context.Context) error {
+	if l.fail != nil {
+		return l.fail
+	}
+	l.mu.Lock()
+	l.locks.Add(1)
+	return nil
+}
+
+func (l *stubWarpLocker) Unlock() error {
+	l.unlocks.Add(1)
+	l.mu.Unlock()
+	return nil
+}
+
+func TestWarpLocker(t *testing.T) {
+	setupTestDB(t)
+	resetWarpBreaker()
+	defer resetWarpBreaker()
+	locker := &stubWarpLocker{}
+	SetWarpLocker(locker)
+	t.Cleanup(func() { SetWarpLocker(nil) })
+
+	var active, maxActive atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			max := maxActive.Load()
+			if n <= max || maxActive.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"id": "acc-1"}`))
+	}))
+	defer server.Close()
+	s := &WarpService{httpClient: &http.Client{Transport: &rewriteTransport{server.URL}}}
+	if err := s.SettingService.SetWarp(`{"device_id": "device-1", "access_token": "token-1"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	const calls = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.SetWarpLicense("license-1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if locker.locks.Load() != calls || locker.unlocks.Load() != calls {
+		t.Fatalf("locker was locked %d and unlocked %d times, want %d", locker.locks.Load(), locker.unlocks.Load(), calls)
+	}
+	if maxActive.Load() != 1 {
+		t.Fatalf("%d warp operations ran at once, want 1", maxActive.Load())
+	}
+
+	// Nothing reaches Cloudflare without the lock
+	locker.fail = errors.New("redis is down")
+	_, err := s.SetWarpLicense("license-2")
+	if err == nil || !strings.Contains(err.Error(), "failed to acquire warp lock: redis is down") {
+		t.Fatalf("got error %v, want the lock error", err)
+	}
+	priv, pub := newWireGuardKeypair(t)
+	if _, err := s.RegWarp(priv, pub); err == nil || !strings.Contains(err.Error(), "failed to acquire warp lock") {
+		t.Fatalf("got error %v, want the lock error", err)
+	}
+}
+
+func TestMutexWarpLocker(t *testing.T) {
+	locker := newMutexWarpLocker()
+	if err := locker.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := locker.Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v while the lock is held, want a deadline error", err)
+	}
+	if err := locker.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := locker.Lock(context.Background()); err != nil {
+		t.Fatalf("lock is not free after unlock: %v", err)
+	}
+}
+
+func TestVerifyWarpCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		warp        string
+		status      int
+		open        bool
+		wantValid   bool
+		wantRevoked bool
+		wantErr     string
+	}{
+		{name: "accepted", warp: `{"device_id": "device-1", "access_token": "token-1"}`, status: http.StatusOK, wantValid: true},
+		{name: "revoked", warp: `{"device_id": "device-1", "access_token": "token-1"}`, status: http.StatusUnauthorized, wantRevoked: true},
+		{name: "forbidden", warp: `{"device_id": "device-1", "access_token": "token-1"}`, status: http.StatusForbidden, wantRevoked: true},
+		{name: "rate limited", warp: `{"device_id": "device-1", "access_token": "token-1"}`, status: http.StatusTooManyRequests, wantErr: "429"},
+		{name: "circuit open", warp: `{"device_id": "device-1", "access_token": "token-1"}`, open: true, wantErr: ErrWarpCircuitOpen.Error()},
+		{name: "not registered", wantErr: "warp is not registered"},
+	}
+	t.Cleanup(func() {
+		warpCredentials.Lock()
+		warpCredentials.status = WarpCredentialsStatus{}
+		warpCredentials.Unlock()
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			resetWarpBreaker()
+			defer resetWarpBreaker()
+			if tt.open {
+				for i := 0; i < warpBreakerThreshold; i++ {
+					recordWarpCall(false)
+				}
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v0a2158/reg/device-1" || r.Header.Get("Authorization") != "Bearer token-1" {
+					t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			s := &WarpService{httpClient: &http.Client{Transport: &rewriteTransport{server.URL}}}
+			if err := s.SettingService.SetWarp(tt.warp); err != nil {
+				t.Fatal(err)
+			}
+			valid, err := s.VerifyWarpCredentials()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			status := s.GetWarpCredentialsStatus()
+			if valid != tt.wantValid || status.Valid != tt.wantValid || status.Revoked != tt.wantRevoked {
+				t.Fatalf("got valid %v and status %+v, want valid %v and revoked %v", valid, status, tt.wantValid, tt.wantRevoked)
+			}
+			if (status.Error != "") != (tt.wantErr != "") || status.CheckedAt == 0 {
+				t.Fatalf("got status %+v", status)
+			}
+		})
+	}
+}
@@ -1,49 +1,71 @@
+package service
+
 import (
 	"encoding/json"
 	"errors"
+	"path"
+	"sort"
 	"sync"
 	"time"
 
+	"x-ui/database"
+	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/sys"
 	"x-ui/xray"
 
 	"go.uber.org/atomic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	p                 *xray.Process
 	lock              sync.Mutex
 	isNeedXrayRestart atomic.Bool
 	result            string
 )
 
+// Short and bounded, the traffic job polls again in a few seconds anyway.
+// Only unavailable errors are retried, they fail fast while the API is not listening.
+var xrayAPIRetry = RetryPolicy{
+	MaxRetries:  3,
+	BaseBackoff: 200 * time.Millisecond,
+	MaxBackoff:  time.Second,
+	Retryable: func(err error) bool {
+		// The counters read before the failure are already reset, another try would lose them
+		var partial *xray.PartialTrafficError
+		if errors.As(err, &partial) {
+			return false
+		}
+		return status.Code(err) == codes.Unavailable
+	},
+}
+
 type XrayService struct {
 	inboundService InboundService
 	settingService SettingService
 	xrayAPI        xray.XrayAPI
-	// Add a channel to signal process termination
-	stopChan chan struct{}
 }
 
-// Initialize the stop channel when creating a new XrayService
 func NewXrayService(inboundService InboundService, settingService SettingService, xrayAPI xray.XrayAPI) *XrayService {
 	return &XrayService{
 		inboundService: inboundService,
 		settingService: settingService,
 		xrayAPI:        xrayAPI,
-		stopChan:       make(chan struct{}),
 	}
 }
 
 func (s *XrayService) IsXrayRunning() bool {
-	return p != nil && p.IsRunning()
+	return xrayProcesses.IsRunning(mainXray)
 }
 
 func (s *XrayService) GetXrayErr() error {
-	if p == nil {
+	process := mainXrayProcess()
+	if process == nil {
 		return nil
 	}
-	return p.GetErr()
+	return process.GetErr()
 }
 
 func (s *XrayService) GetXrayResult() string {
@@ -53,45 +75,111 @@ func (s *XrayService) GetXrayResult() string {
 	if s.IsXrayRunning() {
 		return ""
 	}
-	if p == nil {
+	process := mainXrayProcess()
+	if process == nil {
 		return ""
 	}
-	result = p.GetResult()
+	result = process.GetResult()
 	return result
 }
 
 func (s *XrayService) GetXrayVersion() string {
-	if p == nil {
+	process := mainXrayProcess()
+	if process == nil {
 		return "Unknown"
 	}
-	return p.GetVersion()
+	return process.GetVersion()
 }
 
 func RemoveIndex(s []interface{}, index int) []interface{} {
 	return append(s[:index], s[index+1:]...)
 }
 
+// GetXrayConfig generates the config of the enabled inbounds, reusing the last one for a few
+// seconds while nothing it is built from changed
 func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
-	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	return s.cachedXrayConfig(func() (*xray.Config, error) {
+		include, err := s.mainInbounds()
+		if err != nil {
+			return nil, err
+		}
+		xrayConfig, report, err := s.genXrayConfigReport(s.settingService.GetXrayConfigTemplate, include, true)
+		if err != nil {
+			return nil, err
+		}
+		report.record()
+		return xrayConfig, nil
+	})
+}
+
+// GetXrayConfigFor generates the config with only the given inbounds, whether they are enabled or not
+func (s *XrayService) GetXrayConfigFor(inboundIds []int) (*xray.Config, error) {
+	ids := make(map[int]bool, len(inboundIds))
+	for _, id := range inboundIds {
+		ids[id] = true
+	}
+	return s.genXrayConfig(func(inbound *model.Inbound) bool {
+		return ids[inbound.Id]
+	})
+}
+
+func (s *XrayService) genXrayConfig(include func(inbound *model.Inbound) bool) (*xray.Config, error) {
+	xrayConfig, _, err := s.genXrayConfigReport(s.settingService.GetXrayConfigTemplate, include, true)
+	return xrayConfig, err
+}
+
+// genXrayConfigReport builds the config on the template the loader returns, along with its timing
+// and capped clients. Only the main config records them, the other configs would overwrite what the
+// panel reports. main is false for the extra instances, which use the template of their profile and
+// skip the passes that belong to the main process.
+func (s *XrayService) genXrayConfigReport(template func() (string, error), include func(inbound *model.Inbound) bool, main bool) (*xray.Config, *generationReport, error) {
+	timing := GenerationTiming{}
+	start := time.Now()
+	phase := start
+
+	templateConfig, err := template()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	xrayConfig := &xray.Config{}
 	err = json.Unmarshal([]byte(templateConfig), xrayConfig)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	timing.Template, phase = time.Since(phase), time.Now()
 
 	// Removed redundant call to AddTraffic
 	// s.inboundService.AddTraffic(nil, nil)
 
 	inbounds, err := s.inboundService.GetAllInbounds()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	// The database gives no order guarantee, Equals compares inbounds by position
+	sort.Slice(inbounds, func(i, j int) bool {
+		return inbounds[i].Id < inbounds[j].Id
+	})
+	sockopt, err := s.getSockoptDefaults()
+	if err != nil {
+		return nil, nil, err
+	}
+	validation, err := s.settingService.GetInboundValidation()
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsEnforcement, err := s.settingService.GetTlsEnforcement()
+	if err != nil {
+		return nil, nil, err
+	}
+	filter, err := s.newClientFilter()
+	if err != nil {
+		return nil, nil, err
 	}
+	timing.Load, phase = time.Since(phase), time.Now()
+
 	for _, inbound := range inbounds {
-		if !inbound.Enable {
+		if !include(inbound) {
 			continue
 		}
 		// get settings clients
@@ -101,49 +189,25 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 			logger.Errorf("Failed to unmarshal inbound settings: %v", err)
 			continue
 		}
+		if err := validateInboundSettings(inbound, settings); err != nil {
+			switch validation {
+			case InboundValidationError:
+				return nil, nil, err
+			case InboundValidationSkip:
+				logger.Warning("Skip invalid inbound:", err)
+				continue
+			default:
+				logger.Warning("Invalid inbound:", err)
+			}
+		}
 		clients, ok := settings["clients"].([]interface{})
 		if ok {
-			// check users active or not
-			clientStats := inbound.ClientStats
-			indexDecrease := 0 // Moved outside the loop
-			for _, clientTraffic := range clientStats {
-				for index, client := range clients {
-					c := client.(map[string]interface{})
-					if c["email"] == clientTraffic.Email {
-						if !clientTraffic.Enable {
-							clients = RemoveIndex(clients, index-indexDecrease)
-							indexDecrease++
-							logger.Infof("Remove Inbound User %s due to expiration or traffic limit", c["email"])
-						}
-					}
-				}
-			}
-
-			// clear client config for additional parameters
-			var final_clients []interface{}
-			for _, client := range clients {
-				c := client.(map[string]interface{})
-				if c["enable"] != nil {
-					if enable, ok := c["enable"].(bool); ok && !enable {
-						continue
-					}
-				}
-				// Retain necessary keys and remove others
-				for key := range c {
-					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
-						delete(c, key)
-					}
-				}
-				if c["flow"] == "xtls-rprx-vision-udp443" {
-					c["flow"] = "xtls-rprx-vision"
-				}
-				final_clients = append(final_clients, interface{}(c))
-			}
+			final_clients, _ := filter.filterActiveClients(inbound, clients)
 
 			settings["clients"] = final_clients
 			modifiedSettings, err := json.MarshalIndent(settings, "", "  ")
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			inbound.Settings = string(modifiedSettings)
@@ -158,6 +222,8 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 				continue
 			}
 
+			tlsEnforcement.Apply(stream)
+
 			// Remove the "settings" field under "tlsSettings" and "realitySettings"
 			if tlsSettings, ok := stream["tlsSettings"].(map[string]interface{}); ok {
 				delete(tlsSettings, "settings")
@@ -167,56 +233,155 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 			}
 
 			delete(stream, "externalProxy")
+			sockopt.apply(stream)
 
 			newStream, err := json.MarshalIndent(stream, "", "  ")
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			inbound.StreamSettings = string(newStream)
 		}
 
 		inboundConfig := inbound.GenXrayInboundConfig()
+		if err := mergeInboundFragment(inboundConfig, inbound.ConfigFragment); err != nil {
+			logger.Warningf("Skip config fragment of inbound %s: %v", inbound.Tag, err)
+		}
 		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
 	}
-	return xrayConfig, nil
-}
+	err = s.checkRealityConflicts(xrayConfig.InboundConfigs)
+	if err != nil {
+		return nil, nil, err
+	}
+	timing.Inbounds, phase = time.Since(phase), time.Now()
+	timing.InboundCount = len(xrayConfig.InboundConfigs)
 
-func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
-	if !s.IsXrayRunning() {
-		err := errors.New("xray is not running")
-		logger.Debug("Attempted to fetch Xray traffic, but Xray is not running:", err)
+	err = s.runConfigProcessors(xrayConfig, main)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The user patch goes last so it can override anything the passes did
+	configPatch, err := s.settingService.GetConfigPatch()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = applyConfigPatch(xrayConfig, configPatch)
+	if err != nil {
 		return nil, nil, err
 	}
-	apiPort := p.GetAPIPort()
-	s.xrayAPI.Init(apiPort)
-	// Removed defer s.xrayAPI.Close() to prevent premature closure
+	timing.PostProcess = time.Since(phase)
+	timing.Total = time.Since(start)
+	timing.At = start.Unix() * 1000
+	return xrayConfig, &generationReport{timing: timing, capped: filter.capped}, nil
+}
 
-	traffic, clientTraffic, err := s.xrayAPI.GetTraffic(true)
+// readXrayTraffic reads the counters through api, the resetting reads go to the traffic ledger
+func readXrayTraffic(api *xray.XrayAPI, reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	// Right after a restart the API may not be listening yet
+	var traffic []*xray.Traffic
+	var clientTraffic []*xray.ClientTraffic
+	err := xrayAPIRetry.Do(func() error {
+		var err error
+		traffic, clientTraffic, err = api.GetTraffic(reset)
+		return err
+	})
 	if err != nil {
+		var partial *xray.PartialTrafficError
+		if errors.As(err, &partial) {
+			logger.Warning("Fetched Xray traffic partially:", err)
+			if reset {
+				recordTrafficRead(traffic, clientTraffic)
+			}
+			return traffic, clientTraffic, err
+		}
 		logger.Debug("Failed to fetch Xray traffic:", err)
 		return nil, nil, err
 	}
+	if reset {
+		recordTrafficRead(traffic, clientTraffic)
+	}
 	return traffic, clientTraffic, nil
 }
 
-// Added a monitor function to restart Xray on unexpected termination
-func (s *XrayService) monitorXrayProcess() {
-	for {
-		select {
-		case <-s.stopChan:
-			logger.Debug("Xray process monitor stopped.")
-			return
-		default:
-			if !s.IsXrayRunning() {
-				logger.Warn("Xray process has stopped unexpectedly. Restarting...")
-				err := s.RestartXray(true)
-				if err != nil {
-					logger.Errorf("Failed to restart Xray: %v", err)
-				}
+// TrafficQuery narrows a read of the Xray counters for monitoring. It never resets them, that is
+// left to the traffic job. Email is a pattern like *@example.com, System adds the runtime stats.
+type TrafficQuery struct {
+	InboundTag string `json:"inboundTag" form:"inboundTag"`
+	Email      string `json:"email" form:"email"`
+	System     bool   `json:"system" form:"system"`
+}
+
+type TrafficQueryResult struct {
+	Traffics       []*xray.Traffic       `json:"traffics"`
+	ClientTraffics []*xray.ClientTraffic `json:"clientTraffics"`
+	System         *xray.SysStats        `json:"system,omitempty"`
+	Partial        bool                  `json:"partial"`
+}
+
+// QueryXrayTraffic reads the counters the query selects. With an inbound tag only that inbound and
+// its clients are returned, with an email pattern only the matching clients.
+func (s *XrayService) QueryXrayTraffic(query TrafficQuery) (*TrafficQueryResult, error) {
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	if _, err := path.Match(query.Email, ""); err != nil {
+		return nil, common.NewErrorf("invalid email pattern %q", query.Email)
+	}
+	var emails map[string]bool
+	patterns := []string{"bound>>>", "user>>>"}
+	if query.InboundTag != "" {
+		var inbound model.Inbound
+		err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", query.InboundTag).First(&inbound).Error
+		if err != nil {
+			return nil, common.NewErrorf("inbound %s does not exist", query.InboundTag)
+		}
+		clients, err := s.inboundService.GetClients(&inbound)
+		if err != nil {
+			return nil, err
+		}
+		emails = make(map[string]bool, len(clients))
+		for _, client := range clients {
+			emails[client.Email] = true
+		}
+		patterns = []string{"inbound>>>" + query.InboundTag + ">>>", "user>>>"}
+	} else if query.Email != "" {
+		patterns = []string{"user>>>"}
+	}
+
+	s.xrayAPI.Init(mainXrayProcess().GetAPIPort())
+	result := &TrafficQueryResult{}
+	traffics, clientTraffics, err := s.xrayAPI.QueryTraffic(patterns)
+	if err != nil {
+		var partial *xray.PartialTrafficError
+		if !errors.As(err, &partial) {
+			return nil, err
+		}
+		result.Partial = true
+	}
+	result.Traffics = make([]*xray.Traffic, 0, len(traffics))
+	for _, traffic := range traffics {
+		// The pattern is a substring, inbound>>>a>>> also matches outbound>>>a>>>
+		if query.InboundTag == "" || (traffic.IsInbound && traffic.Tag == query.InboundTag) {
+			result.Traffics = append(result.Traffics, traffic)
+		}
+	}
+	result.ClientTraffics = make([]*xray.ClientTraffic, 0, len(clientTraffics))
+	for _, traffic := range clientTraffics {
+		if emails != nil && !emails[traffic.Email] {
+			continue
+		}
+		if query.Email != "" {
+			if matched, _ := path.Match(query.Email, traffic.Email); !matched {
+				continue
 			}
-			time.Sleep(5 * time.Second) // Adjust the interval as needed
+		}
+		result.ClientTraffics = append(result.ClientTraffics, traffic)
+	}
+	if query.System {
+		if result.System, err = s.xrayAPI.GetSysStats(); err != nil {
+			return nil, err
 		}
 	}
+	return result, nil
 }
 
 func (s *XrayService) RestartXray(isForce bool) error {
@@ -224,33 +389,61 @@ func (s *XrayService) RestartXray(isForce bool) error {
 	defer lock.Unlock()
 	logger.Debug("Restarting Xray, force:", isForce)
 
-	xrayConfig, err := s.GetXrayConfig()
+	xrayConfig, err := s.restartConfig()
 	if err != nil {
 		return err
 	}
+	if err = s.checkEmptyInbounds(xrayConfig); err != nil {
+		return err
+	}
 
-	if s.IsXrayRunning() {
-		if !isForce && p.GetConfig().Equals(xrayConfig) {
+	if s.isStandby() {
+		return s.restartStandby(xrayConfig)
+	}
+
+	if running := mainXrayProcess(); running != nil && running.IsRunning() {
+		if !isForce && running.GetConfig().Equals(xrayConfig) {
 			logger.Debug("No need to restart Xray; configuration unchanged.")
+			s.recordLiveProfile()
+			s.setOutboundProbeTargets(xrayConfig)
+			return nil
+		}
+		if err := s.checkBeforeRestart(xrayConfig); err != nil {
+			return err
+		}
+		// Inbound and outbound changes go through the API, without dropping the other connections
+		if !isForce && s.hotReload(xrayConfig) {
+			s.recordLiveProfile()
+			s.setOutboundProbeTargets(xrayConfig)
 			return nil
 		}
-		err := p.Stop()
+		err := running.Stop()
 		if err != nil {
 			logger.Errorf("Error stopping Xray: %v", err)
 		}
 	}
 
-	p = xray.NewProcess(xrayConfig)
+	// Xray inherits the limit, a busy server easily needs more than the default 1024 files
+	if s.raiseOpenFiles() {
+		if err := sys.RaiseOpenFilesLimit(); err != nil {
+			logger.Warning("Failed to raise the open files limit:", err)
+		}
+	}
+	if err := s.ensureAPIPort(xrayConfig); err != nil {
+		logger.Warning("Failed to find a free port for the xray api:", err)
+	}
+	process := xray.NewProcess(xrayConfig)
+	process.SetLimits(s.getResourceLimits())
+	xrayProcesses.Set(mainXray, process)
 	result = ""
-	err = p.Start()
+	err = process.Start()
 	if err != nil {
 		logger.Errorf("Error starting Xray: %v", err)
 		return err
 	}
-
-	// Start the monitor in a separate goroutine
-	go s.monitorXrayProcess()
-
+	lastXrayRestart.Store(time.Now())
+	s.recordLiveProfile()
+	s.setOutboundProbeTargets(xrayConfig)
 	return nil
 }
 
@@ -259,16 +452,59 @@ func (s *XrayService) StopXray() error {
 	defer lock.Unlock()
 	logger.Debug("Attempting to stop Xray...")
 	if s.IsXrayRunning() {
-		close(s.stopChan) // Signal the monitor to stop
-		return p.Stop()
+		return xrayProcesses.Stop(mainXray)
 	}
 	return errors.New("xray is not running")
 }
 
 func (s *XrayService) SetToNeedRestart() {
+	invalidateXrayConfigCache()
+	if configLocked.Load() {
+		isRestartQueued.Store(true)
+		return
+	}
 	isNeedXrayRestart.Store(true)
 }
 
 func (s *XrayService) IsNeedRestartAndSetFalse() bool {
 	return isNeedXrayRestart.CompareAndSwap(true, false)
 }
+
+// GetOnlineClients maps every client email to the IPs it is connected from right now
+func (s *XrayService) GetOnlineClients() (map[string][]string, error) {
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	var emails []string
+	err := database.GetDB().Model(xray.ClientTraffic{}).Pluck("email", &emails).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Apart from s.xrayAPI, the traffic job keeps using it meanwhile
+	var api xray.XrayAPI
+	err = api.Init(mainXrayProcess().GetAPIPort())
+	if err != nil {
+		return nil, err
+	}
+	defer api.Close()
+	return onlineClients(&api, emails)
+}
+
+func onlineClients(api *xray.XrayAPI, emails []string) (map[string][]string, error) {
+	clients := make(map[string][]string, len(emails))
+	for _, email := range emails {
+		ips, err := api.GetOnlineIPs(email)
+		if err == xray.ErrOnlineIPsUnsupported {
+			logger.Debug("Online IPs are not supported by this Xray core")
+			return nil, err
+		}
+		if err != nil {
+			logger.Warningf("Failed to get online IPs of %s: %v", email, err)
+			continue
+		}
+		sort.Strings(ips)
+		clients[email] = ips
+	}
+	return clients, nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+var (
+	// Xray writes the route of a connection as [inboundTag >> outboundTag], with -> or ==>
+	// when a balancer picked the outbound
+	accessLogInboundRegex = regexp.MustCompile(`(?:accepted|rejected) \S+ \[(\S+) (?:>>|->|==>) `)
+	inboundLogNameRegex   = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// An inbound file is moved to .prev when it grows past this
+const maxInboundAccessLogSize = 10 << 20
+
+// accessLogInbound returns the inbound tag of an access log line, empty when it has none
+func accessLogInbound(line string) string {
+	matches := accessLogInboundRegex.FindStringSubmatch(line)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+func getInboundAccessLogPath(tag string) string {
+	return filepath.Join(xray.GetInboundAccessLogFolder(), inboundLogNameRegex.ReplaceAllString(tag, "_")+".log")
+}
+
+// SplitAccessLog appends the lines of the access log to one file per inbound, when enabled.
+// It runs before the access log is cleared.
+func (s *XrayService) SplitAccessLog(accessLogPath string) error {
+	enable, err := s.settingService.GetInboundAccessLog()
+	if err != nil || !enable {
+		return err
+	}
+	file, err := os.Open(accessLogPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lines := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if tag := accessLogInbound(line); tag != "" {
+			lines[tag] = append(lines[tag], line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err = os.MkdirAll(xray.GetInboundAccessLogFolder(), 0o755); err != nil {
+		return err
+	}
+	for tag, tagLines := range lines {
+		if err := appendInboundAccessLog(getInboundAccessLogPath(tag), tagLines); err != nil {
+			logger.Warningf("Failed to write access log of inbound %s: %v", tag, err)
+		}
+	}
+	return nil
+}
+
+func appendInboundAccessLog(path string, lines []string) error {
+	if info, err := os.Stat(path); err == nil && info.Size() > maxInboundAccessLogSize {
+		if err := os.Rename(path, path+".prev"); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.WriteString(line)
+		writer.WriteByte('\n')
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GetInboundAccessLogs returns the last count access log lines of the inbound. The lines already
+// moved out of the access log are read from the inbound file when splitting is enabled, and from
+// the persistent access log otherwise.
+func (s *XrayService) GetInboundAccessLogs(tag string, count int) ([]string, error) {
+	enable, err := s.settingService.GetInboundAccessLog()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	if enable {
+		paths = append(paths, getInboundAccessLogPath(tag))
+	} else {
+		paths = append(paths, xray.GetAccessPersistentLogPath())
+	}
+	if accessLogPath, err := xray.GetAccessLogPath(); err == nil && accessLogPath != "" && accessLogPath != "none" {
+		paths = append(paths, accessLogPath)
+	}
+
+	lines := []string{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if accessLogInbound(line) != tag {
+				continue
+			}
+			lines = append(lines, line)
+			if count > 0 && len(lines) > 2*count {
+				lines = append(lines[:0], lines[len(lines)-count:]...)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if count > 0 && len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return lines, nil
+}
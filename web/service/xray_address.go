@@ -0,0 +1,216 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/util/common"
+)
+
+const publicAddressTTL = 30 * time.Minute
+
+var (
+	publicAddressLock    sync.Mutex
+	publicAddressCache   string
+	publicAddressExpires time.Time
+)
+
+// ServerPublicAddress returns the public IP or hostname of the server.
+// A static publicAddress setting always wins, otherwise the address is resolved with
+// the configured mode (http echo service or stun) and cached for publicAddressTTL.
+func (s *XrayService) ServerPublicAddress() (string, error) {
+	static, err := s.settingService.GetPublicAddress()
+	if err != nil {
+		return "", err
+	}
+	if static = strings.TrimSpace(static); static != "" {
+		return static, nil
+	}
+
+	publicAddressLock.Lock()
+	if publicAddressCache != "" && time.Now().Before(publicAddressExpires) {
+		address := publicAddressCache
+		publicAddressLock.Unlock()
+		return address, nil
+	}
+	publicAddressLock.Unlock()
+
+	// Resolved without the lock, a slow echo service or stun server would block every caller
+	address, err := s.resolvePublicAddress()
+	if err != nil {
+		return "", err
+	}
+	publicAddressLock.Lock()
+	publicAddressCache = address
+	publicAddressExpires = time.Now().Add(publicAddressTTL)
+	publicAddressLock.Unlock()
+	return address, nil
+}
+
+// resolvePublicAddress looks the address up with the configured mode
+func (s *XrayService) resolvePublicAddress() (string, error) {
+	mode, err := s.settingService.GetPublicAddressMode()
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case "http":
+		echoURL, err := s.settingService.GetPublicAddressEcho()
+		if err != nil {
+			return "", err
+		}
+		return s.resolvePublicAddressByHTTP(echoURL)
+	case "stun":
+		server, err := s.settingService.GetPublicAddressStun()
+		if err != nil {
+			return "", err
+		}
+		return resolvePublicAddressByStun(server)
+	default:
+		return "", common.NewErrorf("unknown public address mode: %s", mode)
+	}
+}
+
+// resolvePublicAddressByHTTP asks an echo service for our address, using the Warp retry client
+func (s *XrayService) resolvePublicAddressByHTTP(echoURL string) (string, error) {
+	req, err := http.NewRequest("GET", echoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	warpService := WarpService{SettingService: s.settingService}
+	resp, err := warpService.doWithRetry(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("echo service returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	address := strings.TrimSpace(string(body))
+	if net.ParseIP(address) == nil {
+		return "", common.NewErrorf("echo service returned an invalid address: %q", address)
+	}
+	return address, nil
+}
+
+const (
+	stunMagicCookie       = 0x2112A442
+	stunBindingRequest    = 0x0001
+	stunBindingSuccess    = 0x0101
+	stunAttrMappedAddr    = 0x0001
+	stunAttrXorMappedAddr = 0x0020
+)
+
+// resolvePublicAddressByStun sends a STUN binding request (RFC 5389) and returns the mapped address
+func resolvePublicAddressByStun(server string) (string, error) {
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	transactionId := request[8:20]
+	if _, err = rand.Read(transactionId); err != nil {
+		return "", err
+	}
+	if _, err = conn.Write(request); err != nil {
+		return "", err
+	}
+
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", err
+	}
+	return parseStunResponse(response[:n], transactionId)
+}
+
+func parseStunResponse(response []byte, transactionId []byte) (string, error) {
+	if len(response) < 20 {
+		return "", common.NewError("stun response is too short")
+	}
+	if binary.BigEndian.Uint16(response[0:2]) != stunBindingSuccess {
+		return "", common.NewError("stun server did not return a binding success")
+	}
+	if !bytes.Equal(response[8:20], transactionId) {
+		return "", common.NewError("stun transaction id mismatch")
+	}
+
+	length := int(binary.BigEndian.Uint16(response[2:4]))
+	attrs := response[20:]
+	if len(attrs) > length {
+		attrs = attrs[:length]
+	}
+	var mapped string
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLen {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunAttrXorMappedAddr:
+			if ip := parseStunAddress(value, true, response[4:20]); ip != nil {
+				return ip.String(), nil
+			}
+		case stunAttrMappedAddr:
+			if ip := parseStunAddress(value, false, nil); ip != nil {
+				mapped = ip.String()
+			}
+		}
+		// Attributes are padded to 4 bytes
+		next := 4 + (attrLen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == "" {
+		return "", common.NewError("stun response has no mapped address")
+	}
+	return mapped, nil
+}
+
+func parseStunAddress(value []byte, xored bool, key []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xored {
+		// The key is the magic cookie followed by the transaction id
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func resetPublicAddressCache() {
+	publicAddressLock.Lock()
+	publicAddressCache = ""
+	publicAddressExpires = time.Time{}
+	publicAddressLock.Unlock()
+}
+
+func TestServerPublicAddress(t *testing.T) {
+	var requests atomic.Int32
+	var reply atomic.Value
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(reply.Load().(string)))
+	}))
+	defer echo.Close()
+
+	tests := []struct {
+		name         string
+		static       string
+		mode         string
+		reply        string
+		cached       string
+		want         string
+		wantErr      string
+		wantRequests int32
+	}{
+		{name: "static setting wins", static: " vpn.example.com ", mode: "http", reply: "203.0.113.1", want: "vpn.example.com"},
+		{name: "echo service", mode: "http", reply: "203.0.113.7\n", want: "203.0.113.7", wantRequests: 1},
+		{name: "ipv6 echo", mode: "http", reply: "2001:db8::1", want: "2001:db8::1", wantRequests: 1},
+		{name: "cached address", mode: "http", reply: "203.0.113.9", cached: "203.0.113.7", want: "203.0.113.7"},
+		{name: "invalid echo reply", mode: "http", reply: "<html>", wantErr: "invalid address", wantRequests: 1},
+		{name: "unknown mode", mode: "dns", wantErr: "unknown public address mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			resetPublicAddressCache()
+			settingService := SettingService{}
+			for key, value := range map[string]string{
+				"publicAddress":     tt.static,
+				"publicAddressMode": tt.mode,
+				"publicAddressEcho": echo.URL,
+			} {
+				if err := settingService.saveSetting(key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Saving the settings drops the cache, so it is filled afterwards
+			if tt.cached != "" {
+				publicAddressCache = tt.cached
+				publicAddressExpires = time.Now().Add(time.Minute)
+			}
+			reply.Store(tt.reply)
+			requests.Store(0)
+
+			s := &XrayService{}
+			got, err := s.ServerPublicAddress()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %v, want error %q", got, err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("echo service asked %d times, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+	resetPublicAddressCache()
+}
+
+// stunResponse builds a binding success with the given attributes
+func stunResponse(transactionId []byte, attrs ...[]byte) []byte {
+	response := make([]byte, 20)
+	binary.BigEndian.PutUint16(response[0:2], stunBindingSuccess)
+	binary.BigEndian.PutUint32(response[4:8], stunMagicCookie)
+	copy(response[8:20], transactionId)
+	for _, attr := range attrs {
+		response = append(response, attr...)
+	}
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(response)-20))
+	return response
+}
+
+func stunAddressAttr(attrType uint16, ip net.IP, transactionId []byte) []byte {
+	family, raw := byte(0x01), []byte(ip.To4())
+	if raw == nil {
+		family, raw = 0x02, []byte(ip.To16())
+	}
+	value := append([]byte{0, family, 0, 0}, raw...)
+	if attrType == stunAttrXorMappedAddr {
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+		copy(key[4:], transactionId)
+		for i := range raw {
+			value[4+i] ^= key[i]
+		}
+	}
+	attr := make([]byte, 4)
+	binary.BigEndian.PutUint16(attr[0:2], attrType)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+	return append(attr, value...)
+}
+
+func TestParseStunResponse(t *testing.T) {
+	id := []byte("0123456789ab")
+	otherId := []byte("ba9876543210")
+	software := []byte{0x80, 0x22, 0, 3, 'x', 'u', 'i', 0}
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     string
+		wantErr  string
+	}{
+		{"xor mapped ipv4", stunResponse(id, stunAddressAttr(stunAttrXorMappedAddr, net.ParseIP("198.51.100.4"), id)), "198.51.100.4", ""},
+		{"xor mapped ipv6", stunResponse(id, stunAddressAttr(stunAttrXorMappedAddr, net.ParseIP("2001:db8::42"), id)), "2001:db8::42", ""},
+		{"mapped address fallback", stunResponse(id, software, stunAddressAttr(stunAttrMappedAddr, net.ParseIP("198.51.100.5"), id)), "198.51.100.5", ""},
+		{"xor mapped preferred", stunResponse(id, stunAddressAttr(stunAttrMappedAddr, net.ParseIP("10.0.0.1"), id), stunAddressAttr(stunAttrXorMappedAddr, net.ParseIP("198.51.100.6"), id)), "198.51.100.6", ""},
+		{"too short", []byte{1, 1, 0}, "", "too short"},
+		{"transaction mismatch", stunResponse(otherId, stunAddressAttr(stunAttrXorMappedAddr, net.ParseIP("198.51.100.4"), otherId)), "", "mismatch"},
+		{"no address", stunResponse(id, software), "", "no mapped address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStunResponse(tt.response, id)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePublicAddressByStun(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		request := make([]byte, 1500)
+		n, addr, err := conn.ReadFrom(request)
+		if err != nil || n < 20 {
+			return
+		}
+		id := request[8:20]
+		conn.WriteTo(stunResponse(id, stunAddressAttr(stunAttrXorMappedAddr, net.ParseIP("192.0.2.77"), id)), addr)
+	}()
+
+	got, err := resolvePublicAddressByStun(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "192.0.2.77" {
+		t.Fatalf("got %q, want 192.0.2.77", got)
+	}
+}
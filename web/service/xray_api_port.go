@@ -0,0 +1,128 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// APIPortFallback replaces the api inbound port of the template, Port, with the free port Xray
+// was started on when Port was taken by another process
+type APIPortFallback struct {
+	Port     int `json:"port"`
+	Fallback int `json:"fallback"`
+}
+
+func (s *XrayService) getAPIPortFallback() (*APIPortFallback, error) {
+	fallback := &APIPortFallback{}
+	data, err := s.settingService.GetXrayAPIPortFallback()
+	if err != nil || data == "" {
+		return fallback, err
+	}
+	err = json.Unmarshal([]byte(data), fallback)
+	if err != nil {
+		return nil, err
+	}
+	return fallback, nil
+}
+
+func getAPIInbound(xrayConfig *xray.Config) *xray.InboundConfig {
+	for i := range xrayConfig.InboundConfigs {
+		if xrayConfig.InboundConfigs[i].Tag == statsAPITag {
+			return &xrayConfig.InboundConfigs[i]
+		}
+	}
+	return nil
+}
+
+// applyAPIPortFallback keeps the api inbound on the port chosen when the template one was taken,
+// so the generated config matches the running one
+func (s *XrayService) applyAPIPortFallback(xrayConfig *xray.Config) error {
+	inbound := getAPIInbound(xrayConfig)
+	if inbound == nil {
+		return nil
+	}
+	fallback, err := s.getAPIPortFallback()
+	if err != nil {
+		return err
+	}
+	if fallback.Fallback > 0 && inbound.Port == fallback.Port {
+		inbound.Port = fallback.Fallback
+	}
+	return nil
+}
+
+// getListenAddress returns the address of the inbound listen field, empty for all addresses
+func getListenAddress(inbound *xray.InboundConfig) string {
+	var address string
+	if len(inbound.Listen) > 0 {
+		json.Unmarshal(inbound.Listen, &address)
+	}
+	return address
+}
+
+// canListen tells whether the port can be bound on address
+func canListen(address string, port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// ensureAPIPort moves the api inbound to a free port when its port is already bound, and keeps
+// the choice for the next configs. Once the port of the template is free again the api moves back
+// and the choice is dropped. It runs after the old process stopped, right before the start.
+func (s *XrayService) ensureAPIPort(xrayConfig *xray.Config) error {
+	inbound := getAPIInbound(xrayConfig)
+	if inbound == nil || inbound.Port <= 0 {
+		return nil
+	}
+	address := getListenAddress(inbound)
+	fallback, err := s.getAPIPortFallback()
+	if err != nil {
+		return err
+	}
+	if fallback.Fallback > 0 && inbound.Port == fallback.Fallback && canListen(address, fallback.Port) {
+		inbound.Port = fallback.Port
+	}
+	if canListen(address, inbound.Port) {
+		if fallback.Fallback > 0 && inbound.Port != fallback.Fallback {
+			if err = s.settingService.SetXrayAPIPortFallback(""); err != nil {
+				return err
+			}
+			invalidateXrayConfigCache()
+			logger.Infof("Xray api is back on port %d", inbound.Port)
+		}
+		return nil
+	}
+	logger.Warningf("Xray api port %d is not available", inbound.Port)
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		return err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// The port of the template stays the key, the fallback may have been taken as well
+	if fallback.Fallback != inbound.Port {
+		fallback.Port = inbound.Port
+	}
+	fallback.Fallback = port
+	data, err := json.Marshal(fallback)
+	if err != nil {
+		return err
+	}
+	if err = s.settingService.SetXrayAPIPortFallback(string(data)); err != nil {
+		return err
+	}
+	invalidateXrayConfigCache()
+	logger.Warningf("Xray api moved from port %d to %d", fallback.Port, port)
+	inbound.Port = port
+	return nil
+}
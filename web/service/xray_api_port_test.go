@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"x-ui/xray"
+)
+
+// listenTestPort binds a free port for the test and returns it
+func listenTestPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// freeTestPort returns a port that nothing listens on
+func freeTestPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestEnsureAPIPort(t *testing.T) {
+	taken := listenTestPort(t)
+	takenFallback := listenTestPort(t)
+	free := freeTestPort(t)
+	tests := []struct {
+		name         string
+		port         int
+		fallback     *APIPortFallback
+		wantPort     int // 0 for a newly chosen port
+		wantFallback *APIPortFallback
+	}{
+		{name: "free port", port: free, wantPort: free},
+		{name: "taken port moves", port: taken, wantFallback: &APIPortFallback{Port: taken}},
+		{name: "back to the free template port", port: 1, fallback: &APIPortFallback{Port: free, Fallback: 1}, wantPort: free},
+		{
+			name:         "template port still taken",
+			port:         free,
+			fallback:     &APIPortFallback{Port: taken, Fallback: free},
+			wantPort:     free,
+			wantFallback: &APIPortFallback{Port: taken, Fallback: free},
+		},
+		{name: "unused fallback is dropped", port: free, fallback: &APIPortFallback{Port: 62789, Fallback: 1}, wantPort: free},
+		{name: "taken fallback moves again", port: takenFallback, fallback: &APIPortFallback{Port: taken, Fallback: takenFallback}, wantFallback: &APIPortFallback{Port: taken}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			resetXrayConfigCache(t)
+			s := &XrayService{}
+			if tt.fallback != nil {
+				data, _ := json.Marshal(tt.fallback)
+				if err := s.settingService.SetXrayAPIPortFallback(string(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			xrayConfig := &xray.Config{InboundConfigs: []xray.InboundConfig{
+				{Tag: statsAPITag, Listen: []byte(`"127.0.0.1"`), Port: tt.port, Protocol: "dokodemo-door"},
+			}}
+			if err := s.ensureAPIPort(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+			port := xrayConfig.InboundConfigs[0].Port
+			if tt.wantPort != 0 && port != tt.wantPort {
+				t.Fatalf("got port %d, want %d", port, tt.wantPort)
+			}
+			if tt.wantPort == 0 && (port == tt.port || port <= 0) {
+				t.Fatalf("got port %d, want a new one", port)
+			}
+
+			stored, err := s.getAPIPortFallback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantFallback
+			if want == nil {
+				want = &APIPortFallback{}
+			} else if want.Fallback == 0 {
+				want = &APIPortFallback{Port: want.Port, Fallback: port}
+			}
+			if *stored != *want {
+				t.Fatalf("got fallback %+v, want %+v", *stored, *want)
+			}
+		})
+	}
+}
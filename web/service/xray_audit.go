@@ -0,0 +1,179 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"x-ui/xray"
+)
+
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// SecurityFinding is a risky pattern found in the generated config
+type SecurityFinding struct {
+	Check       string `json:"check"`
+	Severity    string `json:"severity"`
+	Target      string `json:"target"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation"`
+}
+
+// Protocols carrying the payload in the clear unless the stream adds tls or reality
+var plaintextProtocols = map[string]bool{
+	"vless": true, "trojan": true, "http": true, "socks": true, "mixed": true,
+}
+
+var legacyTlsVersions = map[string]bool{"1.0": true, "1.1": true}
+
+// SecurityAudit checks the generated config for settings that leak the server IP or weaken the encryption
+func (s *XrayService) SecurityAudit() ([]SecurityFinding, error) {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	findings := auditOutbounds(outbounds)
+	for _, inbound := range xrayConfig.InboundConfigs {
+		inboundFindings, err := auditInbound(&inbound)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, inboundFindings...)
+	}
+	return findings, nil
+}
+
+func auditOutbounds(outbounds []map[string]interface{}) []SecurityFinding {
+	var findings []SecurityFinding
+	if len(outbounds) > 0 {
+		protocol, _ := outbounds[0]["protocol"].(string)
+		tag, _ := outbounds[0]["tag"].(string)
+		if protocol == "freedom" {
+			findings = append(findings, SecurityFinding{
+				Check:       "default-freedom",
+				Severity:    SeverityMedium,
+				Target:      "outbound " + tag,
+				Message:     "unmatched traffic leaves directly from the server IP",
+				Remediation: "make a proxy or warp outbound the first one, or route only the wanted destinations to freedom",
+			})
+		}
+	}
+
+	hasDns := false
+	for _, outbound := range outbounds {
+		protocol, _ := outbound["protocol"].(string)
+		tag, _ := outbound["tag"].(string)
+		if protocol == "dns" {
+			hasDns = true
+		}
+		stream, _ := outbound["streamSettings"].(map[string]interface{})
+		tlsSettings, _ := stream["tlsSettings"].(map[string]interface{})
+		if insecure, _ := tlsSettings["allowInsecure"].(bool); insecure {
+			findings = append(findings, SecurityFinding{
+				Check:       "insecure-tls",
+				Severity:    SeverityHigh,
+				Target:      "outbound " + tag,
+				Message:     "certificate verification is disabled",
+				Remediation: "remove allowInsecure and set serverName to a name the certificate covers",
+			})
+		}
+	}
+	if !hasDns {
+		findings = append(findings, SecurityFinding{
+			Check:       "no-dns-outbound",
+			Severity:    SeverityLow,
+			Target:      "outbounds",
+			Message:     "client DNS queries are not handled by Xray and may be resolved outside the tunnel",
+			Remediation: "add a dns outbound and route port 53 to it",
+		})
+	}
+	return findings
+}
+
+func isLoopbackListen(listen []byte) bool {
+	var address string
+	if json.Unmarshal(listen, &address) != nil {
+		return false
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+func auditInbound(inbound *xray.InboundConfig) ([]SecurityFinding, error) {
+	var findings []SecurityFinding
+	target := "inbound " + inbound.Tag
+	settings := map[string]interface{}{}
+	if len(inbound.Settings) > 0 {
+		if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+			return nil, err
+		}
+	}
+	stream := map[string]interface{}{}
+	if len(inbound.StreamSettings) > 0 {
+		if err := json.Unmarshal(inbound.StreamSettings, &stream); err != nil {
+			return nil, err
+		}
+	}
+	security, _ := stream["security"].(string)
+
+	if plaintextProtocols[inbound.Protocol] && (security == "" || security == "none") && !isLoopbackListen(inbound.Listen) {
+		findings = append(findings, SecurityFinding{
+			Check:       "plaintext-inbound",
+			Severity:    SeverityHigh,
+			Target:      target,
+			Message:     fmt.Sprintf("%s without tls or reality is readable on the wire", inbound.Protocol),
+			Remediation: "enable tls or reality, or listen on 127.0.0.1 behind a tls terminating proxy",
+		})
+	}
+
+	if inbound.Protocol == "shadowsocks" {
+		methods := clientValues(settings, "method")
+		if method, _ := settings["method"].(string); method != "" {
+			methods = append(methods, method)
+		}
+		for _, method := range methods {
+			if streamShadowsocksCiphers[method] || method == "none" || method == "plain" {
+				findings = append(findings, SecurityFinding{
+					Check:       "weak-cipher",
+					Severity:    SeverityHigh,
+					Target:      target,
+					Message:     fmt.Sprintf("cipher %s has no authentication", method),
+					Remediation: "use aes-256-gcm, chacha20-poly1305 or a 2022-blake3 method",
+				})
+				break
+			}
+		}
+	}
+
+	if security == "tls" {
+		tlsSettings, _ := stream["tlsSettings"].(map[string]interface{})
+		if minVersion, _ := tlsSettings["minVersion"].(string); legacyTlsVersions[minVersion] {
+			findings = append(findings, SecurityFinding{
+				Check:       "insecure-tls",
+				Severity:    SeverityMedium,
+				Target:      target,
+				Message:     "tls " + minVersion + " is accepted",
+				Remediation: "set minVersion to 1.2 or higher",
+			})
+		}
+		if suites, _ := tlsSettings["cipherSuites"].(string); strings.Contains(suites, "CBC") || strings.Contains(suites, "RC4") {
+			findings = append(findings, SecurityFinding{
+				Check:       "weak-cipher",
+				Severity:    SeverityMedium,
+				Target:      target,
+				Message:     "tls cipher suites include CBC or RC4 ones",
+				Remediation: "remove cipherSuites to use the Go defaults, or keep only GCM and CHACHA20 suites",
+			})
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"x-ui/xray"
+)
+
+// findingChecks lists the check and severity of every finding
+func findingChecks(findings []SecurityFinding) []string {
+	var checks []string
+	for _, finding := range findings {
+		checks = append(checks, finding.Check+"/"+finding.Severity)
+	}
+	return checks
+}
+
+func TestAuditOutbounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		outbounds string
+		want      []string
+	}{
+		{
+			name:      "proxy first with dns",
+			outbounds: `[{"tag": "proxy", "protocol": "vless"}, {"tag": "direct", "protocol": "freedom"}, {"tag": "dns-out", "protocol": "dns"}]`,
+		},
+		{
+			name:      "freedom first",
+			outbounds: `[{"tag": "direct", "protocol": "freedom"}, {"tag": "dns-out", "protocol": "dns"}]`,
+			want:      []string{"default-freedom/medium"},
+		},
+		{
+			name:      "no dns outbound",
+			outbounds: `[{"tag": "proxy", "protocol": "vless"}]`,
+			want:      []string{"no-dns-outbound/low"},
+		},
+		{
+			name:      "insecure tls",
+			outbounds: `[{"tag": "proxy", "protocol": "trojan", "streamSettings": {"security": "tls", "tlsSettings": {"allowInsecure": true}}}, {"tag": "dns-out", "protocol": "dns"}]`,
+			want:      []string{"insecure-tls/high"},
+		},
+		{
+			name:      "no outbounds",
+			outbounds: `[]`,
+			want:      []string{"no-dns-outbound/low"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outbounds []map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.outbounds), &outbounds); err != nil {
+				t.Fatal(err)
+			}
+			got := findingChecks(auditOutbounds(outbounds))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got findings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditInbound(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		listen   string
+		settings string
+		stream   string
+		want     []string
+	}{
+		{name: "vless with reality", protocol: "vless", stream: `{"security": "reality"}`},
+		{name: "plaintext vless", protocol: "vless", stream: `{"security": "none"}`, want: []string{"plaintext-inbound/high"}},
+		{name: "plaintext without stream settings", protocol: "trojan", want: []string{"plaintext-inbound/high"}},
+		{name: "plaintext on loopback", protocol: "vless", listen: `"127.0.0.1"`, stream: `{"security": "none"}`},
+		{name: "vmess encrypts itself", protocol: "vmess", stream: `{"security": "none"}`},
+		{name: "strong shadowsocks", protocol: "shadowsocks", settings: `{"method": "2022-blake3-aes-128-gcm"}`},
+		{name: "weak shadowsocks method", protocol: "shadowsocks", settings: `{"method": "aes-256-cfb"}`, want: []string{"weak-cipher/high"}},
+		{name: "weak shadowsocks client", protocol: "shadowsocks", settings: `{"clients": [{"method": "aes-128-gcm"}, {"method": "none"}]}`, want: []string{"weak-cipher/high"}},
+		{name: "legacy tls version", protocol: "vless", stream: `{"security": "tls", "tlsSettings": {"minVersion": "1.0"}}`, want: []string{"insecure-tls/medium"}},
+		{name: "cbc cipher suites", protocol: "vless", stream: `{"security": "tls", "tlsSettings": {"cipherSuites": "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"}}`, want: []string{"weak-cipher/medium"}},
+		{name: "modern tls", protocol: "vless", stream: `{"security": "tls", "tlsSettings": {"minVersion": "1.3"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := &xray.InboundConfig{Tag: "inbound-20001", Protocol: tt.protocol}
+			if tt.listen != "" {
+				inbound.Listen = []byte(tt.listen)
+			}
+			if tt.settings != "" {
+				inbound.Settings = []byte(tt.settings)
+			}
+			if tt.stream != "" {
+				inbound.StreamSettings = []byte(tt.stream)
+			}
+			findings, err := auditInbound(inbound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := findingChecks(findings); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got findings %v, want %v", got, tt.want)
+			}
+			for _, finding := range findings {
+				if finding.Target != "inbound inbound-20001" || finding.Remediation == "" {
+					t.Fatalf("got finding %+v", finding)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityAudit(t *testing.T) {
+	setChainTestTemplate(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	findings, err := s.SecurityAudit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"default-freedom/medium", "no-dns-outbound/low", "plaintext-inbound/high"}
+	if got := findingChecks(findings); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got findings %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+const (
+	BalancerRandom    = "random"
+	BalancerLeastPing = "leastPing"
+)
+
+// Balancer spreads the traffic of InboundTags, or all traffic not matched by an earlier rule,
+// over the outbounds whose tag starts with one of Selector
+type Balancer struct {
+	Tag         string   `json:"tag"`
+	Selector    []string `json:"selector"`
+	Strategy    string   `json:"strategy"`
+	FallbackTag string   `json:"fallbackTag,omitempty"`
+	InboundTags []string `json:"inboundTags,omitempty"`
+}
+
+// ObservatorySettings configures how leastPing balancers probe their outbounds. Burst uses the
+// burstObservatory, which samples several probes per outbound, instead of the observatory.
+type ObservatorySettings struct {
+	Burst             bool   `json:"burst"`
+	ProbeURL          string `json:"probeUrl,omitempty"`
+	ProbeInterval     string `json:"probeInterval,omitempty"`
+	EnableConcurrency bool   `json:"enableConcurrency,omitempty"`
+	// Only read by the burstObservatory
+	Connectivity string `json:"connectivity,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+	Sampling     int    `json:"sampling,omitempty"`
+}
+
+func (s *XrayService) GetObservatory() (ObservatorySettings, error) {
+	observatory := ObservatorySettings{}
+	data, err := s.settingService.GetObservatory()
+	if err != nil {
+		return observatory, err
+	}
+	if data == "" {
+		return observatory, nil
+	}
+	err = json.Unmarshal([]byte(data), &observatory)
+	return observatory, err
+}
+
+func (s *XrayService) SetObservatory(observatory ObservatorySettings) error {
+	for _, probeURL := range []string{observatory.ProbeURL, observatory.Connectivity} {
+		if probeURL == "" {
+			continue
+		}
+		u, err := url.Parse(probeURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return common.NewErrorf("invalid probe url %q", probeURL)
+		}
+	}
+	for _, duration := range []string{observatory.ProbeInterval, observatory.Timeout} {
+		if duration == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(duration); err != nil || d <= 0 {
+			return common.NewErrorf("invalid duration %q", duration)
+		}
+	}
+	if observatory.Sampling < 0 {
+		return common.NewError("sampling can not be negative")
+	}
+	data, err := json.MarshalIndent(observatory, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetObservatory(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+func (s *XrayService) GetBalancers() ([]Balancer, error) {
+	balancers := []Balancer{}
+	data, err := s.settingService.GetBalancers()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return balancers, nil
+	}
+	err = json.Unmarshal([]byte(data), &balancers)
+	if err != nil {
+		return nil, err
+	}
+	return balancers, nil
+}
+
+func (s *XrayService) saveBalancers(balancers []Balancer) error {
+	data, err := json.MarshalIndent(balancers, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetBalancers(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// selectorMatches tells whether an Xray balancer selector, a tag prefix, matches any of the tags
+func selectorMatches(selector string, tags map[string]bool) bool {
+	for tag := range tags {
+		if strings.HasPrefix(tag, selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBalancer adds or replaces the balancer with the same tag
+func (s *XrayService) SetBalancer(balancer Balancer) error {
+	if balancer.Tag == "" {
+		return common.NewError("balancer tag is empty")
+	}
+	if balancer.Strategy != BalancerRandom && balancer.Strategy != BalancerLeastPing {
+		return common.NewErrorf("unsupported balancer strategy %q", balancer.Strategy)
+	}
+	if len(balancer.Selector) == 0 {
+		return common.NewError("balancer selects no outbound")
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	for _, selector := range balancer.Selector {
+		if selector == "" || !selectorMatches(selector, tags) {
+			return common.NewErrorf("no outbound matches selector %q", selector)
+		}
+	}
+	if balancer.FallbackTag != "" && !tags[balancer.FallbackTag] {
+		return common.NewErrorf("outbound %s does not exist", balancer.FallbackTag)
+	}
+
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range balancers {
+		if balancers[i].Tag == balancer.Tag {
+			balancers[i] = balancer
+			replaced = true
+		}
+	}
+	if !replaced {
+		balancers = append(balancers, balancer)
+	}
+	return s.saveBalancers(balancers)
+}
+
+func (s *XrayService) RemoveBalancer(tag string) error {
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		return err
+	}
+	for i := range balancers {
+		if balancers[i].Tag == tag {
+			return s.saveBalancers(append(balancers[:i], balancers[i+1:]...))
+		}
+	}
+	return common.NewErrorf("balancer %s does not exist", tag)
+}
+
+// applyBalancers adds the balancers and their rules after the template rules. leastPing needs the
+// observatory to probe the selected outbounds, their selectors are added to it.
+func (s *XrayService) applyBalancers(xrayConfig *xray.Config) error {
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		return err
+	}
+	if len(balancers) == 0 {
+		return nil
+	}
+	tags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["balancers"].([]interface{})
+	balancerTags := map[string]bool{}
+	for _, b := range existing {
+		if m, ok := b.(map[string]interface{}); ok {
+			tag, _ := m["tag"].(string)
+			balancerTags[tag] = true
+		}
+	}
+
+	var rules []interface{}
+	var observed []string
+	for _, balancer := range balancers {
+		if balancerTags[balancer.Tag] {
+			logger.Warningf("Skip balancer %s, the template already has one with this tag", balancer.Tag)
+			continue
+		}
+		var selector []interface{}
+		for _, prefix := range balancer.Selector {
+			if selectorMatches(prefix, tags) {
+				selector = append(selector, prefix)
+			}
+		}
+		if len(selector) == 0 {
+			logger.Warningf("Skip balancer %s, no outbound matches its selector", balancer.Tag)
+			continue
+		}
+		config := map[string]interface{}{
+			"tag":      balancer.Tag,
+			"selector": selector,
+			"strategy": map[string]interface{}{"type": balancer.Strategy},
+		}
+		if balancer.FallbackTag != "" && tags[balancer.FallbackTag] {
+			config["fallbackTag"] = balancer.FallbackTag
+		}
+		existing = append(existing, config)
+		balancerTags[balancer.Tag] = true
+		if balancer.Strategy == BalancerLeastPing {
+			observed = append(observed, balancer.Selector...)
+		}
+
+		rule := map[string]interface{}{
+			"type":        "field",
+			"network":     "tcp,udp",
+			"balancerTag": balancer.Tag,
+		}
+		if len(balancer.InboundTags) > 0 {
+			rule["inboundTag"] = balancer.InboundTags
+		}
+		rules = append(rules, rule)
+	}
+	routing["balancers"] = existing
+	if err = setRouting(xrayConfig, routing); err != nil {
+		return err
+	}
+	if err = appendRoutingRules(xrayConfig, rules); err != nil {
+		return err
+	}
+	if len(observed) == 0 {
+		return nil
+	}
+	settings, err := s.GetObservatory()
+	if err != nil {
+		return err
+	}
+	return addObservatorySelectors(xrayConfig, observed, settings)
+}
+
+// addObservatorySelectors adds the selectors to the observatory, or the burstObservatory, and
+// fills the probe options the template does not set
+func addObservatorySelectors(xrayConfig *xray.Config, selectors []string, settings ObservatorySettings) error {
+	if len(selectors) == 0 {
+		return nil
+	}
+	section := &xrayConfig.Observatory
+	if settings.Burst {
+		section = &xrayConfig.BurstObservatory
+	}
+	observatory := map[string]interface{}{}
+	if len(*section) > 0 && string(*section) != "null" {
+		if err := json.Unmarshal(*section, &observatory); err != nil {
+			return err
+		}
+	}
+	subjects, _ := observatory["subjectSelector"].([]interface{})
+	for _, selector := range selectors {
+		if !containsValue(subjects, selector) {
+			subjects = append(subjects, selector)
+		}
+	}
+	observatory["subjectSelector"] = subjects
+
+	setDefault := func(m map[string]interface{}, key string, value interface{}) {
+		if _, ok := m[key]; !ok {
+			m[key] = value
+		}
+	}
+	if settings.Burst {
+		pingConfig, _ := observatory["pingConfig"].(map[string]interface{})
+		if pingConfig == nil {
+			pingConfig = map[string]interface{}{}
+		}
+		if settings.ProbeURL != "" {
+			setDefault(pingConfig, "destination", settings.ProbeURL)
+		}
+		if settings.Connectivity != "" {
+			setDefault(pingConfig, "connectivity", settings.Connectivity)
+		}
+		if settings.ProbeInterval != "" {
+			setDefault(pingConfig, "interval", settings.ProbeInterval)
+		}
+		if settings.Timeout != "" {
+			setDefault(pingConfig, "timeout", settings.Timeout)
+		}
+		if settings.Sampling > 0 {
+			setDefault(pingConfig, "sampling", settings.Sampling)
+		}
+		if len(pingConfig) > 0 {
+			observatory["pingConfig"] = pingConfig
+		}
+	} else {
+		if settings.ProbeURL != "" {
+			setDefault(observatory, "probeUrl", settings.ProbeURL)
+		}
+		if settings.ProbeInterval != "" {
+			setDefault(observatory, "probeInterval", settings.ProbeInterval)
+		}
+		if settings.EnableConcurrency {
+			setDefault(observatory, "enableConcurrency", true)
+		}
+	}
+
+	data, err := json.MarshalIndent(observatory, "", "  ")
+	if err != nil {
+		return err
+	}
+	*section = data
+	return nil
+}
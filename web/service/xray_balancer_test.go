@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestSetBalancer(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name     string
+		balancer Balancer
+		wantErr  string
+	}{
+		{name: "random", balancer: Balancer{Tag: "b1", Selector: []string{"proxy", "warp"}, Strategy: BalancerRandom}},
+		{name: "prefix selector", balancer: Balancer{Tag: "b2", Selector: []string{"pro"}, Strategy: BalancerLeastPing, FallbackTag: "direct"}},
+		{name: "replaces the same tag", balancer: Balancer{Tag: "b1", Selector: []string{"warp"}, Strategy: BalancerRandom}},
+		{name: "no tag", balancer: Balancer{Selector: []string{"proxy"}, Strategy: BalancerRandom}, wantErr: "balancer tag is empty"},
+		{name: "unknown strategy", balancer: Balancer{Tag: "b3", Selector: []string{"proxy"}, Strategy: "roundRobin"}, wantErr: `unsupported balancer strategy "roundRobin"`},
+		{name: "no selector", balancer: Balancer{Tag: "b3", Strategy: BalancerRandom}, wantErr: "balancer selects no outbound"},
+		{name: "unmatched selector", balancer: Balancer{Tag: "b3", Selector: []string{"relay"}, Strategy: BalancerRandom}, wantErr: `no outbound matches selector "relay"`},
+		{name: "unknown fallback", balancer: Balancer{Tag: "b3", Selector: []string{"proxy"}, Strategy: BalancerRandom, FallbackTag: "missing"}, wantErr: "outbound missing does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetBalancer(tt.balancer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	balancers, err := s.GetBalancers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balancers) != 2 || balancers[0].Tag != "b1" || !reflect.DeepEqual(balancers[0].Selector, []string{"warp"}) {
+		t.Fatalf("got balancers %+v", balancers)
+	}
+	if err := s.RemoveBalancer("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveBalancer("b1"); err == nil || !strings.Contains(err.Error(), "balancer b1 does not exist") {
+		t.Fatalf("got error %v removing a missing balancer", err)
+	}
+}
+
+func TestApplyBalancers(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	for _, balancer := range []Balancer{
+		{Tag: "spread", Selector: []string{"proxy", "warp"}, Strategy: BalancerRandom, InboundTags: []string{"inbound-20001"}},
+		{Tag: "fastest", Selector: []string{"proxy", "warp"}, Strategy: BalancerLeastPing, FallbackTag: "direct"},
+	} {
+		if err := s.SetBalancer(balancer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetObservatory(ObservatorySettings{ProbeURL: "https://www.google.com/generate_204", ProbeInterval: "1m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(routing["balancers"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, data, `[
+		{"tag": "spread", "selector": ["proxy", "warp"], "strategy": {"type": "random"}},
+		{"tag": "fastest", "selector": ["proxy", "warp"], "strategy": {"type": "leastPing"}, "fallbackTag": "direct"}
+	]`)
+
+	// The balancer rules come after the template rules
+	rules := configRules(t, xrayConfig)
+	if len(rules) < 2 {
+		t.Fatalf("got rules %v", rules)
+	}
+	spread, fastest := rules[len(rules)-2], rules[len(rules)-1]
+	if spread["balancerTag"] != "spread" || !reflect.DeepEqual(spread["inboundTag"], []interface{}{"inbound-20001"}) {
+		t.Fatalf("got rule %v for the spread balancer", spread)
+	}
+	if fastest["balancerTag"] != "fastest" || fastest["inboundTag"] != nil {
+		t.Fatalf("got rule %v for the fastest balancer", fastest)
+	}
+
+	// Only the leastPing balancer is observed
+	assertJSONEqual(t, xrayConfig.Observatory, `{"subjectSelector": ["proxy", "warp"], "probeUrl": "https://www.google.com/generate_204", "probeInterval": "1m"}`)
+}
+
+func TestAddObservatorySelectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   string
+		settings  ObservatorySettings
+		wantField string
+		want      string
+	}{
+		{
+			name:      "new observatory",
+			settings:  ObservatorySettings{EnableConcurrency: true},
+			wantField: "observatory",
+			want:      `{"subjectSelector": ["proxy"], "enableConcurrency": true}`,
+		},
+		{
+			name:      "template values stay",
+			current:   `{"subjectSelector": ["warp", "proxy"], "probeUrl": "https://template.example.com"}`,
+			settings:  ObservatorySettings{ProbeURL: "https://settings.example.com", ProbeInterval: "30s"},
+			wantField: "observatory",
+			want:      `{"subjectSelector": ["warp", "proxy"], "probeUrl": "https://template.example.com", "probeInterval": "30s"}`,
+		},
+		{
+			name:      "burst",
+			settings:  ObservatorySettings{Burst: true, ProbeURL: "https://probe.example.com", Timeout: "5s", Sampling: 3},
+			wantField: "burstObservatory",
+			want:      `{"subjectSelector": ["proxy"], "pingConfig": {"destination": "https://probe.example.com", "timeout": "5s", "sampling": 3}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xrayConfig := &xray.Config{}
+			if tt.current != "" {
+				xrayConfig.Observatory = []byte(tt.current)
+			}
+			if err := addObservatorySelectors(xrayConfig, []string{"proxy"}, tt.settings); err != nil {
+				t.Fatal(err)
+			}
+			got, other := xrayConfig.Observatory, xrayConfig.BurstObservatory
+			if tt.wantField == "burstObservatory" {
+				got, other = other, got
+			}
+			if len(other) != 0 {
+				t.Fatalf("the other observatory got %s", other)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestSetObservatory(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name    string
+		value   ObservatorySettings
+		wantErr string
+	}{
+		{name: "valid", value: ObservatorySettings{ProbeURL: "https://probe.example.com", ProbeInterval: "10s"}},
+		{name: "probe url without host", value: ObservatorySettings{ProbeURL: "https://"}, wantErr: `invalid probe url "https://"`},
+		{name: "connectivity url scheme", value: ObservatorySettings{Connectivity: "ftp://probe.example.com"}, wantErr: "invalid probe url"},
+		{name: "invalid interval", value: ObservatorySettings{ProbeInterval: "soon"}, wantErr: `invalid duration "soon"`},
+		{name: "zero timeout", value: ObservatorySettings{Timeout: "0s"}, wantErr: `invalid duration "0s"`},
+		{name: "negative sampling", value: ObservatorySettings{Sampling: -1}, wantErr: "sampling can not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetObservatory(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.GetObservatory()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.value {
+				t.Fatalf("got %+v, want %+v", got, tt.value)
+			}
+		})
+	}
+}
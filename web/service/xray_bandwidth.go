@@ -0,0 +1,492 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// BandwidthLimit is a token bucket over the traffic of a client or an inbound. Rate is in KB/s,
+// Burst in KB and defaults to a minute of Rate. Xray has no rate limit of its own, so the traffic
+// is not shaped: a client that empties its bucket is cut off, removed from Xray so it can not
+// connect, until the bucket is half full again.
+type BandwidthLimit struct {
+	Rate  int64 `json:"rate"`
+	Burst int64 `json:"burst,omitempty"`
+}
+
+// BandwidthLimits holds the limits by client email and by inbound tag. The bucket of an inbound
+// is shared by its clients, the ones with traffic when it runs empty are paused.
+type BandwidthLimits struct {
+	Clients  map[string]*BandwidthLimit `json:"clients"`
+	Inbounds map[string]*BandwidthLimit `json:"inbounds"`
+}
+
+// BandwidthPause is a client taken out of Xray for its bandwidth limit
+type BandwidthPause struct {
+	Email string `json:"email"`
+	Limit string `json:"limit"` // client or inbound:<tag>
+	Until int64  `json:"until"` // unix milliseconds
+}
+
+// bandwidthBucket holds the bytes left and when they were counted
+type bandwidthBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take refills the bucket for the time since the last read and takes bytes out of it. When it runs
+// empty it returns how long it needs to be half full again.
+func (b *bandwidthBucket) take(limit *BandwidthLimit, bytes int64, now time.Time) time.Duration {
+	burst := limit.burstBytes()
+	rate := float64(limit.Rate) * 1024
+	b.tokens += rate * now.Sub(b.at).Seconds()
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.at = now
+	b.tokens -= float64(bytes)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration((burst/2 - b.tokens) / rate * float64(time.Second))
+}
+
+var bandwidthState = struct {
+	sync.Mutex
+	// buckets by email, or "inbound:" and the tag
+	buckets map[string]*bandwidthBucket
+	paused  map[string]*BandwidthPause
+}{
+	buckets: map[string]*bandwidthBucket{},
+	paused:  map[string]*BandwidthPause{},
+}
+
+// bandwidthPaused returns the clients paused for their bandwidth limit
+func bandwidthPaused() map[string]bool {
+	bandwidthState.Lock()
+	defer bandwidthState.Unlock()
+	paused := make(map[string]bool, len(bandwidthState.paused))
+	for email := range bandwidthState.paused {
+		paused[email] = true
+	}
+	return paused
+}
+
+func (l *BandwidthLimit) burstBytes() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst) * 1024
+	}
+	return float64(l.Rate) * 1024 * 60
+}
+
+func (s *XrayService) GetBandwidthLimits() (*BandwidthLimits, error) {
+	limits := &BandwidthLimits{
+		Clients:  map[string]*BandwidthLimit{},
+		Inbounds: map[string]*BandwidthLimit{},
+	}
+	data, err := s.settingService.GetBandwidthLimits()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return limits, nil
+	}
+	err = json.Unmarshal([]byte(data), limits)
+	if err != nil {
+		return nil, err
+	}
+	if limits.Clients == nil {
+		limits.Clients = map[string]*BandwidthLimit{}
+	}
+	if limits.Inbounds == nil {
+		limits.Inbounds = map[string]*BandwidthLimit{}
+	}
+	return limits, nil
+}
+
+func (s *XrayService) saveBandwidthLimits(limits *BandwidthLimits) error {
+	data, err := json.MarshalIndent(limits, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.settingService.SetBandwidthLimits(string(data))
+}
+
+func checkBandwidthLimit(limit *BandwidthLimit) error {
+	if limit.Rate < 0 {
+		return common.NewErrorf("invalid bandwidth rate %d", limit.Rate)
+	}
+	if limit.Burst < 0 || (limit.Burst > 0 && limit.Burst < limit.Rate) {
+		return common.NewError("bandwidth burst must be at least the rate")
+	}
+	return nil
+}
+
+// SetClientBandwidthLimit limits the traffic of the client, a rate of 0 removes the limit
+func (s *XrayService) SetClientBandwidthLimit(email string, limit BandwidthLimit) error {
+	if email == "" {
+		return common.NewError("client email is required")
+	}
+	if err := checkBandwidthLimit(&limit); err != nil {
+		return err
+	}
+	limits, err := s.GetBandwidthLimits()
+	if err != nil {
+		return err
+	}
+	if limit.Rate == 0 {
+		delete(limits.Clients, email)
+	} else {
+		if err := s.checkClientExists(email); err != nil {
+			return err
+		}
+		limits.Clients[email] = &limit
+	}
+	s.resetBandwidthBucket(email)
+	return s.saveBandwidthLimits(limits)
+}
+
+// SetInboundBandwidthLimit limits the traffic of all clients of the inbound together, a rate of 0
+// removes the limit
+func (s *XrayService) SetInboundBandwidthLimit(tag string, limit BandwidthLimit) error {
+	if tag == "" {
+		return common.NewError("inbound tag is required")
+	}
+	if err := checkBandwidthLimit(&limit); err != nil {
+		return err
+	}
+	limits, err := s.GetBandwidthLimits()
+	if err != nil {
+		return err
+	}
+	if limit.Rate == 0 {
+		delete(limits.Inbounds, tag)
+	} else {
+		var count int64
+		err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", tag).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return common.NewErrorf("inbound %s does not exist", tag)
+		}
+		limits.Inbounds[tag] = &limit
+	}
+	s.resetBandwidthBucket("inbound:" + tag)
+	return s.saveBandwidthLimits(limits)
+}
+
+// resetBandwidthBucket makes a changed limit start over with a full bucket
+func (s *XrayService) resetBandwidthBucket(key string) {
+	bandwidthState.Lock()
+	delete(bandwidthState.buckets, key)
+	bandwidthState.Unlock()
+}
+
+// GetBandwidthPauses returns the clients paused for their bandwidth limit
+func (s *XrayService) GetBandwidthPauses() []*BandwidthPause {
+	bandwidthState.Lock()
+	pauses := make([]*BandwidthPause, 0, len(bandwidthState.paused))
+	for _, pause := range bandwidthState.paused {
+		copied := *pause
+		pauses = append(pauses, &copied)
+	}
+	bandwidthState.Unlock()
+	sort.Slice(pauses, func(i, j int) bool {
+		return pauses[i].Email < pauses[j].Email
+	})
+	return pauses
+}
+
+// ApplyBandwidthLimits takes the traffic read from Xray out of the buckets. The clients whose
+// bucket, or whose inbound's bucket, runs empty are disconnected: they are removed from the main
+// Xray through the API until it refills, then added back the same way.
+func (s *XrayService) ApplyBandwidthLimits(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) error {
+	limits, err := s.GetBandwidthLimits()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	bandwidthState.Lock()
+	var resumed []string
+	for email, pause := range bandwidthState.paused {
+		if now.UnixMilli() >= pause.Until {
+			delete(bandwidthState.paused, email)
+			resumed = append(resumed, email)
+		}
+	}
+	take := func(key string, limit *BandwidthLimit, bytes int64) time.Duration {
+		bucket, ok := bandwidthState.buckets[key]
+		if !ok {
+			bucket = &bandwidthBucket{tokens: limit.burstBytes(), at: now}
+			bandwidthState.buckets[key] = bucket
+		}
+		return bucket.take(limit, bytes, now)
+	}
+
+	pauses := map[string]*BandwidthPause{}
+	pause := func(email string, limit string, wait time.Duration) {
+		if _, ok := bandwidthState.paused[email]; ok {
+			return
+		}
+		until := now.Add(wait).UnixMilli()
+		if p, ok := pauses[email]; !ok || p.Until < until {
+			pauses[email] = &BandwidthPause{Email: email, Limit: limit, Until: until}
+		}
+	}
+	for _, traffic := range clientTraffics {
+		if limit, ok := limits.Clients[traffic.Email]; ok {
+			if wait := take(traffic.Email, limit, traffic.Up+traffic.Down); wait > 0 {
+				pause(traffic.Email, "client", wait)
+			}
+		}
+	}
+	emptyInbounds := map[string]time.Duration{}
+	for _, traffic := range xray.TagTraffics(traffics) {
+		if !traffic.IsInbound {
+			continue
+		}
+		if limit, ok := limits.Inbounds[traffic.Tag]; ok {
+			if wait := take("inbound:"+traffic.Tag, limit, traffic.Up+traffic.Down); wait > 0 {
+				emptyInbounds[traffic.Tag] = wait
+			}
+		}
+	}
+	bandwidthState.Unlock()
+
+	if len(emptyInbounds) > 0 {
+		active := map[string]bool{}
+		for _, traffic := range clientTraffics {
+			if traffic.Up+traffic.Down > 0 {
+				active[traffic.Email] = true
+			}
+		}
+		for tag, wait := range emptyInbounds {
+			var inbound model.Inbound
+			if err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", tag).First(&inbound).Error; err != nil {
+				continue
+			}
+			clients, err := s.inboundService.GetClients(&inbound)
+			if err != nil {
+				continue
+			}
+			bandwidthState.Lock()
+			for _, client := range clients {
+				if active[client.Email] {
+					pause(client.Email, "inbound:"+tag, wait)
+				}
+			}
+			bandwidthState.Unlock()
+		}
+	}
+
+	paused := make([]string, 0, len(pauses))
+	for email, p := range pauses {
+		logger.Warningf("Client %s used up its bandwidth limit (%s), disconnected until %s", email, p.Limit, time.UnixMilli(p.Until).Format(time.DateTime))
+		bandwidthState.Lock()
+		bandwidthState.paused[email] = p
+		bandwidthState.Unlock()
+		paused = append(paused, email)
+	}
+	if len(paused) == 0 && len(resumed) == 0 {
+		return nil
+	}
+	// The cached config still has the paused clients, or lacks the resumed ones
+	invalidateXrayConfigCache()
+	sort.Strings(paused)
+	sort.Strings(resumed)
+	s.syncBandwidthClients(paused, resumed)
+	return nil
+}
+
+// syncBandwidthClients removes the paused clients from the running main Xray and adds the resumed
+// ones back through the API, and records the changes in the config of the process as hotReload
+// does. A client the API could not change is left to a restart. The extra instances get their
+// clients on their next restart.
+func (s *XrayService) syncBandwidthClients(paused, resumed []string) {
+	lock.Lock()
+	defer lock.Unlock()
+	process := mainXrayProcess()
+	if process == nil || !process.IsRunning() {
+		// The next start generates the config without the paused clients
+		return
+	}
+	var generated *xray.Config
+	if len(resumed) > 0 {
+		var err error
+		generated, err = s.GetXrayConfig()
+		if err != nil {
+			logger.Warning("Unable to generate the config of the resumed clients:", err)
+			s.SetToNeedRestart()
+			return
+		}
+	}
+	if err := s.xrayAPI.Init(process.GetAPIPort()); err != nil {
+		logger.Debug("Unable to change bandwidth paused clients by api:", err)
+		s.SetToNeedRestart()
+		return
+	}
+	defer s.xrayAPI.Close()
+
+	running := cloneXrayConfig(process.GetConfig())
+	failed := false
+	for _, email := range paused {
+		inbound, _ := configInboundOfClient(running, email)
+		if inbound == nil {
+			continue
+		}
+		if err := s.xrayAPI.RemoveUser(inbound.Tag, email); err != nil {
+			logger.Debug("Unable to remove client by api:", err)
+			failed = true
+			continue
+		}
+		if err := setConfigClients(inbound, func(clients []interface{}) []interface{} {
+			return slices.DeleteFunc(clients, func(client interface{}) bool {
+				return configClientEmail(client) == email
+			})
+		}); err != nil {
+			failed = true
+		}
+	}
+	for _, email := range resumed {
+		source, client := configInboundOfClient(generated, email)
+		if source == nil {
+			// Left out of the config for another reason
+			continue
+		}
+		inbound := configInbound(running, source.Tag)
+		if inbound == nil {
+			failed = true
+			continue
+		}
+		if findConfigClient(inbound, email) != nil {
+			continue
+		}
+		if err := s.xrayAPI.AddUser(source.Protocol, source.Tag, apiUser(source, client)); err != nil {
+			logger.Debug("Unable to add client by api:", err)
+			failed = true
+			continue
+		}
+		if err := setConfigClients(inbound, func(clients []interface{}) []interface{} {
+			return insertConfigClient(clients, source, client)
+		}); err != nil {
+			failed = true
+		}
+	}
+	process.SetConfig(running)
+	if failed {
+		s.SetToNeedRestart()
+	}
+}
+
+// configInbound returns the inbound of config with tag, nil when it has none
+func configInbound(config *xray.Config, tag string) *xray.InboundConfig {
+	for i := range config.InboundConfigs {
+		if config.InboundConfigs[i].Tag == tag {
+			return &config.InboundConfigs[i]
+		}
+	}
+	return nil
+}
+
+// configClientEmail returns the email of a client in the settings of an inbound
+func configClientEmail(client interface{}) string {
+	c, _ := client.(map[string]interface{})
+	email, _ := c["email"].(string)
+	return email
+}
+
+// findConfigClient returns the client with email in the settings of the inbound, nil when it has none
+func findConfigClient(inbound *xray.InboundConfig, email string) map[string]interface{} {
+	var settings struct {
+		Clients []map[string]interface{} `json:"clients"`
+	}
+	if json.Unmarshal(inbound.Settings, &settings) != nil {
+		return nil
+	}
+	for _, client := range settings.Clients {
+		if configClientEmail(client) == email {
+			return client
+		}
+	}
+	return nil
+}
+
+// configInboundOfClient returns the inbound of config that has the client with email, and the client
+func configInboundOfClient(config *xray.Config, email string) (*xray.InboundConfig, map[string]interface{}) {
+	for i := range config.InboundConfigs {
+		if client := findConfigClient(&config.InboundConfigs[i], email); client != nil {
+			return &config.InboundConfigs[i], client
+		}
+	}
+	return nil, nil
+}
+
+// setConfigClients replaces the clients in the settings of the inbound with what change returns,
+// encoded the way genXrayConfigReport encodes them
+func setConfigClients(inbound *xray.InboundConfig, change func(clients []interface{}) []interface{}) error {
+	var settings map[string]interface{}
+	if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+		return err
+	}
+	clients, _ := settings["clients"].([]interface{})
+	clients = change(clients)
+	if len(clients) == 0 {
+		// The generated settings have null for no clients
+		clients = nil
+	}
+	settings["clients"] = clients
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = data
+	return nil
+}
+
+// insertConfigClient adds client to clients at its place in the clients of source, the generated
+// inbound, so the running config matches the generated one again
+func insertConfigClient(clients []interface{}, source *xray.InboundConfig, client map[string]interface{}) []interface{} {
+	email := configClientEmail(client)
+	var settings struct {
+		Clients []map[string]interface{} `json:"clients"`
+	}
+	json.Unmarshal(source.Settings, &settings)
+	before := map[string]bool{}
+	for _, c := range settings.Clients {
+		if configClientEmail(c) == email {
+			break
+		}
+		before[configClientEmail(c)] = true
+	}
+	at := 0
+	for at < len(clients) && before[configClientEmail(clients[at])] {
+		at++
+	}
+	return slices.Insert(clients, at, interface{}(client))
+}
+
+// apiUser is the client of the inbound as AddUser takes it
+func apiUser(inbound *xray.InboundConfig, client map[string]interface{}) map[string]interface{} {
+	user := map[string]interface{}{}
+	for _, key := range []string{"email", "id", "flow", "password"} {
+		value, _ := client[key].(string)
+		user[key] = value
+	}
+	var settings struct {
+		Method string `json:"method"`
+	}
+	json.Unmarshal(inbound.Settings, &settings)
+	user["cipher"] = settings.Method
+	return user
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"x-ui/xray"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBandwidthBucketTake(t *testing.T) {
+	now := time.Now()
+	// 10 KB/s with a 20 KB burst
+	limit := &BandwidthLimit{Rate: 10, Burst: 20}
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		bytes      int64
+		wantTokens float64
+		wantWait   time.Duration
+	}{
+		{name: "within the burst", tokens: 20 * 1024, bytes: 5 * 1024, wantTokens: 15 * 1024},
+		{name: "refilled for the elapsed time", tokens: 0, elapsed: time.Second, bytes: 4 * 1024, wantTokens: 6 * 1024},
+		{name: "refill is capped at the burst", tokens: 15 * 1024, elapsed: time.Minute, bytes: 0, wantTokens: 20 * 1024},
+		// Half full is 10 KB, 12 KB short of it at 10 KB/s
+		{name: "empty waits until half full", tokens: 0, bytes: 2 * 1024, wantTokens: -2 * 1024, wantWait: 1200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := &bandwidthBucket{tokens: tt.tokens, at: now.Add(-tt.elapsed)}
+			wait := bucket.take(limit, tt.bytes, now)
+			if wait != tt.wantWait {
+				t.Fatalf("got wait %v, want %v", wait, tt.wantWait)
+			}
+			if bucket.tokens != tt.wantTokens || !bucket.at.Equal(now) {
+				t.Fatalf("got %v tokens at %v, want %v at %v", bucket.tokens, bucket.at, tt.wantTokens, now)
+			}
+		})
+	}
+
+	// Without a burst the bucket holds a minute of the rate
+	if got := (&BandwidthLimit{Rate: 10}).burstBytes(); got != 600*1024 {
+		t.Fatalf("got default burst %v", got)
+	}
+}
+
+func TestBandwidthPauseResume(t *testing.T) {
+	setupTestDB(t)
+	setStubXray(t, runningStubXray)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	const email = "inbound-20001@test"
+	addTestClient(t, inbound.Id, email)
+	resetXrayConfigCache(t)
+	t.Cleanup(func() {
+		bandwidthState.Lock()
+		bandwidthState.buckets = map[string]*bandwidthBucket{}
+		bandwidthState.paused = map[string]*BandwidthPause{}
+		bandwidthState.Unlock()
+	})
+
+	s := &XrayService{}
+	if err := s.SetClientBandwidthLimit(email, BandwidthLimit{Rate: 1, Burst: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var calls []string
+	port := startStubAPI(t, func(method string, data []byte) ([]byte, error) {
+		if method != "/xray.app.proxyman.command.HandlerService/AlterInbound" {
+			return nil, status.Error(codes.Unimplemented, method)
+		}
+		var req command.AlterInboundRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		operation := req.Operation.Type[strings.LastIndex(req.Operation.Type, ".")+1:]
+		mu.Lock()
+		calls = append(calls, operation+" "+req.Tag)
+		mu.Unlock()
+		return proto.Marshal(&command.AlterInboundResponse{})
+	})
+	takeCalls := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := calls
+		calls = nil
+		return got
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configInbound(xrayConfig, statsAPITag).Port = port
+	process := xray.NewProcess(xrayConfig)
+	setMainXrayProcess(t, process)
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { process.Stop() })
+
+	// 4 KB over a 1 KB bucket disconnects the client
+	err = s.ApplyBandwidthLimits(nil, []*xray.ClientTraffic{{Email: email, Up: 4096}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := takeCalls(); len(got) != 1 || got[0] != "RemoveUserOperation inbound-20001" {
+		t.Fatalf("got api calls %v", got)
+	}
+	if pauses := s.GetBandwidthPauses(); len(pauses) != 1 || pauses[0].Email != email || pauses[0].Limit != "client" {
+		t.Fatalf("got pauses %v", pauses)
+	}
+	if client := findConfigClient(configInbound(process.GetConfig(), "inbound-20001"), email); client != nil {
+		t.Fatal("the running config still has the paused client")
+	}
+	if s.IsNeedRestartAndSetFalse() {
+		t.Fatal("pausing a client asked for a restart")
+	}
+	// The config of the process matches the one generated during the pause
+	generated, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configInbound(process.GetConfig(), "inbound-20001").Equals(configInbound(generated, "inbound-20001")) {
+		t.Fatal("the running config differs from the generated one during the pause")
+	}
+
+	// Traffic during the pause does not pause the client again
+	err = s.ApplyBandwidthLimits(nil, []*xray.ClientTraffic{{Email: email, Up: 4096}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := takeCalls(); len(got) != 0 {
+		t.Fatalf("got api calls %v during the pause", got)
+	}
+
+	// The pause ends, the client is added back
+	bandwidthState.Lock()
+	bandwidthState.paused[email].Until = 0
+	bandwidthState.Unlock()
+	if err := s.ApplyBandwidthLimits(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeCalls(); len(got) != 1 || got[0] != "AddUserOperation inbound-20001" {
+		t.Fatalf("got api calls %v", got)
+	}
+	if pauses := s.GetBandwidthPauses(); len(pauses) != 0 {
+		t.Fatalf("got pauses %v after the pause ended", pauses)
+	}
+	if s.IsNeedRestartAndSetFalse() {
+		t.Fatal("resuming a client asked for a restart")
+	}
+	generated, err = s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configInbound(process.GetConfig(), "inbound-20001").Equals(configInbound(generated, "inbound-20001")) {
+		t.Fatal("the running config differs from the generated one after the pause")
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// ClientBilling is the traffic of a client over a billing period, in bytes
+type ClientBilling struct {
+	Email   string `json:"email"`
+	Up      int64  `json:"up"`
+	Down    int64  `json:"down"`
+	Usage   int64  `json:"usage"`
+	Limit   int64  `json:"limit"` // 0 is unlimited
+	Overage int64  `json:"overage"`
+	// The client first appeared in the period
+	New bool `json:"new"`
+	// The client no longer exists, its traffic up to the deletion is billed
+	Deleted bool `json:"deleted"`
+}
+
+// BillingSummary adds up the traffic snapshots of every client over [from, to], most used first.
+// A client whose first snapshot falls in the period counts from zero, deleted clients keep the
+// traffic of their snapshots. The snapshot interval bounds the precision at both ends.
+func (s *XrayService) BillingSummary(from, to time.Time) ([]ClientBilling, error) {
+	if !from.Before(to) {
+		return nil, common.NewError("billing period must end after it starts")
+	}
+	db := database.GetDB()
+	var snapshots []*model.ClientTrafficSnapshot
+	err := db.Model(model.ClientTrafficSnapshot{}).
+		Where("time <= ?", to.UnixMilli()).
+		Order("email, time").Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]int64, len(traffics))
+	for _, traffic := range traffics {
+		limits[traffic.Email] = traffic.Total
+	}
+
+	history := map[string][]*model.ClientTrafficSnapshot{}
+	var emails []string
+	for _, snapshot := range snapshots {
+		if _, ok := history[snapshot.Email]; !ok {
+			emails = append(emails, snapshot.Email)
+		}
+		history[snapshot.Email] = append(history[snapshot.Email], snapshot)
+	}
+
+	billings := make([]ClientBilling, 0, len(emails))
+	for _, email := range emails {
+		points := history[email]
+		// Nothing left after the period started, the client was deleted before it
+		if points[len(points)-1].Time < from.UnixMilli() {
+			continue
+		}
+		billing := ClientBilling{Email: email}
+		if first := points[0]; first.Time >= from.UnixMilli() {
+			billing.New = true
+			billing.Up = first.Up
+			billing.Down = first.Down
+		}
+		for _, point := range trafficPoints(points, from.UnixMilli()) {
+			billing.Up += point.Up
+			billing.Down += point.Down
+		}
+		billing.Usage = billing.Up + billing.Down
+		limit, ok := limits[email]
+		billing.Deleted = !ok
+		billing.Limit = limit
+		if limit > 0 && billing.Usage > limit {
+			billing.Overage = billing.Usage - limit
+		}
+		billings = append(billings, billing)
+	}
+
+	sort.SliceStable(billings, func(i, j int) bool {
+		return billings[i].Usage > billings[j].Usage
+	})
+	return billings, nil
+}
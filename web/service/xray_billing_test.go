@@ -0,0 +1,62 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestBillingSummary(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	for _, traffic := range []*xray.ClientTraffic{
+		{InboundId: 1, Email: "alice", Total: 200, Enable: true},
+		{InboundId: 1, Email: "bob", Enable: true},
+		{InboundId: 1, Email: "erin", Enable: true},
+	} {
+		if err := db.Create(traffic).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, snapshot := range []*model.ClientTrafficSnapshot{
+		{Email: "alice", Up: 100, Time: 500},
+		{Email: "alice", Up: 400, Time: 1500},
+		{Email: "alice", Up: 900, Time: 2500},
+		// Created in the period
+		{Email: "bob", Up: 50, Down: 50, Time: 1200},
+		{Email: "bob", Up: 150, Down: 50, Time: 1800},
+		// Deleted in the period
+		{Email: "carol", Up: 10, Time: 800},
+		{Email: "carol", Up: 60, Time: 1300},
+		// Deleted before the period
+		{Email: "dave", Up: 70, Time: 600},
+		// Created after the period
+		{Email: "erin", Up: 80, Time: 2100},
+	} {
+		if err := db.Create(snapshot).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &XrayService{}
+	billings, err := s.BillingSummary(time.UnixMilli(1000), time.UnixMilli(2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ClientBilling{
+		{Email: "alice", Up: 300, Usage: 300, Limit: 200, Overage: 100},
+		{Email: "bob", Up: 150, Down: 50, Usage: 200, New: true},
+		{Email: "carol", Up: 50, Usage: 50, Deleted: true},
+	}
+	if !reflect.DeepEqual(billings, want) {
+		t.Fatalf("got %+v, want %+v", billings, want)
+	}
+
+	if _, err := s.BillingSummary(time.UnixMilli(2000), time.UnixMilli(2000)); err == nil {
+		t.Fatal("got no error for an empty period")
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// Tag of the blackhole outbound added when the config has none
+const blockOutboundTag = "blocked"
+
+// isBlockRuleIP tells ip destinations (ip, cidr, geoip:) from domain ones
+func isBlockRuleIP(destination string) bool {
+	if strings.HasPrefix(destination, "geoip:") {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(destination); err == nil {
+		return true
+	}
+	return net.ParseIP(destination) != nil
+}
+
+func checkBlockRule(destination string) error {
+	if destination == "" {
+		return common.NewError("destination is empty")
+	}
+	if strings.HasPrefix(destination, "geoip:") {
+		if strings.TrimPrefix(destination, "geoip:") == "" {
+			return common.NewErrorf("invalid destination %q", destination)
+		}
+		return nil
+	}
+	if isBlockRuleIP(destination) {
+		return nil
+	}
+	return checkDomainMatcher(destination)
+}
+
+func (s *XrayService) GetBlockRules() ([]string, error) {
+	rules := []string{}
+	data, err := s.settingService.GetBlockRules()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return rules, nil
+	}
+	err = json.Unmarshal([]byte(data), &rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *XrayService) saveBlockRules(rules []string) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetBlockRules(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// AddBlockRule blocks a domain, ip, cidr, geosite: or geoip: destination for every inbound
+func (s *XrayService) AddBlockRule(destination string) error {
+	destination = strings.TrimSpace(destination)
+	if err := checkBlockRule(destination); err != nil {
+		return err
+	}
+	rules, err := s.GetBlockRules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule == destination {
+			return nil
+		}
+	}
+	return s.saveBlockRules(append(rules, destination))
+}
+
+func (s *XrayService) RemoveBlockRule(destination string) error {
+	rules, err := s.GetBlockRules()
+	if err != nil {
+		return err
+	}
+	for i, rule := range rules {
+		if rule == destination {
+			return s.saveBlockRules(append(rules[:i], rules[i+1:]...))
+		}
+	}
+	return common.NewErrorf("destination %s is not blocked", destination)
+}
+
+// applyBlockRules sends the blocked destinations to a blackhole outbound, adding one when the config has none.
+// The rules go right after the api rule so no template rule can let the traffic through.
+func (s *XrayService) applyBlockRules(xrayConfig *xray.Config) error {
+	blocked, err := s.GetBlockRules()
+	if err != nil {
+		return err
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	tag := ""
+	tags := map[string]bool{}
+	for _, outbound := range outbounds {
+		outboundTag, _ := outbound["tag"].(string)
+		tags[outboundTag] = true
+		if protocol, _ := outbound["protocol"].(string); protocol == "blackhole" && outboundTag != "" && tag == "" {
+			tag = outboundTag
+		}
+	}
+	if tag == "" {
+		tag = blockOutboundTag
+		for tags[tag] {
+			tag = "x-ui-" + tag
+		}
+		outbounds = append(outbounds, map[string]interface{}{
+			"tag":      tag,
+			"protocol": "blackhole",
+			"settings": map[string]interface{}{},
+		})
+		if err = setOutbounds(xrayConfig, outbounds); err != nil {
+			return err
+		}
+	}
+
+	var ips, domains []string
+	for _, destination := range blocked {
+		if isBlockRuleIP(destination) {
+			ips = append(ips, destination)
+		} else {
+			domains = append(domains, destination)
+		}
+	}
+	var rules []interface{}
+	if len(domains) > 0 {
+		rules = append(rules, map[string]interface{}{"type": "field", "domain": domains, "outboundTag": tag})
+	}
+	if len(ips) > 0 {
+		rules = append(rules, map[string]interface{}{"type": "field", "ip": ips, "outboundTag": tag})
+	}
+
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["rules"].([]interface{})
+	at := 0
+	for at < len(existing) {
+		rule, _ := existing[at].(map[string]interface{})
+		inboundTags, _ := rule["inboundTag"].([]interface{})
+		if !containsValue(inboundTags, statsAPITag) {
+			break
+		}
+		at++
+	}
+	merged := make([]interface{}, 0, len(existing)+len(rules))
+	merged = append(merged, existing[:at]...)
+	merged = append(merged, rules...)
+	merged = append(merged, existing[at:]...)
+	routing["rules"] = merged
+	return setRouting(xrayConfig, routing)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+func TestCheckBlockRule(t *testing.T) {
+	tests := []struct {
+		destination string
+		wantIP      bool
+		wantErr     bool
+	}{
+		{destination: "example.com"},
+		{destination: "geosite:category-ads-all"},
+		{destination: "full:ads.example.com"},
+		{destination: "1.2.3.4", wantIP: true},
+		{destination: "2001:db8::1", wantIP: true},
+		{destination: "10.0.0.0/8", wantIP: true},
+		{destination: "geoip:ir", wantIP: true},
+		{destination: "", wantErr: true},
+		{destination: "geoip:", wantErr: true},
+		{destination: "10.0.0.0/33", wantErr: true},
+		{destination: "not a domain", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			err := checkBlockRule(tt.destination)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && isBlockRuleIP(tt.destination) != tt.wantIP {
+				t.Fatalf("got ip %v, want %v", isBlockRuleIP(tt.destination), tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestApplyBlockRules(t *testing.T) {
+	apiRule := map[string]interface{}{"type": "field", "inboundTag": []interface{}{"api"}, "outboundTag": "api"}
+	templateRule := map[string]interface{}{"type": "field", "domain": []interface{}{"geosite:google"}, "outboundTag": "direct"}
+	tests := []struct {
+		name          string
+		outbounds     string
+		blocked       []string
+		wantOutbounds []string
+		wantTag       string
+	}{
+		{
+			name:          "existing blackhole is reused",
+			outbounds:     `[{"tag": "direct", "protocol": "freedom"}, {"tag": "sink", "protocol": "blackhole"}]`,
+			blocked:       []string{"geosite:category-ads-all", "10.0.0.0/8"},
+			wantOutbounds: []string{"direct", "sink"},
+			wantTag:       "sink",
+		},
+		{
+			name:          "blackhole is added",
+			outbounds:     `[{"tag": "direct", "protocol": "freedom"}]`,
+			blocked:       []string{"geosite:category-ads-all", "10.0.0.0/8"},
+			wantOutbounds: []string{"direct", "blocked"},
+			wantTag:       "blocked",
+		},
+		{
+			name:          "added blackhole avoids a taken tag",
+			outbounds:     `[{"tag": "blocked", "protocol": "freedom"}]`,
+			blocked:       []string{"geosite:category-ads-all", "10.0.0.0/8"},
+			wantOutbounds: []string{"blocked", "x-ui-blocked"},
+			wantTag:       "x-ui-blocked",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			s := &XrayService{}
+			t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+			for _, destination := range tt.blocked {
+				if err := s.AddBlockRule(destination); err != nil {
+					t.Fatal(err)
+				}
+			}
+			xrayConfig := &xray.Config{
+				OutboundConfigs: json_util.RawMessage(tt.outbounds),
+				RouterConfig:    json_util.RawMessage(`{"rules": [{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}, {"type": "field", "domain": ["geosite:google"], "outboundTag": "direct"}]}`),
+			}
+			if err := s.applyBlockRules(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			outbounds, err := getOutbounds(xrayConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for _, outbound := range outbounds {
+				tags = append(tags, outbound["tag"].(string))
+			}
+			if !reflect.DeepEqual(tags, tt.wantOutbounds) {
+				t.Fatalf("got outbounds %v, want %v", tags, tt.wantOutbounds)
+			}
+
+			want := []map[string]interface{}{
+				apiRule,
+				{"type": "field", "domain": []interface{}{"geosite:category-ads-all"}, "outboundTag": tt.wantTag},
+				{"type": "field", "ip": []interface{}{"10.0.0.0/8"}, "outboundTag": tt.wantTag},
+				templateRule,
+			}
+			if got := configRules(t, xrayConfig); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got rules %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBlockRules(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	steps := []struct {
+		name    string
+		add     string
+		remove  string
+		wantErr bool
+		want    []string
+	}{
+		{name: "add", add: " example.com ", want: []string{"example.com"}},
+		{name: "add again", add: "example.com", want: []string{"example.com"}},
+		{name: "add ip", add: "1.2.3.4", want: []string{"example.com", "1.2.3.4"}},
+		{name: "invalid", add: "geoip:", wantErr: true, want: []string{"example.com", "1.2.3.4"}},
+		{name: "remove", remove: "example.com", want: []string{"1.2.3.4"}},
+		{name: "remove missing", remove: "example.com", wantErr: true, want: []string{"1.2.3.4"}},
+	}
+	for _, step := range steps {
+		var err error
+		if step.add != "" {
+			err = s.AddBlockRule(step.add)
+		} else {
+			err = s.RemoveBlockRule(step.remove)
+		}
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: got error %v, want error %v", step.name, err, step.wantErr)
+		}
+		rules, err := s.GetBlockRules()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rules, step.want) {
+			t.Fatalf("%s: got rules %v, want %v", step.name, rules, step.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// Long enough to absorb UI polling and back to back validations
+const xrayConfigCacheTTL = 5 * time.Second
+
+var xrayConfigCache struct {
+	sync.Mutex
+	key     [sha256.Size]byte
+	config  *xray.Config
+	expires time.Time
+}
+
+// xrayConfigInputs hashes everything genXrayConfig reads: the settings (template included),
+// the inbounds with the enable flag of their client stats, the panel routing rules, the current minute when clients have time windows,
+// the down outbounds, the clients removed for their device or bandwidth limit and the registered processors
+func (s *XrayService) xrayConfigInputs() ([sha256.Size]byte, error) {
+	var key [sha256.Size]byte
+	db := database.GetDB()
+	var settings []*model.Setting
+	err := db.Model(model.Setting{}).Order("key").Find(&settings).Error
+	if err != nil {
+		return key, err
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return key, err
+	}
+	sort.Slice(inbounds, func(i, j int) bool {
+		return inbounds[i].Id < inbounds[j].Id
+	})
+	var routingRules []*model.RoutingRule
+	err = db.Model(model.RoutingRule{}).Order("id").Find(&routingRules).Error
+	if err != nil {
+		return key, err
+	}
+	// Saving the same settings with another key order or number format keeps the cached config.
+	// Only the enable flag of the counters matters, traffic alone does not change the config.
+	for _, inbound := range inbounds {
+		inbound.Up, inbound.Down = 0, 0
+		for i := range inbound.ClientStats {
+			inbound.ClientStats[i].Up, inbound.ClientStats[i].Down = 0, 0
+		}
+		for _, field := range []*string{&inbound.Settings, &inbound.StreamSettings, &inbound.Sniffing} {
+			if canonical, err := CanonicalizeSettings(*field); err == nil {
+				*field = canonical
+			}
+		}
+	}
+
+	var down []string
+	outboundHealthMu.Lock()
+	for tag, health := range outboundHealth {
+		if !health.Healthy {
+			down = append(down, tag)
+		}
+	}
+	outboundHealthMu.Unlock()
+	sort.Strings(down)
+
+	var removed []string
+	for email := range deviceLimitCooldowns() {
+		removed = append(removed, email)
+	}
+	for email := range bandwidthPaused() {
+		removed = append(removed, email)
+	}
+	sort.Strings(removed)
+
+	// Without time windows the config does not change with the clock
+	var minute int64
+	for _, setting := range settings {
+		if setting.Key == "clientTimeWindows" && setting.Value != "" && setting.Value != "{}" {
+			minute = time.Now().Unix() / 60
+		}
+	}
+
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	processors := len(registeredConfigProcessors())
+	for _, value := range []interface{}{settings, inbounds, routingRules, minute, down, removed, processors} {
+		if err := encoder.Encode(value); err != nil {
+			return key, err
+		}
+	}
+	copy(key[:], hash.Sum(nil))
+	return key, nil
+}
+
+// cloneXrayConfig copies the config so callers can change it without touching the cached one
+func cloneXrayConfig(c *xray.Config) *xray.Config {
+	clone := *c
+	for _, field := range []*[]byte{
+		(*[]byte)(&clone.LogConfig), (*[]byte)(&clone.RouterConfig), (*[]byte)(&clone.DNSConfig),
+		(*[]byte)(&clone.OutboundConfigs), (*[]byte)(&clone.Transport), (*[]byte)(&clone.Policy),
+		(*[]byte)(&clone.API), (*[]byte)(&clone.Stats), (*[]byte)(&clone.Reverse), (*[]byte)(&clone.FakeDNS),
+		(*[]byte)(&clone.Observatory), (*[]byte)(&clone.BurstObservatory),
+	} {
+		*field = bytes.Clone(*field)
+	}
+	clone.InboundConfigs = make([]xray.InboundConfig, len(c.InboundConfigs))
+	for i, inbound := range c.InboundConfigs {
+		inbound.Listen = bytes.Clone(inbound.Listen)
+		inbound.Settings = bytes.Clone(inbound.Settings)
+		inbound.StreamSettings = bytes.Clone(inbound.StreamSettings)
+		inbound.Sniffing = bytes.Clone(inbound.Sniffing)
+		inbound.Allocate = bytes.Clone(inbound.Allocate)
+		clone.InboundConfigs[i] = inbound
+	}
+	return &clone
+}
+
+// cachedXrayConfig returns the config generated from the same inputs within the TTL, or generates it
+func (s *XrayService) cachedXrayConfig(generate func() (*xray.Config, error)) (*xray.Config, error) {
+	key, err := s.xrayConfigInputs()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfigCache.Lock()
+	if xrayConfigCache.config != nil && xrayConfigCache.key == key && time.Now().Before(xrayConfigCache.expires) {
+		cached := cloneXrayConfig(xrayConfigCache.config)
+		xrayConfigCache.Unlock()
+		return cached, nil
+	}
+	xrayConfigCache.Unlock()
+
+	xrayConfig, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfigCache.Lock()
+	xrayConfigCache.key = key
+	xrayConfigCache.config = cloneXrayConfig(xrayConfig)
+	xrayConfigCache.expires = time.Now().Add(xrayConfigCacheTTL)
+	xrayConfigCache.Unlock()
+	return xrayConfig, nil
+}
+
+// invalidateXrayConfigCache drops the cached and the staged config, the next restart generates it again
+func invalidateXrayConfigCache() {
+	xrayConfigCache.Lock()
+	xrayConfigCache.config = nil
+	xrayConfigCache.Unlock()
+	stagedXrayConfig.Lock()
+	stagedXrayConfig.config = nil
+	stagedXrayConfig.Unlock()
+}
+
+var stagedXrayConfig struct {
+	sync.Mutex
+	key    [sha256.Size]byte
+	config *xray.Config
+	report *generationReport
+}
+
+// StageConfig generates the config ahead of the next restart. RestartXray starts the staged config
+// as long as nothing it was built from changed, instead of generating it while holding the lock.
+func (s *XrayService) StageConfig() error {
+	key, err := s.xrayConfigInputs()
+	if err != nil {
+		return err
+	}
+	include, err := s.mainInbounds()
+	if err != nil {
+		return err
+	}
+	xrayConfig, report, err := s.genXrayConfigReport(s.settingService.GetXrayConfigTemplate, include, true)
+	if err != nil {
+		return err
+	}
+	stagedXrayConfig.Lock()
+	defer stagedXrayConfig.Unlock()
+	stagedXrayConfig.key = key
+	stagedXrayConfig.config = xrayConfig
+	stagedXrayConfig.report = report
+	return nil
+}
+
+// restartConfig returns the staged config when its inputs are unchanged, otherwise a generated one
+func (s *XrayService) restartConfig() (*xray.Config, error) {
+	stagedXrayConfig.Lock()
+	staged, stagedKey, report := stagedXrayConfig.config, stagedXrayConfig.key, stagedXrayConfig.report
+	stagedXrayConfig.Unlock()
+	if staged != nil {
+		key, err := s.xrayConfigInputs()
+		if err != nil {
+			return nil, err
+		}
+		if key == stagedKey {
+			logger.Debug("Using the staged Xray config")
+			report.record()
+			return cloneXrayConfig(staged), nil
+		}
+		logger.Debug("Staged Xray config is stale, generating it again")
+		stagedXrayConfig.Lock()
+		if stagedXrayConfig.key == stagedKey {
+			stagedXrayConfig.config = nil
+		}
+		stagedXrayConfig.Unlock()
+	}
+	return s.GetXrayConfig()
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func resetXrayConfigCache(t *testing.T) {
+	t.Helper()
+	invalidateXrayConfigCache()
+	t.Cleanup(func() {
+		invalidateXrayConfigCache()
+		stagedXrayConfig.Lock()
+		stagedXrayConfig.config = nil
+		stagedXrayConfig.Unlock()
+	})
+}
+
+func TestCachedXrayConfig(t *testing.T) {
+	setupTestDB(t)
+	resetXrayConfigCache(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestClient(t, inbound.Id, "inbound-20001@test")
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	generated := 0
+	generate := func() (*xray.Config, error) {
+		generated++
+		return &xray.Config{LogConfig: []byte(`{"loglevel": "warning"}`)}, nil
+	}
+	db := database.GetDB()
+	tests := []struct {
+		name          string
+		change        func() error
+		wantGenerated bool
+	}{
+		{name: "first call", change: func() error { return nil }, wantGenerated: true},
+		{name: "same inputs", change: func() error { return nil }, wantGenerated: false},
+		{name: "traffic only", change: func() error {
+			return db.Model(xray.ClientTraffic{}).Where("email = ?", "inbound-20001@test").Update("up", 1<<20).Error
+		}, wantGenerated: false},
+		{name: "settings reformatted", change: func() error {
+			return db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("sniffing", `{ "enabled" : false }`).Error
+		}, wantGenerated: false},
+		{name: "client disabled by its counter", change: func() error {
+			return db.Model(xray.ClientTraffic{}).Where("email = ?", "inbound-20001@test").Update("enable", false).Error
+		}, wantGenerated: true},
+		{name: "setting changed", change: func() error {
+			return s.settingService.saveSetting("sockoptKeepAlive", "45")
+		}, wantGenerated: true},
+		{name: "routing rule added", change: func() error {
+			return db.Create(&model.RoutingRule{Enable: true, Domain: "example.com", OutboundTag: "direct"}).Error
+		}, wantGenerated: true},
+		{name: "routing rule changed", change: func() error {
+			return db.Model(model.RoutingRule{}).Where("domain = ?", "example.com").Update("outbound_tag", "blocked").Error
+		}, wantGenerated: true},
+		{name: "restart requested", change: func() error {
+			s.SetToNeedRestart()
+			return nil
+		}, wantGenerated: true},
+		{name: "expired", change: func() error {
+			xrayConfigCache.Lock()
+			xrayConfigCache.expires = xrayConfigCache.expires.Add(-xrayConfigCacheTTL)
+			xrayConfigCache.Unlock()
+			return nil
+		}, wantGenerated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(); err != nil {
+				t.Fatal(err)
+			}
+			before := generated
+			if _, err := s.cachedXrayConfig(generate); err != nil {
+				t.Fatal(err)
+			}
+			if got := generated > before; got != tt.wantGenerated {
+				t.Fatalf("generated %v, want %v", got, tt.wantGenerated)
+			}
+		})
+	}
+
+	// Callers get copies, changing one leaves the cached config alone
+	first, err := s.cachedXrayConfig(generate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.LogConfig[0] = '['
+	second, err := s.cachedXrayConfig(generate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(second.LogConfig) != `{"loglevel": "warning"}` {
+		t.Fatalf("cached config changed to %s", second.LogConfig)
+	}
+}
+
+func TestRestartConfigUsesStagedConfig(t *testing.T) {
+	setupTestDB(t)
+	resetXrayConfigCache(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+
+	if err := s.StageConfig(); err != nil {
+		t.Fatal(err)
+	}
+	stagedXrayConfig.Lock()
+	staged := stagedXrayConfig.config
+	stagedXrayConfig.Unlock()
+	xrayConfig, err := s.restartConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xrayConfig == staged || !xrayConfig.Equals(staged) {
+		t.Fatal("restart did not get a copy of the staged config")
+	}
+
+	// A new inbound makes the staged config stale, it is dropped and the config generated again
+	addTestInbound(t, 20002, "inbound-20002", true)
+	xrayConfig, err = s.restartConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := configInboundTags(xrayConfig); len(tags) != 2 {
+		t.Fatalf("got inbounds %v, want both", tags)
+	}
+	stagedXrayConfig.Lock()
+	stale := stagedXrayConfig.config
+	stagedXrayConfig.Unlock()
+	if stale != nil {
+		t.Fatal("the stale staged config was kept")
+	}
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{name: "routing rule added", change: func() error {
+			return database.GetDB().Create(&model.RoutingRule{Enable: true, Domain: "example.com", OutboundTag: "direct"}).Error
+		}},
+		{name: "restart requested", change: func() error {
+			s.SetToNeedRestart()
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+			if err := s.StageConfig(); err != nil {
+				t.Fatal(err)
+			}
+			stagedXrayConfig.Lock()
+			stagedXrayConfig.config.LogConfig = []byte(`{"loglevel": "staged"}`)
+			stagedXrayConfig.Unlock()
+			if err := tt.change(); err != nil {
+				t.Fatal(err)
+			}
+			xrayConfig, err := s.restartConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(xrayConfig.LogConfig) == `{"loglevel": "staged"}` {
+				t.Fatal("restart used the staged config after the change")
+			}
+		})
+	}
+}
+
+func TestRestartXrayStagedConfig(t *testing.T) {
+	setupTestDB(t)
+	resetXrayConfigCache(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	if err := s.SetStandby(true); err != nil {
+		t.Fatal(err)
+	}
+	setMainXrayProcess(t, nil)
+	t.Cleanup(func() {
+		standbyConfigLock.Lock()
+		standbyConfig = nil
+		standbyConfigLock.Unlock()
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	// The marker only exists in the staged config, seeing it proves the restart did not generate one
+	if err := s.StageConfig(); err != nil {
+		t.Fatal(err)
+	}
+	stagedXrayConfig.Lock()
+	stagedXrayConfig.config.LogConfig = []byte(`{"loglevel": "staged"}`)
+	stagedXrayConfig.Unlock()
+	if err := s.RestartXray(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(s.GetStandbyConfig().LogConfig); got != `{"loglevel": "staged"}` {
+		t.Fatalf("got log %s, want the staged one", got)
+	}
+
+	if err := s.settingService.saveSetting("sockoptKeepAlive", "45"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestartXray(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(s.GetStandbyConfig().LogConfig); got == `{"loglevel": "staged"}` {
+		t.Fatal("restart used the staged config after a setting changed")
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"x-ui/util/common"
+)
+
+// CanonicalizeSettings rewrites settings json in one deterministic form for diffing and hashing:
+// object keys sorted, no whitespace, and numbers that are equal written the same (1, 1.0 and 1e0 are 1).
+// Arrays keep their order, it is meaningful in xray settings.
+func CanonicalizeSettings(settings string) (string, error) {
+	if strings.TrimSpace(settings) == "" {
+		return "", nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(settings))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", common.NewErrorf("invalid settings: %v", err)
+	}
+	if decoder.More() {
+		return "", common.NewError("invalid settings: trailing data after the json value")
+	}
+	value, err := canonicalValue(value)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	// Maps are encoded with sorted keys
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func canonicalValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			canonical, err := canonicalValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = canonical
+		}
+	case []interface{}:
+		for i, item := range v {
+			canonical, err := canonicalValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = canonical
+		}
+	case json.Number:
+		return canonicalNumber(v)
+	}
+	return value, nil
+}
+
+func canonicalNumber(number json.Number) (json.Number, error) {
+	if n, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	}
+	f, err := strconv.ParseFloat(string(number), 64)
+	if err != nil {
+		return "", common.NewErrorf("invalid number %s", number)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e21 {
+		return json.Number(strconv.FormatFloat(f, 'f', -1, 64)), nil
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
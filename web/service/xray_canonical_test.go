@@ -0,0 +1,60 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		want     string
+		wantErr  string
+	}{
+		{name: "empty", settings: "  ", want: ""},
+		{name: "sorts keys", settings: `{"b": 1, "a": {"d": 2, "c": 3}}`, want: `{"a":{"c":3,"d":2},"b":1}`},
+		{name: "keeps array order", settings: `{"clients": [{"email": "b"}, {"email": "a"}]}`, want: `{"clients":[{"email":"b"},{"email":"a"}]}`},
+		{name: "whole float", settings: `{"port": 443.0}`, want: `{"port":443}`},
+		{name: "exponent", settings: `{"total": 1e3}`, want: `{"total":1000}`},
+		{name: "fraction", settings: `{"ratio": 0.50}`, want: `{"ratio":0.5}`},
+		{name: "big integer keeps its digits", settings: `{"total": 9007199254740993}`, want: `{"total":9007199254740993}`},
+		{name: "html is not escaped", settings: `{"path": "/a?b=1&c=<d>"}`, want: `{"path":"/a?b=1&c=<d>"}`},
+		{name: "invalid json", settings: `{"a":`, wantErr: "invalid settings"},
+		{name: "trailing data", settings: `{"a": 1} {"b": 2}`, wantErr: "trailing data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalizeSettings(tt.settings)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeSettingsEqual(t *testing.T) {
+	a, err := CanonicalizeSettings(`{
+  "decryption": "none",
+  "clients": [{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "totalGB": 1.0e10, "email": "alice"}]
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CanonicalizeSettings(`{"clients":[{"email":"alice","id":"27848739-7e62-4138-9fd3-098a63964b6b","totalGB":10000000000}],"decryption":"none"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Fatalf("equal settings canonicalized to %s and %s", a, b)
+	}
+}
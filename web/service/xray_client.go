@@ -0,0 +1,95 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+type clientExpiringObserver struct {
+	thresholds []time.Duration
+	callback   func(email string, remaining time.Duration)
+	// email -> expiry time the fired thresholds belong to
+	expiryTime map[string]int64
+	// email -> thresholds already fired for that expiry time
+	fired map[string]map[time.Duration]bool
+}
+
+var (
+	clientExpiringLock      sync.Mutex
+	clientExpiringObservers []*clientExpiringObserver
+)
+
+// OnClientExpiringSoon registers a callback fired once per client for every threshold
+// its remaining time crosses. Renewing a client re-arms its thresholds.
+func (s *XrayService) OnClientExpiringSoon(thresholds []time.Duration, cb func(email string, remaining time.Duration)) {
+	sorted := append([]time.Duration(nil), thresholds...)
+	// Largest first, so callbacks for one client arrive in crossing order
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	clientExpiringLock.Lock()
+	defer clientExpiringLock.Unlock()
+	clientExpiringObservers = append(clientExpiringObservers, &clientExpiringObserver{
+		thresholds: sorted,
+		callback:   cb,
+		expiryTime: make(map[string]int64),
+		fired:      make(map[string]map[time.Duration]bool),
+	})
+}
+
+// NotifyExpiringClients checks client expiry times against the registered thresholds
+func (s *XrayService) NotifyExpiringClients() error {
+	clientExpiringLock.Lock()
+	observers := clientExpiringObservers
+	clientExpiringLock.Unlock()
+	if len(observers) == 0 {
+		return nil
+	}
+
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).Where("enable = ? and expiry_time > 0", true).Find(&traffics).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, observer := range observers {
+		observer.check(traffics, now)
+	}
+	return nil
+}
+
+func (o *clientExpiringObserver) check(traffics []*xray.ClientTraffic, now time.Time) {
+	type notification struct {
+		email     string
+		remaining time.Duration
+	}
+	var notifications []notification
+
+	clientExpiringLock.Lock()
+	for _, traffic := range traffics {
+		remaining := time.UnixMilli(traffic.ExpiryTime).Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+		if o.expiryTime[traffic.Email] != traffic.ExpiryTime {
+			o.expiryTime[traffic.Email] = traffic.ExpiryTime
+			o.fired[traffic.Email] = make(map[time.Duration]bool)
+		}
+		for _, threshold := range o.thresholds {
+			if remaining <= threshold && !o.fired[traffic.Email][threshold] {
+				o.fired[traffic.Email][threshold] = true
+				notifications = append(notifications, notification{traffic.Email, remaining})
+			}
+		}
+	}
+	clientExpiringLock.Unlock()
+
+	for _, n := range notifications {
+		o.callback(n.email, n.remaining)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/random"
+)
+
+// ImportClientRow is the outcome of one CSV row, Row counts from 1 after the header
+type ImportClientRow struct {
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	Error string `json:"error,omitempty"`
+}
+
+type ImportClientsResult struct {
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportClientRow `json:"rows"`
+}
+
+var clientUUIDRegex = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// newClientUUID returns a random version 4 uuid
+func newClientUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// newClientSecret returns the credential a new client of the inbound gets when the CSV has none.
+// Shadowsocks 2022 methods need a base64 key of the method key size.
+func newClientSecret(protocol model.Protocol, method string) (string, error) {
+	switch protocol {
+	case model.Shadowsocks:
+		if strings.HasPrefix(method, "2022-blake3-") {
+			key := make([]byte, 32)
+			if strings.Contains(method, "aes-128") {
+				key = key[:16]
+			}
+			if _, err := rand.Read(key); err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(key), nil
+		}
+		return random.Seq(16), nil
+	case model.Trojan:
+		return random.Seq(10), nil
+	default:
+		return newClientUUID()
+	}
+}
+
+// parseImportExpiry reads an expiry as unix ms, a negative value counts from the first use,
+// or as a 2006-01-02 date
+func parseImportExpiry(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if expiry, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return expiry, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry %q, expected unix ms or YYYY-MM-DD", value)
+	}
+	return date.UnixMilli(), nil
+}
+
+// parseImportRow builds the client of a CSV row. The uuid column holds the id of vmess and vless
+// clients and the password of trojan and shadowsocks ones.
+func parseImportRow(protocol model.Protocol, method string, get func(string) string) (model.Client, error) {
+	client := model.Client{
+		Email:  strings.TrimSpace(get("email")),
+		Enable: true,
+		SubID:  random.Seq(16),
+	}
+	if client.Email == "" {
+		return client, common.NewError("email is required")
+	}
+	if _, err := validateEmail(client.Email); err != nil {
+		return client, err
+	}
+	if limit := get("limit"); limit != "" {
+		gb, err := strconv.ParseFloat(limit, 64)
+		if err != nil || gb < 0 {
+			return client, common.NewErrorf("invalid limit %q, expected GB", limit)
+		}
+		client.TotalGB = int64(gb * 1024 * 1024 * 1024)
+	}
+	expiry, err := parseImportExpiry(get("expiry"))
+	if err != nil {
+		return client, err
+	}
+	client.ExpiryTime = expiry
+
+	secret := get("uuid")
+	if secret == "" {
+		if secret, err = newClientSecret(protocol, method); err != nil {
+			return client, err
+		}
+	}
+	switch protocol {
+	case model.VMESS, model.VLESS:
+		if !clientUUIDRegex.MatchString(secret) {
+			return client, common.NewErrorf("invalid uuid %q", secret)
+		}
+		client.ID = strings.ToLower(secret)
+		if protocol == model.VMESS {
+			client.Security = "auto"
+		}
+	case model.Trojan, model.Shadowsocks:
+		if strings.HasPrefix(method, "2022-blake3-") {
+			key, err := base64.StdEncoding.DecodeString(secret)
+			if err != nil || (len(key) != 16 && len(key) != 32) {
+				return client, common.NewErrorf("%s needs a base64 key as password", method)
+			}
+		}
+		client.Password = secret
+	}
+	return client, nil
+}
+
+// ImportClients adds the clients of a CSV with an email, limit (GB), expiry and uuid header to the
+// inbound. Rows that fail validation are reported and skipped, the others are saved together and
+// Xray restarts once.
+func (s *XrayService) ImportClients(inboundId int, csvData io.Reader) (ImportClientsResult, error) {
+	result := ImportClientsResult{Rows: []ImportClientRow{}}
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return result, err
+	}
+	switch inbound.Protocol {
+	case model.VMESS, model.VLESS, model.Trojan, model.Shadowsocks:
+	default:
+		return result, common.NewErrorf("inbound %s (%s) has no clients", inbound.Tag, inbound.Protocol)
+	}
+	var settings map[string]interface{}
+	err = json.Unmarshal([]byte(inbound.Settings), &settings)
+	if err != nil {
+		return result, err
+	}
+	method, _ := settings["method"].(string)
+
+	reader := csv.NewReader(csvData)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return result, common.NewErrorf("invalid csv header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return result, common.NewError("csv header has no email column")
+	}
+
+	allEmails, err := s.inboundService.getAllEmails()
+	if err != nil {
+		return result, err
+	}
+	emails := make(map[string]bool, len(allEmails))
+	for _, email := range allEmails {
+		emails[strings.Trim(email, `"`)] = true
+	}
+
+	var clients []model.Client
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		item := ImportClientRow{Row: row}
+		if err != nil {
+			item.Error = err.Error()
+			result.Rows = append(result.Rows, item)
+			result.Failed++
+			continue
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		client, err := parseImportRow(inbound.Protocol, method, get)
+		item.Email = client.Email
+		if err == nil && emails[client.Email] {
+			err = common.NewErrorf("Duplicate email: %s", client.Email)
+		}
+		if err != nil {
+			item.Error = err.Error()
+			result.Failed++
+		} else {
+			emails[client.Email] = true
+			clients = append(clients, client)
+			result.Imported++
+		}
+		result.Rows = append(result.Rows, item)
+	}
+	if len(clients) == 0 {
+		return result, nil
+	}
+
+	interfaceClients, _ := settings["clients"].([]interface{})
+	for _, client := range clients {
+		interfaceClients = append(interfaceClients, client)
+	}
+	settings["clients"] = interfaceClients
+	newSettings, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	inbound.Settings = string(newSettings)
+
+	tx := database.GetDB().Begin()
+	for i := range clients {
+		if err = s.inboundService.AddClientStat(tx, inbound.Id, &clients[i]); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+	if err = tx.Save(inbound).Error; err != nil {
+		tx.Rollback()
+		return result, err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return result, err
+	}
+	if inbound.Enable {
+		s.SetToNeedRestart()
+	}
+	return result, nil
+}
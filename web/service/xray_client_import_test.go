@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestParseImportRow(t *testing.T) {
+	aes128Key := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	tests := []struct {
+		name     string
+		protocol model.Protocol
+		method   string
+		row      map[string]string
+		check    func(client model.Client) bool
+		wantErr  string
+	}{
+		{
+			name: "vless with uuid", protocol: model.VLESS,
+			row: map[string]string{"email": "alice", "uuid": "27848739-7E62-4138-9FD3-098A63964B6B", "limit": "1.5", "expiry": "2030-01-02"},
+			check: func(c model.Client) bool {
+				return c.ID == "27848739-7e62-4138-9fd3-098a63964b6b" && c.TotalGB == 3<<29 && c.ExpiryTime == 1893542400000
+			},
+		},
+		{
+			name: "vmess generates a uuid", protocol: model.VMESS,
+			row: map[string]string{"email": "alice", "expiry": "-86400000"},
+			check: func(c model.Client) bool {
+				return clientUUIDRegex.MatchString(c.ID) && c.Security == "auto" && c.ExpiryTime == -86400000
+			},
+		},
+		{
+			name: "trojan password", protocol: model.Trojan,
+			row:   map[string]string{"email": "alice", "uuid": "secret"},
+			check: func(c model.Client) bool { return c.Password == "secret" },
+		},
+		{
+			name: "shadowsocks 2022 generates a key", protocol: model.Shadowsocks, method: "2022-blake3-aes-128-gcm",
+			row: map[string]string{"email": "alice"},
+			check: func(c model.Client) bool {
+				key, err := base64.StdEncoding.DecodeString(c.Password)
+				return err == nil && len(key) == 16
+			},
+		},
+		{
+			name: "shadowsocks 2022 key", protocol: model.Shadowsocks, method: "2022-blake3-aes-128-gcm",
+			row:   map[string]string{"email": "alice", "uuid": aes128Key},
+			check: func(c model.Client) bool { return c.Password == aes128Key },
+		},
+		{name: "shadowsocks 2022 plain password", protocol: model.Shadowsocks, method: "2022-blake3-aes-128-gcm", row: map[string]string{"email": "alice", "uuid": "secret"}, wantErr: "needs a base64 key"},
+		{name: "invalid uuid", protocol: model.VLESS, row: map[string]string{"email": "alice", "uuid": "not-a-uuid"}, wantErr: `invalid uuid "not-a-uuid"`},
+		{name: "no email", protocol: model.VLESS, row: map[string]string{}, wantErr: "email is required"},
+		{name: "uppercase email", protocol: model.VLESS, row: map[string]string{"email": "Alice"}, wantErr: "uppercase"},
+		{name: "negative limit", protocol: model.VLESS, row: map[string]string{"email": "alice", "limit": "-1"}, wantErr: "invalid limit"},
+		{name: "invalid expiry", protocol: model.VLESS, row: map[string]string{"email": "alice", "expiry": "tomorrow"}, wantErr: "invalid expiry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := parseImportRow(tt.protocol, tt.method, func(name string) string { return tt.row[name] })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.Email != "alice" || !client.Enable || client.SubID == "" || !tt.check(client) {
+				t.Fatalf("got client %+v", client)
+			}
+		})
+	}
+}
+
+func TestImportClients(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	csv := "Email, Limit, Expiry, UUID\n" +
+		"alice, 10, , b831381d-6324-4d53-ad4f-8cda48b30811\n" +
+		"bob, , 2030-01-02,\n" +
+		"carol, 5, , not-a-uuid\n" +
+		"inbound-20001@test, , ,\n"
+	result, err := s.ImportClients(inbound.Id, strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportClientRow{
+		{Row: 1, Email: "alice"},
+		{Row: 2, Email: "bob"},
+		{Row: 3, Email: "carol", Error: `invalid uuid "not-a-uuid"`},
+		{Row: 4, Email: "inbound-20001@test", Error: "Duplicate email: inbound-20001@test"},
+	}
+	if result.Imported != 2 || result.Failed != 2 || !reflect.DeepEqual(result.Rows, want) {
+		t.Fatalf("got %+v, want rows %+v", result, want)
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("the import did not ask for a restart")
+	}
+
+	var stored model.Inbound
+	if err := database.GetDB().First(&stored, inbound.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	var settings struct {
+		Clients []model.Client `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(stored.Settings), &settings); err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, client := range settings.Clients {
+		emails = append(emails, client.Email)
+	}
+	if wantEmails := []string{"inbound-20001@test", "alice", "bob"}; !reflect.DeepEqual(emails, wantEmails) {
+		t.Fatalf("got clients %v, want %v", emails, wantEmails)
+	}
+	var alice xray.ClientTraffic
+	if err := database.GetDB().Where("email = ?", "alice").First(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if alice.InboundId != inbound.Id || alice.Total != 10<<30 {
+		t.Fatalf("got alice traffic %+v", alice)
+	}
+
+	for _, csv := range []string{"", "name,uuid\nalice,\n"} {
+		if _, err := s.ImportClients(inbound.Id, strings.NewReader(csv)); err == nil {
+			t.Fatalf("got no error for csv %q", csv)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+func TestClientExpiringObserver(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Now()
+	expiry := now.Add(10 * day).UnixMilli()
+	renewed := now.Add(30 * day).UnixMilli()
+
+	type fired struct {
+		email     string
+		threshold time.Duration
+	}
+	var got []fired
+	s := &XrayService{}
+	defer func() {
+		clientExpiringLock.Lock()
+		clientExpiringObservers = nil
+		clientExpiringLock.Unlock()
+	}()
+	s.OnClientExpiringSoon([]time.Duration{day, 7 * day, 3 * day}, func(email string, remaining time.Duration) {
+		// Report the threshold the remaining time fell under
+		for _, threshold := range []time.Duration{day, 3 * day, 7 * day} {
+			if remaining <= threshold {
+				got = append(got, fired{email, threshold})
+				return
+			}
+		}
+		t.Errorf("%s notified with %v remaining", email, remaining)
+	})
+	observer := clientExpiringObservers[len(clientExpiringObservers)-1]
+
+	steps := []struct {
+		name     string
+		at       time.Time
+		expiry   int64
+		wantSent int
+	}{
+		{"far from expiry", now, expiry, 0},
+		{"crosses 7 days", now.Add(4 * day), expiry, 1},
+		{"same threshold again", now.Add(4*day + time.Hour), expiry, 0},
+		{"jumps over 3 days and 1 day", now.Add(9*day + time.Hour), expiry, 2},
+		{"already fired", now.Add(9*day + 2*time.Hour), expiry, 0},
+		{"expired", now.Add(11 * day), expiry, 0},
+		{"renewed and crosses 7 days again", now.Add(24 * day), renewed, 1},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			got = nil
+			observer.check([]*xray.ClientTraffic{{Email: "alice", ExpiryTime: step.expiry}}, step.at)
+			if len(got) != step.wantSent {
+				t.Fatalf("got %d notifications %v, want %d", len(got), got, step.wantSent)
+			}
+		})
+	}
+
+	// Thresholds crossed at once arrive largest first
+	got = nil
+	observer.check([]*xray.ClientTraffic{{Email: "bob", ExpiryTime: now.Add(12 * time.Hour).UnixMilli()}}, now)
+	if len(got) != 3 || got[0].threshold != day {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestNotifyExpiringClients(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	now := time.Now()
+	clients := []*xray.ClientTraffic{
+		{Email: "soon", Enable: true, ExpiryTime: now.Add(time.Hour).UnixMilli()},
+		{Email: "later", Enable: true, ExpiryTime: now.Add(100 * time.Hour).UnixMilli()},
+		{Email: "disabled", Enable: false, ExpiryTime: now.Add(time.Hour).UnixMilli()},
+		{Email: "unlimited", Enable: true},
+	}
+	for _, client := range clients {
+		if err := db.Create(client).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var notified []string
+	s := &XrayService{}
+	defer func() {
+		clientExpiringLock.Lock()
+		clientExpiringObservers = nil
+		clientExpiringLock.Unlock()
+	}()
+	s.OnClientExpiringSoon([]time.Duration{24 * time.Hour}, func(email string, remaining time.Duration) {
+		notified = append(notified, email)
+	})
+	for i := 0; i < 2; i++ {
+		if err := s.NotifyExpiringClients(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notified) != 1 || notified[0] != "soon" {
+		t.Fatalf("notified %v, want [soon]", notified)
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// compatShim makes a generated config acceptable to Xray versions older than since
+type compatShim struct {
+	since    string
+	describe string
+	apply    func(xrayConfig *xray.Config) error
+}
+
+// compatShims is ordered from the newest version down, a shim may leave a change for an older one
+var compatShims = []compatShim{
+	{
+		since:    "24.11.11",
+		describe: "splithttp was renamed to xhttp",
+		apply: func(xrayConfig *xray.Config) error {
+			return updateInboundStreams(xrayConfig, func(tag string, stream map[string]interface{}) (bool, error) {
+				if network, _ := stream["network"].(string); network != "xhttp" {
+					return false, nil
+				}
+				stream["network"] = "splithttp"
+				if settings, ok := stream["xhttpSettings"]; ok {
+					stream["splithttpSettings"] = settings
+					delete(stream, "xhttpSettings")
+				}
+				return true, nil
+			})
+		},
+	},
+	{
+		since:    "1.8.16",
+		describe: "splithttp transport",
+		apply:    rejectNetwork("splithttp"),
+	},
+	{
+		since:    "1.8.9",
+		describe: "httpupgrade transport",
+		apply:    rejectNetwork("httpupgrade"),
+	},
+	{
+		since:    "1.8.6",
+		describe: "sockopt tcpMptcp",
+		apply: func(xrayConfig *xray.Config) error {
+			return updateInboundStreams(xrayConfig, func(tag string, stream map[string]interface{}) (bool, error) {
+				sockopt, _ := stream["sockopt"].(map[string]interface{})
+				if _, ok := sockopt["tcpMptcp"]; !ok {
+					return false, nil
+				}
+				delete(sockopt, "tcpMptcp")
+				return true, nil
+			})
+		},
+	},
+}
+
+// rejectNetwork fails for transports an older version cannot run, there is nothing to fall back to
+func rejectNetwork(network string) func(xrayConfig *xray.Config) error {
+	return func(xrayConfig *xray.Config) error {
+		return updateInboundStreams(xrayConfig, func(tag string, stream map[string]interface{}) (bool, error) {
+			if n, _ := stream["network"].(string); n == network {
+				return false, common.NewErrorf("inbound %s uses the %s transport, which this Xray version does not support", tag, network)
+			}
+			return false, nil
+		})
+	}
+}
+
+// updateInboundStreams calls fn with the stream settings of every inbound and saves the ones it changed
+func updateInboundStreams(xrayConfig *xray.Config, fn func(tag string, stream map[string]interface{}) (bool, error)) error {
+	for i := range xrayConfig.InboundConfigs {
+		inbound := &xrayConfig.InboundConfigs[i]
+		if len(inbound.StreamSettings) == 0 {
+			continue
+		}
+		var stream map[string]interface{}
+		if err := json.Unmarshal(inbound.StreamSettings, &stream); err != nil {
+			return err
+		}
+		if stream == nil {
+			continue
+		}
+		changed, err := fn(inbound.Tag, stream)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		data, err := json.MarshalIndent(stream, "", "  ")
+		if err != nil {
+			return err
+		}
+		inbound.StreamSettings = data
+	}
+	return nil
+}
+
+// GetXrayConfigForVersion generates the config and adapts it to an older Xray version. Fields the version
+// does not know are dropped or renamed, a transport it cannot run is an error.
+func (s *XrayService) GetXrayConfigForVersion(version string) (*xray.Config, error) {
+	target := parseXrayVersion(version)
+	if target == nil {
+		return nil, common.NewErrorf("invalid xray version %q", version)
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, shim := range compatShims {
+		if compareXrayVersion(target, parseXrayVersion(shim.since)) >= 0 {
+			continue
+		}
+		if err := shim.apply(xrayConfig); err != nil {
+			return nil, err
+		}
+	}
+	return xrayConfig, nil
+}
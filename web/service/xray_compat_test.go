@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+)
+
+func TestGetXrayConfigForVersion(t *testing.T) {
+	xhttpStream := `{"network": "xhttp", "security": "none", "xhttpSettings": {"path": "/x"}, "sockopt": {"tcpMptcp": true}}`
+	tests := []struct {
+		name        string
+		stream      string
+		version     string
+		wantNetwork string
+		wantMptcp   bool
+		wantErr     string
+	}{
+		{name: "current version", stream: xhttpStream, version: "25.1.30", wantNetwork: "xhttp", wantMptcp: true},
+		{name: "before the xhttp rename", stream: xhttpStream, version: "24.10.31", wantNetwork: "splithttp", wantMptcp: true},
+		{name: "v prefix", stream: xhttpStream, version: "v1.8.16", wantNetwork: "splithttp", wantMptcp: true},
+		{name: "before splithttp", stream: xhttpStream, version: "1.8.15", wantErr: "uses the splithttp transport"},
+		{name: "before httpupgrade", stream: `{"network": "httpupgrade"}`, version: "1.8.8", wantErr: "uses the httpupgrade transport"},
+		{name: "before tcpMptcp", stream: `{"network": "tcp", "sockopt": {"tcpMptcp": true, "mark": 255}}`, version: "1.8.4", wantNetwork: "tcp"},
+		{name: "invalid version", stream: xhttpStream, version: "latest", wantErr: `invalid xray version "latest"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			inbound := addTestInbound(t, 20001, "inbound-20001", true)
+			inbound.StreamSettings = tt.stream
+			if err := database.GetDB().Save(inbound).Error; err != nil {
+				t.Fatal(err)
+			}
+			s := &XrayService{}
+			xrayConfig, err := s.GetXrayConfigForVersion(tt.version)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stream struct {
+				Network           string                 `json:"network"`
+				XhttpSettings     map[string]interface{} `json:"xhttpSettings"`
+				SplithttpSettings map[string]interface{} `json:"splithttpSettings"`
+				Sockopt           map[string]interface{} `json:"sockopt"`
+			}
+			for _, inboundConfig := range xrayConfig.InboundConfigs {
+				if inboundConfig.Tag == inbound.Tag {
+					if err := json.Unmarshal(inboundConfig.StreamSettings, &stream); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if stream.Network != tt.wantNetwork {
+				t.Fatalf("got network %q, want %q", stream.Network, tt.wantNetwork)
+			}
+			// The transport settings move with the network name
+			if tt.wantNetwork == "splithttp" && (stream.SplithttpSettings["path"] != "/x" || stream.XhttpSettings != nil) {
+				t.Fatalf("got xhttp settings %v and splithttp settings %v", stream.XhttpSettings, stream.SplithttpSettings)
+			}
+			if _, ok := stream.Sockopt["tcpMptcp"]; ok != tt.wantMptcp {
+				t.Fatalf("got sockopt %v, want tcpMptcp %v", stream.Sockopt, tt.wantMptcp)
+			}
+		})
+	}
+}
+
+func TestGetXrayConfigForVersionKeepsCache(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	inbound.StreamSettings = `{"network": "xhttp", "xhttpSettings": {"path": "/x"}}`
+	if err := database.GetDB().Save(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	s := &XrayService{}
+	if _, err := s.GetXrayConfigForVersion("1.8.24"); err != nil {
+		t.Fatal(err)
+	}
+	// The shims work on a copy, the current config still has xhttp
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inboundConfig := range xrayConfig.InboundConfigs {
+		if inboundConfig.Tag == inbound.Tag && !strings.Contains(string(inboundConfig.StreamSettings), `"xhttp"`) {
+			t.Fatalf("got stream settings %s", inboundConfig.StreamSettings)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// ConfigCheckError is one problem xray found in a config. Line is in the config as written for
+// xray, Section names the part of the config it is in when it could be found.
+type ConfigCheckError struct {
+	Line    int    `json:"line,omitempty"`
+	Section string `json:"section,omitempty"`
+	Message string `json:"message"`
+}
+
+type ConfigCheckResult struct {
+	Valid  bool               `json:"valid"`
+	Errors []ConfigCheckError `json:"errors"`
+}
+
+var (
+	configCheckLineRegex = regexp.MustCompile(`at line (\d+)`)
+	configSectionRegex   = regexp.MustCompile(`^  "(\w+)":`)
+)
+
+// Words of the xray error chain and the config section they point at, most specific first
+var configCheckSections = []struct {
+	word    string
+	section string
+}{
+	{"sniffing", "inbounds"},
+	{"inbound", "inbounds"},
+	{"Mux", "outbounds"},
+	{"outbound", "outbounds"},
+	{"DNS", "dns"},
+	{"router", "routing"},
+	{"routing", "routing"},
+	{"balancer", "routing"},
+	{"policy", "policy"},
+	{"reverse", "reverse"},
+	{"fakedns", "fakedns"},
+	{"observatory", "observatory"},
+	{"log", "log"},
+}
+
+// CheckXrayConfig generates the config and runs it through xray -test
+func (s *XrayService) CheckXrayConfig() (*ConfigCheckResult, error) {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	return checkXrayConfig(xrayConfig)
+}
+
+// checkXrayConfig runs the config through xray -test. The error is for failing to run the check,
+// a config xray rejects gives a result with its errors.
+func checkXrayConfig(xrayConfig *xray.Config) (*ConfigCheckResult, error) {
+	data, output, err := xray.TestConfig(xrayConfig)
+	if err == nil {
+		return &ConfigCheckResult{Valid: true, Errors: []ConfigCheckError{}}, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil, err
+	}
+
+	result := &ConfigCheckResult{Errors: locateConfigErrors(xrayConfig)}
+	if len(result.Errors) == 0 {
+		result.Errors = parseConfigCheckOutput(output, data)
+	}
+	return result, nil
+}
+
+// locateConfigErrors builds every inbound and outbound alone, so a broken one is reported with
+// its index and tag. Both are built with the xray-core the panel links, which may be older than
+// the binary, so this only explains a failure the binary already reported.
+func locateConfigErrors(xrayConfig *xray.Config) []ConfigCheckError {
+	var errs []ConfigCheckError
+	for i, inbound := range xrayConfig.InboundConfigs {
+		data, err := json.Marshal(inbound)
+		if err == nil {
+			_, err = xray.BuildInbound(data)
+		}
+		if err != nil {
+			errs = append(errs, ConfigCheckError{
+				Section: fmt.Sprintf("inbounds[%d] %s", i, inbound.Tag),
+				Message: lastErrorSegment(err.Error()),
+			})
+		}
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return append(errs, ConfigCheckError{Section: "outbounds", Message: err.Error()})
+	}
+	for i, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		data, err := json.Marshal(outbound)
+		if err == nil {
+			_, err = xray.BuildOutbound(data)
+		}
+		if err != nil {
+			errs = append(errs, ConfigCheckError{
+				Section: fmt.Sprintf("outbounds[%d] %s", i, tag),
+				Message: lastErrorSegment(err.Error()),
+			})
+		}
+	}
+	return errs
+}
+
+// parseConfigCheckOutput reads the "Failed to start" lines xray -test prints
+func parseConfigCheckOutput(output string, data []byte) []ConfigCheckError {
+	var errs []ConfigCheckError
+	for _, line := range strings.Split(output, "\n") {
+		_, chain, found := strings.Cut(line, "Failed to start:")
+		if !found {
+			continue
+		}
+		item := ConfigCheckError{Message: lastErrorSegment(chain)}
+		if m := configCheckLineRegex.FindStringSubmatch(chain); m != nil {
+			item.Line, _ = strconv.Atoi(m[1])
+			item.Section = configSectionAt(data, item.Line)
+		}
+		if item.Section == "" {
+			for _, s := range configCheckSections {
+				if strings.Contains(chain, s.word) {
+					item.Section = s.section
+					break
+				}
+			}
+		}
+		errs = append(errs, item)
+	}
+	if len(errs) == 0 {
+		errs = append(errs, ConfigCheckError{Message: strings.TrimSpace(output)})
+	}
+	return errs
+}
+
+// configSectionAt returns the top level key the line of the indented config belongs to
+func configSectionAt(data []byte, line int) string {
+	section := ""
+	for i, text := range strings.Split(string(data), "\n") {
+		if i >= line {
+			break
+		}
+		if m := configSectionRegex.FindStringSubmatch(text); m != nil {
+			section = m[1]
+		}
+	}
+	return section
+}
+
+// lastErrorSegment drops the chain of wrapping errors xray prints before the cause
+func lastErrorSegment(chain string) string {
+	segments := strings.Split(chain, " > ")
+	return strings.TrimSpace(segments[len(segments)-1])
+}
+
+// checkBeforeRestart refuses a config xray rejects, so the running process keeps serving. It runs
+// once xrayConfigCheck is turned on. Failing to run the check does not block the restart.
+func (s *XrayService) checkBeforeRestart(xrayConfig *xray.Config) error {
+	enabled, err := s.settingService.GetXrayConfigCheck()
+	if err != nil || !enabled {
+		return err
+	}
+	result, err := checkXrayConfig(xrayConfig)
+	if err != nil {
+		logger.Warning("Failed to check the xray config:", err)
+		return nil
+	}
+	if result.Valid {
+		return nil
+	}
+	messages := make([]string, 0, len(result.Errors))
+	for _, e := range result.Errors {
+		if e.Section != "" {
+			messages = append(messages, e.Section+": "+e.Message)
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+	return common.NewErrorf("xray rejected the config: %s", strings.Join(messages, "; "))
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+// setStubXray makes the xray binary a shell script
+func setStubXray(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub xray is a shell script")
+	}
+	binFolder := t.TempDir()
+	t.Setenv("XUI_BIN_FOLDER", binFolder)
+	if err := os.WriteFile(filepath.Join(binFolder, xray.GetBinaryName()), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConfigCheckOutput(t *testing.T) {
+	data := []byte("{\n  \"log\": {\n    \"loglevel\": \"warning\"\n  },\n  \"routing\": {\n    \"rules\": []\n  }\n}")
+	tests := []struct {
+		name   string
+		output string
+		want   []ConfigCheckError
+	}{
+		{
+			name:   "line in a section",
+			output: "Xray 1.8.24\nFailed to start: main: failed to load config files > infra/conf/serial: failed to parse json config at line 6 > invalid character",
+			want:   []ConfigCheckError{{Line: 6, Section: "routing", Message: "invalid character"}},
+		},
+		{
+			name:   "section from the error chain",
+			output: "Failed to start: main: failed to create server > app/router: failed to build balancer > unknown strategy",
+			want:   []ConfigCheckError{{Section: "routing", Message: "unknown strategy"}},
+		},
+		{
+			name:   "no failure line",
+			output: "  something went wrong  ",
+			want:   []ConfigCheckError{{Message: "something went wrong"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseConfigCheckOutput(tt.output, data); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBeforeRestart(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	xrayConfig := &xray.Config{LogConfig: []byte(`{"loglevel": "warning"}`)}
+	setStubXray(t, "#!/bin/sh\necho 'Failed to start: main: failed to create server > app/log: invalid log level' >&2\nexit 23\n")
+
+	// Off by default, xray is not even run
+	if err := s.checkBeforeRestart(xrayConfig); err != nil {
+		t.Fatalf("got error %v with the check off", err)
+	}
+	if err := s.settingService.SetXrayConfigCheck(true); err != nil {
+		t.Fatal(err)
+	}
+	err := s.checkBeforeRestart(xrayConfig)
+	if err == nil || !strings.Contains(err.Error(), "xray rejected the config: log: app/log: invalid log level") {
+		t.Fatalf("got error %v, want the rejection", err)
+	}
+
+	// A check that can not run does not block the restart
+	t.Setenv("XUI_BIN_FOLDER", t.TempDir())
+	if err := s.checkBeforeRestart(xrayConfig); err != nil {
+		t.Fatalf("got error %v without an xray binary", err)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"encoding/json"
+
+	"x-ui/logger"
+)
+
+// ConfigStats describes the scale of the config that would be generated
+type ConfigStats struct {
+	EnabledInbounds  int `json:"enabledInbounds"`
+	DisabledInbounds int `json:"disabledInbounds"`
+	TotalClients     int `json:"totalClients"`
+	ActiveClients    int `json:"activeClients"`
+	// Clients of enabled inbounds left out of the config, disabled or over their limits
+	FilteredClients int `json:"filteredClients"`
+	// Estimated from the stored JSON of the template and the enabled inbounds
+	EstimatedBytes int `json:"estimatedBytes"`
+}
+
+// ConfigStats counts inbounds and clients the way GetXrayConfig filters them, without generating the config
+func (s *XrayService) ConfigStats() (ConfigStats, error) {
+	stats := ConfigStats{}
+	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return stats, err
+	}
+	stats.EstimatedBytes = len(templateConfig)
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return stats, err
+	}
+	for _, inbound := range inbounds {
+		var settings struct {
+			Clients []struct {
+				Email  string `json:"email"`
+				Enable *bool  `json:"enable"`
+			} `json:"clients"`
+		}
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			logger.Warningf("Failed to unmarshal settings of inbound %d: %v", inbound.Id, err)
+		}
+		stats.TotalClients += len(settings.Clients)
+
+		if !inbound.Enable {
+			stats.DisabledInbounds++
+			continue
+		}
+		stats.EnabledInbounds++
+		stats.EstimatedBytes += len(inbound.Settings) + len(inbound.StreamSettings) + len(inbound.Sniffing)
+
+		depleted := make(map[string]bool, len(inbound.ClientStats))
+		for _, clientTraffic := range inbound.ClientStats {
+			if !clientTraffic.Enable {
+				depleted[clientTraffic.Email] = true
+			}
+		}
+		for _, client := range settings.Clients {
+			if depleted[client.Email] || (client.Enable != nil && !*client.Enable) {
+				stats.FilteredClients++
+			} else {
+				stats.ActiveClients++
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestConfigStats(t *testing.T) {
+	tests := []struct {
+		name string
+		// settings of the inbounds, enabled unless their index is in disabled
+		inbounds []string
+		disabled map[int]bool
+		// emails whose traffic row is disabled, over their limits
+		depleted []string
+		want     ConfigStats
+	}{
+		{name: "no inbounds"},
+		{
+			name:     "active clients",
+			inbounds: []string{`{"clients": [{"email": "a"}, {"email": "b", "enable": true}]}`},
+			want:     ConfigStats{EnabledInbounds: 1, TotalClients: 2, ActiveClients: 2},
+		},
+		{
+			name:     "disabled and depleted clients are filtered",
+			inbounds: []string{`{"clients": [{"email": "a"}, {"email": "b", "enable": false}, {"email": "c"}]}`},
+			depleted: []string{"c"},
+			want:     ConfigStats{EnabledInbounds: 1, TotalClients: 3, ActiveClients: 1, FilteredClients: 2},
+		},
+		{
+			name: "clients of disabled inbounds are only counted in total",
+			inbounds: []string{
+				`{"clients": [{"email": "a"}]}`,
+				`{"clients": [{"email": "b"}, {"email": "c"}]}`,
+			},
+			disabled: map[int]bool{1: true},
+			want:     ConfigStats{EnabledInbounds: 1, DisabledInbounds: 1, TotalClients: 3, ActiveClients: 1},
+		},
+		{
+			name:     "zero active clients",
+			inbounds: []string{`{"clients": [{"email": "a", "enable": false}]}`},
+			want:     ConfigStats{EnabledInbounds: 1, TotalClients: 1, FilteredClients: 1},
+		},
+		{
+			name:     "inbound without clients",
+			inbounds: []string{`{"address": "1.1.1.1", "port": 53}`},
+			want:     ConfigStats{EnabledInbounds: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			s := &XrayService{}
+			template, err := s.settingService.GetXrayConfigTemplate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBytes := len(template)
+
+			depleted := map[string]bool{}
+			for _, email := range tt.depleted {
+				depleted[email] = true
+			}
+			for i, settings := range tt.inbounds {
+				inbound := &model.Inbound{
+					Port:     20001 + i,
+					Tag:      "inbound-" + string(rune('a'+i)),
+					Enable:   !tt.disabled[i],
+					Protocol: model.VLESS,
+					Settings: settings,
+					Sniffing: `{"enabled": false}`,
+				}
+				if err := database.GetDB().Create(inbound).Error; err != nil {
+					t.Fatal(err)
+				}
+				if inbound.Enable {
+					wantBytes += len(inbound.Settings) + len(inbound.StreamSettings) + len(inbound.Sniffing)
+				}
+				for email := range depleted {
+					traffic := &xray.ClientTraffic{InboundId: inbound.Id, Email: email, Enable: true}
+					if err := database.GetDB().Create(traffic).Error; err != nil {
+						t.Fatal(err)
+					}
+					if err := database.GetDB().Model(traffic).Update("enable", false).Error; err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			got, err := s.ConfigStats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.EstimatedBytes = wantBytes
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
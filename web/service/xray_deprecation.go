@@ -0,0 +1,233 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"x-ui/database/model"
+	"x-ui/logger"
+)
+
+// Deprecation is a deprecated value found in the settings of an inbound
+type Deprecation struct {
+	InboundId   int    `json:"inboundId"`
+	Tag         string `json:"tag"`
+	Field       string `json:"field"`
+	Value       string `json:"value"`
+	Replacement string `json:"replacement"`
+	Since       string `json:"since"`
+	// Removed is set when the running core no longer accepts the value
+	Removed bool `json:"removed"`
+}
+
+type deprecationRule struct {
+	since       string // first xray version deprecating the value
+	removed     string // first xray version rejecting it, empty while still accepted
+	field       string
+	replacement string
+	find        func(inbound *model.Inbound, settings, stream map[string]interface{}) []string
+}
+
+var legacyXtlsFlows = map[string]bool{
+	"xtls-rprx-origin": true, "xtls-rprx-origin-udp443": true,
+	"xtls-rprx-direct": true, "xtls-rprx-direct-udp443": true,
+	"xtls-rprx-splice": true, "xtls-rprx-splice-udp443": true,
+}
+
+var streamShadowsocksCiphers = map[string]bool{
+	"aes-128-cfb": true, "aes-192-cfb": true, "aes-256-cfb": true,
+	"aes-128-ctr": true, "aes-192-ctr": true, "aes-256-ctr": true,
+	"chacha20": true, "chacha20-ietf": true, "rc4-md5": true,
+}
+
+// deprecationRules is ordered by the version the deprecation appeared in
+var deprecationRules = []deprecationRule{
+	{
+		since: "1.0.0", removed: "1.0.0",
+		field: "settings.method", replacement: "aes-256-gcm or 2022-blake3-aes-256-gcm",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			if inbound.Protocol != model.Shadowsocks {
+				return nil
+			}
+			var found []string
+			if method, _ := settings["method"].(string); streamShadowsocksCiphers[method] {
+				found = append(found, method)
+			}
+			for _, method := range clientValues(settings, "method") {
+				if streamShadowsocksCiphers[method] {
+					found = append(found, method)
+				}
+			}
+			return found
+		},
+	},
+	{
+		since: "1.0.0",
+		field: "settings.clients.alterId", replacement: "0 (VMess AEAD)",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			if inbound.Protocol != model.VMESS {
+				return nil
+			}
+			var found []string
+			clients, _ := settings["clients"].([]interface{})
+			for _, client := range clients {
+				c, _ := client.(map[string]interface{})
+				if alterId, ok := c["alterId"].(float64); ok && alterId > 0 {
+					found = append(found, strconv.Itoa(int(alterId)))
+				}
+			}
+			return found
+		},
+	},
+	{
+		since: "1.8.0", removed: "1.8.0",
+		field: "settings.clients.flow", replacement: "xtls-rprx-vision",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			var found []string
+			for _, flow := range clientValues(settings, "flow") {
+				if legacyXtlsFlows[flow] {
+					found = append(found, flow)
+				}
+			}
+			return found
+		},
+	},
+	{
+		since: "1.8.0",
+		field: "settings.clients.flow", replacement: "xtls-rprx-vision",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			var found []string
+			for _, flow := range clientValues(settings, "flow") {
+				if flow == "xtls-rprx-vision-udp443" {
+					found = append(found, flow)
+				}
+			}
+			return found
+		},
+	},
+	{
+		since: "1.8.0", removed: "1.8.0",
+		field: "streamSettings.security", replacement: "tls or reality",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			if security, _ := stream["security"].(string); security == "xtls" {
+				return []string{security}
+			}
+			return nil
+		},
+	},
+	{
+		since: "24.9.30",
+		field: "streamSettings.network", replacement: "splithttp",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			if network, _ := stream["network"].(string); network == "quic" {
+				return []string{network}
+			}
+			return nil
+		},
+	},
+	{
+		since: "24.9.30",
+		field: "streamSettings.network", replacement: "tcp with a unix socket listen address",
+		find: func(inbound *model.Inbound, settings, stream map[string]interface{}) []string {
+			if network, _ := stream["network"].(string); network == "domainsocket" {
+				return []string{network}
+			}
+			return nil
+		},
+	},
+}
+
+func clientValues(settings map[string]interface{}, key string) []string {
+	var values []string
+	clients, _ := settings["clients"].([]interface{})
+	for _, client := range clients {
+		c, _ := client.(map[string]interface{})
+		if value, ok := c[key].(string); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseXrayVersion turns "1.8.24" or "v24.9.30" into comparable parts, nil when unknown
+func parseXrayVersion(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return nil
+	}
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compareXrayVersion(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// DeprecatedFields scans the inbounds for values the running Xray version deprecates.
+// When the version is unknown every rule applies.
+func (s *XrayService) DeprecatedFields() ([]Deprecation, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	running := parseXrayVersion(s.GetXrayVersion())
+
+	deprecations := []Deprecation{}
+	for _, inbound := range inbounds {
+		settings := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			logger.Warningf("Failed to unmarshal settings of inbound %d: %v", inbound.Id, err)
+			continue
+		}
+		stream := map[string]interface{}{}
+		if inbound.StreamSettings != "" {
+			if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+				logger.Warningf("Failed to unmarshal stream settings of inbound %d: %v", inbound.Id, err)
+				continue
+			}
+		}
+
+		for _, rule := range deprecationRules {
+			if running != nil && compareXrayVersion(running, parseXrayVersion(rule.since)) < 0 {
+				continue
+			}
+			removed := running != nil && rule.removed != "" &&
+				compareXrayVersion(running, parseXrayVersion(rule.removed)) >= 0
+			for _, value := range rule.find(inbound, settings, stream) {
+				deprecations = append(deprecations, Deprecation{
+					InboundId:   inbound.Id,
+					Tag:         inbound.Tag,
+					Field:       rule.field,
+					Value:       value,
+					Replacement: rule.replacement,
+					Since:       rule.since,
+					Removed:     removed,
+				})
+			}
+		}
+	}
+	return deprecations, nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+)
+
+func TestCompareXrayVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.8.24", "1.8.0", 1},
+		{"v1.8.0", "1.8.0", 0},
+		{"1.8", "1.8.0", 0},
+		{"1.7.5", "1.8.0", -1},
+		{"24.9.30", "1.8.24", 1},
+		{"24.9.30", "24.11.11", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := compareXrayVersion(parseXrayVersion(tt.a), parseXrayVersion(tt.b)); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+	for _, version := range []string{"", "Unknown", "1.8.x"} {
+		if parts := parseXrayVersion(version); parts != nil {
+			t.Fatalf("parsed %q as %v, want unknown", version, parts)
+		}
+	}
+}
+
+func TestDeprecatedFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol model.Protocol
+		settings string
+		stream   string
+		want     []Deprecation
+	}{
+		{
+			name:     "current vless inbound",
+			protocol: model.VLESS,
+			settings: `{"clients": [{"email": "a", "flow": "xtls-rprx-vision"}], "decryption": "none"}`,
+			stream:   `{"network": "tcp", "security": "reality"}`,
+		},
+		{
+			name:     "legacy xtls flow and security",
+			protocol: model.VLESS,
+			settings: `{"clients": [{"email": "a", "flow": "xtls-rprx-direct"}, {"email": "b", "flow": "xtls-rprx-vision-udp443"}], "decryption": "none"}`,
+			stream:   `{"network": "tcp", "security": "xtls"}`,
+			want: []Deprecation{
+				{Field: "settings.clients.flow", Value: "xtls-rprx-direct", Replacement: "xtls-rprx-vision", Since: "1.8.0"},
+				{Field: "settings.clients.flow", Value: "xtls-rprx-vision-udp443", Replacement: "xtls-rprx-vision", Since: "1.8.0"},
+				{Field: "streamSettings.security", Value: "xtls", Replacement: "tls or reality", Since: "1.8.0"},
+			},
+		},
+		{
+			name:     "vmess alterId",
+			protocol: model.VMESS,
+			settings: `{"clients": [{"email": "a", "alterId": 64}, {"email": "b", "alterId": 0}]}`,
+			stream:   `{"network": "ws"}`,
+			want: []Deprecation{
+				{Field: "settings.clients.alterId", Value: "64", Replacement: "0 (VMess AEAD)", Since: "1.0.0"},
+			},
+		},
+		{
+			name:     "shadowsocks stream cipher",
+			protocol: model.Shadowsocks,
+			settings: `{"method": "aes-256-cfb", "password": "secret", "clients": []}`,
+			stream:   `{"network": "tcp"}`,
+			want: []Deprecation{
+				{Field: "settings.method", Value: "aes-256-cfb", Replacement: "aes-256-gcm or 2022-blake3-aes-256-gcm", Since: "1.0.0"},
+			},
+		},
+		{
+			name:     "removed transports",
+			protocol: model.VLESS,
+			settings: `{"clients": [], "decryption": "none"}`,
+			stream:   `{"network": "quic"}`,
+			want: []Deprecation{
+				{Field: "streamSettings.network", Value: "quic", Replacement: "splithttp", Since: "24.9.30"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			inbound := &model.Inbound{
+				Port:           20001,
+				Tag:            "inbound-20001",
+				Enable:         true,
+				Protocol:       tt.protocol,
+				Settings:       tt.settings,
+				StreamSettings: tt.stream,
+			}
+			if err := database.GetDB().Create(inbound).Error; err != nil {
+				t.Fatal(err)
+			}
+			// Without a running core the version is unknown and every rule applies
+			s := &XrayService{}
+			got, err := s.DeprecatedFields()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Deprecation{}
+			for _, deprecation := range tt.want {
+				deprecation.InboundId = inbound.Id
+				deprecation.Tag = inbound.Tag
+				want = append(want, deprecation)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+const (
+	// Clients over their device limit are removed from Xray until the cooldown ends
+	DeviceLimitActionRemove = "remove"
+	// Clients over their device limit are only recorded
+	DeviceLimitActionLog = "log"
+)
+
+// How many violations are kept for GetDeviceLimitViolations
+const maxDeviceLimitViolations = 100
+
+// DeviceLimitViolation is a client seen connected from more IPs than its device limit
+type DeviceLimitViolation struct {
+	Email  string   `json:"email"`
+	Limit  int      `json:"limit"`
+	IPs    []string `json:"ips"`
+	Action string   `json:"action"`
+	At     int64    `json:"at"`              // unix milliseconds
+	Until  int64    `json:"until,omitempty"` // unix milliseconds the client is removed until
+}
+
+var deviceLimitState = struct {
+	sync.Mutex
+	violations []DeviceLimitViolation
+	// cooldowns holds the clients removed for their device limit and when they come back
+	cooldowns map[string]time.Time
+}{
+	cooldowns: map[string]time.Time{},
+}
+
+// deviceLimitCooldowns returns the clients still in their cooldown
+func deviceLimitCooldowns() map[string]bool {
+	deviceLimitState.Lock()
+	defer deviceLimitState.Unlock()
+	now := time.Now()
+	removed := make(map[string]bool, len(deviceLimitState.cooldowns))
+	for email, until := range deviceLimitState.cooldowns {
+		if now.Before(until) {
+			removed[email] = true
+		}
+	}
+	return removed
+}
+
+// GetDeviceLimits returns the device limit of every limited client by email
+func (s *XrayService) GetDeviceLimits() (map[string]int, error) {
+	limits := map[string]int{}
+	data, err := s.settingService.GetDeviceLimits()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return limits, nil
+	}
+	err = json.Unmarshal([]byte(data), &limits)
+	if err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// SetClientDeviceLimit caps how many IPs the client may be connected from at once, 0 removes the cap
+func (s *XrayService) SetClientDeviceLimit(email string, limit int) error {
+	if email == "" {
+		return common.NewError("client email is required")
+	}
+	if limit < 0 {
+		return common.NewErrorf("invalid device limit %d", limit)
+	}
+	limits, err := s.GetDeviceLimits()
+	if err != nil {
+		return err
+	}
+	if limit == 0 {
+		delete(limits, email)
+	} else {
+		if err := s.checkClientExists(email); err != nil {
+			return err
+		}
+		limits[email] = limit
+	}
+	data, err := json.MarshalIndent(limits, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.settingService.SetDeviceLimits(string(data))
+}
+
+// SetDeviceLimitAction sets what happens to clients over their limit and for how many minutes they are removed
+func (s *XrayService) SetDeviceLimitAction(action string, cooldown int) error {
+	if action != DeviceLimitActionRemove && action != DeviceLimitActionLog {
+		return common.NewErrorf("device limit action must be %s or %s", DeviceLimitActionRemove, DeviceLimitActionLog)
+	}
+	if cooldown < 1 {
+		return common.NewErrorf("invalid device limit cooldown %d", cooldown)
+	}
+	err := s.settingService.SetDeviceLimitAction(action)
+	if err != nil {
+		return err
+	}
+	return s.settingService.SetDeviceLimitCooldown(cooldown)
+}
+
+// GetDeviceLimitViolations returns the recent violations, newest first
+func (s *XrayService) GetDeviceLimitViolations() []DeviceLimitViolation {
+	deviceLimitState.Lock()
+	defer deviceLimitState.Unlock()
+	violations := make([]DeviceLimitViolation, len(deviceLimitState.violations))
+	for i, violation := range deviceLimitState.violations {
+		violations[len(violations)-1-i] = violation
+	}
+	return violations
+}
+
+// removeClientByAPI drops the client from the running inbound it belongs to
+func (s *XrayService) removeClientByAPI(email string) error {
+	traffic, err := s.inboundService.GetClientTrafficByEmail(email)
+	if err != nil || traffic == nil {
+		return common.NewErrorf("client %s does not exist", email)
+	}
+	inbound, err := s.inboundService.GetInbound(traffic.InboundId)
+	if err != nil {
+		return err
+	}
+	err = s.xrayAPI.Init(mainXrayProcess().GetAPIPort())
+	if err != nil {
+		return err
+	}
+	defer s.xrayAPI.Close()
+	return s.xrayAPI.RemoveUser(inbound.Tag, email)
+}
+
+// CheckDeviceLimits records the clients connected from more IPs than their limit and, when the
+// action is remove, takes them out of Xray for the cooldown. Clients whose cooldown ended are
+// brought back with a restart.
+func (s *XrayService) CheckDeviceLimits() error {
+	limits, err := s.GetDeviceLimits()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expired := false
+	deviceLimitState.Lock()
+	for email, until := range deviceLimitState.cooldowns {
+		if !now.Before(until) {
+			delete(deviceLimitState.cooldowns, email)
+			expired = true
+		}
+	}
+	deviceLimitState.Unlock()
+	if expired {
+		s.SetToNeedRestart()
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+
+	onlineClients, err := s.GetOnlineClients()
+	if err != nil {
+		return err
+	}
+	return s.enforceDeviceLimits(limits, onlineClients, s.removeClientByAPI)
+}
+
+// enforceDeviceLimits records the online clients over their limit, remove takes one out of Xray
+func (s *XrayService) enforceDeviceLimits(limits map[string]int, onlineClients map[string][]string, remove func(email string) error) error {
+	action, err := s.settingService.GetDeviceLimitAction()
+	if err != nil {
+		return err
+	}
+	cooldown, err := s.settingService.GetDeviceLimitCooldown()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	emails := make([]string, 0, len(limits))
+	for email := range limits {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		ips := onlineClients[email]
+		if len(ips) <= limits[email] {
+			continue
+		}
+		deviceLimitState.Lock()
+		_, cooling := deviceLimitState.cooldowns[email]
+		deviceLimitState.Unlock()
+		if cooling {
+			// Connections from before the removal, the client can not make new ones
+			continue
+		}
+
+		violation := DeviceLimitViolation{
+			Email:  email,
+			Limit:  limits[email],
+			IPs:    ips,
+			Action: action,
+			At:     now.UnixMilli(),
+		}
+		logger.Warningf("Client %s is connected from %d devices, over its limit of %d", email, len(ips), limits[email])
+		if action == DeviceLimitActionRemove {
+			until := now.Add(time.Duration(cooldown) * time.Minute)
+			if err := remove(email); err != nil {
+				// The config leaves the client out during the cooldown, a restart removes it as well
+				logger.Debug("Unable to remove client by api:", err)
+				s.SetToNeedRestart()
+			}
+			violation.Until = until.UnixMilli()
+			deviceLimitState.Lock()
+			deviceLimitState.cooldowns[email] = until
+			deviceLimitState.Unlock()
+			// The cached config still has the client
+			invalidateXrayConfigCache()
+		}
+
+		deviceLimitState.Lock()
+		deviceLimitState.violations = append(deviceLimitState.violations, violation)
+		if len(deviceLimitState.violations) > maxDeviceLimitViolations {
+			deviceLimitState.violations = deviceLimitState.violations[1:]
+		}
+		deviceLimitState.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetDeviceLimitState(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		deviceLimitState.Lock()
+		deviceLimitState.violations = nil
+		deviceLimitState.cooldowns = map[string]time.Time{}
+		deviceLimitState.Unlock()
+	})
+}
+
+func TestSetClientDeviceLimit(t *testing.T) {
+	setupTestDB(t)
+	addTestClient(t, 1, "alice")
+	s := &XrayService{}
+	tests := []struct {
+		name    string
+		email   string
+		limit   int
+		want    map[string]int
+		wantErr string
+	}{
+		{name: "sets a limit", email: "alice", limit: 2, want: map[string]int{"alice": 2}},
+		{name: "unknown client", email: "nobody", limit: 2, wantErr: "client nobody does not exist"},
+		{name: "negative limit", email: "alice", limit: -1, wantErr: "invalid device limit -1"},
+		{name: "no email", limit: 2, wantErr: "client email is required"},
+		{name: "zero removes the limit", email: "alice", limit: 0, want: map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetClientDeviceLimit(tt.email, tt.limit)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			limits, err := s.GetDeviceLimits()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(limits, tt.want) {
+				t.Fatalf("got limits %v, want %v", limits, tt.want)
+			}
+		})
+	}
+	if err := s.SetDeviceLimitAction("ban", 10); err == nil {
+		t.Fatal("got no error for an unknown action")
+	}
+	if err := s.SetDeviceLimitAction(DeviceLimitActionLog, 0); err == nil {
+		t.Fatal("got no error for a zero cooldown")
+	}
+}
+
+func TestEnforceDeviceLimits(t *testing.T) {
+	setupTestDB(t)
+	resetDeviceLimitState(t)
+	addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "enable": true},
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "bob", "enable": true}
+	]`, "alice", "bob")
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	limits := map[string]int{"alice": 1, "bob": 2}
+	online := map[string][]string{"alice": {"203.0.113.1", "203.0.113.2"}, "bob": {"203.0.113.3", "203.0.113.4"}}
+
+	var removed []string
+	remove := func(email string) error {
+		removed = append(removed, email)
+		return errors.New("xray is not running")
+	}
+	if err := s.enforceDeviceLimits(limits, online, remove); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"alice"}) {
+		t.Fatalf("removed %v, want only alice", removed)
+	}
+	// The removal failed, a restart leaves alice out instead
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("a failed removal did not ask for a restart")
+	}
+	violations := s.GetDeviceLimitViolations()
+	if len(violations) != 1 {
+		t.Fatalf("got violations %+v, want one", violations)
+	}
+	violation := violations[0]
+	if violation.Email != "alice" || violation.Limit != 1 || violation.Action != DeviceLimitActionRemove || len(violation.IPs) != 2 {
+		t.Fatalf("got violation %+v", violation)
+	}
+	if cooldown := time.Duration(violation.Until-violation.At) * time.Millisecond; cooldown != 10*time.Minute {
+		t.Fatalf("got cooldown %v, want the default 10m", cooldown)
+	}
+	if _, err := s.EffectiveClientConfig("alice"); err == nil || !strings.Contains(err.Error(), "over its device limit") {
+		t.Fatalf("got error %v, want alice left out of the config", err)
+	}
+
+	// Connections from before the removal do not count again during the cooldown
+	if err := s.enforceDeviceLimits(limits, online, remove); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || len(s.GetDeviceLimitViolations()) != 1 {
+		t.Fatalf("got removals %v during the cooldown", removed)
+	}
+
+	// The log action only records
+	if err := s.SetDeviceLimitAction(DeviceLimitActionLog, 5); err != nil {
+		t.Fatal(err)
+	}
+	online["bob"] = append(online["bob"], "203.0.113.5")
+	if err := s.enforceDeviceLimits(limits, online, remove); err != nil {
+		t.Fatal(err)
+	}
+	violations = s.GetDeviceLimitViolations()
+	if len(removed) != 1 || len(violations) != 2 || violations[0].Email != "bob" || violations[0].Until != 0 {
+		t.Fatalf("got removals %v and violations %+v, want bob logged only", removed, violations)
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+const (
+	ConfigChangeAdded   = "added"
+	ConfigChangeRemoved = "removed"
+	ConfigChangeChanged = "changed"
+)
+
+// ConfigChange is one value that differs between the running and the generated config. Path
+// is like inbounds[tag=inbound-443].settings.clients[2].email.
+type ConfigChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// PendingConfigDiff is what a restart would change in the running Xray
+type PendingConfigDiff struct {
+	NeedRestart bool           `json:"needRestart"`
+	Changes     []ConfigChange `json:"changes"`
+}
+
+// GetPendingConfigDiff compares the config Xray runs with against the one the next restart
+// would start it with
+func (s *XrayService) GetPendingConfigDiff() (*PendingConfigDiff, error) {
+	if !s.IsXrayRunning() {
+		return nil, common.NewError("xray is not running")
+	}
+	running := mainXrayProcess().GetConfig()
+	generated, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := diffXrayConfigs(running, generated)
+	if err != nil {
+		return nil, err
+	}
+	return &PendingConfigDiff{
+		NeedRestart: isNeedXrayRestart.Load(),
+		Changes:     changes,
+	}, nil
+}
+
+func diffXrayConfigs(old, new *xray.Config) ([]ConfigChange, error) {
+	oldValue, err := configToValue(old)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := configToValue(new)
+	if err != nil {
+		return nil, err
+	}
+	changes := []ConfigChange{}
+	diffConfigValues("", oldValue, newValue, &changes)
+	return changes, nil
+}
+
+// configToValue decodes the config to plain maps and slices, so both sides compare the same way
+func configToValue(config *xray.Config) (interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+func diffConfigValues(path string, old, new interface{}, changes *[]ConfigChange) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+	if old == nil {
+		*changes = append(*changes, ConfigChange{Path: path, Op: ConfigChangeAdded, New: new})
+		return
+	}
+	if new == nil {
+		*changes = append(*changes, ConfigChange{Path: path, Op: ConfigChangeRemoved, Old: old})
+		return
+	}
+
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		if newValue, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(oldValue)+len(newValue))
+			for key := range oldValue {
+				keys = append(keys, key)
+			}
+			for key := range newValue {
+				if _, ok := oldValue[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				keyPath := key
+				if path != "" {
+					keyPath = path + "." + key
+				}
+				diffConfigValues(keyPath, oldValue[key], newValue[key], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if newValue, ok := new.([]interface{}); ok {
+			diffConfigLists(path, oldValue, newValue, changes)
+			return
+		}
+	}
+	*changes = append(*changes, ConfigChange{Path: path, Op: ConfigChangeChanged, Old: old, New: new})
+}
+
+// diffConfigLists matches the items by tag when all of them have one, like the inbounds and
+// outbounds, so removing one item does not show every later one as changed
+func diffConfigLists(path string, old, new []interface{}, changes *[]ConfigChange) {
+	oldTags, oldTagged := configListTags(old)
+	newTags, newTagged := configListTags(new)
+	if !oldTagged || !newTagged {
+		for i := 0; i < len(old) || i < len(new); i++ {
+			var oldItem, newItem interface{}
+			if i < len(old) {
+				oldItem = old[i]
+			}
+			if i < len(new) {
+				newItem = new[i]
+			}
+			diffConfigValues(fmt.Sprintf("%s[%d]", path, i), oldItem, newItem, changes)
+		}
+		return
+	}
+
+	// The order matters too, the first outbound takes the traffic no rule matched
+	if !reflect.DeepEqual(commonTags(oldTags, newTags), commonTags(newTags, oldTags)) {
+		*changes = append(*changes, ConfigChange{Path: path, Op: ConfigChangeChanged, Old: oldTags, New: newTags})
+	}
+	for i, tag := range oldTags {
+		var newItem interface{}
+		if j, ok := indexOfTag(newTags, tag); ok {
+			newItem = new[j]
+		}
+		diffConfigValues(fmt.Sprintf("%s[tag=%s]", path, tag), old[i], newItem, changes)
+	}
+	for j, tag := range newTags {
+		if _, ok := indexOfTag(oldTags, tag); !ok {
+			diffConfigValues(fmt.Sprintf("%s[tag=%s]", path, tag), nil, new[j], changes)
+		}
+	}
+}
+
+// configListTags returns the tags of the items, false when one has no tag or a tag repeats
+func configListTags(list []interface{}) ([]string, bool) {
+	tags := make([]string, 0, len(list))
+	seen := map[string]bool{}
+	for _, item := range list {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		tag, _ := object["tag"].(string)
+		if tag == "" || seen[tag] {
+			return nil, false
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, true
+}
+
+// commonTags returns the tags also in other, in the order of tags
+func commonTags(tags []string, other []string) []string {
+	shared := []string{}
+	for _, tag := range tags {
+		if _, ok := indexOfTag(other, tag); ok {
+			shared = append(shared, tag)
+		}
+	}
+	return shared
+}
+
+func indexOfTag(tags []string, tag string) (int, bool) {
+	for i, t := range tags {
+		if t == tag {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// OutboundDns resolves the listed domains, or everything when empty, with servers reached through one outbound
+type OutboundDns struct {
+	Servers []string `json:"servers"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// parseDnsServer returns the host and port xray will dial for a dns server address
+func parseDnsServer(address string) (string, string, error) {
+	address = strings.TrimSpace(address)
+	switch address {
+	case "", "localhost", "fakedns":
+		return "", "", common.NewErrorf("dns server %q cannot be bound to an outbound", address)
+	}
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", "", err
+		}
+		port := u.Port()
+		if port == "" {
+			switch u.Scheme {
+			case "https", "https+local", "quic", "quic+local":
+				port = "443"
+			case "tcp", "tcp+local":
+				port = "53"
+			default:
+				return "", "", common.NewErrorf("unsupported dns server scheme %q", u.Scheme)
+			}
+		}
+		return u.Hostname(), port, nil
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return host, port, nil
+	}
+	return strings.Trim(address, "[]"), "53", nil
+}
+
+// GetOutboundDns returns the dns servers bound to outbounds by tag
+func (s *XrayService) GetOutboundDns() (map[string]*OutboundDns, error) {
+	hints := map[string]*OutboundDns{}
+	data, err := s.settingService.GetOutboundDns()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return hints, nil
+	}
+	err = json.Unmarshal([]byte(data), &hints)
+	if err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+func (s *XrayService) saveOutboundDns(hints map[string]*OutboundDns) error {
+	data, err := json.MarshalIndent(hints, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetOutboundDns(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetOutboundDns makes the given dns servers resolve through the outbound
+func (s *XrayService) SetOutboundDns(outboundTag string, hint *OutboundDns) error {
+	if outboundTag == "" || hint == nil || len(hint.Servers) == 0 {
+		return common.NewError("outbound tag and dns servers are required")
+	}
+	for _, server := range hint.Servers {
+		if _, _, err := parseDnsServer(server); err != nil {
+			return err
+		}
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[outboundTag] {
+		return common.NewErrorf("outbound %s does not exist", outboundTag)
+	}
+
+	hints, err := s.GetOutboundDns()
+	if err != nil {
+		return err
+	}
+	hints[outboundTag] = hint
+	return s.saveOutboundDns(hints)
+}
+
+func (s *XrayService) RemoveOutboundDns(outboundTag string) error {
+	hints, err := s.GetOutboundDns()
+	if err != nil {
+		return err
+	}
+	if _, ok := hints[outboundTag]; !ok {
+		return common.NewErrorf("outbound %s has no dns servers", outboundTag)
+	}
+	delete(hints, outboundTag)
+	return s.saveOutboundDns(hints)
+}
+
+// applyOutboundDns adds the servers to the dns block and routes queries to them through their outbound
+func (s *XrayService) applyOutboundDns(xrayConfig *xray.Config) error {
+	hints, err := s.GetOutboundDns()
+	if err != nil {
+		return err
+	}
+	if len(hints) == 0 {
+		return nil
+	}
+	tags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+
+	dns := map[string]interface{}{}
+	if len(xrayConfig.DNSConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.DNSConfig, &dns); err != nil {
+			return err
+		}
+	}
+	servers, _ := dns["servers"].([]interface{})
+
+	outboundTags := make([]string, 0, len(hints))
+	for outboundTag := range hints {
+		outboundTags = append(outboundTags, outboundTag)
+	}
+	sort.Strings(outboundTags)
+
+	var rules []interface{}
+	for _, outboundTag := range outboundTags {
+		if !tags[outboundTag] {
+			logger.Warningf("Skip dns servers of missing outbound %s", outboundTag)
+			continue
+		}
+		hint := hints[outboundTag]
+		for _, server := range hint.Servers {
+			host, port, err := parseDnsServer(server)
+			if err != nil {
+				logger.Warningf("Skip dns server %s of outbound %s: %v", server, outboundTag, err)
+				continue
+			}
+			dnsServer := map[string]interface{}{"address": server}
+			if len(hint.Domains) > 0 {
+				dnsServer["domains"] = hint.Domains
+			}
+			servers = append(servers, dnsServer)
+
+			rule := map[string]interface{}{
+				"type":        "field",
+				"port":        port,
+				"outboundTag": outboundTag,
+			}
+			if net.ParseIP(host) != nil {
+				rule["ip"] = []string{host}
+			} else {
+				rule["domain"] = []string{"full:" + host}
+			}
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	dns["servers"] = servers
+	if xrayConfig.DNSConfig, err = json.MarshalIndent(dns, "", "  "); err != nil {
+		return err
+	}
+	// Ahead of the template rules, a broader rule must not catch the queries
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["rules"].([]interface{})
+	routing["rules"] = append(rules, existing...)
+	return setRouting(xrayConfig, routing)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDnsServer(t *testing.T) {
+	tests := []struct {
+		address  string
+		wantHost string
+		wantPort string
+		wantErr  bool
+	}{
+		{address: "1.1.1.1", wantHost: "1.1.1.1", wantPort: "53"},
+		{address: "8.8.8.8:5353", wantHost: "8.8.8.8", wantPort: "5353"},
+		{address: "[2606:4700:4700::1111]", wantHost: "2606:4700:4700::1111", wantPort: "53"},
+		{address: "https://dns.google/dns-query", wantHost: "dns.google", wantPort: "443"},
+		{address: "https://dns.example.com:8443/dns-query", wantHost: "dns.example.com", wantPort: "8443"},
+		{address: "quic+local://dns.adguard.com", wantHost: "dns.adguard.com", wantPort: "443"},
+		{address: "tcp://1.1.1.1", wantHost: "1.1.1.1", wantPort: "53"},
+		{address: "localhost", wantErr: true},
+		{address: "fakedns", wantErr: true},
+		{address: "", wantErr: true},
+		{address: "udp://1.1.1.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			host, port, err := parseDnsServer(tt.address)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s:%s, want an error", host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Fatalf("got %s and %s, want %s and %s", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestSetOutboundDns(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name    string
+		tag     string
+		hint    *OutboundDns
+		wantErr string
+	}{
+		{name: "existing outbound", tag: "warp", hint: &OutboundDns{Servers: []string{"1.1.1.1"}}},
+		{name: "unknown outbound", tag: "missing", hint: &OutboundDns{Servers: []string{"1.1.1.1"}}, wantErr: "outbound missing does not exist"},
+		{name: "no servers", tag: "warp", hint: &OutboundDns{}, wantErr: "dns servers are required"},
+		{name: "unbindable server", tag: "warp", hint: &OutboundDns{Servers: []string{"localhost"}}, wantErr: "cannot be bound to an outbound"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetOutboundDns(tt.tag, tt.hint)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			hints, err := s.GetOutboundDns()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hints[tt.tag], tt.hint) {
+				t.Fatalf("stored %+v, want %+v", hints[tt.tag], tt.hint)
+			}
+		})
+	}
+}
+
+func TestOutboundDnsInGeneratedConfig(t *testing.T) {
+	setChainTestTemplate(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	// Off until a hint is set
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xrayConfig.DNSConfig) != 0 {
+		t.Fatalf("got dns config %s without hints", xrayConfig.DNSConfig)
+	}
+
+	if err := s.SetOutboundDns("warp", &OutboundDns{Servers: []string{"1.1.1.1", "https://dns.google/dns-query"}, Domains: []string{"geosite:google"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOutboundDns("direct", &OutboundDns{Servers: []string{"8.8.8.8:5353"}}); err != nil {
+		t.Fatal(err)
+	}
+	xrayConfig, err = s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dns struct {
+		Servers []struct {
+			Address string   `json:"address"`
+			Domains []string `json:"domains"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(xrayConfig.DNSConfig, &dns); err != nil {
+		t.Fatal(err)
+	}
+	wantServers := []string{"8.8.8.8:5353", "1.1.1.1", "https://dns.google/dns-query"}
+	if len(dns.Servers) != len(wantServers) {
+		t.Fatalf("got dns servers %+v, want %v", dns.Servers, wantServers)
+	}
+	for i, server := range dns.Servers {
+		if server.Address != wantServers[i] {
+			t.Fatalf("got dns servers %+v, want %v", dns.Servers, wantServers)
+		}
+	}
+	if len(dns.Servers[0].Domains) != 0 || strings.Join(dns.Servers[1].Domains, ",") != "geosite:google" {
+		t.Fatalf("got dns server domains %+v", dns.Servers)
+	}
+
+	wantRules := []map[string]interface{}{
+		{"type": "field", "port": "5353", "outboundTag": "direct", "ip": []interface{}{"8.8.8.8"}},
+		{"type": "field", "port": "53", "outboundTag": "warp", "ip": []interface{}{"1.1.1.1"}},
+		{"type": "field", "port": "443", "outboundTag": "warp", "domain": []interface{}{"full:dns.google"}},
+	}
+	rules := configRules(t, xrayConfig)
+	if len(rules) < len(wantRules) {
+		t.Fatalf("got rules %v, want %v first", rules, wantRules)
+	}
+	for i, want := range wantRules {
+		if !reflect.DeepEqual(rules[i], want) {
+			t.Fatalf("rule %d is %v, want %v", i, rules[i], want)
+		}
+	}
+}
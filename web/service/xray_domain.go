@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// DomainOutbound routes sniffed domains matching Domain to an outbound,
+// only for traffic of InboundTags when set
+type DomainOutbound struct {
+	Domain      string   `json:"domain"`
+	OutboundTag string   `json:"outboundTag"`
+	InboundTags []string `json:"inboundTags,omitempty"`
+}
+
+func (s *XrayService) GetDomainOutbounds() ([]DomainOutbound, error) {
+	routes := []DomainOutbound{}
+	data, err := s.settingService.GetDomainOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return routes, nil
+	}
+	err = json.Unmarshal([]byte(data), &routes)
+	if err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+func (s *XrayService) saveDomainOutbounds(routes []DomainOutbound) error {
+	data, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetDomainOutbounds(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetDomainOutbound adds or replaces the route of a domain pattern
+func (s *XrayService) SetDomainOutbound(route DomainOutbound) error {
+	if err := checkDomainMatcher(route.Domain); err != nil {
+		return err
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[route.OutboundTag] {
+		return common.NewErrorf("outbound %s does not exist", route.OutboundTag)
+	}
+	for _, inboundTag := range route.InboundTags {
+		if inboundTag == "" {
+			return common.NewError("inbound tag is empty")
+		}
+	}
+
+	routes, err := s.GetDomainOutbounds()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range routes {
+		if routes[i].Domain == route.Domain {
+			routes[i] = route
+			replaced = true
+		}
+	}
+	if !replaced {
+		routes = append(routes, route)
+	}
+	return s.saveDomainOutbounds(routes)
+}
+
+func (s *XrayService) RemoveDomainOutbound(domain string) error {
+	routes, err := s.GetDomainOutbounds()
+	if err != nil {
+		return err
+	}
+	for i := range routes {
+		if routes[i].Domain == domain {
+			return s.saveDomainOutbounds(append(routes[:i], routes[i+1:]...))
+		}
+	}
+	return common.NewErrorf("domain %s has no outbound route", domain)
+}
+
+// applyDomainOutbounds adds one rule per outbound and inbound set, after the template rules
+func (s *XrayService) applyDomainOutbounds(xrayConfig *xray.Config) error {
+	routes, err := s.GetDomainOutbounds()
+	if err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+	tags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+
+	type ruleKey struct {
+		outboundTag string
+		inboundTags string
+	}
+	var keys []ruleKey
+	domains := map[ruleKey][]string{}
+	for _, route := range routes {
+		if !tags[route.OutboundTag] {
+			logger.Warningf("Skip routing %s to missing outbound %s", route.Domain, route.OutboundTag)
+			continue
+		}
+		inboundTags := append([]string(nil), route.InboundTags...)
+		sort.Strings(inboundTags)
+		key := ruleKey{route.OutboundTag, strings.Join(inboundTags, ",")}
+		if _, ok := domains[key]; !ok {
+			keys = append(keys, key)
+		}
+		domains[key] = append(domains[key], route.Domain)
+	}
+
+	rules := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		rule := map[string]interface{}{
+			"type":        "field",
+			"domain":      domains[key],
+			"outboundTag": key.outboundTag,
+		}
+		if key.inboundTags != "" {
+			rule["inboundTag"] = strings.Split(key.inboundTags, ",")
+		}
+		rules = append(rules, rule)
+	}
+	return appendRoutingRules(xrayConfig, rules)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const domainTestTemplate = `{
+  "outbounds": [
+    {"tag": "direct", "protocol": "freedom"},
+    {"tag": "warp", "protocol": "wireguard"},
+    {"tag": "blocked", "protocol": "blackhole"}
+  ],
+  "routing": {"rules": [{"type": "field", "ip": ["geoip:private"], "outboundTag": "blocked"}]}
+}`
+
+func TestCheckDomainMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "example.com"},
+		{pattern: "domain:example.com"},
+		{pattern: "full:www.example.com"},
+		{pattern: "keyword:google"},
+		{pattern: "geosite:netflix"},
+		{pattern: `regexp:\.example\.com$`},
+		{pattern: "ext:custom.dat:streaming"},
+		{pattern: "", wantErr: true},
+		{pattern: "domain:", wantErr: true},
+		{pattern: "exa mple.com", wantErr: true},
+		{pattern: "regexp:(", wantErr: true},
+		{pattern: "ext:custom.dat", wantErr: true},
+		{pattern: "geoip:us", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := checkDomainMatcher(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetDomainOutbound(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	if err := s.settingService.saveSetting("xrayTemplateConfig", domainTestTemplate); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		route   DomainOutbound
+		wantErr string
+		want    []DomainOutbound
+	}{
+		{
+			name:  "new route",
+			route: DomainOutbound{Domain: "geosite:netflix", OutboundTag: "warp"},
+			want:  []DomainOutbound{{Domain: "geosite:netflix", OutboundTag: "warp"}},
+		},
+		{
+			name:  "same domain replaces the route",
+			route: DomainOutbound{Domain: "geosite:netflix", OutboundTag: "direct", InboundTags: []string{"inbound-20001"}},
+			want:  []DomainOutbound{{Domain: "geosite:netflix", OutboundTag: "direct", InboundTags: []string{"inbound-20001"}}},
+		},
+		{
+			name:    "unknown outbound",
+			route:   DomainOutbound{Domain: "example.com", OutboundTag: "missing"},
+			wantErr: "outbound missing does not exist",
+		},
+		{
+			name:    "invalid domain",
+			route:   DomainOutbound{Domain: "regexp:(", OutboundTag: "warp"},
+			wantErr: "invalid domain regexp",
+		},
+		{
+			name:    "empty inbound tag",
+			route:   DomainOutbound{Domain: "example.com", OutboundTag: "warp", InboundTags: []string{""}},
+			wantErr: "inbound tag is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetDomainOutbound(tt.route)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes, err := s.GetDomainOutbounds()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(routes, tt.want) {
+				t.Fatalf("got routes %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+
+	if err := s.RemoveDomainOutbound("geosite:netflix"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveDomainOutbound("geosite:netflix"); err == nil {
+		t.Fatal("removed a route that does not exist")
+	}
+}
+
+func TestDomainOutboundsInGeneratedConfig(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	if err := s.settingService.saveSetting("xrayTemplateConfig", domainTestTemplate); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []DomainOutbound{
+		{Domain: "geosite:netflix", OutboundTag: "warp"},
+		{Domain: "domain:example.com", OutboundTag: "direct", InboundTags: []string{"inbound-20001"}},
+		{Domain: "keyword:openai", OutboundTag: "warp"},
+	} {
+		if err := s.SetDomainOutbound(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := configRules(t, xrayConfig)
+	want := []map[string]interface{}{
+		{"type": "field", "ip": []interface{}{"geoip:private"}, "outboundTag": "blocked"},
+		{"type": "field", "domain": []interface{}{"geosite:netflix", "keyword:openai"}, "outboundTag": "warp"},
+		{"type": "field", "domain": []interface{}{"domain:example.com"}, "outboundTag": "direct", "inboundTag": []interface{}{"inbound-20001"}},
+	}
+	// Leaving out the api rule, the template rule stays ahead of the domain rules
+	var got []map[string]interface{}
+	for _, rule := range rules {
+		if rule["outboundTag"] != "api" {
+			got = append(got, rule)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got rules %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"encoding/json"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/json_util"
+	"x-ui/xray"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	// EmptyInboundsNoop keeps Xray running with only the api inbound, so the stats still work
+	EmptyInboundsNoop = "noop"
+	// EmptyInboundsRefuse stops Xray and fails the restart
+	EmptyInboundsRefuse = "refuse"
+)
+
+// ErrNoInbounds is returned by RestartXray when no inbound is enabled and the setting refuses to start
+var ErrNoInbounds = common.NewError("no inbound is enabled, xray is not started")
+
+// Whether the last restart found no inbound to serve, Status reports it so the UI can warn
+var noInbounds atomic.Bool
+
+// hasServingInbounds reports whether the config has an inbound besides the stats api
+func hasServingInbounds(xrayConfig *xray.Config) bool {
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag != statsAPITag {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEmptyInbounds gives a config without any inbound the loopback api inbound in noop mode,
+// Xray has nothing to listen on otherwise
+func (s *XrayService) applyEmptyInbounds(xrayConfig *xray.Config) error {
+	if len(xrayConfig.InboundConfigs) > 0 {
+		return nil
+	}
+	mode, err := s.settingService.GetEmptyInbounds()
+	if err != nil || mode != EmptyInboundsNoop {
+		return err
+	}
+	xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, xray.InboundConfig{
+		Listen:   json_util.RawMessage(`"127.0.0.1"`),
+		Port:     statsAPIPort,
+		Protocol: "dokodemo-door",
+		Settings: json_util.RawMessage(`{"address": "127.0.0.1"}`),
+		Tag:      statsAPITag,
+	})
+
+	apiTag := statsAPITag
+	api := map[string]interface{}{}
+	if len(xrayConfig.API) > 0 {
+		if err := json.Unmarshal(xrayConfig.API, &api); err != nil {
+			return err
+		}
+	}
+	if tag, _ := api["tag"].(string); tag != "" {
+		apiTag = tag
+	}
+
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	rules, _ := routing["rules"].([]interface{})
+	for _, rule := range rules {
+		r, _ := rule.(map[string]interface{})
+		inboundTags, _ := r["inboundTag"].([]interface{})
+		if containsValue(inboundTags, statsAPITag) {
+			return nil
+		}
+	}
+	routing["rules"] = append([]interface{}{map[string]interface{}{
+		"type":        "field",
+		"inboundTag":  []interface{}{statsAPITag},
+		"outboundTag": apiTag,
+	}}, rules...)
+	return setRouting(xrayConfig, routing)
+}
+
+// checkEmptyInbounds records whether the config serves any inbound. In refuse mode a config
+// without one stops the running process, its inbounds were disabled, and fails with ErrNoInbounds.
+func (s *XrayService) checkEmptyInbounds(xrayConfig *xray.Config) error {
+	if hasServingInbounds(xrayConfig) {
+		noInbounds.Store(false)
+		return nil
+	}
+	noInbounds.Store(true)
+	mode, err := s.settingService.GetEmptyInbounds()
+	if err != nil {
+		return err
+	}
+	if mode != EmptyInboundsRefuse {
+		logger.Warning("No inbound is enabled, xray runs with the api inbound only")
+		return nil
+	}
+	if s.IsXrayRunning() {
+		if err := mainXrayProcess().Stop(); err != nil {
+			logger.Errorf("Error stopping Xray: %v", err)
+		}
+	}
+	result = ErrNoInbounds.Error()
+	return ErrNoInbounds
+}
+
+// SetEmptyInbounds chooses what a restart does when no inbound is enabled
+func (s *XrayService) SetEmptyInbounds(mode string) error {
+	if mode != EmptyInboundsNoop && mode != EmptyInboundsRefuse {
+		return common.NewErrorf("empty inbounds must be %s or %s", EmptyInboundsNoop, EmptyInboundsRefuse)
+	}
+	err := s.settingService.SetEmptyInbounds(mode)
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestApplyEmptyInbounds(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name      string
+		mode      string
+		inbounds  []string
+		api       string
+		rules     string
+		wantTags  []string
+		wantRules []string
+	}{
+		{name: "has inbounds", mode: EmptyInboundsNoop, inbounds: []string{"inbound-20001"}, rules: `[]`, wantTags: []string{"inbound-20001"}, wantRules: []string{}},
+		{name: "refuse leaves it empty", mode: EmptyInboundsRefuse, rules: `[]`, wantRules: []string{}},
+		{name: "noop adds the api inbound", mode: EmptyInboundsNoop, rules: `[{"ip": ["geoip:private"], "outboundTag": "blocked"}]`, wantTags: []string{"api"}, wantRules: []string{"api", "blocked"}},
+		{name: "custom api tag", mode: EmptyInboundsNoop, api: `{"tag": "stats"}`, rules: `[]`, wantTags: []string{"api"}, wantRules: []string{"stats"}},
+		{name: "api rule kept", mode: EmptyInboundsNoop, rules: `[{"inboundTag": ["api"], "outboundTag": "api"}]`, wantTags: []string{"api"}, wantRules: []string{"api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetEmptyInbounds(tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			xrayConfig := &xray.Config{RouterConfig: []byte(`{"rules": ` + tt.rules + `}`)}
+			if tt.api != "" {
+				xrayConfig.API = []byte(tt.api)
+			}
+			for _, tag := range tt.inbounds {
+				xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, xray.InboundConfig{Tag: tag})
+			}
+			if err := s.applyEmptyInbounds(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for _, inbound := range xrayConfig.InboundConfigs {
+				tags = append(tags, inbound.Tag)
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Fatalf("got inbounds %v, want %v", tags, tt.wantTags)
+			}
+			outboundTags := []string{}
+			for _, rule := range configRules(t, xrayConfig) {
+				outboundTags = append(outboundTags, rule["outboundTag"].(string))
+			}
+			if !reflect.DeepEqual(outboundTags, tt.wantRules) {
+				t.Fatalf("got rules to %v, want %v", outboundTags, tt.wantRules)
+			}
+		})
+	}
+	if err := s.SetEmptyInbounds("start"); err == nil {
+		t.Fatal("got no error for an unknown mode")
+	}
+}
+
+func TestRestartXrayEmptyInbounds(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", false)
+	s := &XrayService{}
+	if err := s.SetStandby(true); err != nil {
+		t.Fatal(err)
+	}
+	setMainXrayProcess(t, nil)
+	oldResult := result
+	t.Cleanup(func() {
+		result = oldResult
+		noInbounds.Store(false)
+		standbyConfigLock.Lock()
+		standbyConfig = nil
+		standbyConfigLock.Unlock()
+		stagedXrayConfig.Lock()
+		stagedXrayConfig.config = nil
+		stagedXrayConfig.Unlock()
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	// The default runs with the api inbound only
+	if err := s.RestartXray(false); err != nil {
+		t.Fatal(err)
+	}
+	if !noInbounds.Load() || s.GetStandbyConfig() == nil {
+		t.Fatal("a restart without inbounds was not run and flagged")
+	}
+
+	if err := s.SetEmptyInbounds(EmptyInboundsRefuse); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestartXray(false); !errors.Is(err, ErrNoInbounds) {
+		t.Fatalf("got error %v, want ErrNoInbounds", err)
+	}
+	if result != ErrNoInbounds.Error() {
+		t.Fatalf("got result %q", result)
+	}
+
+	err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", "inbound-20001").Update("enable", true).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestartXray(false); err != nil {
+		t.Fatal(err)
+	}
+	if noInbounds.Load() {
+		t.Fatal("still flagged without inbounds after enabling one")
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// clientFilter decides which clients of an inbound go into the generated config
+type clientFilter struct {
+	windows map[string][]ClientTimeWindow
+	// disabledGroups maps the members of disabled groups to the group
+	disabledGroups map[string]string
+	// overDeviceLimit are the clients removed for too many devices, until their cooldown ends
+	overDeviceLimit map[string]bool
+	// overBandwidth are the clients paused until their bandwidth bucket refills
+	overBandwidth map[string]bool
+	// now in the panel time zone
+	now time.Time
+	// maxClients caps the active clients of an inbound, 0 is no cap
+	maxClients int
+	// capped lists the clients left out by the cap per inbound tag
+	capped map[string][]string
+}
+
+var (
+	cappedClientsMu sync.Mutex
+	cappedClients   = map[string][]string{}
+)
+
+func (s *XrayService) newClientFilter() (*clientFilter, error) {
+	windows, err := s.GetClientTimeWindows()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	maxClients, err := s.settingService.GetMaxClientsPerInbound()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return nil, err
+	}
+	disabledGroups, _ := resolveClientGroups(groups)
+	return &clientFilter{
+		windows:         windows,
+		disabledGroups:  disabledGroups,
+		overDeviceLimit: deviceLimitCooldowns(),
+		overBandwidth:   bandwidthPaused(),
+		now:             time.Now().In(loc),
+		maxClients:      maxClients,
+		capped:          map[string][]string{},
+	}, nil
+}
+
+// filteredReason tells why a client is left out of the config, empty when it is active
+func (f *clientFilter) filteredReason(c map[string]interface{}, depleted map[string]bool) string {
+	email, _ := c["email"].(string)
+	if depleted[email] {
+		return "expired or reached its traffic limit"
+	}
+	if enable, ok := c["enable"].(bool); ok && !enable {
+		return "disabled"
+	}
+	if group, ok := f.disabledGroups[email]; ok {
+		return fmt.Sprintf("disabled with group %s", group)
+	}
+	if f.overDeviceLimit[email] {
+		return "over its device limit"
+	}
+	if f.overBandwidth[email] {
+		return "over its bandwidth limit"
+	}
+	if windows, ok := f.windows[email]; ok && !inTimeWindows(windows, f.now) {
+		return "outside its allowed time window"
+	}
+	return ""
+}
+
+// filterActiveClients drops the clients that should not connect and strips the panel only keys
+// from the rest. filtered maps the email of every dropped client to the reason.
+func (f *clientFilter) filterActiveClients(inbound *model.Inbound, clients []interface{}) ([]interface{}, map[string]string) {
+	depleted := make(map[string]bool, len(inbound.ClientStats))
+	for _, clientTraffic := range inbound.ClientStats {
+		if !clientTraffic.Enable {
+			depleted[clientTraffic.Email] = true
+		}
+	}
+
+	var active []interface{}
+	filtered := map[string]string{}
+	for _, client := range clients {
+		c, ok := client.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if reason := f.filteredReason(c, depleted); reason != "" {
+			email, _ := c["email"].(string)
+			filtered[email] = reason
+			if depleted[email] {
+				logger.Infof("Remove Inbound User %s due to expiration or traffic limit", email)
+			}
+			continue
+		}
+		// Retain necessary keys and remove others
+		for key := range c {
+			if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
+				delete(c, key)
+			}
+		}
+		if c["flow"] == "xtls-rprx-vision-udp443" {
+			c["flow"] = "xtls-rprx-vision"
+		}
+		active = append(active, c)
+	}
+
+	// Clients are appended on creation, so the newest ones are cut
+	if f.maxClients > 0 && len(active) > f.maxClients {
+		var emails []string
+		for _, client := range active[f.maxClients:] {
+			email, _ := client.(map[string]interface{})["email"].(string)
+			emails = append(emails, email)
+			filtered[email] = fmt.Sprintf("over the limit of %d clients per inbound", f.maxClients)
+		}
+		logger.Warningf("Inbound %s has %d active clients, over the limit of %d, excluded: %s",
+			inbound.Tag, len(active), f.maxClients, strings.Join(emails, ", "))
+		f.capped[inbound.Tag] = emails
+		active = active[:f.maxClients]
+	}
+	return active, filtered
+}
+
+// SetMaxClientsPerInbound caps the active clients each inbound gets in the config, 0 removes the cap
+func (s *XrayService) SetMaxClientsPerInbound(n int) error {
+	if n < 0 {
+		return common.NewErrorf("invalid client limit %d", n)
+	}
+	err := s.settingService.SetMaxClientsPerInbound(n)
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// GetCappedClients returns, per inbound tag, the clients the last generated main config left out for the cap
+func (s *XrayService) GetCappedClients() map[string][]string {
+	cappedClientsMu.Lock()
+	defer cappedClientsMu.Unlock()
+	capped := make(map[string][]string, len(cappedClients))
+	for tag, emails := range cappedClients {
+		capped[tag] = emails
+	}
+	return capped
+}
+
+func setCappedClients(capped map[string][]string) {
+	cappedClientsMu.Lock()
+	defer cappedClientsMu.Unlock()
+	cappedClients = capped
+}
+
+// EffectiveClientConfig returns the client as it goes into the generated config, with the inbound
+// stream settings it connects with. A client left out of the config is reported with the reason.
+func (s *XrayService) EffectiveClientConfig(email string) (map[string]interface{}, error) {
+	_, inbound, err := s.inboundService.GetClientInboundByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if inbound == nil {
+		return nil, common.NewErrorf("client %s not found", email)
+	}
+	if !inbound.Enable {
+		return nil, common.NewErrorf("client %s not found in config: inbound %s is disabled", email, inbound.Tag)
+	}
+
+	settings := map[string]interface{}{}
+	err = json.Unmarshal([]byte(inbound.Settings), &settings)
+	if err != nil {
+		return nil, err
+	}
+	clients, _ := settings["clients"].([]interface{})
+	filter, err := s.newClientFilter()
+	if err != nil {
+		return nil, err
+	}
+	// Filtered on a copy that holds the stats of every client of the inbound, the traffic limits
+	// and the client cap depend on them
+	filterInbound := *inbound
+	err = database.GetDB().Model(xray.ClientTraffic{}).Where("inbound_id = ?", inbound.Id).Find(&filterInbound.ClientStats).Error
+	if err != nil {
+		return nil, err
+	}
+	active, filtered := filter.filterActiveClients(&filterInbound, clients)
+	if reason, ok := filtered[email]; ok {
+		return nil, common.NewErrorf("client %s not found in config: %s", email, reason)
+	}
+	var client map[string]interface{}
+	for _, c := range active {
+		if c.(map[string]interface{})["email"] == email {
+			client = c.(map[string]interface{})
+			break
+		}
+	}
+	if client == nil {
+		return nil, common.NewErrorf("client %s not found in inbound %s", email, inbound.Tag)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, inboundConfig := range xrayConfig.InboundConfigs {
+		if inboundConfig.Tag != inbound.Tag {
+			continue
+		}
+		var stream map[string]interface{}
+		if len(inboundConfig.StreamSettings) > 0 {
+			err = json.Unmarshal(inboundConfig.StreamSettings, &stream)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{
+			"inbound":        inbound.Tag,
+			"protocol":       inboundConfig.Protocol,
+			"port":           inboundConfig.Port,
+			"client":         client,
+			"streamSettings": stream,
+		}, nil
+	}
+	return nil, common.NewErrorf("client %s not found in config: inbound %s was skipped", email, inbound.Tag)
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+// addFilterTestInbound stores a VLESS inbound with the given clients and a traffic row per email
+func addFilterTestInbound(t *testing.T, port int, tag string, enable bool, clients string, emails ...string) *model.Inbound {
+	t.Helper()
+	inbound := &model.Inbound{
+		Port:           port,
+		Tag:            tag,
+		Enable:         enable,
+		Protocol:       model.VLESS,
+		Settings:       `{"clients": ` + clients + `, "decryption": "none"}`,
+		StreamSettings: `{"network": "tcp", "security": "none", "tcpSettings": {"header": {"type": "none"}}}`,
+		Sniffing:       `{"enabled": false}`,
+	}
+	if err := database.GetDB().Create(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range emails {
+		addTestClient(t, inbound.Id, email)
+	}
+	return inbound
+}
+
+func TestEffectiveClientConfig(t *testing.T) {
+	setupTestDB(t)
+	inbound := addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "flow": "xtls-rprx-vision-udp443", "enable": true, "limitIp": 2, "subId": "abc"},
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "bob", "enable": false},
+		{"id": "c5a9a7b1-3e43-4bd4-9d39-2d7e5b0b2e57", "email": "carol", "enable": true}
+	]`, "alice", "bob", "carol")
+	addFilterTestInbound(t, 20002, "inbound-20002", false, `[{"id": "d1e0b8a5-64c4-4d8e-a0a3-4d3f0a1c9b2e", "email": "dave", "enable": true}]`, "dave")
+	// carol used up her traffic
+	if err := database.GetDB().Model(xray.ClientTraffic{}).Where("email = ?", "carol").Update("enable", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s := &XrayService{}
+	tests := []struct {
+		name    string
+		email   string
+		wantErr string
+	}{
+		{name: "active client", email: "alice"},
+		{name: "disabled client", email: "bob", wantErr: "client bob not found in config: disabled"},
+		{name: "depleted client", email: "carol", wantErr: "client carol not found in config: expired or reached its traffic limit"},
+		{name: "disabled inbound", email: "dave", wantErr: "inbound inbound-20002 is disabled"},
+		{name: "unknown client", email: "nobody", wantErr: "client nobody not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.EffectiveClientConfig(tt.email)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["inbound"] != inbound.Tag || got["protocol"] != "vless" || got["port"] != 20001 {
+				t.Fatalf("got inbound %v, protocol %v and port %v", got["inbound"], got["protocol"], got["port"])
+			}
+			// Only the keys xray gets, with the flow the optimizer rewrote
+			wantClient := map[string]interface{}{
+				"id":    "27848739-7e62-4138-9fd3-098a63964b6b",
+				"email": "alice",
+				"flow":  "xtls-rprx-vision",
+			}
+			if !reflect.DeepEqual(got["client"], wantClient) {
+				t.Fatalf("got client %v, want %v", got["client"], wantClient)
+			}
+			stream, _ := got["streamSettings"].(map[string]interface{})
+			if stream["network"] != "tcp" {
+				t.Fatalf("got stream settings %v", got["streamSettings"])
+			}
+		})
+	}
+}
+
+func TestFilterActiveClientsCap(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxClients int
+		disabled   []string
+		wantActive []string
+		wantCapped []string
+	}{
+		{name: "no cap", maxClients: 0, wantActive: []string{"a", "b", "c"}},
+		{name: "under the cap", maxClients: 5, wantActive: []string{"a", "b", "c"}},
+		{name: "at the cap", maxClients: 3, wantActive: []string{"a", "b", "c"}},
+		{name: "over the cap cuts the newest", maxClients: 2, wantActive: []string{"a", "b"}, wantCapped: []string{"c"}},
+		{name: "inactive clients do not count", maxClients: 2, disabled: []string{"a"}, wantActive: []string{"b", "c"}},
+		{name: "cap of one", maxClients: 1, wantActive: []string{"a"}, wantCapped: []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := &model.Inbound{Tag: "inbound-20001"}
+			var clients []interface{}
+			for _, email := range []string{"a", "b", "c"} {
+				clients = append(clients, map[string]interface{}{"id": email, "email": email, "enable": true})
+			}
+			for _, email := range tt.disabled {
+				inbound.ClientStats = append(inbound.ClientStats, xray.ClientTraffic{Email: email})
+			}
+			f := &clientFilter{maxClients: tt.maxClients, capped: map[string][]string{}}
+			active, filtered := f.filterActiveClients(inbound, clients)
+
+			var gotActive []string
+			for _, client := range active {
+				gotActive = append(gotActive, client.(map[string]interface{})["email"].(string))
+			}
+			if !reflect.DeepEqual(gotActive, tt.wantActive) {
+				t.Fatalf("got active %v, want %v", gotActive, tt.wantActive)
+			}
+			if !reflect.DeepEqual(f.capped[inbound.Tag], tt.wantCapped) {
+				t.Fatalf("got capped %v, want %v", f.capped[inbound.Tag], tt.wantCapped)
+			}
+			for _, email := range tt.wantCapped {
+				if !strings.Contains(filtered[email], "over the limit of") {
+					t.Fatalf("got reason %q for %s", filtered[email], email)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxClientsPerInbound(t *testing.T) {
+	setupTestDB(t)
+	addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "enable": true},
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "bob", "enable": true},
+		{"id": "c5a9a7b1-3e43-4bd4-9d39-2d7e5b0b2e57", "email": "carol", "enable": true}
+	]`, "alice", "bob", "carol")
+	addFilterTestInbound(t, 20002, "inbound-20002", true, `[{"id": "d1e0b8a5-64c4-4d8e-a0a3-4d3f0a1c9b2e", "email": "dave", "enable": true}]`, "dave")
+	s := &XrayService{}
+	t.Cleanup(func() {
+		setCappedClients(map[string][]string{})
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	if err := s.SetMaxClientsPerInbound(-1); err == nil || !strings.Contains(err.Error(), "invalid client limit -1") {
+		t.Fatalf("got error %v for a negative limit", err)
+	}
+	if err := s.SetMaxClientsPerInbound(2); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("setting the limit did not ask for a restart")
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, inbound := range xrayConfig.InboundConfigs {
+		var settings struct {
+			Clients []interface{} `json:"clients"`
+		}
+		if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+			t.Fatal(err)
+		}
+		counts[inbound.Tag] = len(settings.Clients)
+	}
+	if counts["inbound-20001"] != 2 || counts["inbound-20002"] != 1 {
+		t.Fatalf("got client counts %v", counts)
+	}
+	if capped := s.GetCappedClients(); !reflect.DeepEqual(capped, map[string][]string{"inbound-20001": {"carol"}}) {
+		t.Fatalf("got capped clients %v", capped)
+	}
+
+	// The effective config counts every client of the inbound against the cap
+	if _, err := s.EffectiveClientConfig("bob"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.EffectiveClientConfig("carol")
+	if err == nil || !strings.Contains(err.Error(), "over the limit of 2 clients per inbound") {
+		t.Fatalf("got error %v for a capped client", err)
+	}
+
+	// Removing the cap brings every client back
+	if err := s.SetMaxClientsPerInbound(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetXrayConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if capped := s.GetCappedClients(); len(capped) != 0 {
+		t.Fatalf("got capped clients %v without a cap", capped)
+	}
+	if _, err := s.EffectiveClientConfig("carol"); err != nil {
+		t.Fatal(err)
+	}
+}
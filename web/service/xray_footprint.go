@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// InboundFootprint compares the stored settings of an inbound with what the config gets
+type InboundFootprint struct {
+	Tag             string `json:"tag"`
+	StoredBytes     int    `json:"storedBytes"`
+	GeneratedBytes  int    `json:"generatedBytes"`
+	StoredFields    int    `json:"storedFields"`
+	GeneratedFields int    `json:"generatedFields"`
+}
+
+// OptimizationFootprint is how much the generation passes (client filtering and key stripping,
+// stream cleanup) shrink the inbounds. Sizes are of compact JSON, fields are leaf values.
+type OptimizationFootprint struct {
+	Inbounds        []InboundFootprint `json:"inbounds"`
+	StoredBytes     int                `json:"storedBytes"`
+	GeneratedBytes  int                `json:"generatedBytes"`
+	SavedBytes      int                `json:"savedBytes"`
+	StoredFields    int                `json:"storedFields"`
+	GeneratedFields int                `json:"generatedFields"`
+	SavedFields     int                `json:"savedFields"`
+}
+
+// jsonFootprint returns the compact size and the leaf value count of a JSON document
+func jsonFootprint(data []byte) (int, int, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return 0, 0, nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return 0, 0, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return 0, 0, err
+	}
+	return compact.Len(), countJsonFields(value), nil
+}
+
+func countJsonFields(value interface{}) int {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		count := 0
+		for _, item := range v {
+			count += countJsonFields(item)
+		}
+		return count
+	case []interface{}:
+		count := 0
+		for _, item := range v {
+			count += countJsonFields(item)
+		}
+		return count
+	default:
+		return 1
+	}
+}
+
+// OptimizationFootprint measures the settings and stream settings of the enabled inbounds before and
+// after generation
+func (s *XrayService) OptimizationFootprint() (*OptimizationFootprint, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	generated := make(map[string]int, len(xrayConfig.InboundConfigs))
+	for i, inboundConfig := range xrayConfig.InboundConfigs {
+		generated[inboundConfig.Tag] = i
+	}
+
+	footprint := &OptimizationFootprint{Inbounds: []InboundFootprint{}}
+	for _, inbound := range inbounds {
+		i, ok := generated[inbound.Tag]
+		if !inbound.Enable || !ok {
+			continue
+		}
+		inboundConfig := xrayConfig.InboundConfigs[i]
+		item := InboundFootprint{Tag: inbound.Tag}
+		for _, pair := range [][2][]byte{
+			{[]byte(inbound.Settings), inboundConfig.Settings},
+			{[]byte(inbound.StreamSettings), inboundConfig.StreamSettings},
+		} {
+			storedBytes, storedFields, err := jsonFootprint(pair[0])
+			if err != nil {
+				return nil, err
+			}
+			generatedBytes, generatedFields, err := jsonFootprint(pair[1])
+			if err != nil {
+				return nil, err
+			}
+			item.StoredBytes += storedBytes
+			item.StoredFields += storedFields
+			item.GeneratedBytes += generatedBytes
+			item.GeneratedFields += generatedFields
+		}
+		footprint.Inbounds = append(footprint.Inbounds, item)
+		footprint.StoredBytes += item.StoredBytes
+		footprint.StoredFields += item.StoredFields
+		footprint.GeneratedBytes += item.GeneratedBytes
+		footprint.GeneratedFields += item.GeneratedFields
+	}
+	footprint.SavedBytes = footprint.StoredBytes - footprint.GeneratedBytes
+	footprint.SavedFields = footprint.StoredFields - footprint.GeneratedFields
+	return footprint, nil
+}
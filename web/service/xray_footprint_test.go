@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestJsonFootprint(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantBytes  int
+		wantFields int
+		wantErr    bool
+	}{
+		{name: "empty", data: "", wantBytes: 0, wantFields: 0},
+		{name: "blank", data: "  \n", wantBytes: 0, wantFields: 0},
+		{name: "flat object", data: `{"a": 1, "b": "x"}`, wantBytes: 15, wantFields: 2},
+		{name: "nested", data: `{"a": {"b": [1, 2, {"c": null}]}}`, wantBytes: 28, wantFields: 3},
+		{name: "empty object", data: `{}`, wantBytes: 2, wantFields: 0},
+		{name: "invalid", data: `{"a": }`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, fields, err := jsonFootprint([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error for invalid JSON")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != tt.wantBytes || fields != tt.wantFields {
+				t.Fatalf("got %d bytes and %d fields, want %d and %d", size, fields, tt.wantBytes, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestOptimizationFootprint(t *testing.T) {
+	setupTestDB(t)
+	addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "enable": true, "limitIp": 2, "subId": "abc"},
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "bob", "enable": false}
+	]`, "alice", "bob")
+	addFilterTestInbound(t, 20002, "inbound-20002", false, `[{"id": "d1e0b8a5-64c4-4d8e-a0a3-4d3f0a1c9b2e", "email": "dave", "enable": true}]`, "dave")
+
+	s := &XrayService{}
+	footprint, err := s.OptimizationFootprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(footprint.Inbounds) != 1 || footprint.Inbounds[0].Tag != "inbound-20001" {
+		t.Fatalf("got inbounds %+v, want only the enabled one", footprint.Inbounds)
+	}
+	// bob is left out and alice keeps only her id and email, the stream settings are unchanged
+	item := footprint.Inbounds[0]
+	if item.StoredFields != 12 || item.GeneratedFields != 6 {
+		t.Fatalf("got %d stored and %d generated fields, want 12 and 6", item.StoredFields, item.GeneratedFields)
+	}
+	if item.GeneratedBytes >= item.StoredBytes {
+		t.Fatalf("got %d generated bytes from %d stored", item.GeneratedBytes, item.StoredBytes)
+	}
+	if footprint.SavedBytes != item.StoredBytes-item.GeneratedBytes || footprint.SavedFields != 6 {
+		t.Fatalf("got %d saved bytes and %d saved fields", footprint.SavedBytes, footprint.SavedFields)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+// parseInboundFragment reads a fragment like {"settings": {"fallbacks": [...]}}, only the inbound
+// objects can be extended since listen, port, protocol and tag are managed by the panel
+func parseInboundFragment(fragment string) (map[string]map[string]interface{}, error) {
+	var raw map[string]interface{}
+	err := json.Unmarshal([]byte(fragment), &raw)
+	if err != nil {
+		return nil, common.NewErrorf("config fragment must be a JSON object: %v", err)
+	}
+	sections := make(map[string]map[string]interface{}, len(raw))
+	for key, value := range raw {
+		switch key {
+		case "settings", "streamSettings", "sniffing", "allocate":
+		default:
+			return nil, common.NewErrorf("config fragment cannot set %q", key)
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, common.NewErrorf("config fragment %q must be an object", key)
+		}
+		sections[key] = section
+	}
+	return sections, nil
+}
+
+// fragmentCollisions lists the fragment keys the section already has
+func fragmentCollisions(name string, current []byte, section map[string]interface{}) ([]string, map[string]interface{}, error) {
+	existing := map[string]interface{}{}
+	if len(current) > 0 && string(current) != "null" {
+		if err := json.Unmarshal(current, &existing); err != nil {
+			return nil, nil, err
+		}
+	}
+	var collisions []string
+	for key := range section {
+		if _, ok := existing[key]; ok || (name == "settings" && key == "clients") {
+			collisions = append(collisions, name+"."+key)
+		}
+	}
+	return collisions, existing, nil
+}
+
+func validateInboundFragment(inbound *model.Inbound) error {
+	if strings.TrimSpace(inbound.ConfigFragment) == "" {
+		return nil
+	}
+	inboundConfig := inbound.GenXrayInboundConfig()
+	return mergeInboundFragment(inboundConfig, inbound.ConfigFragment)
+}
+
+// mergeInboundFragment adds the fragment keys to the inbound config. It runs after the generation
+// passes so they keep the fragment as written, and fails without changes when a key is already set.
+func mergeInboundFragment(inboundConfig *xray.InboundConfig, fragment string) error {
+	if strings.TrimSpace(fragment) == "" {
+		return nil
+	}
+	sections, err := parseInboundFragment(fragment)
+	if err != nil {
+		return err
+	}
+	fields := map[string]*json_util.RawMessage{
+		"settings":       &inboundConfig.Settings,
+		"streamSettings": &inboundConfig.StreamSettings,
+		"sniffing":       &inboundConfig.Sniffing,
+		"allocate":       &inboundConfig.Allocate,
+	}
+
+	merged := make(map[string][]byte, len(sections))
+	var collisions []string
+	for name, section := range sections {
+		sectionCollisions, existing, err := fragmentCollisions(name, *fields[name], section)
+		if err != nil {
+			return err
+		}
+		collisions = append(collisions, sectionCollisions...)
+		for key, value := range section {
+			existing[key] = value
+		}
+		merged[name], err = json.MarshalIndent(existing, "", "  ")
+		if err != nil {
+			return err
+		}
+	}
+	if len(collisions) > 0 {
+		return common.NewErrorf("config fragment sets managed fields: %s", strings.Join(collisions, ", "))
+	}
+	for name, data := range merged {
+		*fields[name] = data
+	}
+	return nil
+}
+
+// SetInboundFragment stores the config fragment of an inbound, the inbound form leaves it as is
+func (s *InboundService) SetInboundFragment(id int, fragment string) error {
+	inbound, err := s.GetInbound(id)
+	if err != nil {
+		return err
+	}
+	inbound.ConfigFragment = fragment
+	if err = validateInboundFragment(inbound); err != nil {
+		return err
+	}
+	return database.GetDB().Model(model.Inbound{}).Where("id = ?", id).Update("config_fragment", fragment).Error
+}
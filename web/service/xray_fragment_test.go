@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestMergeInboundFragment(t *testing.T) {
+	tests := []struct {
+		name         string
+		fragment     string
+		wantSettings string
+		wantSniffing string
+		wantErr      string
+	}{
+		{
+			name:         "empty fragment",
+			fragment:     " ",
+			wantSettings: `{"clients": [], "decryption": "none"}`,
+		},
+		{
+			name:         "fallbacks",
+			fragment:     `{"settings": {"fallbacks": [{"dest": 8080}, {"path": "/ws", "dest": "@ws"}]}}`,
+			wantSettings: `{"clients": [], "decryption": "none", "fallbacks": [{"dest": 8080}, {"path": "/ws", "dest": "@ws"}]}`,
+		},
+		{
+			name:         "two sections",
+			fragment:     `{"settings": {"fallbacks": []}, "sniffing": {"routeOnly": true}}`,
+			wantSettings: `{"clients": [], "decryption": "none", "fallbacks": []}`,
+			wantSniffing: `{"routeOnly": true}`,
+		},
+		{name: "not an object", fragment: `[]`, wantErr: "config fragment must be a JSON object"},
+		{name: "managed top level key", fragment: `{"port": 443}`, wantErr: `config fragment cannot set "port"`},
+		{name: "section is not an object", fragment: `{"settings": []}`, wantErr: `config fragment "settings" must be an object`},
+		{name: "clients are managed", fragment: `{"settings": {"clients": []}}`, wantErr: "config fragment sets managed fields: settings.clients"},
+		{name: "existing key", fragment: `{"settings": {"decryption": "aes"}}`, wantErr: "config fragment sets managed fields: settings.decryption"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inboundConfig := &xray.InboundConfig{Settings: []byte(`{"clients": [], "decryption": "none"}`)}
+			err := mergeInboundFragment(inboundConfig, tt.fragment)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				// A failed merge leaves the config as it was
+				if string(inboundConfig.Settings) != `{"clients": [], "decryption": "none"}` || inboundConfig.Sniffing != nil {
+					t.Fatalf("failed merge changed the config to %s and %s", inboundConfig.Settings, inboundConfig.Sniffing)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, inboundConfig.Settings, tt.wantSettings)
+			if tt.wantSniffing != "" {
+				assertJSONEqual(t, inboundConfig.Sniffing, tt.wantSniffing)
+			}
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestInboundFragmentGeneration(t *testing.T) {
+	setupTestDB(t)
+	inbound := addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "enable": true, "limitIp": 2}
+	]`, "alice")
+	inboundService := &InboundService{}
+	s := &XrayService{}
+
+	err := inboundService.SetInboundFragment(inbound.Id, `{"settings": {"decryption": "aes"}}`)
+	if err == nil || !strings.Contains(err.Error(), "settings.decryption") {
+		t.Fatalf("got error %v for a fragment setting a managed field", err)
+	}
+	fallbacks := `[{"dest": 8080}, {"path": "/ws", "dest": "@ws", "xver": 1}]`
+	if err := inboundService.SetInboundFragment(inbound.Id, `{"settings": {"fallbacks": `+fallbacks+`}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fallbacks survive generation, while the clients still go through the passes
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings map[string]json.RawMessage
+	for _, inboundConfig := range xrayConfig.InboundConfigs {
+		if inboundConfig.Tag == inbound.Tag {
+			if err := json.Unmarshal(inboundConfig.Settings, &settings); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	assertJSONEqual(t, settings["fallbacks"], fallbacks)
+	assertJSONEqual(t, settings["clients"], `[{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice"}]`)
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// FreedomFragment splits the first packets of a connection, so filters matching the TLS client
+// hello do not see it in one piece
+type FreedomFragment struct {
+	Packets  string `json:"packets"`
+	Length   string `json:"length"`
+	Interval string `json:"interval"`
+}
+
+// FreedomNoise is sent before the connection's own packets. Packet is a length range for rand,
+// and the content for str and base64.
+type FreedomNoise struct {
+	Type   string `json:"type"`
+	Packet string `json:"packet"`
+	Delay  string `json:"delay"`
+}
+
+// FreedomSettings are written into the freedom outbounds of OutboundTags, or all of them when it
+// is empty. They replace the fragment and noises the template may set there.
+type FreedomSettings struct {
+	Enable       bool             `json:"enable"`
+	Fragment     *FreedomFragment `json:"fragment,omitempty"`
+	Noises       []FreedomNoise   `json:"noises,omitempty"`
+	OutboundTags []string         `json:"outboundTags,omitempty"`
+}
+
+// Xray reads these as a number or a from-to range
+var freedomRangeRegex = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+func checkFreedomRange(name string, value string, required bool) error {
+	if value == "" && !required {
+		return nil
+	}
+	if !freedomRangeRegex.MatchString(value) {
+		return common.NewErrorf("invalid %s %q", name, value)
+	}
+	from, to, isRange := strings.Cut(value, "-")
+	if isRange {
+		start, _ := strconv.Atoi(from)
+		end, _ := strconv.Atoi(to)
+		if start > end {
+			return common.NewErrorf("invalid %s %q", name, value)
+		}
+	}
+	return nil
+}
+
+func checkFreedomSettings(settings *FreedomSettings) error {
+	if settings.Enable && settings.Fragment == nil && len(settings.Noises) == 0 {
+		return common.NewError("enable a fragment or at least one noise")
+	}
+	if fragment := settings.Fragment; fragment != nil {
+		fragment.Packets = strings.TrimSpace(fragment.Packets)
+		if fragment.Packets != "tlshello" {
+			if err := checkFreedomRange("fragment packets", fragment.Packets, true); err != nil {
+				return err
+			}
+		}
+		if err := checkFreedomRange("fragment length", fragment.Length, true); err != nil {
+			return err
+		}
+		if err := checkFreedomRange("fragment interval", fragment.Interval, true); err != nil {
+			return err
+		}
+	}
+	for _, noise := range settings.Noises {
+		switch noise.Type {
+		case "rand":
+			if err := checkFreedomRange("noise packet", noise.Packet, true); err != nil {
+				return err
+			}
+		case "str":
+			if noise.Packet == "" {
+				return common.NewError("str noise needs a packet")
+			}
+		case "base64":
+			if _, err := base64.StdEncoding.DecodeString(noise.Packet); err != nil || noise.Packet == "" {
+				return common.NewErrorf("invalid base64 noise packet %q", noise.Packet)
+			}
+		default:
+			return common.NewErrorf("unsupported noise type %q", noise.Type)
+		}
+		if err := checkFreedomRange("noise delay", noise.Delay, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *XrayService) GetFreedomSettings() (*FreedomSettings, error) {
+	settings := &FreedomSettings{}
+	data, err := s.settingService.GetFreedomSettings()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return settings, nil
+	}
+	err = json.Unmarshal([]byte(data), settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *XrayService) SetFreedomSettings(settings *FreedomSettings) error {
+	err := checkFreedomSettings(settings)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetFreedomSettings(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// applyFreedomSettings writes the fragment and noises into the selected freedom outbounds
+func (s *XrayService) applyFreedomSettings(xrayConfig *xray.Config) error {
+	settings, err := s.GetFreedomSettings()
+	if err != nil || !settings.Enable {
+		return err
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	selected := map[string]bool{}
+	for _, tag := range settings.OutboundTags {
+		selected[tag] = true
+	}
+
+	applied := map[string]bool{}
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		if protocol, _ := outbound["protocol"].(string); protocol != "freedom" {
+			continue
+		}
+		if len(selected) > 0 && !selected[tag] {
+			continue
+		}
+		outboundSettings, _ := outbound["settings"].(map[string]interface{})
+		if outboundSettings == nil {
+			outboundSettings = map[string]interface{}{}
+		}
+		delete(outboundSettings, "fragment")
+		delete(outboundSettings, "noises")
+		if settings.Fragment != nil {
+			outboundSettings["fragment"] = settings.Fragment
+		}
+		if len(settings.Noises) > 0 {
+			outboundSettings["noises"] = settings.Noises
+		}
+		outbound["settings"] = outboundSettings
+		applied[tag] = true
+	}
+	for tag := range selected {
+		if !applied[tag] {
+			logger.Warningf("Skip fragment of outbound %s, it is missing or not a freedom outbound", tag)
+		}
+	}
+	return setOutbounds(xrayConfig, outbounds)
+}
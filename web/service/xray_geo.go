@@ -0,0 +1,185 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// GeoFileResult is the outcome of updating one geo file
+type GeoFileResult struct {
+	Name    string `json:"name"`
+	Updated bool   `json:"updated"`
+	Source  string `json:"source,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Geo files are a few MB, anything far larger is not one
+const maxGeoFileSize = 100 << 20
+
+var (
+	geoUpdateLock sync.Mutex
+	geoHttpClient = &http.Client{Timeout: 5 * time.Minute}
+)
+
+// UpdateGeoFiles downloads geoip.dat and geosite.dat from the first mirror that works, replaces
+// the local files that changed and restarts Xray to load them
+func (s *XrayService) UpdateGeoFiles() ([]GeoFileResult, error) {
+	geoUpdateLock.Lock()
+	defer geoUpdateLock.Unlock()
+
+	verify, err := s.settingService.GetGeoChecksum()
+	if err != nil {
+		return nil, err
+	}
+	geoipUrl, err := s.settingService.GetGeoipUrl()
+	if err != nil {
+		return nil, err
+	}
+	geositeUrl, err := s.settingService.GetGeositeUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		path    string
+		mirrors string
+	}{
+		{xray.GetGeoipPath(), geoipUrl},
+		{xray.GetGeositePath(), geositeUrl},
+	}
+	results := make([]GeoFileResult, 0, len(files))
+	updated := false
+	for _, file := range files {
+		result := GeoFileResult{Name: filepath.Base(file.path)}
+		for _, mirror := range strings.Split(file.mirrors, ",") {
+			mirror = strings.TrimSpace(mirror)
+			if mirror == "" {
+				continue
+			}
+			changed, err := updateGeoFile(file.path, mirror, verify)
+			if err != nil {
+				logger.Warningf("Failed to update %s from %s: %v", result.Name, mirror, err)
+				result.Error = err.Error()
+				continue
+			}
+			result.Updated = changed
+			result.Source = mirror
+			result.Error = ""
+			break
+		}
+		updated = updated || result.Updated
+		results = append(results, result)
+	}
+
+	if updated && s.IsXrayRunning() {
+		// Xray only reads the geo files when it starts
+		if err := s.RestartXray(true); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// updateGeoFile downloads the file next to the current one and renames it over it, so Xray
+// never sees a partial file. It reports whether the content changed.
+func updateGeoFile(path string, fileUrl string, verify bool) (bool, error) {
+	var checksum string
+	if verify {
+		var err error
+		checksum, err = fetchGeoChecksum(fileUrl + ".sha256sum")
+		if err != nil {
+			return false, err
+		}
+		if local, err := fileSha256(path); err == nil && local == checksum {
+			return false, nil
+		}
+	}
+
+	resp, err := geoHttpClient.Get(fileUrl)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, common.NewErrorf("download failed: %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, maxGeoFileSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	if n == 0 || n > maxGeoFileSize {
+		return false, common.NewErrorf("unexpected file size %d", n)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if verify && sum != checksum {
+		return false, common.NewErrorf("checksum mismatch: got %s, want %s", sum, checksum)
+	}
+	if local, err := fileSha256(path); err == nil && local == sum {
+		return false, nil
+	}
+
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return false, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	logger.Infof("Updated %s from %s", filepath.Base(path), fileUrl)
+	return true, nil
+}
+
+// fetchGeoChecksum reads a sha256sum file, the hash is its first field
+func fetchGeoChecksum(checksumUrl string) (string, error) {
+	resp, err := geoHttpClient.Get(checksumUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", common.NewErrorf("checksum download failed: %s", resp.Status)
+	}
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", common.NewError("invalid checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+// ClientGroup applies its settings to all member clients at once. A setting of the client
+// itself wins over the one of its group.
+type ClientGroup struct {
+	Members     []string `json:"members"`
+	Disabled    bool     `json:"disabled,omitempty"`
+	OutboundTag string   `json:"outboundTag,omitempty"`
+}
+
+// GetClientGroups returns the client groups by name
+func (s *XrayService) GetClientGroups() (map[string]*ClientGroup, error) {
+	groups := map[string]*ClientGroup{}
+	data, err := s.settingService.GetClientGroups()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return groups, nil
+	}
+	err = json.Unmarshal([]byte(data), &groups)
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (s *XrayService) saveClientGroups(groups map[string]*ClientGroup) error {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetClientGroups(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+func (s *XrayService) getClientGroup(groups map[string]*ClientGroup, name string) (*ClientGroup, error) {
+	group, ok := groups[name]
+	if !ok {
+		return nil, common.NewErrorf("client group %s does not exist", name)
+	}
+	return group, nil
+}
+
+// resolveClientGroups maps the members of disabled groups to the group and the members of groups
+// with an outbound to it. Groups are walked by name, so a client in several groups gets the
+// outbound of the first one and is disabled by the first disabled one.
+func resolveClientGroups(groups map[string]*ClientGroup) (disabled map[string]string, outbounds map[string]string) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	disabled = map[string]string{}
+	outbounds = map[string]string{}
+	for _, name := range names {
+		group := groups[name]
+		for _, email := range group.Members {
+			if _, ok := disabled[email]; !ok && group.Disabled {
+				disabled[email] = name
+			}
+			if _, ok := outbounds[email]; !ok && group.OutboundTag != "" {
+				outbounds[email] = group.OutboundTag
+			}
+		}
+	}
+	return disabled, outbounds
+}
+
+// SetClientGroup creates the group or replaces its members, keeping its settings
+func (s *XrayService) SetClientGroup(name string, members []string) error {
+	if name == "" {
+		return common.NewError("group name is required")
+	}
+	seen := map[string]bool{}
+	for _, email := range members {
+		if seen[email] {
+			return common.NewErrorf("client %s is listed twice", email)
+		}
+		seen[email] = true
+		if err := s.checkClientExists(email); err != nil {
+			return err
+		}
+	}
+
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	group, ok := groups[name]
+	if !ok {
+		group = &ClientGroup{}
+		groups[name] = group
+	}
+	group.Members = members
+	return s.saveClientGroups(groups)
+}
+
+func (s *XrayService) RemoveClientGroup(name string) error {
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	if _, err := s.getClientGroup(groups, name); err != nil {
+		return err
+	}
+	delete(groups, name)
+	return s.saveClientGroups(groups)
+}
+
+// SetClientGroupEnable disables all members of the group, or lifts that. Members disabled on
+// their own stay disabled.
+func (s *XrayService) SetClientGroupEnable(name string, enable bool) error {
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	group, err := s.getClientGroup(groups, name)
+	if err != nil {
+		return err
+	}
+	group.Disabled = !enable
+	return s.saveClientGroups(groups)
+}
+
+// SetClientGroupOutbound routes the members through the outbound, an empty tag removes the route.
+// Members with their own client outbound keep it.
+func (s *XrayService) SetClientGroupOutbound(name string, outboundTag string) error {
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	group, err := s.getClientGroup(groups, name)
+	if err != nil {
+		return err
+	}
+	if outboundTag != "" {
+		tags, err := s.getTemplateOutboundTags()
+		if err != nil {
+			return err
+		}
+		if !tags[outboundTag] {
+			return common.NewErrorf("outbound %s does not exist", outboundTag)
+		}
+	}
+	group.OutboundTag = outboundTag
+	return s.saveClientGroups(groups)
+}
+
+// ResetClientGroupTraffic resets the traffic of every member, which enables the depleted ones again.
+// A member that fails does not stop the others, the errors are returned together.
+func (s *XrayService) ResetClientGroupTraffic(name string) error {
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	group, err := s.getClientGroup(groups, name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	reset := false
+	for _, email := range group.Members {
+		if err := s.inboundService.ResetClientTrafficByEmail(email); err != nil {
+			logger.Warningf("Failed to reset traffic of client %s in group %s: %v", email, name, err)
+			errs = append(errs, common.NewErrorf("client %s: %v", email, err))
+			continue
+		}
+		reset = true
+	}
+	// The members that were reset are enabled again whatever happened to the others
+	if reset {
+		s.SetToNeedRestart()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+func TestResolveClientGroups(t *testing.T) {
+	groups := map[string]*ClientGroup{
+		"b-team":  {Members: []string{"alice", "carol"}, Disabled: true, OutboundTag: "warp"},
+		"a-team":  {Members: []string{"alice", "bob"}, OutboundTag: "proxy"},
+		"c-team":  {Members: []string{"carol", "dave"}, Disabled: true},
+		"no-team": {},
+	}
+	disabled, outbounds := resolveClientGroups(groups)
+	wantDisabled := map[string]string{"alice": "b-team", "carol": "b-team", "dave": "c-team"}
+	if !reflect.DeepEqual(disabled, wantDisabled) {
+		t.Fatalf("got disabled %v, want %v", disabled, wantDisabled)
+	}
+	// The first group by name gives the outbound
+	wantOutbounds := map[string]string{"alice": "proxy", "bob": "proxy", "carol": "warp"}
+	if !reflect.DeepEqual(outbounds, wantOutbounds) {
+		t.Fatalf("got outbounds %v, want %v", outbounds, wantOutbounds)
+	}
+}
+
+func TestSetClientGroup(t *testing.T) {
+	setChainTestTemplate(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestClient(t, inbound.Id, "alice")
+	addTestClient(t, inbound.Id, "bob")
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name    string
+		group   string
+		members []string
+		wantErr string
+	}{
+		{name: "new group", group: "team", members: []string{"alice", "bob"}},
+		{name: "no name", group: "", members: []string{"alice"}, wantErr: "group name is required"},
+		{name: "listed twice", group: "team", members: []string{"alice", "alice"}, wantErr: "client alice is listed twice"},
+		{name: "unknown client", group: "team", members: []string{"alice", "nobody"}, wantErr: "client nobody does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetClientGroup(tt.group, tt.members)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Replacing the members keeps the settings of the group
+	if err := s.SetClientGroupEnable("team", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetClientGroup("team", []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group := groups["team"]; !group.Disabled || !reflect.DeepEqual(group.Members, []string{"bob"}) {
+		t.Fatalf("got group %+v", group)
+	}
+
+	if err := s.SetClientGroupOutbound("team", "missing"); err == nil || !strings.Contains(err.Error(), "outbound missing does not exist") {
+		t.Fatalf("got error %v for a missing outbound", err)
+	}
+	if err := s.SetClientGroupEnable("nobody", true); err == nil || !strings.Contains(err.Error(), "client group nobody does not exist") {
+		t.Fatalf("got error %v for a missing group", err)
+	}
+	if err := s.RemoveClientGroup("team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveClientGroup("team"); err == nil {
+		t.Fatal("removed a missing group")
+	}
+}
+
+func TestClientGroupGeneration(t *testing.T) {
+	setChainTestTemplate(t)
+	addFilterTestInbound(t, 20001, "inbound-20001", true, `[
+		{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice", "enable": true},
+		{"id": "b831381d-6324-4d53-ad4f-8cda48b30811", "email": "bob", "enable": true},
+		{"id": "c5a9a7b1-3e43-4bd4-9d39-2d7e5b0b2e57", "email": "carol", "enable": true}
+	]`, "alice", "bob", "carol")
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	for name, members := range map[string][]string{"blocked": {"alice", "bob"}, "routed": {"bob", "carol"}} {
+		if err := s.SetClientGroup(name, members); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetClientGroupEnable("blocked", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetClientGroupOutbound("routed", "warp"); err != nil {
+		t.Fatal(err)
+	}
+	// carol's own outbound wins over the one of her group
+	if err := s.SetClientOutbound("carol", "proxy"); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings struct {
+		Clients []struct {
+			Email string `json:"email"`
+		} `json:"clients"`
+	}
+	for _, inboundConfig := range xrayConfig.InboundConfigs {
+		if inboundConfig.Tag == "inbound-20001" {
+			if err := json.Unmarshal(inboundConfig.Settings, &settings); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(settings.Clients) != 1 || settings.Clients[0].Email != "carol" {
+		t.Fatalf("got clients %+v, want only carol", settings.Clients)
+	}
+	routes := map[string]interface{}{}
+	for _, rule := range configRules(t, xrayConfig) {
+		if users, ok := rule["user"].([]interface{}); ok {
+			for _, user := range users {
+				routes[user.(string)] = rule["outboundTag"]
+			}
+		}
+	}
+	if want := map[string]interface{}{"bob": "warp", "carol": "proxy"}; !reflect.DeepEqual(routes, want) {
+		t.Fatalf("got routes %v, want %v", routes, want)
+	}
+
+	// A traffic reset of the group enables the depleted members again
+	db := database.GetDB()
+	if err := db.Model(xray.ClientTraffic{}).Where("email = ?", "carol").Updates(map[string]interface{}{"enable": false, "up": 100}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResetClientGroupTraffic("routed"); err != nil {
+		t.Fatal(err)
+	}
+	var traffic xray.ClientTraffic
+	if err := db.Where("email = ?", "carol").First(&traffic).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !traffic.Enable || traffic.Up != 0 {
+		t.Fatalf("got traffic %+v after the reset", traffic)
+	}
+}
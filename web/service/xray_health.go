@@ -0,0 +1,324 @@
+package service
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// Consecutive failed probes before an outbound counts as down
+const outboundDownAfter = 3
+
+// downOutboundTag is the blackhole outbound that takes the traffic of the down outbounds
+const downOutboundTag = "outbound-down"
+
+type OutboundHealth struct {
+	Healthy   bool   `json:"healthy"`
+	LatencyMs int64  `json:"latencyMs"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError,omitempty"`
+	CheckedAt int64  `json:"checkedAt"`
+}
+
+// OutboundProber measures the round trip to an outbound, outbound is its config object
+type OutboundProber interface {
+	Probe(outbound map[string]interface{}) (time.Duration, error)
+}
+
+// tcpOutboundProber connects to the server of the outbound, it tells a dead server from a live one
+// without needing a route through Xray
+type tcpOutboundProber struct {
+	timeout time.Duration
+}
+
+func (p tcpOutboundProber) Probe(outbound map[string]interface{}) (time.Duration, error) {
+	address, ok := outboundServerAddress(outbound)
+	if !ok {
+		return 0, common.NewError("outbound has no server address")
+	}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, p.timeout)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+var (
+	outboundProber OutboundProber = tcpOutboundProber{timeout: 5 * time.Second}
+
+	outboundHealthMu sync.Mutex
+	outboundHealth   = map[string]OutboundHealth{}
+	// outbounds of the config Xray was last started with, and the dead ones it leaves out
+	outboundProbeTargets []map[string]interface{}
+)
+
+// SetOutboundProber replaces how outbounds are probed, nil restores the tcp prober
+func SetOutboundProber(prober OutboundProber) {
+	outboundHealthMu.Lock()
+	defer outboundHealthMu.Unlock()
+	if prober == nil {
+		prober = tcpOutboundProber{timeout: 5 * time.Second}
+	}
+	outboundProber = prober
+}
+
+// outboundDialer returns the tag of the outbound that the outbound dials through, empty when it
+// connects on its own
+func outboundDialer(outbound map[string]interface{}) string {
+	stream, _ := outbound["streamSettings"].(map[string]interface{})
+	sockopt, _ := stream["sockopt"].(map[string]interface{})
+	if tag, _ := sockopt["dialerProxy"].(string); tag != "" {
+		return tag
+	}
+	proxySettings, _ := outbound["proxySettings"].(map[string]interface{})
+	tag, _ := proxySettings["tag"].(string)
+	return tag
+}
+
+// outboundServerAddress returns host:port of the first server of vnext, servers or wireguard peers
+func outboundServerAddress(outbound map[string]interface{}) (string, bool) {
+	settings, _ := outbound["settings"].(map[string]interface{})
+	for _, key := range []string{"vnext", "servers"} {
+		servers, _ := settings[key].([]interface{})
+		if len(servers) == 0 {
+			continue
+		}
+		server, _ := servers[0].(map[string]interface{})
+		address, _ := server["address"].(string)
+		port, _ := server["port"].(float64)
+		if address == "" || port <= 0 {
+			return "", false
+		}
+		return net.JoinHostPort(address, strconv.Itoa(int(port))), true
+	}
+	peers, _ := settings["peers"].([]interface{})
+	if len(peers) > 0 {
+		peer, _ := peers[0].(map[string]interface{})
+		if endpoint, _ := peer["endpoint"].(string); endpoint != "" {
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+func (s *XrayService) GetOutboundHealth() map[string]OutboundHealth {
+	outboundHealthMu.Lock()
+	defer outboundHealthMu.Unlock()
+	health := make(map[string]OutboundHealth, len(outboundHealth))
+	for tag, h := range outboundHealth {
+		health[tag] = h
+	}
+	return health
+}
+
+// ProbeOutbounds probes every outbound with a server and records the result. When dead outbounds
+// are excluded, a restart is requested as soon as one goes down or comes back. An outbound that
+// dials through another one is not probed, a direct probe does not take its path. It counts as
+// down with the outbound it dials through.
+func (s *XrayService) ProbeOutbounds() error {
+	outboundHealthMu.Lock()
+	targets := outboundProbeTargets
+	prober := outboundProber
+	outboundHealthMu.Unlock()
+	// Nothing to probe before Xray has been started
+	if targets == nil {
+		return nil
+	}
+
+	changed := false
+	probed := map[string]bool{}
+	for _, outbound := range targets {
+		tag, _ := outbound["tag"].(string)
+		if _, ok := outboundServerAddress(outbound); !ok || tag == "" || outboundDialer(outbound) != "" {
+			continue
+		}
+		probed[tag] = true
+		latency, err := prober.Probe(outbound)
+
+		outboundHealthMu.Lock()
+		health, seen := outboundHealth[tag]
+		wasHealthy := !seen || health.Healthy
+		health.CheckedAt = time.Now().Unix() * 1000
+		if err != nil {
+			health.Failures++
+			health.LatencyMs = 0
+			health.LastError = err.Error()
+			health.Healthy = health.Failures < outboundDownAfter
+		} else {
+			health = OutboundHealth{Healthy: true, LatencyMs: latency.Milliseconds(), CheckedAt: health.CheckedAt}
+		}
+		outboundHealth[tag] = health
+		outboundHealthMu.Unlock()
+
+		if health.Healthy != wasHealthy {
+			changed = true
+			if health.Healthy {
+				logger.Info("Outbound", tag, "is healthy again")
+			} else {
+				logger.Warningf("Outbound %s is down: %s", tag, health.LastError)
+			}
+		}
+	}
+
+	outboundHealthMu.Lock()
+	for tag := range outboundHealth {
+		if !probed[tag] {
+			delete(outboundHealth, tag)
+		}
+	}
+	outboundHealthMu.Unlock()
+
+	if changed {
+		exclude, err := s.settingService.GetOutboundHealthExclude()
+		if err != nil {
+			return err
+		}
+		if exclude {
+			s.SetToNeedRestart()
+		}
+	}
+	return nil
+}
+
+// setOutboundProbeTargets records the outbounds of the config Xray runs with. Previous targets
+// that were left out of it for being down stay, so they are seen coming back.
+func (s *XrayService) setOutboundProbeTargets(xrayConfig *xray.Config) {
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		logger.Warning("Failed to read the outbounds to probe:", err)
+		return
+	}
+	exclude, err := s.settingService.GetOutboundHealthExclude()
+	if err != nil {
+		logger.Warning("Failed to read the outbound health setting:", err)
+	}
+	outboundHealthMu.Lock()
+	defer outboundHealthMu.Unlock()
+	if exclude {
+		applied := make(map[string]bool, len(outbounds))
+		for _, outbound := range outbounds {
+			tag, _ := outbound["tag"].(string)
+			applied[tag] = true
+		}
+		for _, outbound := range outboundProbeTargets {
+			tag, _ := outbound["tag"].(string)
+			if health, ok := outboundHealth[tag]; ok && !health.Healthy && !applied[tag] {
+				outbounds = append(outbounds, outbound)
+			}
+		}
+	}
+	outboundProbeTargets = outbounds
+}
+
+// applyOutboundHealth takes the outbounds that are down out of the config, when enabled, and sends
+// their traffic to a blackhole, so it does not leak through the default outbound. This covers the
+// rules and balancer fallbacks naming them, the balancers left without outbounds and the outbounds
+// dialing through them. The first outbound is the default one and stays.
+func (s *XrayService) applyOutboundHealth(xrayConfig *xray.Config) error {
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	outboundHealthMu.Lock()
+	down := map[string]bool{}
+	for tag, health := range outboundHealth {
+		if !health.Healthy {
+			down[tag] = true
+		}
+	}
+	outboundHealthMu.Unlock()
+
+	exclude, err := s.settingService.GetOutboundHealthExclude()
+	if err != nil || !exclude || len(down) == 0 || len(outbounds) == 0 {
+		return err
+	}
+
+	first, _ := outbounds[0]["tag"].(string)
+	delete(down, first)
+	// An outbound dialing through a down one is down as well, along the whole chain
+	for changed := true; changed; {
+		changed = false
+		for _, outbound := range outbounds {
+			tag, _ := outbound["tag"].(string)
+			if dialer := outboundDialer(outbound); down[dialer] && !down[tag] && tag != first {
+				down[tag] = true
+				changed = true
+			}
+		}
+	}
+
+	kept := make([]map[string]interface{}, 0, len(outbounds)+1)
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		if down[tag] {
+			logger.Debug("Exclude outbound", tag, "from config, it is down")
+			continue
+		}
+		kept = append(kept, outbound)
+	}
+	if len(kept) == len(outbounds) {
+		return nil
+	}
+
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	// A balancer none of whose selectors matches a kept outbound has nothing left to pick
+	deadBalancers := map[string]bool{}
+	balancers, _ := routing["balancers"].([]interface{})
+	keptBalancers := make([]interface{}, 0, len(balancers))
+	for _, balancer := range balancers {
+		b, _ := balancer.(map[string]interface{})
+		if tag, _ := b["tag"].(string); !balancerHasOutbound(b, kept) {
+			deadBalancers[tag] = true
+			continue
+		}
+		if fallback, _ := b["fallbackTag"].(string); down[fallback] {
+			b["fallbackTag"] = downOutboundTag
+		}
+		keptBalancers = append(keptBalancers, balancer)
+	}
+	if balancers != nil {
+		routing["balancers"] = keptBalancers
+	}
+	rules, _ := routing["rules"].([]interface{})
+	for _, rule := range rules {
+		r, _ := rule.(map[string]interface{})
+		if tag, _ := r["outboundTag"].(string); down[tag] {
+			r["outboundTag"] = downOutboundTag
+		}
+		if tag, _ := r["balancerTag"].(string); deadBalancers[tag] {
+			delete(r, "balancerTag")
+			r["outboundTag"] = downOutboundTag
+		}
+	}
+
+	kept = append(kept, map[string]interface{}{"tag": downOutboundTag, "protocol": "blackhole"})
+	if err = setOutbounds(xrayConfig, kept); err != nil {
+		return err
+	}
+	return setRouting(xrayConfig, routing)
+}
+
+// balancerHasOutbound tells whether a selector of the balancer, a tag prefix, matches one of outbounds
+func balancerHasOutbound(balancer map[string]interface{}, outbounds []map[string]interface{}) bool {
+	selectors, _ := balancer["selector"].([]interface{})
+	for _, selector := range selectors {
+		prefix, _ := selector.(string)
+		for _, outbound := range outbounds {
+			if tag, _ := outbound["tag"].(string); strings.HasPrefix(tag, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"x-ui/xray"
+)
+
+// stubOutboundProber fails the probes of the outbounds in down
+type stubOutboundProber struct {
+	down map[string]bool
+}
+
+func (p stubOutboundProber) Probe(outbound map[string]interface{}) (time.Duration, error) {
+	if tag, _ := outbound["tag"].(string); p.down[tag] {
+		return 0, errors.New("connection refused")
+	}
+	return 20 * time.Millisecond, nil
+}
+
+const healthTestConfig = `[
+  {"tag": "direct", "protocol": "freedom"},
+  {"tag": "proxy", "protocol": "vless", "settings": {"vnext": [{"address": "203.0.113.1", "port": 443}]}},
+  {"tag": "backup", "protocol": "trojan", "settings": {"servers": [{"address": "203.0.113.2", "port": 443}]}}
+]`
+
+func resetOutboundHealth(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		SetOutboundProber(nil)
+		outboundHealthMu.Lock()
+		outboundHealth = map[string]OutboundHealth{}
+		outboundProbeTargets = nil
+		outboundHealthMu.Unlock()
+	})
+}
+
+func TestOutboundServerAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     string
+		wantOk   bool
+	}{
+		{"vnext", map[string]interface{}{"vnext": []interface{}{map[string]interface{}{"address": "a.example.com", "port": 443.0}}}, "a.example.com:443", true},
+		{"servers", map[string]interface{}{"servers": []interface{}{map[string]interface{}{"address": "2001:db8::1", "port": 8443.0}}}, "[2001:db8::1]:8443", true},
+		{"wireguard peer", map[string]interface{}{"peers": []interface{}{map[string]interface{}{"endpoint": "engage.cloudflareclient.com:2408"}}}, "engage.cloudflareclient.com:2408", true},
+		{"server without port", map[string]interface{}{"servers": []interface{}{map[string]interface{}{"address": "a.example.com"}}}, "", false},
+		{"no server", map[string]interface{}{"domainStrategy": "UseIP"}, "", false},
+		{"no settings", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbound := map[string]interface{}{"tag": "test"}
+			if tt.settings != nil {
+				outbound["settings"] = tt.settings
+			}
+			got, ok := outboundServerAddress(outbound)
+			if got != tt.want || ok != tt.wantOk {
+				t.Fatalf("got %q and %v, want %q and %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestProbeOutbounds(t *testing.T) {
+	setChainTestTemplate(t)
+	resetOutboundHealth(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	if err := s.settingService.SetOutboundHealthExclude(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before Xray has been started there is nothing to probe
+	if err := s.ProbeOutbounds(); err != nil {
+		t.Fatal(err)
+	}
+	if health := s.GetOutboundHealth(); len(health) != 0 {
+		t.Fatalf("got health %v before any config was applied", health)
+	}
+
+	// A generated config is not what Xray runs, it does not change the targets
+	if _, err := s.GetXrayConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if outboundProbeTargets != nil {
+		t.Fatalf("generating a config set the probe targets to %v", outboundProbeTargets)
+	}
+
+	s.setOutboundProbeTargets(&xray.Config{OutboundConfigs: []byte(healthTestConfig)})
+	SetOutboundProber(stubOutboundProber{down: map[string]bool{"proxy": true}})
+	s.IsNeedRestartAndSetFalse()
+	for i := 1; i <= outboundDownAfter; i++ {
+		if err := s.ProbeOutbounds(); err != nil {
+			t.Fatal(err)
+		}
+		health := s.GetOutboundHealth()
+		if len(health) != 2 {
+			t.Fatalf("got health of %d outbounds, want the 2 with a server", len(health))
+		}
+		if !health["backup"].Healthy || health["backup"].LatencyMs != 20 {
+			t.Fatalf("backup got %+v, want healthy with 20ms", health["backup"])
+		}
+		proxy := health["proxy"]
+		if proxy.Failures != i || proxy.LastError != "connection refused" {
+			t.Fatalf("probe %d: proxy got %+v", i, proxy)
+		}
+		if wantHealthy := i < outboundDownAfter; proxy.Healthy != wantHealthy {
+			t.Fatalf("probe %d: proxy healthy %v, want %v", i, proxy.Healthy, wantHealthy)
+		}
+		if restart := s.IsNeedRestartAndSetFalse(); restart != !proxy.Healthy {
+			t.Fatalf("probe %d: restart requested %v", i, restart)
+		}
+	}
+
+	// The config Xray restarts with leaves the dead outbound out and blackholes its rules
+	xrayConfig := &xray.Config{
+		OutboundConfigs: []byte(healthTestConfig),
+		RouterConfig:    []byte(`{"rules": [{"outboundTag": "proxy", "domain": ["example.com"]}, {"outboundTag": "backup", "ip": ["10.0.0.0/8"]}]}`),
+	}
+	if err := s.applyOutboundHealth(xrayConfig); err != nil {
+		t.Fatal(err)
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 3 || outbounds[0]["tag"] != "direct" || outbounds[1]["tag"] != "backup" || outbounds[2]["tag"] != downOutboundTag {
+		t.Fatalf("got outbounds %v, want direct, backup and the blackhole", outbounds)
+	}
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, _ := routing["rules"].([]interface{})
+	if len(rules) != 2 || rules[0].(map[string]interface{})["outboundTag"] != downOutboundTag {
+		t.Fatalf("got rules %v, want the proxy one blackholed", rules)
+	}
+
+	// The dead outbound stays a target, so it is seen coming back
+	s.setOutboundProbeTargets(xrayConfig)
+	SetOutboundProber(stubOutboundProber{})
+	if err := s.ProbeOutbounds(); err != nil {
+		t.Fatal(err)
+	}
+	if proxy := s.GetOutboundHealth()["proxy"]; !proxy.Healthy || proxy.Failures != 0 {
+		t.Fatalf("proxy got %+v, want healthy again", proxy)
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("a recovered outbound did not ask for a restart")
+	}
+}
+
+func TestProbeOutboundsSkipsChains(t *testing.T) {
+	resetOutboundHealth(t)
+	s := &XrayService{}
+	s.setOutboundProbeTargets(&xray.Config{OutboundConfigs: []byte(`[
+  {"tag": "direct", "protocol": "freedom"},
+  {"tag": "proxy", "protocol": "vless", "settings": {"vnext": [{"address": "203.0.113.1", "port": 443}]}},
+  {"tag": "chained", "protocol": "trojan", "settings": {"servers": [{"address": "203.0.113.2", "port": 443}]},
+   "streamSettings": {"sockopt": {"dialerProxy": "proxy"}}}
+]`)})
+	SetOutboundProber(stubOutboundProber{})
+	if err := s.ProbeOutbounds(); err != nil {
+		t.Fatal(err)
+	}
+	health := s.GetOutboundHealth()
+	if _, ok := health["chained"]; ok || len(health) != 1 {
+		t.Fatalf("got health %v, want only the proxy probed", health)
+	}
+}
+
+func TestApplyOutboundHealth(t *testing.T) {
+	setupTestDB(t)
+	resetOutboundHealth(t)
+	s := &XrayService{}
+	const outbounds = `[
+  {"tag": "direct", "protocol": "freedom"},
+  {"tag": "proxy-a", "protocol": "vless", "settings": {"vnext": [{"address": "203.0.113.1", "port": 443}]}},
+  {"tag": "proxy-b", "protocol": "trojan", "settings": {"servers": [{"address": "203.0.113.2", "port": 443}]}},
+  {"tag": "chained", "protocol": "trojan", "settings": {"servers": [{"address": "203.0.113.3", "port": 443}]},
+   "streamSettings": {"sockopt": {"dialerProxy": "proxy-a"}}},
+  {"tag": "chained-twice", "protocol": "trojan", "settings": {"servers": [{"address": "203.0.113.4", "port": 443}]},
+   "proxySettings": {"tag": "chained"}}
+]`
+	const routing = `{
+  "balancers": [
+    {"tag": "all", "selector": ["proxy-"], "fallbackTag": "proxy-a"},
+    {"tag": "only-a", "selector": ["proxy-a"]}
+  ],
+  "rules": [
+    {"outboundTag": "proxy-a", "domain": ["a.example.com"]},
+    {"outboundTag": "chained-twice", "domain": ["c.example.com"]},
+    {"balancerTag": "all", "domain": ["all.example.com"]},
+    {"balancerTag": "only-a", "domain": ["only.example.com"]},
+    {"outboundTag": "proxy-b", "domain": ["b.example.com"]}
+  ]
+}`
+	tests := []struct {
+		name          string
+		exclude       bool
+		down          []string
+		wantOutbounds []string
+		wantBalancers []string
+		wantFallback  string
+		wantRules     []string
+	}{
+		{
+			name:          "exclusion off",
+			down:          []string{"proxy-a"},
+			wantOutbounds: []string{"direct", "proxy-a", "proxy-b", "chained", "chained-twice"},
+			wantBalancers: []string{"all", "only-a"},
+			wantFallback:  "proxy-a",
+			wantRules:     []string{"proxy-a", "chained-twice", "balancer all", "balancer only-a", "proxy-b"},
+		},
+		{
+			name:          "nothing down",
+			exclude:       true,
+			wantOutbounds: []string{"direct", "proxy-a", "proxy-b", "chained", "chained-twice"},
+			wantBalancers: []string{"all", "only-a"},
+			wantFallback:  "proxy-a",
+			wantRules:     []string{"proxy-a", "chained-twice", "balancer all", "balancer only-a", "proxy-b"},
+		},
+		{
+			name:          "down outbound with its chain, rules and balancers",
+			exclude:       true,
+			down:          []string{"proxy-a"},
+			wantOutbounds: []string{"direct", "proxy-b", downOutboundTag},
+			wantBalancers: []string{"all"},
+			wantFallback:  downOutboundTag,
+			wantRules:     []string{downOutboundTag, downOutboundTag, "balancer all", downOutboundTag, "proxy-b"},
+		},
+		{
+			name:          "default outbound down",
+			exclude:       true,
+			down:          []string{"direct"},
+			wantOutbounds: []string{"direct", "proxy-a", "proxy-b", "chained", "chained-twice"},
+			wantBalancers: []string{"all", "only-a"},
+			wantFallback:  "proxy-a",
+			wantRules:     []string{"proxy-a", "chained-twice", "balancer all", "balancer only-a", "proxy-b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.settingService.SetOutboundHealthExclude(tt.exclude); err != nil {
+				t.Fatal(err)
+			}
+			outboundHealthMu.Lock()
+			outboundHealth = map[string]OutboundHealth{}
+			for _, tag := range tt.down {
+				outboundHealth[tag] = OutboundHealth{Failures: outboundDownAfter}
+			}
+			outboundHealthMu.Unlock()
+
+			xrayConfig := &xray.Config{OutboundConfigs: []byte(outbounds), RouterConfig: []byte(routing)}
+			if err := s.applyOutboundHealth(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+			gotOutbounds, err := getOutbounds(xrayConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for _, outbound := range gotOutbounds {
+				tags = append(tags, outbound["tag"].(string))
+			}
+			if !reflect.DeepEqual(tags, tt.wantOutbounds) {
+				t.Fatalf("got outbounds %v, want %v", tags, tt.wantOutbounds)
+			}
+
+			gotRouting, err := getRouting(xrayConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var balancers []string
+			fallback := ""
+			for _, balancer := range gotRouting["balancers"].([]interface{}) {
+				b := balancer.(map[string]interface{})
+				balancers = append(balancers, b["tag"].(string))
+				if b["tag"] == "all" {
+					fallback, _ = b["fallbackTag"].(string)
+				}
+			}
+			if !reflect.DeepEqual(balancers, tt.wantBalancers) || fallback != tt.wantFallback {
+				t.Fatalf("got balancers %v with fallback %q, want %v with %q", balancers, fallback, tt.wantBalancers, tt.wantFallback)
+			}
+			var rules []string
+			for _, rule := range gotRouting["rules"].([]interface{}) {
+				r := rule.(map[string]interface{})
+				if tag, ok := r["balancerTag"].(string); ok {
+					rules = append(rules, "balancer "+tag)
+				} else {
+					rules = append(rules, r["outboundTag"].(string))
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Fatalf("got rules %v, want %v", rules, tt.wantRules)
+			}
+		})
+	}
+}
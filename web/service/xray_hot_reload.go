@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// configChange is what the API has to do to turn the running config into a new one
+type configChange struct {
+	delInbounds  []string
+	addInbounds  [][]byte
+	delOutbounds []string
+	addOutbounds [][]byte
+}
+
+// diffConfigs returns the inbounds and outbounds to remove and add, or false when anything else
+// changed, which only a restart applies
+func diffConfigs(old, new *xray.Config) (*configChange, bool) {
+	for _, pair := range [][2][]byte{
+		{old.LogConfig, new.LogConfig}, {old.RouterConfig, new.RouterConfig}, {old.DNSConfig, new.DNSConfig},
+		{old.Transport, new.Transport}, {old.Policy, new.Policy}, {old.API, new.API}, {old.Stats, new.Stats},
+		{old.Reverse, new.Reverse}, {old.FakeDNS, new.FakeDNS}, {old.Observatory, new.Observatory},
+		{old.BurstObservatory, new.BurstObservatory},
+	} {
+		if !bytes.Equal(pair[0], pair[1]) {
+			return nil, false
+		}
+	}
+	change := &configChange{}
+
+	oldInbounds := make(map[string]*xray.InboundConfig, len(old.InboundConfigs))
+	for i := range old.InboundConfigs {
+		inbound := &old.InboundConfigs[i]
+		if inbound.Tag == "" {
+			return nil, false
+		}
+		oldInbounds[inbound.Tag] = inbound
+	}
+	newTags := make(map[string]bool, len(new.InboundConfigs))
+	for i := range new.InboundConfigs {
+		inbound := &new.InboundConfigs[i]
+		if inbound.Tag == "" {
+			return nil, false
+		}
+		newTags[inbound.Tag] = true
+		oldInbound, ok := oldInbounds[inbound.Tag]
+		if ok && oldInbound.Equals(inbound) {
+			continue
+		}
+		// The api inbound is what the API is reached through
+		if inbound.Tag == statsAPITag {
+			return nil, false
+		}
+		data, err := json.Marshal(inbound)
+		if err != nil {
+			return nil, false
+		}
+		if ok {
+			change.delInbounds = append(change.delInbounds, inbound.Tag)
+		}
+		change.addInbounds = append(change.addInbounds, data)
+	}
+	for i := range old.InboundConfigs {
+		if tag := old.InboundConfigs[i].Tag; !newTags[tag] {
+			if tag == statsAPITag {
+				return nil, false
+			}
+			change.delInbounds = append(change.delInbounds, tag)
+		}
+	}
+
+	if bytes.Equal(old.OutboundConfigs, new.OutboundConfigs) {
+		return change, true
+	}
+	oldOutbounds, err := getOutbounds(old)
+	if err != nil {
+		return nil, false
+	}
+	newOutbounds, err := getOutbounds(new)
+	if err != nil {
+		return nil, false
+	}
+	encode := func(outbounds []map[string]interface{}) (map[string][]byte, []string, bool) {
+		encoded := make(map[string][]byte, len(outbounds))
+		tags := make([]string, 0, len(outbounds))
+		for _, outbound := range outbounds {
+			tag, _ := outbound["tag"].(string)
+			data, err := json.Marshal(outbound)
+			if tag == "" || err != nil {
+				return nil, nil, false
+			}
+			encoded[tag] = data
+			tags = append(tags, tag)
+		}
+		return encoded, tags, true
+	}
+	oldEncoded, oldTags, ok := encode(oldOutbounds)
+	if !ok {
+		return nil, false
+	}
+	newEncoded, newOrder, ok := encode(newOutbounds)
+	if !ok {
+		return nil, false
+	}
+	// The first outbound is the default one, an added outbound would go last
+	if len(oldTags) == 0 || len(newOrder) == 0 || oldTags[0] != newOrder[0] ||
+		!bytes.Equal(oldEncoded[oldTags[0]], newEncoded[newOrder[0]]) {
+		return nil, false
+	}
+	for _, tag := range newOrder {
+		oldData, ok := oldEncoded[tag]
+		if ok && bytes.Equal(oldData, newEncoded[tag]) {
+			continue
+		}
+		if ok {
+			change.delOutbounds = append(change.delOutbounds, tag)
+		}
+		change.addOutbounds = append(change.addOutbounds, newEncoded[tag])
+	}
+	for _, tag := range oldTags {
+		if _, ok := newEncoded[tag]; !ok {
+			change.delOutbounds = append(change.delOutbounds, tag)
+		}
+	}
+	return change, true
+}
+
+// hotReload applies a config that only changes inbounds and outbounds through the API, so the
+// connections of everything else stay up. It is off until xrayHotReload is turned on, a removed
+// inbound or outbound takes its unsaved traffic counters with it. The caller holds the lock. It
+// reports whether the config was applied, a restart is needed otherwise.
+func (s *XrayService) hotReload(xrayConfig *xray.Config) bool {
+	enabled, err := s.settingService.GetXrayHotReload()
+	if err != nil || !enabled {
+		return false
+	}
+	process := mainXrayProcess()
+	change, ok := diffConfigs(process.GetConfig(), xrayConfig)
+	if !ok {
+		return false
+	}
+	if err := s.applyConfigChange(change); err != nil {
+		logger.Warning("Failed to apply the config by api, restarting xray:", err)
+		return false
+	}
+	process.SetConfig(xrayConfig)
+	logger.Infof("Xray config applied by api: %d inbounds and %d outbounds removed, %d inbounds and %d outbounds added",
+		len(change.delInbounds), len(change.delOutbounds), len(change.addInbounds), len(change.addOutbounds))
+	return true
+}
+
+func (s *XrayService) applyConfigChange(change *configChange) error {
+	err := s.xrayAPI.Init(mainXrayProcess().GetAPIPort())
+	if err != nil {
+		return err
+	}
+	defer s.xrayAPI.Close()
+	for _, tag := range change.delInbounds {
+		if err := s.xrayAPI.DelInbound(tag); err != nil {
+			return common.NewErrorf("remove inbound %s: %v", tag, err)
+		}
+	}
+	for _, tag := range change.delOutbounds {
+		if err := s.xrayAPI.DelOutbound(tag); err != nil {
+			return common.NewErrorf("remove outbound %s: %v", tag, err)
+		}
+	}
+	for _, data := range change.addOutbounds {
+		if err := s.xrayAPI.AddOutbound(data); err != nil {
+			return common.NewErrorf("add outbound: %v", err)
+		}
+	}
+	for _, data := range change.addInbounds {
+		if err := s.xrayAPI.AddInbound(data); err != nil {
+			return common.NewErrorf("add inbound: %v", err)
+		}
+	}
+	return nil
+}
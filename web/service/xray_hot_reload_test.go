@@ -0,0 +1,103 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	inbound := func(tag string, port int) xray.InboundConfig {
+		return xray.InboundConfig{Tag: tag, Port: port, Protocol: "vless"}
+	}
+	base := func() *xray.Config {
+		return &xray.Config{
+			LogConfig:       []byte(`{"loglevel": "warning"}`),
+			RouterConfig:    []byte(`{"rules": []}`),
+			InboundConfigs:  []xray.InboundConfig{inbound(statsAPITag, 62789), inbound("inbound-20001", 20001)},
+			OutboundConfigs: []byte(`[{"tag": "direct", "protocol": "freedom"}, {"tag": "blocked", "protocol": "blackhole"}]`),
+		}
+	}
+	type want struct {
+		delInbounds  []string
+		addInbounds  int
+		delOutbounds []string
+		addOutbounds int
+	}
+	tests := []struct {
+		name   string
+		change func(c *xray.Config)
+		ok     bool
+		want   want
+	}{
+		{name: "unchanged", change: func(c *xray.Config) {}, ok: true},
+		{name: "inbound added", change: func(c *xray.Config) {
+			c.InboundConfigs = append(c.InboundConfigs, inbound("inbound-20002", 20002))
+		}, ok: true, want: want{addInbounds: 1}},
+		{name: "inbound changed", change: func(c *xray.Config) {
+			c.InboundConfigs[1].Port = 20003
+		}, ok: true, want: want{delInbounds: []string{"inbound-20001"}, addInbounds: 1}},
+		{name: "inbound removed", change: func(c *xray.Config) {
+			c.InboundConfigs = c.InboundConfigs[:1]
+		}, ok: true, want: want{delInbounds: []string{"inbound-20001"}}},
+		{name: "outbound added", change: func(c *xray.Config) {
+			c.OutboundConfigs = []byte(`[{"tag": "direct", "protocol": "freedom"}, {"tag": "blocked", "protocol": "blackhole"}, {"tag": "warp", "protocol": "wireguard"}]`)
+		}, ok: true, want: want{addOutbounds: 1}},
+		{name: "outbound changed and removed", change: func(c *xray.Config) {
+			c.OutboundConfigs = []byte(`[{"tag": "direct", "protocol": "freedom"}, {"tag": "proxy", "protocol": "vless"}]`)
+		}, ok: true, want: want{delOutbounds: []string{"blocked"}, addOutbounds: 1}},
+		{name: "outbounds reformatted", change: func(c *xray.Config) {
+			c.OutboundConfigs = []byte(`[{"protocol": "freedom", "tag": "direct"}, {"protocol": "blackhole", "tag": "blocked"}]`)
+		}, ok: true},
+		{name: "routing changed", change: func(c *xray.Config) {
+			c.RouterConfig = []byte(`{"rules": [{"outboundTag": "blocked", "ip": ["geoip:private"]}]}`)
+		}},
+		{name: "default outbound changed", change: func(c *xray.Config) {
+			c.OutboundConfigs = []byte(`[{"tag": "blocked", "protocol": "blackhole"}, {"tag": "direct", "protocol": "freedom"}]`)
+		}},
+		{name: "api inbound changed", change: func(c *xray.Config) {
+			c.InboundConfigs[0].Port = 62790
+		}},
+		{name: "api inbound removed", change: func(c *xray.Config) {
+			c.InboundConfigs = c.InboundConfigs[1:]
+		}},
+		{name: "untagged inbound", change: func(c *xray.Config) {
+			c.InboundConfigs = append(c.InboundConfigs, inbound("", 20002))
+		}},
+		{name: "untagged outbound", change: func(c *xray.Config) {
+			c.OutboundConfigs = []byte(`[{"tag": "direct", "protocol": "freedom"}, {"protocol": "blackhole"}]`)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newConfig := base()
+			tt.change(newConfig)
+			change, ok := diffConfigs(base(), newConfig)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			got := want{
+				delInbounds:  change.delInbounds,
+				addInbounds:  len(change.addInbounds),
+				delOutbounds: change.delOutbounds,
+				addOutbounds: len(change.addOutbounds),
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHotReloadOffByDefault(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	// The setting is read before the running config, so no process is needed
+	if s.hotReload(&xray.Config{}) {
+		t.Fatal("hot reload applied a config while it is off")
+	}
+}
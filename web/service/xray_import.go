@@ -0,0 +1,154 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/xray"
+)
+
+// ImportedInbound is the outcome of importing one inbound of an Xray config
+type ImportedInbound struct {
+	Tag      string `json:"tag"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Id       int    `json:"id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type ImportResult struct {
+	Imported []ImportedInbound `json:"imported"`
+	Skipped  []ImportedInbound `json:"skipped"`
+	Failed   []ImportedInbound `json:"failed"`
+}
+
+var importableProtocols = map[model.Protocol]bool{
+	model.VMESS: true, model.VLESS: true, model.Trojan: true, model.Shadowsocks: true,
+	model.DOKODEMO: true, model.Socks: true, model.HTTP: true, model.WireGuard: true,
+}
+
+// ImportXrayConfig creates panel inbounds from the inbounds of a standard Xray config.
+// The api inbound and unsupported protocols are skipped, inbounds the panel rejects are reported as failed.
+func (s *XrayService) ImportXrayConfig(config string) (ImportResult, error) {
+	result := ImportResult{
+		Imported: []ImportedInbound{},
+		Skipped:  []ImportedInbound{},
+		Failed:   []ImportedInbound{},
+	}
+	xrayConfig := &xray.Config{}
+	err := json.Unmarshal([]byte(config), xrayConfig)
+	if err != nil {
+		return result, common.NewErrorf("invalid xray config: %v", err)
+	}
+	userService := UserService{}
+	user, err := userService.GetFirstUser()
+	if err != nil {
+		return result, err
+	}
+
+	needRestart := false
+	for i := range xrayConfig.InboundConfigs {
+		inboundConfig := &xrayConfig.InboundConfigs[i]
+		item := ImportedInbound{Tag: inboundConfig.Tag, Port: inboundConfig.Port, Protocol: inboundConfig.Protocol}
+		if inboundConfig.Tag == statsAPITag {
+			item.Reason = "api inbound is managed by the panel"
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+		if !importableProtocols[model.Protocol(inboundConfig.Protocol)] {
+			item.Reason = "unsupported protocol"
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+
+		inbound, err := importInbound(inboundConfig)
+		if err == nil {
+			inbound.UserId = user.Id
+			var restart bool
+			inbound, restart, err = s.inboundService.AddInbound(inbound)
+			needRestart = needRestart || restart
+		}
+		if err != nil {
+			item.Reason = err.Error()
+			result.Failed = append(result.Failed, item)
+			logger.Warningf("Failed to import inbound %s: %v", item.Tag, err)
+			continue
+		}
+		item.Id = inbound.Id
+		item.Tag = inbound.Tag
+		result.Imported = append(result.Imported, item)
+	}
+	if needRestart {
+		s.SetToNeedRestart()
+	}
+	return result, nil
+}
+
+// importInbound converts an xray inbound into a panel inbound, filling the client fields the panel relies on
+func importInbound(inboundConfig *xray.InboundConfig) (*model.Inbound, error) {
+	listen := ""
+	if len(inboundConfig.Listen) > 0 {
+		if err := json.Unmarshal(inboundConfig.Listen, &listen); err != nil {
+			return nil, common.NewErrorf("invalid listen: %v", err)
+		}
+	}
+	tag := inboundConfig.Tag
+	if tag == "" {
+		if listen == "" || listen == "0.0.0.0" || listen == "::" || listen == "::0" {
+			tag = fmt.Sprintf("inbound-%v", inboundConfig.Port)
+		} else {
+			tag = fmt.Sprintf("inbound-%v:%v", listen, inboundConfig.Port)
+		}
+	}
+
+	settings := map[string]interface{}{}
+	if len(inboundConfig.Settings) > 0 {
+		if err := json.Unmarshal(inboundConfig.Settings, &settings); err != nil {
+			return nil, common.NewErrorf("invalid settings: %v", err)
+		}
+	}
+	if clients, ok := settings["clients"].([]interface{}); ok {
+		for j, client := range clients {
+			c, ok := client.(map[string]interface{})
+			if !ok {
+				return nil, common.NewErrorf("invalid client %d", j)
+			}
+			if email, _ := c["email"].(string); email == "" {
+				c["email"] = fmt.Sprintf("%s-%d", tag, j+1)
+			}
+			if _, ok := c["enable"]; !ok {
+				c["enable"] = true
+			}
+			if _, ok := c["subId"]; !ok {
+				c["subId"] = random.Seq(16)
+			}
+			for _, key := range []string{"limitIp", "totalGB", "expiryTime", "tgId", "reset"} {
+				if _, ok := c[key]; !ok {
+					c[key] = 0
+				}
+			}
+		}
+		settings["clients"] = clients
+	}
+	settingsJson, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Inbound{
+		Remark:         tag,
+		Enable:         true,
+		Listen:         listen,
+		Port:           inboundConfig.Port,
+		Protocol:       model.Protocol(inboundConfig.Protocol),
+		Settings:       string(settingsJson),
+		StreamSettings: string(inboundConfig.StreamSettings),
+		Tag:            tag,
+		Sniffing:       string(inboundConfig.Sniffing),
+		Allocate:       string(inboundConfig.Allocate),
+	}, nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+const importTestConfig = `{
+  "inbounds": [
+    {"tag": "api", "listen": "127.0.0.1", "port": 62789, "protocol": "dokodemo-door", "settings": {"address": "127.0.0.1"}},
+    {"tag": "vless-in", "port": 20001, "protocol": "vless",
+     "settings": {"clients": [{"id": "27848739-7e62-4138-9fd3-098a63964b6b", "email": "alice"}, {"id": "b831381d-6324-4d53-ad4f-8cda48b30811"}], "decryption": "none"},
+     "streamSettings": {"network": "tcp"}},
+    {"listen": "127.0.0.2", "port": 20002, "protocol": "socks", "settings": {"auth": "noauth", "udp": true}},
+    {"tag": "mixed-in", "port": 20003, "protocol": "mixed", "settings": {}},
+    {"tag": "taken", "port": 20001, "protocol": "trojan", "settings": {"clients": [{"password": "secret", "email": "bob"}]}}
+  ]
+}`
+
+func TestImportXrayConfig(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	// AddInbound pushes enabled inbounds to the api of the process, a stopped one has none
+	setMainXrayProcess(t, xray.NewProcess(&xray.Config{}))
+	t.Cleanup(func() {
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	result, err := s.ImportXrayConfig(importTestConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := func(items []ImportedInbound) []string {
+		list := []string{}
+		for _, item := range items {
+			list = append(list, item.Tag)
+		}
+		return list
+	}
+	tests := []struct {
+		name  string
+		items []ImportedInbound
+		want  []string
+	}{
+		{"imported", result.Imported, []string{"vless-in", "inbound-127.0.0.2:20002"}},
+		{"skipped", result.Skipped, []string{"api", "mixed-in"}},
+		{"failed", result.Failed, []string{"taken"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tags(tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, item := range tt.items {
+				if tt.name == "imported" && item.Id == 0 {
+					t.Fatalf("imported inbound %s has no id", item.Tag)
+				}
+				if tt.name != "imported" && item.Reason == "" {
+					t.Fatalf("%s inbound %s has no reason", tt.name, item.Tag)
+				}
+			}
+		})
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("import did not request a restart")
+	}
+
+	// Clients get the fields the panel relies on
+	inboundService := InboundService{}
+	inbound, err := inboundService.GetInbound(result.Imported[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings struct {
+		Clients []map[string]interface{} `json:"clients"`
+	}
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if len(settings.Clients) != 2 {
+		t.Fatalf("got %d clients, want 2", len(settings.Clients))
+	}
+	if settings.Clients[0]["email"] != "alice" || settings.Clients[1]["email"] != "vless-in-2" {
+		t.Fatalf("got client emails %v and %v", settings.Clients[0]["email"], settings.Clients[1]["email"])
+	}
+	for _, client := range settings.Clients {
+		if client["enable"] != true || client["subId"] == "" {
+			t.Fatalf("client %v is missing enable or subId", client)
+		}
+	}
+	var traffics []xray.ClientTraffic
+	if err := database.GetDB().Where("inbound_id = ?", inbound.Id).Find(&traffics).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(traffics) != 2 {
+		t.Fatalf("got %d client traffic rows, want 2", len(traffics))
+	}
+}
+
+func TestImportXrayConfigInvalid(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	_, err := s.ImportXrayConfig(`{"inbounds": [`)
+	if err == nil || !strings.Contains(err.Error(), "invalid xray config") {
+		t.Fatalf("got error %v, want an invalid config error", err)
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// XrayInstance is an Xray process run next to the main one, to keep the inbounds of a tenant
+// apart. It is built from the template of Profile with the inbounds of InboundTags, which the
+// main config leaves out while the instance is enabled.
+type XrayInstance struct {
+	Name        string   `json:"name"`
+	Profile     string   `json:"profile"`
+	InboundTags []string `json:"inboundTags"`
+	Enable      bool     `json:"enable"`
+}
+
+// XrayInstanceStatus is an instance with the state of its process
+type XrayInstanceStatus struct {
+	XrayInstance
+	Running bool   `json:"running"`
+	Pid     int    `json:"pid"`
+	ApiPort int    `json:"apiPort"`
+	Uptime  uint64 `json:"uptime"`
+	Result  string `json:"result"`
+}
+
+// Serializes the starts and stops of the instances, like lock does for the main Xray
+var instanceLock sync.Mutex
+
+func (s *XrayService) GetXrayInstances() ([]XrayInstance, error) {
+	instances := []XrayInstance{}
+	data, err := s.settingService.GetXrayInstances()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return instances, nil
+	}
+	err = json.Unmarshal([]byte(data), &instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (s *XrayService) saveXrayInstances(instances []XrayInstance) error {
+	data, err := json.MarshalIndent(instances, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetXrayInstances(string(data))
+	if err != nil {
+		return err
+	}
+	// The main config gains or loses the inbounds of the instance
+	s.SetToNeedRestart()
+	return nil
+}
+
+func (s *XrayService) getXrayInstance(name string) (*XrayInstance, error) {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return nil, err
+	}
+	for i := range instances {
+		if instances[i].Name == name {
+			return &instances[i], nil
+		}
+	}
+	return nil, common.NewErrorf("xray instance %s does not exist", name)
+}
+
+// SetXrayInstance adds or replaces the instance with the same name. An inbound belongs to one
+// instance at most, a running instance picks the change up with its next restart.
+func (s *XrayService) SetXrayInstance(instance XrayInstance) error {
+	if !templateProfileNameRegex.MatchString(instance.Name) {
+		return common.NewErrorf("invalid xray instance name %q", instance.Name)
+	}
+	settingService := XraySettingService{s.settingService}
+	if _, err := settingService.GetTemplateProfile(instance.Profile); err != nil {
+		return err
+	}
+	if len(instance.InboundTags) == 0 {
+		return common.NewError("an xray instance needs at least one inbound")
+	}
+	var count int64
+	err := database.GetDB().Model(model.Inbound{}).Where("tag in ?", instance.InboundTags).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if int(count) != len(instance.InboundTags) {
+		return common.NewError("some inbounds of the xray instance do not exist")
+	}
+
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range instances {
+		if instances[i].Name == instance.Name {
+			instances[i] = instance
+			replaced = true
+			continue
+		}
+		for _, tag := range instance.InboundTags {
+			if slices.Contains(instances[i].InboundTags, tag) {
+				return common.NewErrorf("inbound %s already belongs to xray instance %s", tag, instances[i].Name)
+			}
+		}
+	}
+	if !replaced {
+		instances = append(instances, instance)
+	}
+	if !instance.Enable {
+		s.dropXrayInstance(instance.Name)
+	}
+	return s.saveXrayInstances(instances)
+}
+
+func (s *XrayService) RemoveXrayInstance(name string) error {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return err
+	}
+	for i := range instances {
+		if instances[i].Name == name {
+			s.dropXrayInstance(name)
+			return s.saveXrayInstances(append(instances[:i], instances[i+1:]...))
+		}
+	}
+	return common.NewErrorf("xray instance %s does not exist", name)
+}
+
+// mainInbounds selects the enabled inbounds that no enabled instance runs
+func (s *XrayService) mainInbounds() (func(inbound *model.Inbound) bool, error) {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return nil, err
+	}
+	elsewhere := map[string]bool{}
+	for _, instance := range instances {
+		if !instance.Enable {
+			continue
+		}
+		for _, tag := range instance.InboundTags {
+			elsewhere[tag] = true
+		}
+	}
+	return func(inbound *model.Inbound) bool {
+		return inbound.Enable && !elsewhere[inbound.Tag]
+	}, nil
+}
+
+// genXrayInstanceConfig builds the config of the instance. Its api inbound keeps the port the
+// instance runs with, so an unchanged instance compares equal.
+func (s *XrayService) genXrayInstanceConfig(instance *XrayInstance) (*xray.Config, error) {
+	settingService := XraySettingService{s.settingService}
+	xrayConfig, _, err := s.genXrayConfigReport(func() (string, error) {
+		return settingService.GetTemplateProfile(instance.Profile)
+	}, func(inbound *model.Inbound) bool {
+		return inbound.Enable && slices.Contains(instance.InboundTags, inbound.Tag)
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	if api := getAPIInbound(xrayConfig); api != nil {
+		if running := xrayProcesses.Get(instance.Name); running != nil && running.IsRunning() {
+			api.Port = running.GetAPIPort()
+		}
+	}
+	return xrayConfig, nil
+}
+
+// xrayInstancePorts returns the inbound ports of the enabled instances, from their templates and
+// inbounds, whether they run yet or not
+func (s *XrayService) xrayInstancePorts() ([]int, error) {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return nil, err
+	}
+	var ports []int
+	for i := range instances {
+		if !instances[i].Enable {
+			continue
+		}
+		xrayConfig, err := s.genXrayInstanceConfig(&instances[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, inbound := range xrayConfig.InboundConfigs {
+			ports = append(ports, inbound.Port)
+		}
+	}
+	return ports, nil
+}
+
+// StartXrayInstance starts the instance, or restarts it when its config changed
+func (s *XrayService) StartXrayInstance(name string) error {
+	instance, err := s.getXrayInstance(name)
+	if err != nil {
+		return err
+	}
+	return s.restartXrayInstance(instance, false)
+}
+
+func (s *XrayService) RestartXrayInstance(name string) error {
+	instance, err := s.getXrayInstance(name)
+	if err != nil {
+		return err
+	}
+	return s.restartXrayInstance(instance, true)
+}
+
+func (s *XrayService) restartXrayInstance(instance *XrayInstance, isForce bool) error {
+	if !instance.Enable {
+		return common.NewErrorf("xray instance %s is disabled", instance.Name)
+	}
+	xrayConfig, err := s.genXrayInstanceConfig(instance)
+	if err != nil {
+		return err
+	}
+
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	if running := xrayProcesses.Get(instance.Name); running != nil && running.IsRunning() {
+		if !isForce && running.GetConfig().Equals(xrayConfig) {
+			return nil
+		}
+		if err := running.Stop(); err != nil {
+			logger.Errorf("Error stopping xray instance %s: %v", instance.Name, err)
+		}
+	}
+	// The port of the template is the one of the main Xray
+	if api := getAPIInbound(xrayConfig); api != nil {
+		if api.Port, err = freeLocalPort(); err != nil {
+			return err
+		}
+	}
+	process := xray.NewInstanceProcess(instance.Name, xrayConfig)
+	process.SetLimits(s.getResourceLimits())
+	xrayProcesses.Set(instance.Name, process)
+	if err = process.Start(); err != nil {
+		logger.Errorf("Error starting xray instance %s: %v", instance.Name, err)
+		return err
+	}
+	logger.Infof("Started xray instance %s", instance.Name)
+	return nil
+}
+
+// StopXrayInstance stops the instance on purpose, the watchdog does not restart it
+func (s *XrayService) StopXrayInstance(name string) error {
+	if name == mainXray {
+		return common.NewError("the main xray is not an instance")
+	}
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	if xrayProcesses.Get(name) == nil {
+		return common.NewErrorf("xray instance %s is not running", name)
+	}
+	return xrayProcesses.Stop(name)
+}
+
+// dropXrayInstance stops the process of a removed or disabled instance and forgets it
+func (s *XrayService) dropXrayInstance(name string) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	xrayProcesses.Remove(name)
+}
+
+// RestartXrayInstances brings the instances in line with their settings. The ones removed or
+// disabled are dropped, the running ones restarted when their config changed. isForce starts all
+// enabled instances, like the panel does at startup.
+func (s *XrayService) RestartXrayInstances(isForce bool) {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		logger.Warning("Failed to read the xray instances:", err)
+		return
+	}
+	enabled := map[string]bool{}
+	for _, instance := range instances {
+		enabled[instance.Name] = instance.Enable
+	}
+	for _, name := range xrayProcesses.Names() {
+		if name != mainXray && !enabled[name] {
+			s.dropXrayInstance(name)
+		}
+	}
+	for i := range instances {
+		if !instances[i].Enable || (!isForce && !xrayProcesses.IsRunning(instances[i].Name)) {
+			continue
+		}
+		if err := s.restartXrayInstance(&instances[i], isForce); err != nil {
+			logger.Warningf("Restart xray instance %s failed: %v", instances[i].Name, err)
+		}
+	}
+}
+
+// StopXrayInstances stops all instances, when the panel shuts down
+func (s *XrayService) StopXrayInstances() {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	for _, name := range xrayProcesses.Names() {
+		if name != mainXray {
+			xrayProcesses.Remove(name)
+		}
+	}
+}
+
+func (s *XrayService) GetXrayInstanceStatuses() ([]XrayInstanceStatus, error) {
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]XrayInstanceStatus, 0, len(instances))
+	for _, instance := range instances {
+		status := XrayInstanceStatus{XrayInstance: instance}
+		if process := xrayProcesses.Get(instance.Name); process != nil {
+			status.Running = process.IsRunning()
+			status.Result = process.GetResult()
+			if status.Running {
+				status.Pid = process.GetPid()
+				status.ApiPort = process.GetAPIPort()
+				status.Uptime = process.GetUptime()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
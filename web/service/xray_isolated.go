@@ -0,0 +1,201 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+// How long a starting isolated Xray has to reject its config
+const isolatedStartWait = time.Second
+
+// IsolatedInstance is an Xray started apart from the main one, to reproduce a problem with a
+// config someone exported. Its inbounds listen on loopback ports picked for it.
+type IsolatedInstance struct {
+	Id    string         `json:"id"`
+	Pid   int            `json:"pid"`
+	Ports map[string]int `json:"ports"` // inbound tag -> port it listens on
+}
+
+type isolatedProcess struct {
+	cmd        *exec.Cmd
+	exited     chan struct{}
+	output     *isolatedOutput
+	configPath string
+	ports      []int
+}
+
+// isolatedOutput keeps the start of the instance output, written while it runs
+type isolatedOutput struct {
+	sync.Mutex
+	data []byte
+}
+
+func (o *isolatedOutput) Write(p []byte) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+	if room := 64*1024 - len(o.data); room > 0 {
+		o.data = append(o.data, p[:min(len(p), room)]...)
+	}
+	return len(p), nil
+}
+
+func (o *isolatedOutput) String() string {
+	o.Lock()
+	defer o.Unlock()
+	return strings.TrimSpace(string(o.data))
+}
+
+var (
+	isolatedMu        sync.Mutex
+	isolatedProcesses = map[string]*isolatedProcess{}
+	isolatedCount     int
+)
+
+// isolatedPort returns a free loopback port the main Xray does not use either, it may not listen yet
+func isolatedPort(taken map[int]bool) (int, error) {
+	for i := 0; i < 100; i++ {
+		port, err := freeLocalPort()
+		if err != nil {
+			return 0, err
+		}
+		if !taken[port] {
+			taken[port] = true
+			return port, nil
+		}
+	}
+	return 0, common.NewError("no free port for the isolated xray")
+}
+
+// StartIsolated runs the config in a separate Xray. Every inbound is moved to a loopback port
+// neither the main Xray, its instances nor another isolated one uses, and logs go to the instance output
+// instead of the panel log files. Stop it with StopIsolated.
+func (s *XrayService) StartIsolated(config string) (IsolatedInstance, error) {
+	instance := IsolatedInstance{Ports: map[string]int{}}
+	xrayConfig := &xray.Config{}
+	if err := json.Unmarshal([]byte(config), xrayConfig); err != nil {
+		return instance, common.NewErrorf("invalid config: %v", err)
+	}
+
+	taken := map[int]bool{}
+	if s.IsXrayRunning() {
+		for _, inbound := range mainXrayProcess().GetConfig().InboundConfigs {
+			taken[inbound.Port] = true
+		}
+	}
+	instancePorts, err := s.xrayInstancePorts()
+	if err != nil {
+		return instance, err
+	}
+	for _, port := range instancePorts {
+		taken[port] = true
+	}
+	isolatedMu.Lock()
+	for _, process := range isolatedProcesses {
+		for _, port := range process.ports {
+			taken[port] = true
+		}
+	}
+	isolatedMu.Unlock()
+
+	var ports []int
+	for i := range xrayConfig.InboundConfigs {
+		inbound := &xrayConfig.InboundConfigs[i]
+		port, err := isolatedPort(taken)
+		if err != nil {
+			return instance, err
+		}
+		inbound.Port = port
+		inbound.Listen = json_util.RawMessage(`"127.0.0.1"`)
+		tag := inbound.Tag
+		if tag == "" {
+			tag = fmt.Sprintf("inbound-%d", i)
+		}
+		instance.Ports[tag] = port
+		ports = append(ports, port)
+	}
+	xrayConfig.LogConfig = json_util.RawMessage(`{"loglevel": "warning"}`)
+
+	data, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return instance, err
+	}
+	file, err := os.CreateTemp("", "x-ui-isolated-*.json")
+	if err != nil {
+		return instance, err
+	}
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return instance, err
+	}
+
+	process := &isolatedProcess{
+		cmd:        exec.Command(xray.GetBinaryPath(), "-c", file.Name()),
+		exited:     make(chan struct{}),
+		output:     &isolatedOutput{},
+		configPath: file.Name(),
+		ports:      ports,
+	}
+	process.cmd.Stdout = process.output
+	process.cmd.Stderr = process.output
+	if err = process.cmd.Start(); err != nil {
+		os.Remove(file.Name())
+		return instance, err
+	}
+	go func() {
+		process.cmd.Wait()
+		os.Remove(process.configPath)
+		close(process.exited)
+	}()
+
+	select {
+	case <-process.exited:
+		return instance, common.NewErrorf("isolated xray exited: %s", process.output.String())
+	case <-time.After(isolatedStartWait):
+	}
+
+	isolatedMu.Lock()
+	isolatedCount++
+	instance.Id = fmt.Sprintf("isolated-%d", isolatedCount)
+	isolatedProcesses[instance.Id] = process
+	isolatedMu.Unlock()
+	instance.Pid = process.cmd.Process.Pid
+	logger.Infof("Started %s with pid %d", instance.Id, instance.Pid)
+	return instance, nil
+}
+
+// StopIsolated stops the isolated Xray and returns what it printed
+func (s *XrayService) StopIsolated(id string) (string, error) {
+	isolatedMu.Lock()
+	process, ok := isolatedProcesses[id]
+	delete(isolatedProcesses, id)
+	isolatedMu.Unlock()
+	if !ok {
+		return "", common.NewErrorf("isolated xray %s does not exist", id)
+	}
+
+	select {
+	case <-process.exited:
+	default:
+		process.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-process.exited:
+		case <-time.After(5 * time.Second):
+			process.cmd.Process.Kill()
+			<-process.exited
+		}
+	}
+	return process.output.String(), nil
+}
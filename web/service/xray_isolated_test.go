@@ -0,0 +1,99 @@
+package service
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIsolatedPort(t *testing.T) {
+	taken := map[int]bool{}
+	first, err := isolatedPort(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !taken[first] {
+		t.Fatalf("port %d was not marked taken", first)
+	}
+	second, err := isolatedPort(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatalf("got port %d twice", first)
+	}
+}
+
+func TestXrayInstancePorts(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	addTestInbound(t, 20002, "inbound-20002", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	ports, err := s.xrayInstancePorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 0 {
+		t.Fatalf("got ports %v without instances", ports)
+	}
+
+	if err := s.SetXrayInstance(XrayInstance{Name: "tenant", Profile: "default", InboundTags: []string{"inbound-20001"}, Enable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetXrayInstance(XrayInstance{Name: "off", Profile: "default", InboundTags: []string{"inbound-20002"}}); err != nil {
+		t.Fatal(err)
+	}
+	ports, err = s.xrayInstancePorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(ports)
+	// The api inbound of the template and the inbound of the enabled instance
+	if want := []int{20001, 62789}; !reflect.DeepEqual(ports, want) {
+		t.Fatalf("got ports %v, want %v", ports, want)
+	}
+}
+
+func TestStartIsolated(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	config := `{"inbounds": [{"tag": "socks-in", "port": 1080, "protocol": "socks"}, {"port": 1081, "protocol": "http"}], "outbounds": [{"protocol": "freedom"}]}`
+
+	if _, err := s.StartIsolated("{"); err == nil || !strings.Contains(err.Error(), "invalid config") {
+		t.Fatalf("got error %v for a broken config", err)
+	}
+
+	setStubXray(t, "#!/bin/sh\necho 'unknown protocol' >&2\nexit 23\n")
+	if _, err := s.StartIsolated(config); err == nil || !strings.Contains(err.Error(), "isolated xray exited: unknown protocol") {
+		t.Fatalf("got error %v for a config xray rejects", err)
+	}
+
+	setStubXray(t, "#!/bin/sh\necho 'xray started'\nexec sleep 30\n")
+	instance, err := s.StartIsolated(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Id == "" || instance.Pid == 0 {
+		t.Fatalf("got instance %+v", instance)
+	}
+	if len(instance.Ports) != 2 || instance.Ports["socks-in"] == 1080 || instance.Ports["inbound-1"] == 1081 {
+		t.Fatalf("got ports %v, want both inbounds moved", instance.Ports)
+	}
+	if instance.Ports["socks-in"] == instance.Ports["inbound-1"] {
+		t.Fatalf("both inbounds got port %d", instance.Ports["socks-in"])
+	}
+
+	output, err := s.StopIsolated(instance.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "xray started" {
+		t.Fatalf("got output %q", output)
+	}
+	if _, err := s.StopIsolated(instance.Id); err == nil {
+		t.Fatal("got no error stopping the instance twice")
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"x-ui/logger"
+
+	"go.uber.org/atomic"
+)
+
+var (
+	configLocked    atomic.Bool
+	isRestartQueued atomic.Bool
+)
+
+// LockConfig starts an editing session, restarts requested until UnlockConfig are queued instead
+func (s *XrayService) LockConfig() {
+	configLocked.Store(true)
+	// A restart requested just before locking waits for the unlock as well
+	if isNeedXrayRestart.CompareAndSwap(true, false) {
+		isRestartQueued.Store(true)
+	}
+	logger.Info("Xray config locked, restarts are deferred until unlock")
+}
+
+// UnlockConfig ends the editing session and applies the queued changes in one restart
+func (s *XrayService) UnlockConfig() error {
+	configLocked.Store(false)
+	if !isRestartQueued.CompareAndSwap(true, false) {
+		return nil
+	}
+	logger.Info("Xray config unlocked, applying queued changes")
+	// A pending flag would restart a second time for the same changes
+	isNeedXrayRestart.Store(false)
+	return s.RestartXray(false)
+}
+
+func (s *XrayService) IsConfigLocked() bool {
+	return configLocked.Load()
+}
@@ -0,0 +1,91 @@
+package service
+
+import "testing"
+
+func TestConfigLock(t *testing.T) {
+	tests := []struct {
+		name              string
+		pendingBeforeLock bool
+		// runs between LockConfig and UnlockConfig
+		whileLocked func(s *XrayService)
+		wantRestart bool
+	}{
+		{
+			name:        "restart request is deferred",
+			whileLocked: func(s *XrayService) { s.SetToNeedRestart() },
+			wantRestart: true,
+		},
+		{
+			name: "several requests restart once",
+			whileLocked: func(s *XrayService) {
+				s.SetToNeedRestart()
+				s.SetToNeedRestart()
+			},
+			wantRestart: true,
+		},
+		{
+			name:        "scheduled restart is deferred",
+			whileLocked: func(s *XrayService) { s.scheduledRestart() },
+			wantRestart: true,
+		},
+		{
+			name:              "request from before the lock is deferred",
+			pendingBeforeLock: true,
+			whileLocked:       func(s *XrayService) {},
+			wantRestart:       true,
+		},
+		{
+			name:        "nothing to apply",
+			whileLocked: func(s *XrayService) {},
+			wantRestart: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			addTestInbound(t, 20001, "inbound-20001", true)
+			s := &XrayService{}
+			// Standby restarts validate and cache the config, which shows the restart ran
+			if err := s.SetStandby(true); err != nil {
+				t.Fatal(err)
+			}
+			s.IsNeedRestartAndSetFalse()
+			t.Cleanup(func() {
+				configLocked.Store(false)
+				isRestartQueued.Store(false)
+				s.IsNeedRestartAndSetFalse()
+				standbyConfigLock.Lock()
+				standbyConfig = nil
+				standbyConfigLock.Unlock()
+			})
+
+			if tt.pendingBeforeLock {
+				s.SetToNeedRestart()
+			}
+			s.LockConfig()
+			if !s.IsConfigLocked() {
+				t.Fatal("config is not locked")
+			}
+			tt.whileLocked(s)
+			if s.IsNeedRestartAndSetFalse() {
+				t.Fatal("restart requested while the config is locked")
+			}
+			if s.GetStandbyConfig() != nil {
+				t.Fatal("xray restarted while the config is locked")
+			}
+
+			if err := s.UnlockConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if s.IsConfigLocked() {
+				t.Fatal("config is still locked")
+			}
+			if restarted := s.GetStandbyConfig() != nil; restarted != tt.wantRestart {
+				t.Fatalf("restarted %v, want %v", restarted, tt.wantRestart)
+			}
+			if s.IsNeedRestartAndSetFalse() {
+				t.Fatal("unlock left a second restart pending")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"x-ui/xray"
+)
+
+// Tag of the outbound all client traffic goes to in maintenance mode
+const maintenanceOutboundTag = "maintenance"
+
+// SetMaintenanceMode sends all client traffic to a blackhole that answers http requests with 403,
+// so clients see the service is down on purpose. The stats API keeps working.
+func (s *XrayService) SetMaintenanceMode(enable bool) error {
+	err := s.settingService.SetMaintenanceMode(enable)
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// applyMaintenanceMode routes every inbound but the stats API to the maintenance outbound,
+// right after the API rules so no template rule gets to them first
+func (s *XrayService) applyMaintenanceMode(xrayConfig *xray.Config) error {
+	enable, err := s.settingService.GetMaintenanceMode()
+	if err != nil || !enable {
+		return err
+	}
+
+	var inboundTags []string
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag != "" && inbound.Tag != statsAPITag {
+			inboundTags = append(inboundTags, inbound.Tag)
+		}
+	}
+	if len(inboundTags) == 0 {
+		return nil
+	}
+
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	tags := map[string]bool{}
+	for _, outbound := range outbounds {
+		outboundTag, _ := outbound["tag"].(string)
+		tags[outboundTag] = true
+	}
+	tag := maintenanceOutboundTag
+	for tags[tag] {
+		tag = "x-ui-" + tag
+	}
+	outbounds = append(outbounds, map[string]interface{}{
+		"tag":      tag,
+		"protocol": "blackhole",
+		"settings": map[string]interface{}{
+			"response": map[string]interface{}{"type": "http"},
+		},
+	})
+	if err = setOutbounds(xrayConfig, outbounds); err != nil {
+		return err
+	}
+
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["rules"].([]interface{})
+	at := 0
+	for at < len(existing) {
+		rule, _ := existing[at].(map[string]interface{})
+		ruleInboundTags, _ := rule["inboundTag"].([]interface{})
+		if !containsValue(ruleInboundTags, statsAPITag) {
+			break
+		}
+		at++
+	}
+	rule := map[string]interface{}{
+		"type":        "field",
+		"inboundTag":  inboundTags,
+		"outboundTag": tag,
+	}
+	merged := make([]interface{}, 0, len(existing)+1)
+	merged = append(merged, existing[:at]...)
+	merged = append(merged, rule)
+	merged = append(merged, existing[at:]...)
+	routing["rules"] = merged
+	return setRouting(xrayConfig, routing)
+}
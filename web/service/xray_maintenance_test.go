@@ -0,0 +1,69 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestApplyMaintenanceMode(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name      string
+		enable    bool
+		inbounds  []string
+		outbounds string
+		wantTag   string
+		wantAt    int
+	}{
+		{name: "off", enable: false, inbounds: []string{"inbound-20001"}, outbounds: `[{"tag": "direct", "protocol": "freedom"}]`},
+		{name: "only the api inbound", enable: true, inbounds: []string{"api"}, outbounds: `[{"tag": "direct", "protocol": "freedom"}]`},
+		{name: "after the api rule", enable: true, inbounds: []string{"api", "inbound-20001"}, outbounds: `[{"tag": "direct", "protocol": "freedom"}]`, wantTag: "maintenance", wantAt: 1},
+		{name: "taken tag", enable: true, inbounds: []string{"api", "inbound-20001"}, outbounds: `[{"tag": "maintenance", "protocol": "freedom"}]`, wantTag: "x-ui-maintenance", wantAt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetMaintenanceMode(tt.enable); err != nil {
+				t.Fatal(err)
+			}
+			if !s.IsNeedRestartAndSetFalse() {
+				t.Fatal("toggling maintenance mode did not ask for a restart")
+			}
+			xrayConfig := &xray.Config{
+				OutboundConfigs: []byte(tt.outbounds),
+				RouterConfig:    []byte(`{"rules": [{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}, {"type": "field", "ip": ["geoip:private"], "outboundTag": "blocked"}]}`),
+			}
+			for _, tag := range tt.inbounds {
+				xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, xray.InboundConfig{Tag: tag})
+			}
+			if err := s.applyMaintenanceMode(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+			rules := configRules(t, xrayConfig)
+			outbounds, err := getOutbounds(xrayConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantTag == "" {
+				if len(rules) != 2 || len(outbounds) != 1 {
+					t.Fatalf("got rules %v and outbounds %v, want them unchanged", rules, outbounds)
+				}
+				return
+			}
+			if len(rules) != 3 {
+				t.Fatalf("got rules %v, want 3", rules)
+			}
+			rule := rules[tt.wantAt]
+			if rule["outboundTag"] != tt.wantTag || !reflect.DeepEqual(rule["inboundTag"], []interface{}{"inbound-20001"}) {
+				t.Fatalf("got rule %v at %d", rule, tt.wantAt)
+			}
+			last := outbounds[len(outbounds)-1]
+			if last["tag"] != tt.wantTag || last["protocol"] != "blackhole" {
+				t.Fatalf("got outbound %v, want a %s blackhole", last, tt.wantTag)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+func getOutbounds(xrayConfig *xray.Config) ([]map[string]interface{}, error) {
+	outbounds := []map[string]interface{}{}
+	if len(xrayConfig.OutboundConfigs) == 0 {
+		return outbounds, nil
+	}
+	err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds)
+	if err != nil {
+		return nil, err
+	}
+	return outbounds, nil
+}
+
+func setOutbounds(xrayConfig *xray.Config, outbounds []map[string]interface{}) error {
+	data, err := json.MarshalIndent(outbounds, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.OutboundConfigs = data
+	return nil
+}
+
+// getTemplateOutboundTags returns the tags of the outbounds defined in the xray template
+func (s *XrayService) getTemplateOutboundTags() (map[string]bool, error) {
+	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig := &xray.Config{}
+	err = json.Unmarshal([]byte(templateConfig), xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool, len(outbounds))
+	for _, outbound := range outbounds {
+		if tag, ok := outbound["tag"].(string); ok && tag != "" {
+			tags[tag] = true
+		}
+	}
+	return tags, nil
+}
+
+// GetOutboundChains returns the configured chains as outbound tag -> dialer proxy tag
+func (s *XrayService) GetOutboundChains() (map[string]string, error) {
+	chains := map[string]string{}
+	data, err := s.settingService.GetOutboundChains()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return chains, nil
+	}
+	err = json.Unmarshal([]byte(data), &chains)
+	if err != nil {
+		return nil, err
+	}
+	return chains, nil
+}
+
+func (s *XrayService) saveOutboundChains(chains map[string]string) error {
+	data, err := json.MarshalIndent(chains, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetOutboundChains(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetOutboundChain routes the outbound tag through the proxyTag outbound using sockopt.dialerProxy
+func (s *XrayService) SetOutboundChain(tag string, proxyTag string) error {
+	if tag == "" || proxyTag == "" {
+		return common.NewError("outbound tag and proxy tag are required")
+	}
+	if tag == proxyTag {
+		return common.NewErrorf("outbound %s can not be chained to itself", tag)
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[tag] {
+		return common.NewErrorf("outbound %s does not exist", tag)
+	}
+	if !tags[proxyTag] {
+		return common.NewErrorf("outbound %s does not exist", proxyTag)
+	}
+
+	chains, err := s.GetOutboundChains()
+	if err != nil {
+		return err
+	}
+	chains[tag] = proxyTag
+	if err = checkOutboundChainCycle(chains, tag); err != nil {
+		return err
+	}
+	return s.saveOutboundChains(chains)
+}
+
+func (s *XrayService) RemoveOutboundChain(tag string) error {
+	chains, err := s.GetOutboundChains()
+	if err != nil {
+		return err
+	}
+	if _, ok := chains[tag]; !ok {
+		return common.NewErrorf("outbound %s is not chained", tag)
+	}
+	delete(chains, tag)
+	return s.saveOutboundChains(chains)
+}
+
+// checkOutboundChainCycle follows the chain starting at tag and fails if it returns to a visited outbound
+func checkOutboundChainCycle(chains map[string]string, tag string) error {
+	visited := map[string]bool{tag: true}
+	path := tag
+	for next, ok := chains[tag]; ok; next, ok = chains[next] {
+		path += " -> " + next
+		if visited[next] {
+			return common.NewErrorf("outbound chain has a cycle: %s", path)
+		}
+		visited[next] = true
+	}
+	return nil
+}
+
+// applyOutboundChains sets streamSettings.sockopt.dialerProxy on every chained outbound. A chain
+// whose proxy outbound is no longer in the config is skipped, Xray refuses a dangling dialerProxy.
+func (s *XrayService) applyOutboundChains(xrayConfig *xray.Config) error {
+	chains, err := s.GetOutboundChains()
+	if err != nil {
+		return err
+	}
+	if len(chains) == 0 {
+		return nil
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	tags := make(map[string]bool, len(outbounds))
+	for _, outbound := range outbounds {
+		if tag, ok := outbound["tag"].(string); ok && tag != "" {
+			tags[tag] = true
+		}
+	}
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		proxyTag, ok := chains[tag]
+		if !ok {
+			continue
+		}
+		if !tags[proxyTag] {
+			logger.Warningf("Skip outbound chain %s -> %s, the proxy outbound is missing", tag, proxyTag)
+			continue
+		}
+		stream, ok := outbound["streamSettings"].(map[string]interface{})
+		if !ok {
+			stream = map[string]interface{}{}
+			outbound["streamSettings"] = stream
+		}
+		sockopt, ok := stream["sockopt"].(map[string]interface{})
+		if !ok {
+			sockopt = map[string]interface{}{}
+			stream["sockopt"] = sockopt
+		}
+		sockopt["dialerProxy"] = proxyTag
+	}
+	return setOutbounds(xrayConfig, outbounds)
+}
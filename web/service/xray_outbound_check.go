@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// outboundTestURL answers with the caller IP, as "ip=<addr>" lines (cloudflare trace) or the bare address
+var outboundTestURL = "https://cloudflare.com/cdn-cgi/trace"
+
+const outboundTestTimeout = 15 * time.Second
+
+type OutboundTestResult struct {
+	Success   bool   `json:"success"`
+	LatencyMs int64  `json:"latencyMs"`
+	EgressIP  string `json:"egressIp"`
+	Error     string `json:"error,omitempty"`
+}
+
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// outboundTestConfig routes everything from a local http proxy inbound to the outbound
+func outboundTestConfig(outbound map[string]interface{}, port int) ([]byte, error) {
+	tested := make(map[string]interface{}, len(outbound))
+	for key, value := range outbound {
+		tested[key] = value
+	}
+	tested["tag"] = "test"
+	return json.MarshalIndent(map[string]interface{}{
+		"log": map[string]interface{}{"loglevel": "warning"},
+		"inbounds": []interface{}{map[string]interface{}{
+			"tag":      "test-in",
+			"listen":   "127.0.0.1",
+			"port":     port,
+			"protocol": "http",
+			"settings": map[string]interface{}{},
+		}},
+		"outbounds": []interface{}{tested},
+	}, "", "  ")
+}
+
+func parseEgressIP(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if ip, ok := strings.CutPrefix(strings.TrimSpace(line), "ip="); ok {
+			return ip
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(body)); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// requestThroughProxy fetches outboundTestURL through the http proxy and returns the latency and egress IP
+func requestThroughProxy(ctx context.Context, proxy string) (time.Duration, string, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return 0, "", err
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, err := http.NewRequestWithContext(ctx, "GET", outboundTestURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode/100 != 2 {
+		return latency, "", fmt.Errorf("test destination returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return latency, "", err
+	}
+	return latency, parseEgressIP(string(body)), nil
+}
+
+// TestOutbound starts a throwaway Xray with only the given outbound and requests the test destination
+// through it. Failing to connect is reported in the result, the error is for failing to run the test.
+// Outbounds chained to other outbounds cannot be tested alone.
+func (s *XrayService) TestOutbound(outbound map[string]interface{}) (OutboundTestResult, error) {
+	result := OutboundTestResult{}
+	if protocol, _ := outbound["protocol"].(string); protocol == "" {
+		return result, common.NewError("outbound has no protocol")
+	}
+	port, err := freeLocalPort()
+	if err != nil {
+		return result, err
+	}
+	data, err := outboundTestConfig(outbound, port)
+	if err != nil {
+		return result, err
+	}
+	file, err := os.CreateTemp("", "x-ui-outbound-test-*.json")
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboundTestTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, xray.GetBinaryPath(), "-c", file.Name())
+	output := &strings.Builder{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err = cmd.Start(); err != nil {
+		return result, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	proxy := fmt.Sprintf("127.0.0.1:%d", port)
+	for {
+		conn, err := net.DialTimeout("tcp", proxy, 200*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		select {
+		case <-exited:
+			return result, fmt.Errorf("xray rejected the outbound: %s", strings.TrimSpace(output.String()))
+		case <-ctx.Done():
+			return result, common.NewError("xray did not start in time")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	latency, egressIP, err := requestThroughProxy(ctx, "http://"+proxy)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Success = true
+	result.LatencyMs = latency.Milliseconds()
+	result.EgressIP = egressIP
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestParseEgressIP(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"cloudflare trace", "fl=1f1\nh=cloudflare.com\nip=198.51.100.7\nts=1700000000.1\n", "198.51.100.7"},
+		{"bare ipv4", "198.51.100.7\n", "198.51.100.7"},
+		{"bare ipv6", "2001:db8::7", "2001:db8::7"},
+		{"html", "<html>blocked</html>", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEgressIP(tt.body); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboundTestConfig(t *testing.T) {
+	outbound := map[string]interface{}{"tag": "proxy", "protocol": "vless"}
+	data, err := outboundTestConfig(outbound, 20001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xrayConfig := &xray.Config{}
+	if err := json.Unmarshal(data, xrayConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(xrayConfig.InboundConfigs) != 1 || xrayConfig.InboundConfigs[0].Port != 20001 || xrayConfig.InboundConfigs[0].Protocol != "http" {
+		t.Fatalf("got inbounds %+v", xrayConfig.InboundConfigs)
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 1 || outbounds[0]["tag"] != "test" || outbounds[0]["protocol"] != "vless" {
+		t.Fatalf("got outbounds %v", outbounds)
+	}
+	// The caller's outbound keeps its tag
+	if outbound["tag"] != "proxy" {
+		t.Fatalf("outbound tag changed to %v", outbound["tag"])
+	}
+}
+
+func TestRequestThroughProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantIP    string
+		wantError string
+	}{
+		{name: "trace", status: http.StatusOK, body: "h=cloudflare.com\nip=198.51.100.7\n", wantIP: "198.51.100.7"},
+		{name: "unknown body", status: http.StatusOK, body: "hello", wantIP: ""},
+		{name: "error status", status: http.StatusForbidden, wantError: "test destination returned 403 Forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The stub proxy answers for the test destination itself
+			var requested string
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = r.URL.String()
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer proxy.Close()
+			testURL := outboundTestURL
+			outboundTestURL = "http://trace.example.com/cdn-cgi/trace"
+			defer func() { outboundTestURL = testURL }()
+
+			_, egressIP, err := requestThroughProxy(context.Background(), proxy.URL)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("got error %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requested != outboundTestURL {
+				t.Fatalf("proxy got %q, want %q", requested, outboundTestURL)
+			}
+			if egressIP != tt.wantIP {
+				t.Fatalf("got egress IP %q, want %q", egressIP, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestTestOutbound(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub xray is a shell script")
+	}
+	binFolder := t.TempDir()
+	t.Setenv("XUI_BIN_FOLDER", binFolder)
+	script := "#!/bin/sh\necho 'failed to build outbound config: unknown protocol' >&2\nexit 23\n"
+	if err := os.WriteFile(filepath.Join(binFolder, xray.GetBinaryName()), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &XrayService{}
+	tests := []struct {
+		name     string
+		outbound map[string]interface{}
+		wantErr  string
+	}{
+		{name: "no protocol", outbound: map[string]interface{}{"tag": "proxy"}, wantErr: "outbound has no protocol"},
+		{name: "rejected by xray", outbound: map[string]interface{}{"protocol": "nope"}, wantErr: "xray rejected the outbound: failed to build outbound config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.TestOutbound(tt.outbound)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	// The throwaway config is removed
+	leftover, _ := filepath.Glob(filepath.Join(os.TempDir(), "x-ui-outbound-test-*.json"))
+	if len(leftover) != 0 {
+		t.Fatalf("got leftover configs %v", leftover)
+	}
+}
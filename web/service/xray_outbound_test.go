@@ -0,0 +1,159 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+const chainTestTemplate = `{
+  "outbounds": [
+    {"tag": "direct", "protocol": "freedom"},
+    {"tag": "proxy", "protocol": "vless", "streamSettings": {"network": "tcp", "sockopt": {"mark": 255}}},
+    {"tag": "warp", "protocol": "wireguard"},
+    {"tag": "blocked", "protocol": "blackhole"}
+  ]
+}`
+
+func setChainTestTemplate(t *testing.T) {
+	t.Helper()
+	setupTestDB(t)
+	settingService := SettingService{}
+	if err := settingService.saveSetting("xrayTemplateConfig", chainTestTemplate); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOutboundChainCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		chains  map[string]string
+		tag     string
+		wantErr string
+	}{
+		{"single hop", map[string]string{"proxy": "warp"}, "proxy", ""},
+		{"two hops", map[string]string{"proxy": "warp", "warp": "direct"}, "proxy", ""},
+		{"unrelated cycle is not followed", map[string]string{"proxy": "warp", "a": "b", "b": "a"}, "proxy", ""},
+		{"two outbounds", map[string]string{"proxy": "warp", "warp": "proxy"}, "proxy", "proxy -> warp -> proxy"},
+		{"three outbounds", map[string]string{"a": "b", "b": "c", "c": "a"}, "a", "a -> b -> c -> a"},
+		{"cycle further down", map[string]string{"a": "b", "b": "c", "c": "b"}, "a", "a -> b -> c -> b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutboundChainCycle(tt.chains, tt.tag)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetOutboundChain(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+
+	tests := []struct {
+		name     string
+		tag      string
+		proxyTag string
+		wantErr  string
+	}{
+		{"empty tag", "", "warp", "required"},
+		{"chained to itself", "proxy", "proxy", "itself"},
+		{"missing outbound", "nope", "warp", "outbound nope does not exist"},
+		{"missing proxy", "proxy", "nope", "outbound nope does not exist"},
+		{"proxy through warp", "proxy", "warp", ""},
+		{"warp through direct", "warp", "direct", ""},
+		{"cycle back to proxy", "direct", "proxy", "cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetOutboundChain(tt.tag, tt.proxyTag)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	chains, err := s.GetOutboundChains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 2 || chains["proxy"] != "warp" || chains["warp"] != "direct" {
+		t.Fatalf("rejected chains were stored: %v", chains)
+	}
+	if err := s.RemoveOutboundChain("warp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveOutboundChain("warp"); err == nil {
+		t.Fatal("removing a missing chain succeeded")
+	}
+}
+
+func TestApplyOutboundChains(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+	if err := s.SetOutboundChain("proxy", "warp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOutboundChain("direct", "blocked"); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig := &xray.Config{}
+	if err := json.Unmarshal([]byte(chainTestTemplate), xrayConfig); err != nil {
+		t.Fatal(err)
+	}
+	// The config being generated lost the blocked outbound, its chain must not dangle
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setOutbounds(xrayConfig, outbounds[:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.applyOutboundChains(xrayConfig); err != nil {
+		t.Fatal(err)
+	}
+	outbounds, err = getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialerProxy := func(outbound map[string]interface{}) interface{} {
+		stream, _ := outbound["streamSettings"].(map[string]interface{})
+		sockopt, _ := stream["sockopt"].(map[string]interface{})
+		return sockopt["dialerProxy"]
+	}
+	for _, outbound := range outbounds {
+		tag := outbound["tag"].(string)
+		switch tag {
+		case "proxy":
+			if got := dialerProxy(outbound); got != "warp" {
+				t.Errorf("proxy dialerProxy = %v, want warp", got)
+			}
+			stream := outbound["streamSettings"].(map[string]interface{})
+			if stream["network"] != "tcp" || stream["sockopt"].(map[string]interface{})["mark"] != float64(255) {
+				t.Errorf("proxy lost its stream settings: %v", stream)
+			}
+		default:
+			if got := dialerProxy(outbound); got != nil {
+				t.Errorf("%s dialerProxy = %v, want none", tag, got)
+			}
+		}
+	}
+}
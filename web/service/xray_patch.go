@@ -0,0 +1,122 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// mergePatch applies an RFC 7386 JSON merge patch to target
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// configSection finds the xray.Config field a top level config key is stored in
+func configSection(config *xray.Config, key string) (reflect.Value, bool) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if tag == key {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func parseConfigPatch(patch string) (map[string]interface{}, error) {
+	var sections map[string]interface{}
+	err := json.Unmarshal([]byte(patch), &sections)
+	if err != nil {
+		return nil, common.NewErrorf("config patch must be a JSON object: %v", err)
+	}
+	config := &xray.Config{}
+	for key := range sections {
+		if _, ok := configSection(config, key); !ok {
+			return nil, common.NewErrorf("config patch has unknown section %q", key)
+		}
+	}
+	return sections, nil
+}
+
+// applyConfigPatch merges the patch into the config. Only the sections the patch names are
+// re-encoded, the others keep their bytes so unchanged configs still compare equal.
+func applyConfigPatch(config *xray.Config, patch string) error {
+	if patch == "" {
+		return nil
+	}
+	sections, err := parseConfigPatch(patch)
+	if err != nil {
+		return err
+	}
+	for key, sectionPatch := range sections {
+		field, _ := configSection(config, key)
+		if sectionPatch == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		var current interface{}
+		data, err := json.Marshal(field.Interface())
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &current)
+		if err != nil {
+			return err
+		}
+		data, err = json.MarshalIndent(mergePatch(current, sectionPatch), "", "  ")
+		if err != nil {
+			return err
+		}
+		section := reflect.New(field.Type())
+		err = json.Unmarshal(data, section.Interface())
+		if err != nil {
+			return common.NewErrorf("config patch makes an invalid %q section: %v", key, err)
+		}
+		field.Set(section.Elem())
+	}
+	return nil
+}
+
+// SetConfigPatch stores a JSON merge patch applied to the generated config after every other
+// pass, empty removes it. The patch is checked against the config template.
+func (s *XrayService) SetConfigPatch(patch string) error {
+	if patch != "" {
+		templateConfig, err := s.settingService.GetXrayConfigTemplate()
+		if err != nil {
+			return err
+		}
+		xrayConfig := &xray.Config{}
+		err = json.Unmarshal([]byte(templateConfig), xrayConfig)
+		if err != nil {
+			return err
+		}
+		err = applyConfigPatch(xrayConfig, patch)
+		if err != nil {
+			return err
+		}
+	}
+	err := s.settingService.SetConfigPatch(patch)
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
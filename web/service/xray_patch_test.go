@@ -0,0 +1,133 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{"adds a key", `{"a": 1}`, `{"b": 2}`, `{"a": 1, "b": 2}`},
+		{"replaces a key", `{"a": 1}`, `{"a": 2}`, `{"a": 2}`},
+		{"null removes a key", `{"a": 1, "b": 2}`, `{"b": null}`, `{"a": 1}`},
+		{"merges nested objects", `{"a": {"b": 1, "c": 2}}`, `{"a": {"c": 3}}`, `{"a": {"b": 1, "c": 3}}`},
+		{"arrays are replaced", `{"a": [1, 2]}`, `{"a": [3]}`, `{"a": [3]}`},
+		{"object over a scalar", `{"a": 1}`, `{"a": {"b": 1}}`, `{"a": {"b": 1}}`},
+		{"scalar patch replaces the target", `{"a": 1}`, `"b"`, `"b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, patch, want interface{}
+			for _, v := range []struct {
+				data string
+				dst  *interface{}
+			}{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+				if err := json.Unmarshal([]byte(v.data), v.dst); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantLog string
+		wantErr string
+	}{
+		{name: "empty patch", patch: "", wantLog: `{"loglevel": "warning", "access": "none"}`},
+		{name: "changes the log level", patch: `{"log": {"loglevel": "debug"}}`, wantLog: `{"loglevel": "debug", "access": "none"}`},
+		{name: "null removes a section", patch: `{"log": null}`, wantLog: ``},
+		{name: "not an object", patch: `[1]`, wantErr: "config patch must be a JSON object"},
+		{name: "unknown section", patch: `{"logs": {}}`, wantErr: `config patch has unknown section "logs"`},
+		{name: "invalid section", patch: `{"inbounds": {"port": 1}}`, wantErr: `config patch makes an invalid "inbounds" section`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &xray.Config{LogConfig: []byte(`{"loglevel": "warning", "access": "none"}`)}
+			err := applyConfigPatch(config, tt.patch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantLog == "" {
+				if len(config.LogConfig) != 0 {
+					t.Fatalf("got log %s, want none", config.LogConfig)
+				}
+				return
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(config.LogConfig, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.wantLog), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got log %s, want %s", config.LogConfig, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestSetConfigPatch(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+	if err := s.SetConfigPatch(`{"nope": {}}`); err == nil {
+		t.Fatal("got no error for a patch with an unknown section")
+	}
+	if err := s.SetConfigPatch(`{"log": {"loglevel": "debug"}}`); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("setting the patch did not ask for a restart")
+	}
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log map[string]interface{}
+	if err := json.Unmarshal(xrayConfig.LogConfig, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log["loglevel"] != "debug" {
+		t.Fatalf("got log level %v, want debug", log["loglevel"])
+	}
+
+	// Removing the patch brings back the template's log level
+	if err := s.SetConfigPatch(""); err != nil {
+		t.Fatal(err)
+	}
+	xrayConfig, err = s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log = nil
+	if err := json.Unmarshal(xrayConfig.LogConfig, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log["loglevel"] == "debug" {
+		t.Fatal("log level is still debug after removing the patch")
+	}
+}
@@ -0,0 +1,339 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// Policy levels of the inbounds start here, clear of the levels templates use
+const inboundPolicyLevelBase = 1000
+
+const (
+	maxInboundBufferSize = 64 * 1024 // KB
+	maxInboundConnIdle   = 24 * 60 * 60
+)
+
+// InboundPolicy tunes the connections of one inbound. BufferSize is in KB per connection, 0 turns
+// the buffer off, ConnIdle is in seconds. Nil fields keep the xray defaults.
+type InboundPolicy struct {
+	BufferSize *int `json:"bufferSize,omitempty"`
+	ConnIdle   *int `json:"connIdle,omitempty"`
+}
+
+// GetInboundPolicies returns the policies by inbound tag
+func (s *XrayService) GetInboundPolicies() (map[string]*InboundPolicy, error) {
+	policies := map[string]*InboundPolicy{}
+	data, err := s.settingService.GetInboundPolicies()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return policies, nil
+	}
+	err = json.Unmarshal([]byte(data), &policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (s *XrayService) saveInboundPolicies(policies map[string]*InboundPolicy) error {
+	data, err := json.MarshalIndent(policies, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetInboundPolicies(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetInboundPolicy sets the buffer size and idle timeout of the inbound connections
+func (s *XrayService) SetInboundPolicy(tag string, policy *InboundPolicy) error {
+	if tag == "" || policy == nil || (policy.BufferSize == nil && policy.ConnIdle == nil) {
+		return common.NewError("inbound tag and a buffer size or idle timeout are required")
+	}
+	if policy.BufferSize != nil && (*policy.BufferSize < 0 || *policy.BufferSize > maxInboundBufferSize) {
+		return common.NewErrorf("buffer size must be between 0 and %d KB", maxInboundBufferSize)
+	}
+	if policy.ConnIdle != nil && (*policy.ConnIdle < 1 || *policy.ConnIdle > maxInboundConnIdle) {
+		return common.NewErrorf("idle timeout must be between 1 and %d seconds", maxInboundConnIdle)
+	}
+	var count int64
+	err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", tag).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.NewErrorf("inbound %s does not exist", tag)
+	}
+
+	policies, err := s.GetInboundPolicies()
+	if err != nil {
+		return err
+	}
+	policies[tag] = policy
+	return s.saveInboundPolicies(policies)
+}
+
+func (s *XrayService) RemoveInboundPolicy(tag string) error {
+	policies, err := s.GetInboundPolicies()
+	if err != nil {
+		return err
+	}
+	if _, ok := policies[tag]; !ok {
+		return common.NewErrorf("inbound %s has no policy", tag)
+	}
+	delete(policies, tag)
+	return s.saveInboundPolicies(policies)
+}
+
+// setInboundLevel puts the clients of the inbound, or the inbound itself when it has none, on the level
+func setInboundLevel(inbound *xray.InboundConfig, level int) error {
+	settings := map[string]interface{}{}
+	if len(inbound.Settings) > 0 {
+		if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+			return err
+		}
+	}
+	if clients, ok := settings["clients"].([]interface{}); ok {
+		for _, client := range clients {
+			if c, ok := client.(map[string]interface{}); ok {
+				c["level"] = level
+			}
+		}
+	} else {
+		settings["userLevel"] = level
+		settings["level"] = level
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = data
+	return nil
+}
+
+// applyInboundPolicies gives every inbound with a policy its own policy level, a copy of level 0
+// with the inbound tunables over it, so the user stats counters stay on
+func (s *XrayService) applyInboundPolicies(xrayConfig *xray.Config) error {
+	policies, err := s.GetInboundPolicies()
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+
+	policy := map[string]interface{}{}
+	if len(xrayConfig.Policy) > 0 {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]interface{})
+	if levels == nil {
+		levels = map[string]interface{}{}
+	}
+	level0, _ := levels["0"].(map[string]interface{})
+
+	tags := make([]string, 0, len(policies))
+	for tag := range policies {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	applied := 0
+	for i, tag := range tags {
+		index := -1
+		for j := range xrayConfig.InboundConfigs {
+			if xrayConfig.InboundConfigs[j].Tag == tag {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			logger.Debug("Skip policy of inbound not in the config:", tag)
+			continue
+		}
+		level := inboundPolicyLevelBase + i
+		if err := setInboundLevel(&xrayConfig.InboundConfigs[index], level); err != nil {
+			logger.Warningf("Skip policy of inbound %s: %v", tag, err)
+			continue
+		}
+		inboundLevel := make(map[string]interface{}, len(level0)+2)
+		for key, value := range level0 {
+			inboundLevel[key] = value
+		}
+		if policies[tag].BufferSize != nil {
+			inboundLevel["bufferSize"] = *policies[tag].BufferSize
+		}
+		if policies[tag].ConnIdle != nil {
+			inboundLevel["connIdle"] = *policies[tag].ConnIdle
+		}
+		levels[strconv.Itoa(level)] = inboundLevel
+		applied++
+	}
+	if applied == 0 {
+		return nil
+	}
+	policy["levels"] = levels
+	xrayConfig.Policy, err = json.MarshalIndent(policy, "", "  ")
+	return err
+}
+
+// PolicyLevel is written over the level of the same number in the template. Timeouts are in
+// seconds, BufferSize in KB. Nil fields keep what the template sets.
+type PolicyLevel struct {
+	Handshake         *int  `json:"handshake,omitempty"`
+	ConnIdle          *int  `json:"connIdle,omitempty"`
+	UplinkOnly        *int  `json:"uplinkOnly,omitempty"`
+	DownlinkOnly      *int  `json:"downlinkOnly,omitempty"`
+	BufferSize        *int  `json:"bufferSize,omitempty"`
+	StatsUserUplink   *bool `json:"statsUserUplink,omitempty"`
+	StatsUserDownlink *bool `json:"statsUserDownlink,omitempty"`
+}
+
+type PolicySystem struct {
+	StatsInboundUplink    *bool `json:"statsInboundUplink,omitempty"`
+	StatsInboundDownlink  *bool `json:"statsInboundDownlink,omitempty"`
+	StatsOutboundUplink   *bool `json:"statsOutboundUplink,omitempty"`
+	StatsOutboundDownlink *bool `json:"statsOutboundDownlink,omitempty"`
+}
+
+// PolicySettings are merged into the policy block of the template. The levels are keyed by
+// number and stay below the inbound policy levels.
+type PolicySettings struct {
+	Levels map[string]*PolicyLevel `json:"levels,omitempty"`
+	System *PolicySystem           `json:"system,omitempty"`
+}
+
+func checkPolicySeconds(name string, value *int) error {
+	if value != nil && (*value < 0 || *value > maxInboundConnIdle) {
+		return common.NewErrorf("%s must be between 0 and %d seconds", name, maxInboundConnIdle)
+	}
+	return nil
+}
+
+func checkPolicySettings(settings *PolicySettings) error {
+	for key, level := range settings.Levels {
+		number, err := strconv.Atoi(key)
+		if err != nil || number < 0 || number >= inboundPolicyLevelBase || strconv.Itoa(number) != key {
+			return common.NewErrorf("policy level must be a number between 0 and %d, got %q", inboundPolicyLevelBase-1, key)
+		}
+		if level == nil {
+			return common.NewErrorf("policy level %s is empty", key)
+		}
+		if err := checkPolicySeconds("handshake", level.Handshake); err != nil {
+			return err
+		}
+		if err := checkPolicySeconds("connIdle", level.ConnIdle); err != nil {
+			return err
+		}
+		if err := checkPolicySeconds("uplinkOnly", level.UplinkOnly); err != nil {
+			return err
+		}
+		if err := checkPolicySeconds("downlinkOnly", level.DownlinkOnly); err != nil {
+			return err
+		}
+		if level.BufferSize != nil && (*level.BufferSize < 0 || *level.BufferSize > maxInboundBufferSize) {
+			return common.NewErrorf("buffer size must be between 0 and %d KB", maxInboundBufferSize)
+		}
+	}
+	return nil
+}
+
+func (s *XrayService) GetPolicySettings() (*PolicySettings, error) {
+	settings := &PolicySettings{}
+	data, err := s.settingService.GetPolicySettings()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return settings, nil
+	}
+	err = json.Unmarshal([]byte(data), settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (s *XrayService) SetPolicySettings(settings *PolicySettings) error {
+	err := checkPolicySettings(settings)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetPolicySettings(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// mergePolicyFields sets the non nil fields of value, a struct of pointers, into target by json name
+func mergePolicyFields(target map[string]interface{}, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &target)
+}
+
+// applyPolicySettings merges the edited levels and system counters into the policy block
+func (s *XrayService) applyPolicySettings(xrayConfig *xray.Config) error {
+	settings, err := s.GetPolicySettings()
+	if err != nil {
+		return err
+	}
+	if len(settings.Levels) == 0 && settings.System == nil {
+		return nil
+	}
+
+	policy := map[string]interface{}{}
+	if len(xrayConfig.Policy) > 0 {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]interface{})
+	if levels == nil {
+		levels = map[string]interface{}{}
+	}
+	for key, level := range settings.Levels {
+		target, _ := levels[key].(map[string]interface{})
+		if target == nil {
+			target = map[string]interface{}{}
+		}
+		if err := mergePolicyFields(target, level); err != nil {
+			return err
+		}
+		levels[key] = target
+	}
+	policy["levels"] = levels
+	if settings.System != nil {
+		system, _ := policy["system"].(map[string]interface{})
+		if system == nil {
+			system = map[string]interface{}{}
+		}
+		if err := mergePolicyFields(system, settings.System); err != nil {
+			return err
+		}
+		policy["system"] = system
+	}
+	xrayConfig.Policy, err = json.MarshalIndent(policy, "", "  ")
+	return err
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestSetInboundPolicy(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name    string
+		tag     string
+		policy  *InboundPolicy
+		wantErr string
+	}{
+		{name: "buffer size", tag: "inbound-20001", policy: &InboundPolicy{BufferSize: intPtr(16)}},
+		{name: "buffer off", tag: "inbound-20001", policy: &InboundPolicy{BufferSize: intPtr(0)}},
+		{name: "idle timeout", tag: "inbound-20001", policy: &InboundPolicy{ConnIdle: intPtr(120)}},
+		{name: "no tunables", tag: "inbound-20001", policy: &InboundPolicy{}, wantErr: "a buffer size or idle timeout are required"},
+		{name: "negative buffer", tag: "inbound-20001", policy: &InboundPolicy{BufferSize: intPtr(-1)}, wantErr: "buffer size must be between"},
+		{name: "huge buffer", tag: "inbound-20001", policy: &InboundPolicy{BufferSize: intPtr(maxInboundBufferSize + 1)}, wantErr: "buffer size must be between"},
+		{name: "zero idle", tag: "inbound-20001", policy: &InboundPolicy{ConnIdle: intPtr(0)}, wantErr: "idle timeout must be between"},
+		{name: "unknown inbound", tag: "inbound-20002", policy: &InboundPolicy{ConnIdle: intPtr(120)}, wantErr: "inbound inbound-20002 does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetInboundPolicy(tt.tag, tt.policy)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.IsNeedRestartAndSetFalse() {
+				t.Fatal("setting a policy did not ask for a restart")
+			}
+		})
+	}
+	if err := s.RemoveInboundPolicy("inbound-20002"); err == nil {
+		t.Fatal("got no error removing a missing policy")
+	}
+}
+
+func TestInboundPolicyGeneration(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	addTestInbound(t, 20002, "inbound-20002", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	if err := s.SetInboundPolicy("inbound-20001", &InboundPolicy{BufferSize: intPtr(16), ConnIdle: intPtr(120)}); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy struct {
+		Levels map[string]map[string]interface{} `json:"levels"`
+	}
+	if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+		t.Fatal(err)
+	}
+	level := policy.Levels["1000"]
+	if level["bufferSize"] != 16.0 || level["connIdle"] != 120.0 {
+		t.Fatalf("got level %v, want the inbound buffer size and idle timeout", level)
+	}
+	// The user counters of level 0 stay on
+	if level["statsUserUplink"] != true || level["statsUserDownlink"] != true {
+		t.Fatalf("got level %v without the user stats", level)
+	}
+	if _, ok := policy.Levels["0"]["bufferSize"]; ok {
+		t.Fatalf("level 0 got %v, want it untouched", policy.Levels["0"])
+	}
+
+	for _, inbound := range xrayConfig.InboundConfigs {
+		var settings struct {
+			Clients []map[string]interface{} `json:"clients"`
+		}
+		if err := json.Unmarshal(inbound.Settings, &settings); err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range settings.Clients {
+			switch inbound.Tag {
+			case "inbound-20001":
+				if client["level"] != 1000.0 {
+					t.Fatalf("client %v got level %v, want 1000", client["email"], client["level"])
+				}
+			case "inbound-20002":
+				if _, ok := client["level"]; ok {
+					t.Fatalf("client %v of an inbound without policy got level %v", client["email"], client["level"])
+				}
+			}
+		}
+	}
+}
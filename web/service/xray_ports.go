@@ -0,0 +1,99 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"x-ui/xray"
+)
+
+// PortBinding is a port Xray listens on. An empty Host listens on every address.
+type PortBinding struct {
+	Tag      string `json:"tag"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Network  string `json:"network"`
+}
+
+// inboundNetworks returns whether the inbound listens on tcp and on udp
+func inboundNetworks(inbound *xray.InboundConfig) (tcp bool, udp bool) {
+	settings := map[string]interface{}{}
+	if len(inbound.Settings) > 0 {
+		json.Unmarshal(inbound.Settings, &settings)
+	}
+	var stream struct {
+		Network     string `json:"network"`
+		TlsSettings struct {
+			Alpn []string `json:"alpn"`
+		} `json:"tlsSettings"`
+	}
+	if len(inbound.StreamSettings) > 0 {
+		json.Unmarshal(inbound.StreamSettings, &stream)
+	}
+
+	switch inbound.Protocol {
+	case "wireguard":
+		return false, true
+	case "shadowsocks", "dokodemo-door":
+		network, _ := settings["network"].(string)
+		if network == "" {
+			network = "tcp"
+		}
+		return strings.Contains(network, "tcp"), strings.Contains(network, "udp")
+	case "socks":
+		// The udp associate listens on the same port
+		udp, _ := settings["udp"].(bool)
+		return true, udp
+	}
+	switch stream.Network {
+	case "kcp", "mkcp", "quic":
+		return false, true
+	case "splithttp", "xhttp":
+		for _, alpn := range stream.TlsSettings.Alpn {
+			if alpn == "h3" {
+				return false, true
+			}
+		}
+	}
+	return true, false
+}
+
+// ListenPorts returns the ports the generated config opens, one binding per network,
+// so firewall rules can be generated from them
+func (s *XrayService) ListenPorts() ([]PortBinding, error) {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+	bindings := []PortBinding{}
+	for i := range xrayConfig.InboundConfigs {
+		inbound := &xrayConfig.InboundConfigs[i]
+		host := ""
+		if len(inbound.Listen) > 0 {
+			json.Unmarshal(inbound.Listen, &host)
+		}
+		// Unix domain sockets open no port
+		if strings.HasPrefix(host, "/") || strings.HasPrefix(host, "@") || inbound.Port <= 0 {
+			continue
+		}
+		if host == "0.0.0.0" || host == "::" || host == "::0" {
+			host = ""
+		}
+		tcp, udp := inboundNetworks(inbound)
+		binding := PortBinding{Tag: inbound.Tag, Host: host, Port: inbound.Port, Protocol: inbound.Protocol}
+		if tcp {
+			binding.Network = "tcp"
+			bindings = append(bindings, binding)
+		}
+		if udp {
+			binding.Network = "udp"
+			bindings = append(bindings, binding)
+		}
+	}
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].Port < bindings[j].Port
+	})
+	return bindings, nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+func TestInboundNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		settings string
+		stream   string
+		wantTcp  bool
+		wantUdp  bool
+	}{
+		{name: "vless over tcp", protocol: "vless", stream: `{"network": "tcp"}`, wantTcp: true},
+		{name: "no stream settings", protocol: "trojan", wantTcp: true},
+		{name: "kcp", protocol: "vmess", stream: `{"network": "kcp"}`, wantUdp: true},
+		{name: "xhttp over h3", protocol: "vless", stream: `{"network": "xhttp", "tlsSettings": {"alpn": ["h3"]}}`, wantUdp: true},
+		{name: "xhttp over h2", protocol: "vless", stream: `{"network": "xhttp", "tlsSettings": {"alpn": ["h2"]}}`, wantTcp: true},
+		{name: "wireguard", protocol: "wireguard", wantUdp: true},
+		{name: "shadowsocks default", protocol: "shadowsocks", settings: `{"method": "aes-128-gcm"}`, wantTcp: true},
+		{name: "shadowsocks tcp and udp", protocol: "shadowsocks", settings: `{"network": "tcp,udp"}`, wantTcp: true, wantUdp: true},
+		{name: "dokodemo udp", protocol: "dokodemo-door", settings: `{"network": "udp"}`, wantUdp: true},
+		{name: "socks with udp", protocol: "socks", settings: `{"udp": true}`, wantTcp: true, wantUdp: true},
+		{name: "socks without udp", protocol: "socks", settings: `{"udp": false}`, wantTcp: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := &xray.InboundConfig{Protocol: tt.protocol}
+			if tt.settings != "" {
+				inbound.Settings = []byte(tt.settings)
+			}
+			if tt.stream != "" {
+				inbound.StreamSettings = []byte(tt.stream)
+			}
+			tcp, udp := inboundNetworks(inbound)
+			if tcp != tt.wantTcp || udp != tt.wantUdp {
+				t.Fatalf("got tcp %v and udp %v, want %v and %v", tcp, udp, tt.wantTcp, tt.wantUdp)
+			}
+		})
+	}
+}
+
+func TestListenPorts(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20002, "inbound-20002", true)
+	addTestInbound(t, 20003, "inbound-20003", false)
+	socket := addTestInbound(t, 0, "inbound-socket", true)
+	socket.Listen = "/run/xray/vless.sock"
+	if err := database.GetDB().Save(socket).Error; err != nil {
+		t.Fatal(err)
+	}
+	shadowsocks := addTestInbound(t, 20001, "inbound-20001", true)
+	shadowsocks.Protocol = "shadowsocks"
+	shadowsocks.Listen = "0.0.0.0"
+	shadowsocks.Settings = `{"method": "2022-blake3-aes-128-gcm", "network": "tcp,udp", "clients": []}`
+	if err := database.GetDB().Save(shadowsocks).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	s := &XrayService{}
+	bindings, err := s.ListenPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PortBinding{
+		{Tag: "inbound-20001", Port: 20001, Protocol: "shadowsocks", Network: "tcp"},
+		{Tag: "inbound-20001", Port: 20001, Protocol: "shadowsocks", Network: "udp"},
+		{Tag: "inbound-20002", Port: 20002, Protocol: "vless", Network: "tcp"},
+		{Tag: "api", Host: "127.0.0.1", Port: 62789, Protocol: "dokodemo-door", Network: "tcp"},
+	}
+	if !reflect.DeepEqual(bindings, want) {
+		t.Fatalf("got %+v, want %+v", bindings, want)
+	}
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// mainXray is the name of the main Xray process, the extra instances run under their own name
+const mainXray = ""
+
+// managedProcess is the process of a name with the watchdog state of that name
+type managedProcess struct {
+	process *xray.Process
+	// Set by a stop on purpose, the watchdog leaves the process alone
+	stopped bool
+	// Crashes in a row and when the watchdog may restart the process again
+	failures    int
+	nextRestart time.Time
+	// The last exited process the watchdog counted, every exit is counted once
+	crashed *xray.Process
+}
+
+// xrayProcessManager keeps the Xray processes by name. The main Xray and the extra instances are
+// started, stopped, supervised and read through it the same way.
+type xrayProcessManager struct {
+	sync.Mutex
+	processes map[string]*managedProcess
+}
+
+var xrayProcesses = &xrayProcessManager{processes: map[string]*managedProcess{}}
+
+// mainXrayProcess returns the main Xray process, nil before the first start
+func mainXrayProcess() *xray.Process {
+	return xrayProcesses.Get(mainXray)
+}
+
+// entry returns the state of name, created when missing. The lock must be held.
+func (m *xrayProcessManager) entry(name string) *managedProcess {
+	state, ok := m.processes[name]
+	if !ok {
+		state = &managedProcess{}
+		m.processes[name] = state
+	}
+	return state
+}
+
+// Get returns the process of name, nil when it has none
+func (m *xrayProcessManager) Get(name string) *xray.Process {
+	m.Lock()
+	defer m.Unlock()
+	if state, ok := m.processes[name]; ok {
+		return state.process
+	}
+	return nil
+}
+
+func (m *xrayProcessManager) IsRunning(name string) bool {
+	process := m.Get(name)
+	return process != nil && process.IsRunning()
+}
+
+// Set makes process the one of name, to be started by the caller. The backoff of the name stays,
+// so a process that crashes at once keeps backing off.
+func (m *xrayProcessManager) Set(name string, process *xray.Process) {
+	m.Lock()
+	defer m.Unlock()
+	state := m.entry(name)
+	state.process = process
+	state.stopped = false
+}
+
+// Stop stops the process of name on purpose, the watchdog does not restart it. A process that
+// already exited stays down.
+func (m *xrayProcessManager) Stop(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	state, ok := m.processes[name]
+	if !ok || state.process == nil {
+		return errors.New("xray is not running")
+	}
+	state.stopped = true
+	if !state.process.IsRunning() {
+		return nil
+	}
+	return state.process.Stop()
+}
+
+// Remove stops the process of name and forgets the name
+func (m *xrayProcessManager) Remove(name string) {
+	m.Lock()
+	defer m.Unlock()
+	state, ok := m.processes[name]
+	if !ok {
+		return
+	}
+	delete(m.processes, name)
+	if state.process != nil && state.process.IsRunning() {
+		state.process.Stop()
+	}
+}
+
+// Names returns the names that have a process, sorted
+func (m *xrayProcessManager) Names() []string {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]string, 0, len(m.processes))
+	for name, state := range m.processes {
+		if state.process != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// APIPorts returns the api port of every running process by name
+func (m *xrayProcessManager) APIPorts() map[string]int {
+	m.Lock()
+	defer m.Unlock()
+	ports := map[string]int{}
+	for name, state := range m.processes {
+		if state.process != nil && state.process.IsRunning() {
+			ports[name] = state.process.GetAPIPort()
+		}
+	}
+	return ports
+}
+
+// watch is one watchdog check of name. A process that stays up resets the backoff. The first check
+// that sees a process exited sets crashed, with the backoff before its restart. due is set once a
+// process that did not stop on purpose may be restarted.
+func (m *xrayProcessManager) watch(name string, now time.Time) (process *xray.Process, backoff time.Duration, crashed bool, due bool) {
+	m.Lock()
+	defer m.Unlock()
+	state := m.entry(name)
+	process = state.process
+	if process != nil && process.IsRunning() {
+		if state.failures > 0 && process.GetUptime() >= xrayStableUptime {
+			state.failures = 0
+		}
+		return process, 0, false, false
+	}
+	if state.stopped {
+		return process, 0, false, false
+	}
+	if process != nil && process != state.crashed {
+		state.crashed = process
+		backoff = state.schedule(now)
+		crashed = true
+	}
+	return process, backoff, crashed, !now.Before(state.nextRestart)
+}
+
+// restartFailed pushes the next restart of name back. A restart that replaced process has its
+// failed start counted as a crash on the next check instead.
+func (m *xrayProcessManager) restartFailed(name string, process *xray.Process) {
+	m.Lock()
+	defer m.Unlock()
+	state := m.entry(name)
+	if state.process == process {
+		state.schedule(time.Now())
+	}
+}
+
+// watchdog returns the crashes in a row of name and when it is restarted next, zero when it is not waiting
+func (m *xrayProcessManager) watchdog(name string) (int, time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	state := m.entry(name)
+	if state.failures > 0 && time.Now().Before(state.nextRestart) {
+		return state.failures, state.nextRestart
+	}
+	return state.failures, time.Time{}
+}
+
+// schedule counts a failure and returns how long the next restart waits
+func (state *managedProcess) schedule(now time.Time) time.Duration {
+	backoff := xrayWatchdogRetry.Backoff(state.failures)
+	state.failures++
+	state.nextRestart = now.Add(backoff)
+	return backoff
+}
+
+// superviseProcess restarts the process of name once it exited on its own and its backoff passed.
+// onCrash sees every exit once, with the backoff before the restart.
+func (s *XrayService) superviseProcess(name string, restart func() error, onCrash func(process *xray.Process, backoff time.Duration)) {
+	process, backoff, crashed, due := xrayProcesses.watch(name, time.Now())
+	if crashed {
+		onCrash(process, backoff)
+	}
+	if !due {
+		return
+	}
+	if err := restart(); err != nil {
+		logger.Errorf("Restart xray %s failed: %v", processLabel(name), err)
+		xrayProcesses.restartFailed(name, process)
+	}
+}
+
+// processLabel names the process in logs
+func processLabel(name string) string {
+	if name == mainXray {
+		return "main"
+	}
+	return "instance " + name
+}
+
+// GetXrayTraffic reads the counters of every running Xray process, the main one and the extra
+// instances, and adds up the ones of the same tag or client. Only the persistence path should reset
+// them, the counts it reads are added to the database as deltas and a second resetting reader would
+// lose traffic. A *xray.PartialTrafficError comes with the traffic read before a failure, which must
+// be saved.
+func (s *XrayService) GetXrayTraffic(reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	ports := xrayProcesses.APIPorts()
+	if len(ports) == 0 {
+		err := errors.New("xray is not running")
+		logger.Debug("Attempted to fetch Xray traffic, but Xray is not running:", err)
+		return nil, nil, err
+	}
+	return readProcessesTraffic(ports, reset)
+}
+
+// readProcessesTraffic reads the counters through the api port of every process and merges them.
+// A process that fails is skipped, once another one was read the error is partial.
+func readProcessesTraffic(ports map[string]int, reset bool) ([]*xray.Traffic, []*xray.ClientTraffic, error) {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var traffics []*xray.Traffic
+	var clientTraffics []*xray.ClientTraffic
+	var failed error
+	read := false
+	for _, name := range names {
+		var api xray.XrayAPI
+		if err := api.Init(ports[name]); err != nil {
+			logger.Debugf("Failed to connect to xray %s: %v", processLabel(name), err)
+			failed = err
+			continue
+		}
+		traffic, clientTraffic, err := readXrayTraffic(&api, reset)
+		api.Close()
+		var partial *xray.PartialTrafficError
+		if err != nil && !errors.As(err, &partial) {
+			failed = err
+			continue
+		}
+		if err != nil {
+			failed = err
+		}
+		read = true
+		traffics = append(traffics, traffic...)
+		clientTraffics = append(clientTraffics, clientTraffic...)
+	}
+	if failed == nil {
+		return mergeTraffics(traffics), mergeClientTraffics(clientTraffics), nil
+	}
+	if !read {
+		return nil, nil, failed
+	}
+	var partial *xray.PartialTrafficError
+	if !errors.As(failed, &partial) {
+		failed = &xray.PartialTrafficError{Err: failed}
+	}
+	return mergeTraffics(traffics), mergeClientTraffics(clientTraffics), failed
+}
+
+// mergeTraffics adds up the counters of the same tag, the processes share outbounds like direct
+func mergeTraffics(traffics []*xray.Traffic) []*xray.Traffic {
+	type trafficKey struct {
+		isInbound, isOutbound bool
+		tag, protocol         string
+	}
+	merged := make([]*xray.Traffic, 0, len(traffics))
+	byKey := make(map[trafficKey]*xray.Traffic, len(traffics))
+	for _, traffic := range traffics {
+		key := trafficKey{traffic.IsInbound, traffic.IsOutbound, traffic.Tag, traffic.Protocol}
+		if sum, ok := byKey[key]; ok {
+			sum.Up += traffic.Up
+			sum.Down += traffic.Down
+			continue
+		}
+		sum := *traffic
+		byKey[key] = &sum
+		merged = append(merged, &sum)
+	}
+	return merged
+}
+
+// mergeClientTraffics adds up the counters of the same client
+func mergeClientTraffics(clientTraffics []*xray.ClientTraffic) []*xray.ClientTraffic {
+	merged := make([]*xray.ClientTraffic, 0, len(clientTraffics))
+	byEmail := make(map[string]*xray.ClientTraffic, len(clientTraffics))
+	for _, clientTraffic := range clientTraffics {
+		if sum, ok := byEmail[clientTraffic.Email]; ok {
+			sum.Up += clientTraffic.Up
+			sum.Down += clientTraffic.Down
+			continue
+		}
+		sum := *clientTraffic
+		byEmail[clientTraffic.Email] = &sum
+		merged = append(merged, &sum)
+	}
+	return merged
+}
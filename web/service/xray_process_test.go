@@ -0,0 +1,406 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"x-ui/xray"
+)
+
+// setMainXrayProcess makes process the main Xray for the test, nil for none
+func setMainXrayProcess(t *testing.T, process *xray.Process) {
+	t.Helper()
+	old := mainXrayProcess()
+	xrayProcesses.Set(mainXray, process)
+	t.Cleanup(func() {
+		xrayProcesses.Set(mainXray, old)
+	})
+}
+
+// waitStopped waits for the process of name to exit
+func waitStopped(t *testing.T, name string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !xrayProcesses.IsRunning(name) {
+			return
+		}
+	}
+	t.Fatalf("xray %s is still running", processLabel(name))
+}
+
+// runningStubXray answers the version query and keeps running until stopped
+const runningStubXray = "#!/bin/sh\nif [ \"$1\" = -version ]; then echo 'Xray 1.8.24'; exit 0; fi\nexec sleep 30\n"
+
+// setupTestInstance stores an enabled instance running inbound-20001, on a stub xray that runs
+// script, and drops its process when the test ends
+func setupTestInstance(t *testing.T, script string) *XrayService {
+	t.Helper()
+	setupTestDB(t)
+	setStubXray(t, script)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() {
+		s.StopXrayInstances()
+		s.IsNeedRestartAndSetFalse()
+	})
+	if err := s.SetXrayInstance(XrayInstance{Name: "tenant", Profile: "default", InboundTags: []string{"inbound-20001"}, Enable: true}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestXrayProcessManager(t *testing.T) {
+	manager := &xrayProcessManager{processes: map[string]*managedProcess{}}
+	main := xray.NewProcess(&xray.Config{})
+	tenant := xray.NewInstanceProcess("tenant", &xray.Config{})
+	manager.Set(mainXray, main)
+	manager.Set("tenant", tenant)
+
+	if got := manager.Get(mainXray); got != main {
+		t.Fatal("got another main process")
+	}
+	if got := manager.Get("other"); got != nil {
+		t.Fatal("got a process for an unknown name")
+	}
+	if got := manager.Names(); !reflect.DeepEqual(got, []string{mainXray, "tenant"}) {
+		t.Fatalf("got names %q", got)
+	}
+	if err := manager.Stop("other"); err == nil || err.Error() != "xray is not running" {
+		t.Fatalf("got error %v stopping a name without a process", err)
+	}
+	if ports := manager.APIPorts(); len(ports) != 0 {
+		t.Fatalf("got api ports %v without running processes", ports)
+	}
+
+	// A process that never ran is restarted at once, a failed restart waits for the backoff
+	now := time.Now()
+	if _, _, crashed, due := manager.watch("tenant", now); !crashed || due {
+		t.Fatalf("got crashed %v and due %v for a process that exited", crashed, due)
+	}
+	if _, _, crashed, _ := manager.watch("tenant", now); crashed {
+		t.Fatal("the same exit was counted twice")
+	}
+	if failures, next := manager.watchdog("tenant"); failures != 1 || next.IsZero() {
+		t.Fatalf("got %d failures and next restart %v", failures, next)
+	}
+	if _, _, _, due := manager.watch("tenant", now.Add(xrayWatchdogRetry.MaxBackoff)); !due {
+		t.Fatal("the restart is not due after the backoff")
+	}
+	manager.restartFailed("tenant", tenant)
+	if failures, _ := manager.watchdog("tenant"); failures != 2 {
+		t.Fatalf("got %d failures after a failed restart", failures)
+	}
+
+	manager.Remove("tenant")
+	if got := manager.Names(); !reflect.DeepEqual(got, []string{mainXray}) {
+		t.Fatalf("got names %q after removing the instance", got)
+	}
+}
+
+func TestXrayInstanceStartStop(t *testing.T) {
+	s := setupTestInstance(t, runningStubXray)
+
+	if err := s.StopXrayInstance("tenant"); err == nil || !strings.Contains(err.Error(), "xray instance tenant is not running") {
+		t.Fatalf("got error %v stopping an instance that was not started", err)
+	}
+	if err := s.StartXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	process := xrayProcesses.Get("tenant")
+	if process == nil || !process.IsRunning() {
+		t.Fatal("the instance is not running")
+	}
+	if port := process.GetAPIPort(); port <= 0 || port == 62789 {
+		t.Fatalf("got api port %d, want a free one apart from the main api", port)
+	}
+	statuses, err := s.GetXrayInstanceStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || !statuses[0].Running || statuses[0].Pid != process.GetPid() {
+		t.Fatalf("got statuses %+v", statuses)
+	}
+
+	// Starting an unchanged instance keeps its process
+	if err := s.StartXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if xrayProcesses.Get("tenant") != process {
+		t.Fatal("starting an unchanged instance replaced its process")
+	}
+	if err := s.RestartXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if xrayProcesses.Get("tenant") == process {
+		t.Fatal("a forced restart kept the process")
+	}
+
+	if err := s.StopXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	waitStopped(t, "tenant")
+	if _, _, _, due := xrayProcesses.watch("tenant", time.Now().Add(time.Hour)); due {
+		t.Fatal("the watchdog restarts an instance stopped on purpose")
+	}
+
+	// Disabling the instance forgets its process
+	if err := s.StartXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetXrayInstance(XrayInstance{Name: "tenant", Profile: "default", InboundTags: []string{"inbound-20001"}}); err != nil {
+		t.Fatal(err)
+	}
+	if xrayProcesses.Get("tenant") != nil {
+		t.Fatal("a disabled instance kept its process")
+	}
+	if err := s.StartXrayInstance("tenant"); err == nil || !strings.Contains(err.Error(), "xray instance tenant is disabled") {
+		t.Fatalf("got error %v starting a disabled instance", err)
+	}
+}
+
+func TestSuperviseXrayInstance(t *testing.T) {
+	s := setupTestInstance(t, "#!/bin/sh\nexit 1\n")
+	// Standby keeps the watchdog away from the main Xray
+	if err := s.SetStandby(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartXrayInstance("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	crashed := xrayProcesses.Get("tenant")
+	waitStopped(t, "tenant")
+
+	// The first check counts the exit and waits for the backoff
+	s.SuperviseXray()
+	if xrayProcesses.Get("tenant") != crashed {
+		t.Fatal("the instance was restarted before its backoff")
+	}
+	if failures, next := xrayProcesses.watchdog("tenant"); failures != 1 || next.IsZero() {
+		t.Fatalf("got %d failures and next restart %v", failures, next)
+	}
+
+	xrayProcesses.Lock()
+	xrayProcesses.processes["tenant"].nextRestart = time.Now().Add(-time.Second)
+	xrayProcesses.Unlock()
+	s.SuperviseXray()
+	if xrayProcesses.Get("tenant") == crashed {
+		t.Fatal("the watchdog did not restart the instance")
+	}
+	if xrayProcesses.Get(mainXray) != nil && xrayProcesses.IsRunning(mainXray) {
+		t.Fatal("the watchdog started the main xray in standby")
+	}
+}
+
+func TestXrayInstanceConfig(t *testing.T) {
+	s := setupTestInstance(t, runningStubXray)
+	addTestInbound(t, 20002, "inbound-20002", true)
+	resetXrayConfigCache(t)
+	t.Cleanup(func() {
+		setLastGenerationTiming(GenerationTiming{})
+		setCappedClients(map[string][]string{})
+	})
+	if err := s.settingService.SetXrayAPIPortFallback(`{"port": 62789, "fallback": 1234}`); err != nil {
+		t.Fatal(err)
+	}
+
+	mainConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configInboundTags(mainConfig); !reflect.DeepEqual(got, []string{"inbound-20002"}) {
+		t.Fatalf("main config has inbounds %v", got)
+	}
+	if api := getAPIInbound(mainConfig); api.Port != 1234 {
+		t.Fatalf("got main api port %d, want the fallback", api.Port)
+	}
+	timing := s.LastGenerationTiming()
+
+	instances, err := s.GetXrayInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceConfig, err := s.genXrayInstanceConfig(&instances[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configInboundTags(instanceConfig); !reflect.DeepEqual(got, []string{"inbound-20001"}) {
+		t.Fatalf("instance config has inbounds %v", got)
+	}
+	if api := getAPIInbound(instanceConfig); api.Port != 62789 {
+		t.Fatalf("got instance api port %d, the main fallback leaked into it", api.Port)
+	}
+	if got := s.LastGenerationTiming(); got != timing {
+		t.Fatal("the instance config replaced the timing of the main config")
+	}
+}
+
+func TestMergeTraffics(t *testing.T) {
+	tests := []struct {
+		name           string
+		traffics       []*xray.Traffic
+		clientTraffics []*xray.ClientTraffic
+		wantTraffics   []xray.Traffic
+		wantClients    []xray.ClientTraffic
+	}{
+		{
+			name: "shared outbound is added up",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "direct", Up: 1, Down: 2},
+				{IsInbound: true, Tag: "inbound-20001", Up: 3, Down: 4},
+				{IsOutbound: true, Tag: "direct", Up: 10, Down: 20},
+			},
+			wantTraffics: []xray.Traffic{
+				{IsOutbound: true, Tag: "direct", Up: 11, Down: 22},
+				{IsInbound: true, Tag: "inbound-20001", Up: 3, Down: 4},
+			},
+		},
+		{
+			name: "inbound and outbound of the same tag stay apart",
+			traffics: []*xray.Traffic{
+				{IsInbound: true, Tag: "api", Up: 1},
+				{IsOutbound: true, Tag: "api", Up: 2},
+			},
+			wantTraffics: []xray.Traffic{
+				{IsInbound: true, Tag: "api", Up: 1},
+				{IsOutbound: true, Tag: "api", Up: 2},
+			},
+		},
+		{
+			name: "protocol counters stay apart",
+			traffics: []*xray.Traffic{
+				{IsOutbound: true, Tag: "direct", Protocol: "tcp", Up: 1},
+				{IsOutbound: true, Tag: "direct", Protocol: "udp", Up: 2},
+				{IsOutbound: true, Tag: "direct", Protocol: "tcp", Up: 3},
+			},
+			wantTraffics: []xray.Traffic{
+				{IsOutbound: true, Tag: "direct", Protocol: "tcp", Up: 4},
+				{IsOutbound: true, Tag: "direct", Protocol: "udp", Up: 2},
+			},
+		},
+		{
+			name: "clients by email",
+			clientTraffics: []*xray.ClientTraffic{
+				{Email: "alice", Up: 1, Down: 2},
+				{Email: "bob", Up: 5},
+				{Email: "alice", Up: 3, Down: 4},
+			},
+			wantTraffics: []xray.Traffic{},
+			wantClients: []xray.ClientTraffic{
+				{Email: "alice", Up: 4, Down: 6},
+				{Email: "bob", Up: 5},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traffics := []xray.Traffic{}
+			for _, traffic := range mergeTraffics(tt.traffics) {
+				traffics = append(traffics, *traffic)
+			}
+			if tt.wantTraffics != nil && !reflect.DeepEqual(traffics, tt.wantTraffics) {
+				t.Fatalf("got traffics %+v, want %+v", traffics, tt.wantTraffics)
+			}
+			var clients []xray.ClientTraffic
+			for _, clientTraffic := range mergeClientTraffics(tt.clientTraffics) {
+				clients = append(clients, *clientTraffic)
+			}
+			if !reflect.DeepEqual(clients, tt.wantClients) {
+				t.Fatalf("got client traffics %+v, want %+v", clients, tt.wantClients)
+			}
+		})
+	}
+
+	// The merged counters are copies, the read ones stay as they were
+	read := []*xray.Traffic{{IsOutbound: true, Tag: "direct", Up: 1}, {IsOutbound: true, Tag: "direct", Up: 2}}
+	mergeTraffics(read)
+	if read[0].Up != 1 {
+		t.Fatalf("merging changed the read counter to %d", read[0].Up)
+	}
+}
+
+func TestReadProcessesTraffic(t *testing.T) {
+	setupTestDB(t)
+	resetTrafficLedger(t)
+	counters := func(inbound, email string, up int64) map[string]int64 {
+		return map[string]int64{
+			"inbound>>>" + inbound + ">>>traffic>>>uplink": up,
+			"outbound>>>direct>>>traffic>>>uplink":         up,
+			"user>>>" + email + ">>>traffic>>>uplink":      up,
+		}
+	}
+	main := startStatsServer(t, counters("inbound-20001", "alice", 100))
+	tenant := startStatsServer(t, counters("inbound-20002", "bob", 10))
+
+	type tagUp map[string]int64
+	tests := []struct {
+		name        string
+		ports       map[string]int
+		wantTags    tagUp
+		wantClients tagUp
+		wantErr     string
+		wantPartial bool
+	}{
+		{
+			name:        "main and instance",
+			ports:       map[string]int{mainXray: main, "tenant": tenant},
+			wantTags:    tagUp{"inbound-20001": 100, "inbound-20002": 10, "direct": 110},
+			wantClients: tagUp{"alice": 100, "bob": 10},
+		},
+		{
+			name:        "instance without api",
+			ports:       map[string]int{mainXray: main, "tenant": 0},
+			wantTags:    tagUp{"inbound-20001": 100, "direct": 100},
+			wantClients: tagUp{"alice": 100},
+			wantErr:     "partial traffic",
+			wantPartial: true,
+		},
+		{
+			name:    "no api at all",
+			ports:   map[string]int{mainXray: 0},
+			wantErr: "invalid Xray API port",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traffics, clientTraffics, err := readProcessesTraffic(tt.ports, false)
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			var partial *xray.PartialTrafficError
+			if got := errors.As(err, &partial); got != tt.wantPartial {
+				t.Fatalf("got partial %v, want %v", got, tt.wantPartial)
+			}
+			tags := tagUp{}
+			for _, traffic := range traffics {
+				tags[traffic.Tag] += traffic.Up
+			}
+			clients := tagUp{}
+			for _, clientTraffic := range clientTraffics {
+				clients[clientTraffic.Email] += clientTraffic.Up
+			}
+			if len(tags) == 0 && tt.wantTags == nil {
+				return
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) || !reflect.DeepEqual(clients, tt.wantClients) {
+				t.Fatalf("got tags %v and clients %v", tags, clients)
+			}
+			names := make([]string, 0, len(traffics))
+			for _, traffic := range traffics {
+				names = append(names, traffic.Tag)
+			}
+			sort.Strings(names)
+			for i := 1; i < len(names); i++ {
+				if names[i] == names[i-1] {
+					t.Fatalf("tag %s was returned twice", names[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"sync"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// ConfigProcessor is one pass over the generated config. An error aborts the generation.
+type ConfigProcessor func(xrayConfig *xray.Config) error
+
+var configProcessors struct {
+	sync.RWMutex
+	list []ConfigProcessor
+}
+
+// RegisterConfigProcessor adds a pass that runs after the built-in ones, in registration order.
+// The config patch of the settings still goes last, so admins can override what processors did.
+func RegisterConfigProcessor(processor ConfigProcessor) {
+	if processor == nil {
+		return
+	}
+	configProcessors.Lock()
+	configProcessors.list = append(configProcessors.list, processor)
+	configProcessors.Unlock()
+	invalidateXrayConfigCache()
+}
+
+// registeredConfigProcessors returns a copy of the registered processors, so registering one
+// while a config is generated does not race with it
+func registeredConfigProcessors() []ConfigProcessor {
+	configProcessors.RLock()
+	defer configProcessors.RUnlock()
+	return append([]ConfigProcessor(nil), configProcessors.list...)
+}
+
+// builtinConfigProcessors are the passes every generation runs first, in this order. The config
+// of an extra instance, not main, leaves out the passes of the main process.
+func (s *XrayService) builtinConfigProcessors(main bool) []ConfigProcessor {
+	processors := []ConfigProcessor{
+		s.applyOutboundChains,
+		s.applyClientOutbounds,
+		s.applyDnsSettings,
+		s.applyOutboundDns,
+		s.applyDomainOutbounds,
+		s.applySendThrough,
+		s.applyFreedomSettings,
+		s.applyBalancers,
+		s.applyRoutingRules,
+		s.applyReverseProxies,
+		s.applyBlockRules,
+		s.applyOutboundHealth,
+		// Before the stats pass, so it checks the counters of the edited levels
+		s.applyPolicySettings,
+		s.ensureStatsAPI,
+		s.applyEmptyInbounds,
+	}
+	// The fallback is the one of the main api port, an instance picks a free port when it starts
+	if main {
+		processors = append(processors, s.applyAPIPortFallback)
+	}
+	return append(processors,
+		// After the stats pass, the inbound levels copy its counters from level 0
+		s.applyInboundPolicies,
+		s.applyMaintenanceMode,
+		s.applyWarpOverrides,
+	)
+}
+
+// runConfigProcessors runs the built-in passes and then the registered ones
+func (s *XrayService) runConfigProcessors(xrayConfig *xray.Config, main bool) error {
+	for _, processor := range s.builtinConfigProcessors(main) {
+		if err := processor(xrayConfig); err != nil {
+			return err
+		}
+	}
+	for i, processor := range registeredConfigProcessors() {
+		if err := processor(xrayConfig); err != nil {
+			return common.NewErrorf("config processor %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func resetConfigProcessors(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		configProcessors.Lock()
+		configProcessors.list = nil
+		configProcessors.Unlock()
+		invalidateXrayConfigCache()
+	})
+}
+
+// outboundTags lists the tags of the config outbounds in order
+func outboundTags(t *testing.T, xrayConfig *xray.Config) []string {
+	t.Helper()
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func TestRegisterConfigProcessor(t *testing.T) {
+	setupTestDB(t)
+	resetConfigProcessors(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	if err := s.SetMaintenanceMode(true); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen [][]string
+	addOutbound := func(tag string) ConfigProcessor {
+		return func(xrayConfig *xray.Config) error {
+			seen = append(seen, outboundTags(t, xrayConfig))
+			outbounds, err := getOutbounds(xrayConfig)
+			if err != nil {
+				return err
+			}
+			return setOutbounds(xrayConfig, append(outbounds, map[string]interface{}{"tag": tag, "protocol": "freedom"}))
+		}
+	}
+	RegisterConfigProcessor(addOutbound("first"))
+	RegisterConfigProcessor(nil)
+	RegisterConfigProcessor(addOutbound("second"))
+	// The config patch still goes last
+	if err := s.SetConfigPatch(`{"log": {"loglevel": "debug"}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := outboundTags(t, xrayConfig)
+	if n := len(tags); n < 3 || !reflect.DeepEqual(tags[n-3:], []string{"maintenance", "first", "second"}) {
+		t.Fatalf("got outbounds %v, want the built-in maintenance one, then first and second", tags)
+	}
+	if len(seen) != 2 || len(seen[1]) != len(seen[0])+1 || seen[0][len(seen[0])-1] != "maintenance" {
+		t.Fatalf("processors saw outbounds %v", seen)
+	}
+	if !strings.Contains(string(xrayConfig.LogConfig), "debug") {
+		t.Fatalf("got log %s, want the patched one", xrayConfig.LogConfig)
+	}
+
+	// A failing processor aborts the generation and the ones after it do not run
+	RegisterConfigProcessor(func(xrayConfig *xray.Config) error { return errors.New("rejected") })
+	RegisterConfigProcessor(addOutbound("third"))
+	seen = nil
+	if _, err := s.GetXrayConfig(); err == nil || !strings.Contains(err.Error(), "config processor 3: rejected") {
+		t.Fatalf("got error %v, want the third processor to fail", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("got %d processors run, want the 2 before the failing one", len(seen))
+	}
+}
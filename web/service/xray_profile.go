@@ -0,0 +1,182 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+
+	"go.uber.org/atomic"
+)
+
+// The profile name of the template Xray was last started or reloaded with
+var liveTemplateProfile atomic.String
+
+var templateProfileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// TemplateProfiles lists the stored config templates. The active one is the template
+// GetXrayConfig builds from, the live one is what the running Xray was built from.
+type TemplateProfiles struct {
+	Active   string   `json:"active"`
+	Live     string   `json:"live"`
+	Profiles []string `json:"profiles"`
+}
+
+// getProfileTemplates returns the stored templates by name. The active template lives in
+// xrayTemplateConfig, its copy here is only refreshed when switching away from it.
+func (s *XraySettingService) getProfileTemplates() (map[string]string, error) {
+	templates := map[string]string{}
+	data, err := s.getString("xrayTemplateProfiles")
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return templates, nil
+	}
+	err = json.Unmarshal([]byte(data), &templates)
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (s *XraySettingService) saveProfileTemplates(templates map[string]string) error {
+	data, err := json.Marshal(templates)
+	if err != nil {
+		return err
+	}
+	return s.saveSetting("xrayTemplateProfiles", string(data))
+}
+
+func (s *XraySettingService) GetActiveProfile() (string, error) {
+	return s.getString("xrayTemplateProfile")
+}
+
+func (s *XraySettingService) GetTemplateProfiles() (*TemplateProfiles, error) {
+	active, err := s.GetActiveProfile()
+	if err != nil {
+		return nil, err
+	}
+	templates, err := s.getProfileTemplates()
+	if err != nil {
+		return nil, err
+	}
+	profiles := []string{active}
+	for name := range templates {
+		if name != active {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return &TemplateProfiles{
+		Active:   active,
+		Live:     liveTemplateProfile.Load(),
+		Profiles: profiles,
+	}, nil
+}
+
+func (s *XraySettingService) GetTemplateProfile(name string) (string, error) {
+	active, err := s.GetActiveProfile()
+	if err != nil {
+		return "", err
+	}
+	if name == active {
+		return s.GetXrayConfigTemplate()
+	}
+	templates, err := s.getProfileTemplates()
+	if err != nil {
+		return "", err
+	}
+	template, ok := templates[name]
+	if !ok {
+		return "", common.NewErrorf("template profile %s does not exist", name)
+	}
+	return template, nil
+}
+
+// SaveTemplateProfile creates or updates a profile, saving the active one changes the template
+func (s *XraySettingService) SaveTemplateProfile(name string, template string) error {
+	if !templateProfileNameRegex.MatchString(name) {
+		return common.NewErrorf("invalid template profile name %q", name)
+	}
+	if err := s.CheckXrayConfig(template); err != nil {
+		return err
+	}
+	active, err := s.GetActiveProfile()
+	if err != nil {
+		return err
+	}
+	if name == active {
+		return s.SaveXraySetting(template)
+	}
+	templates, err := s.getProfileTemplates()
+	if err != nil {
+		return err
+	}
+	templates[name] = template
+	return s.saveProfileTemplates(templates)
+}
+
+// SwitchTemplateProfile makes the profile the template of the next config, the caller restarts xray
+func (s *XraySettingService) SwitchTemplateProfile(name string) error {
+	active, err := s.GetActiveProfile()
+	if err != nil {
+		return err
+	}
+	if name == active {
+		return nil
+	}
+	templates, err := s.getProfileTemplates()
+	if err != nil {
+		return err
+	}
+	template, ok := templates[name]
+	if !ok {
+		return common.NewErrorf("template profile %s does not exist", name)
+	}
+	current, err := s.GetXrayConfigTemplate()
+	if err != nil {
+		return err
+	}
+	templates[active] = current
+	delete(templates, name)
+	if err = s.saveProfileTemplates(templates); err != nil {
+		return err
+	}
+	if err = s.SaveXraySetting(template); err != nil {
+		return err
+	}
+	logger.Infof("Switched xray template profile from %s to %s", active, name)
+	return s.saveSetting("xrayTemplateProfile", name)
+}
+
+func (s *XraySettingService) DelTemplateProfile(name string) error {
+	active, err := s.GetActiveProfile()
+	if err != nil {
+		return err
+	}
+	if name == active {
+		return common.NewError("the active template profile can not be deleted")
+	}
+	templates, err := s.getProfileTemplates()
+	if err != nil {
+		return err
+	}
+	if _, ok := templates[name]; !ok {
+		return common.NewErrorf("template profile %s does not exist", name)
+	}
+	delete(templates, name)
+	return s.saveProfileTemplates(templates)
+}
+
+// recordLiveProfile notes the active profile once Xray runs with a config built from it
+func (s *XrayService) recordLiveProfile() {
+	profile, err := s.settingService.getString("xrayTemplateProfile")
+	if err != nil {
+		logger.Warning("Failed to read the template profile:", err)
+		return
+	}
+	liveTemplateProfile.Store(profile)
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// ShareLinkBuilder builds the share link of a client, an empty link means the protocol has none
+type ShareLinkBuilder func(inbound *model.Inbound, email string, host string) string
+
+var shareLinkBuilder ShareLinkBuilder
+
+// RegisterShareLinkBuilder sets the share link generator, it lives in the sub package which imports this one
+func RegisterShareLinkBuilder(builder ShareLinkBuilder) {
+	shareLinkBuilder = builder
+}
+
+// GenerateClientQR returns the share link of a client encoded as a PNG QR code
+func (s *XrayService) GenerateClientQR(inboundId int, email string) ([]byte, error) {
+	if shareLinkBuilder == nil {
+		return nil, common.NewError("share link generator is not available")
+	}
+	inbound := &model.Inbound{}
+	err := database.GetDB().Model(model.Inbound{}).Preload("ClientStats").First(inbound, inboundId).Error
+	if err != nil {
+		return nil, err
+	}
+	host, err := s.ServerPublicAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	link := shareLinkBuilder(inbound, email, host)
+	if link == "" {
+		return nil, common.NewErrorf("no share link for client %s of %s inbound", email, inbound.Protocol)
+	}
+	return qrcode.Encode(link, qrcode.Medium, 512)
+}
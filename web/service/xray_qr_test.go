@@ -0,0 +1,64 @@
+package service
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"x-ui/database/model"
+)
+
+func TestGenerateClientQR(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	if err := s.settingService.saveSetting("publicAddress", "vpn.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	oldBuilder := shareLinkBuilder
+	t.Cleanup(func() { shareLinkBuilder = oldBuilder })
+	RegisterShareLinkBuilder(func(inbound *model.Inbound, email string, host string) string {
+		if email != "inbound-20001@test" {
+			return ""
+		}
+		return "vless://" + email + "@" + host + ":20001"
+	})
+
+	tests := []struct {
+		name      string
+		inboundId int
+		email     string
+		wantErr   string
+	}{
+		{name: "client link", inboundId: inbound.Id, email: "inbound-20001@test"},
+		{name: "no share link", inboundId: inbound.Id, email: "other@test", wantErr: "no share link for client other@test of vless inbound"},
+		{name: "unknown inbound", inboundId: 999, email: "inbound-20001@test", wantErr: "record not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := s.GenerateClientQR(tt.inboundId, tt.email)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("not a valid png: %v", err)
+			}
+			if size := img.Bounds().Size(); size.X != 512 || size.Y != 512 {
+				t.Fatalf("got a %v image, want 512x512", size)
+			}
+		})
+	}
+
+	shareLinkBuilder = nil
+	if _, err := s.GenerateClientQR(inbound.Id, "inbound-20001@test"); err == nil {
+		t.Fatal("generated a qr code without a share link generator")
+	}
+}
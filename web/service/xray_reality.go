@@ -0,0 +1,231 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+// realityRotation is a pending key switch of a reality inbound
+type realityRotation struct {
+	PrivateKey  string   `json:"privateKey"`
+	PublicKey   string   `json:"publicKey"`
+	ShortIds    []string `json:"shortIds"`
+	OldShortIds []string `json:"oldShortIds"`
+	SwitchAt    int64    `json:"switchAt"`
+}
+
+// RealityKeys are the keys a rotation gives out to the clients. SwitchAt, unix milliseconds, is
+// when the inbound starts using them.
+type RealityKeys struct {
+	PublicKey string   `json:"publicKey"`
+	ShortIds  []string `json:"shortIds"`
+	SwitchAt  int64    `json:"switchAt"`
+}
+
+// generateRealityKeyPair returns an x25519 key pair encoded the way xray x25519 prints it
+func generateRealityKeyPair() (string, string, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(privateKey.Bytes()),
+		base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes()), nil
+}
+
+func generateRealityShortIds(count int) ([]string, error) {
+	shortIds := make([]string, count)
+	for i := range shortIds {
+		// lengths 2, 4, ... up to the 16 hex digits xray allows
+		b := make([]byte, i%8+1)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		shortIds[i] = hex.EncodeToString(b)
+	}
+	return shortIds, nil
+}
+
+func (s *XrayService) getRealityRotations() (map[string]*realityRotation, error) {
+	data, err := s.settingService.GetRealityRotations()
+	if err != nil {
+		return nil, err
+	}
+	rotations := map[string]*realityRotation{}
+	if data == "" {
+		return rotations, nil
+	}
+	err = json.Unmarshal([]byte(data), &rotations)
+	if err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
+
+func (s *XrayService) saveRealityRotations(rotations map[string]*realityRotation) error {
+	data, err := json.Marshal(rotations)
+	if err != nil {
+		return err
+	}
+	return s.settingService.SetRealityRotations(string(data))
+}
+
+func getRealitySettings(inbound *model.Inbound) (map[string]interface{}, map[string]interface{}, error) {
+	var stream map[string]interface{}
+	err := json.Unmarshal([]byte(inbound.StreamSettings), &stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	if security, _ := stream["security"].(string); security != "reality" {
+		return nil, nil, common.NewErrorf("inbound %d does not use reality", inbound.Id)
+	}
+	reality, ok := stream["realitySettings"].(map[string]interface{})
+	if !ok {
+		return nil, nil, common.NewErrorf("inbound %d has no realitySettings", inbound.Id)
+	}
+	return stream, reality, nil
+}
+
+func saveStreamSettings(inboundId int, stream map[string]interface{}) error {
+	data, err := json.MarshalIndent(stream, "", "  ")
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Model(model.Inbound{}).Where("id = ?", inboundId).
+		Update("stream_settings", string(data)).Error
+}
+
+// RotateRealityKeys generates a new key pair and short ids for a reality inbound and returns them for redistribution.
+// Xray takes a single private key per inbound, so the two keys never work at the same time: the old key keeps
+// serving for graceFor and the new public key only works from SwitchAt on, when the old one stops working. The
+// new short ids are accepted with the old key already, the old short ids are dropped at the switch.
+func (s *XrayService) RotateRealityKeys(inboundId int, graceFor time.Duration) (*RealityKeys, error) {
+	if graceFor < 0 {
+		return nil, common.NewError("grace period cannot be negative")
+	}
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return nil, err
+	}
+	stream, reality, err := getRealitySettings(inbound)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, publicKey, err := generateRealityKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	shortIds, err := generateRealityShortIds(8)
+	if err != nil {
+		return nil, err
+	}
+	var oldShortIds []string
+	existing, _ := reality["shortIds"].([]interface{})
+	for _, id := range existing {
+		if shortId, ok := id.(string); ok {
+			oldShortIds = append(oldShortIds, shortId)
+		}
+	}
+
+	rotation := &realityRotation{
+		PrivateKey:  privateKey,
+		PublicKey:   publicKey,
+		ShortIds:    shortIds,
+		OldShortIds: oldShortIds,
+		SwitchAt:    time.Now().Add(graceFor).Unix() * 1000,
+	}
+	if graceFor == 0 {
+		err = applyRealityRotation(inboundId, stream, reality, rotation)
+	} else {
+		// Accept the new short ids next to the old ones until the switch
+		accepted := make([]interface{}, 0, len(oldShortIds)+len(shortIds))
+		for _, id := range oldShortIds {
+			accepted = append(accepted, id)
+		}
+		for _, id := range shortIds {
+			accepted = append(accepted, id)
+		}
+		reality["shortIds"] = accepted
+		err = saveStreamSettings(inboundId, stream)
+		if err == nil {
+			var rotations map[string]*realityRotation
+			rotations, err = s.getRealityRotations()
+			if err == nil {
+				rotations[strconv.Itoa(inboundId)] = rotation
+				err = s.saveRealityRotations(rotations)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.SetToNeedRestart()
+	return &RealityKeys{PublicKey: publicKey, ShortIds: shortIds, SwitchAt: rotation.SwitchAt}, nil
+}
+
+func applyRealityRotation(inboundId int, stream map[string]interface{}, reality map[string]interface{}, rotation *realityRotation) error {
+	reality["privateKey"] = rotation.PrivateKey
+	shortIds := make([]interface{}, len(rotation.ShortIds))
+	for i, id := range rotation.ShortIds {
+		shortIds[i] = id
+	}
+	reality["shortIds"] = shortIds
+	settings, ok := reality["settings"].(map[string]interface{})
+	if !ok {
+		settings = map[string]interface{}{}
+		reality["settings"] = settings
+	}
+	settings["publicKey"] = rotation.PublicKey
+	return saveStreamSettings(inboundId, stream)
+}
+
+// ApplyDueRealityRotations switches the keys of rotations whose grace period ended
+func (s *XrayService) ApplyDueRealityRotations() error {
+	rotations, err := s.getRealityRotations()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix() * 1000
+	changed := false
+	for id, rotation := range rotations {
+		if rotation.SwitchAt > now {
+			continue
+		}
+		delete(rotations, id)
+		changed = true
+
+		inboundId, _ := strconv.Atoi(id)
+		inbound, err := s.inboundService.GetInbound(inboundId)
+		if err != nil {
+			logger.Warningf("Drop reality rotation of inbound %s: %v", id, err)
+			continue
+		}
+		stream, reality, err := getRealitySettings(inbound)
+		if err != nil {
+			logger.Warningf("Drop reality rotation of inbound %s: %v", id, err)
+			continue
+		}
+		if err = applyRealityRotation(inboundId, stream, reality, rotation); err != nil {
+			return err
+		}
+		logger.Infof("Switched reality keys of inbound %s", id)
+	}
+	if !changed {
+		return nil
+	}
+	if err = s.saveRealityRotations(rotations); err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
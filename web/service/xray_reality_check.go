@@ -0,0 +1,228 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+const realityCheckTimeout = 10 * time.Second
+
+// RealityNameCheck is the handshake with dest for one of the serverNames
+type RealityNameCheck struct {
+	ServerName string `json:"serverName"`
+	Valid      bool   `json:"valid"`
+	TLS13      bool   `json:"tls13"`
+	H2         bool   `json:"h2"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RealityCheck tells whether dest is a TLS 1.3 server with a certificate for every serverName
+type RealityCheck struct {
+	Dest  string             `json:"dest"`
+	Valid bool               `json:"valid"`
+	Names []RealityNameCheck `json:"names"`
+	Error string             `json:"error,omitempty"`
+}
+
+// realityDestAddress turns the dest forms Xray accepts ("host:port", "port", a number) into host:port
+func realityDestAddress(dest interface{}) (string, error) {
+	switch d := dest.(type) {
+	case float64:
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(d))), nil
+	case string:
+		if strings.HasPrefix(d, "/") || strings.HasPrefix(d, "@") {
+			return "", common.NewErrorf("dest %s is a unix socket and cannot be checked", d)
+		}
+		if _, err := strconv.Atoi(d); err == nil {
+			return net.JoinHostPort("127.0.0.1", d), nil
+		}
+		if _, _, err := net.SplitHostPort(d); err != nil {
+			return "", common.NewErrorf("invalid dest %q: %v", d, err)
+		}
+		return d, nil
+	}
+	return "", common.NewError("reality dest is not set")
+}
+
+// checkRealityName handshakes with the SNI a client would send and verifies the certificate for it,
+// against the system pool when roots is nil
+func checkRealityName(address string, serverName string, roots *x509.CertPool) RealityNameCheck {
+	check := RealityNameCheck{ServerName: serverName}
+	dialer := &net.Dialer{Timeout: realityCheckTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName: serverName,
+		// Browsers offer h2, a dest without it stands out from the site it imitates
+		NextProtos: []string{"h2", "http/1.1"},
+		// Verified below, to tell a name mismatch from an untrusted chain
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	check.TLS13 = state.Version == tls.VersionTLS13
+	check.H2 = state.NegotiatedProtocol == "h2"
+	if len(state.PeerCertificates) == 0 {
+		check.Error = "dest presented no certificate"
+		return check
+	}
+	leaf := state.PeerCertificates[0]
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		check.Error = fmt.Sprintf("certificate does not cover %s, it is for %s", serverName, strings.Join(leaf.DNSNames, ", "))
+		return check
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: serverName})
+	if err != nil {
+		check.Error = "certificate is not trusted: " + err.Error()
+		return check
+	}
+	if !check.TLS13 {
+		check.Error = "dest does not negotiate TLS 1.3, reality needs it"
+		return check
+	}
+	check.Valid = true
+	return check
+}
+
+// ValidateReality connects to the dest of a reality inbound once per serverName and checks that the
+// certificate it presents covers the name. Problems with dest are reported in the check.
+func (s *XrayService) ValidateReality(inboundId int) (RealityCheck, error) {
+	check := RealityCheck{Names: []RealityNameCheck{}}
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return check, err
+	}
+	_, reality, err := getRealitySettings(inbound)
+	if err != nil {
+		return check, err
+	}
+	check.Dest = fmt.Sprint(reality["dest"])
+	address, err := realityDestAddress(reality["dest"])
+	if err != nil {
+		check.Error = err.Error()
+		return check, nil
+	}
+
+	names, _ := reality["serverNames"].([]interface{})
+	for _, name := range names {
+		serverName, _ := name.(string)
+		if serverName == "" {
+			continue
+		}
+		check.Names = append(check.Names, checkRealityName(address, serverName, nil))
+	}
+	if len(check.Names) == 0 {
+		check.Error = "reality has no serverNames"
+		return check, nil
+	}
+	check.Valid = true
+	for _, name := range check.Names {
+		if !name.Valid {
+			check.Valid = false
+		}
+	}
+	return check, nil
+}
+
+// RealityHealth is the last periodic check of a reality inbound
+type RealityHealth struct {
+	InboundId int          `json:"inboundId"`
+	Remark    string       `json:"remark"`
+	CheckedAt int64        `json:"checkedAt"`
+	Check     RealityCheck `json:"check"`
+}
+
+var (
+	realityHealth     = map[int]*RealityHealth{}
+	realityHealthLock sync.RWMutex
+)
+
+// CheckRealityDests validates the dest and serverNames of every enabled reality inbound, and warns
+// when one that passed before fails, as the camouflage site changed under it
+func (s *XrayService) CheckRealityDests() error {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return err
+	}
+	checked := map[int]*RealityHealth{}
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		if _, _, err := getRealitySettings(inbound); err != nil {
+			continue
+		}
+		check, err := s.ValidateReality(inbound.Id)
+		if err != nil {
+			logger.Warningf("Failed to check reality of inbound %d: %v", inbound.Id, err)
+			continue
+		}
+		checked[inbound.Id] = &RealityHealth{
+			InboundId: inbound.Id,
+			Remark:    inbound.Remark,
+			CheckedAt: time.Now().Unix() * 1000,
+			Check:     check,
+		}
+	}
+
+	realityHealthLock.Lock()
+	previous := realityHealth
+	realityHealth = checked
+	realityHealthLock.Unlock()
+	for id, health := range checked {
+		if health.Check.Valid {
+			continue
+		}
+		if last, ok := previous[id]; !ok || last.Check.Valid {
+			logger.Warningf("Reality dest %s of inbound %d is no longer suitable: %s", health.Check.Dest, id, realityCheckProblem(health.Check))
+		}
+	}
+	return nil
+}
+
+// realityCheckProblem returns the first error of the check
+func realityCheckProblem(check RealityCheck) string {
+	if check.Error != "" {
+		return check.Error
+	}
+	for _, name := range check.Names {
+		if name.Error != "" {
+			return name.ServerName + ": " + name.Error
+		}
+	}
+	return ""
+}
+
+// GetRealityHealth returns the last check of the reality inbounds, the failing ones first
+func (s *XrayService) GetRealityHealth() []*RealityHealth {
+	realityHealthLock.RLock()
+	health := make([]*RealityHealth, 0, len(realityHealth))
+	for _, h := range realityHealth {
+		health = append(health, h)
+	}
+	realityHealthLock.RUnlock()
+	sort.Slice(health, func(i, j int) bool {
+		if health[i].Check.Valid != health[j].Check.Valid {
+			return !health[i].Check.Valid
+		}
+		return health[i].InboundId < health[j].InboundId
+	})
+	return health
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+)
+
+// startRealityDest serves TLS with the httptest certificate, which covers example.com
+func startRealityDest(t *testing.T, maxVersion uint16) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	server.TLS.MaxVersion = maxVersion
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRealityDestAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		dest    interface{}
+		want    string
+		wantErr string
+	}{
+		{name: "host and port", dest: "www.example.com:443", want: "www.example.com:443"},
+		{name: "port number", dest: 8443.0, want: "127.0.0.1:8443"},
+		{name: "port string", dest: "8443", want: "127.0.0.1:8443"},
+		{name: "ipv6", dest: "[2001:db8::1]:443", want: "[2001:db8::1]:443"},
+		{name: "unix socket", dest: "/dev/shm/site.sock", wantErr: "is a unix socket"},
+		{name: "abstract socket", dest: "@site", wantErr: "is a unix socket"},
+		{name: "no port", dest: "www.example.com", wantErr: `invalid dest "www.example.com"`},
+		{name: "not set", dest: nil, wantErr: "reality dest is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := realityDestAddress(tt.dest)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRealityName(t *testing.T) {
+	modern := startRealityDest(t, 0)
+	legacy := startRealityDest(t, tls.VersionTLS12)
+	trusted := x509.NewCertPool()
+	trusted.AddCert(modern.Certificate())
+
+	tests := []struct {
+		name       string
+		server     *httptest.Server
+		serverName string
+		roots      *x509.CertPool
+		wantErr    string
+	}{
+		{name: "covered name", server: modern, serverName: "example.com", roots: trusted},
+		{name: "other name", server: modern, serverName: "www.example.org", roots: trusted, wantErr: "certificate does not cover www.example.org"},
+		{name: "untrusted chain", server: modern, serverName: "example.com", roots: x509.NewCertPool(), wantErr: "certificate is not trusted"},
+		{name: "tls 1.2 only", server: legacy, serverName: "example.com", roots: trusted, wantErr: "does not negotiate TLS 1.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkRealityName(tt.server.Listener.Addr().String(), tt.serverName, tt.roots)
+			if tt.wantErr != "" {
+				if check.Valid || !strings.Contains(check.Error, tt.wantErr) {
+					t.Fatalf("got %+v, want error %q", check, tt.wantErr)
+				}
+				return
+			}
+			if !check.Valid || !check.TLS13 || !check.H2 || check.Error != "" {
+				t.Fatalf("got %+v, want a valid check", check)
+			}
+		})
+	}
+
+	check := checkRealityName("127.0.0.1:1", "example.com", trusted)
+	if check.Valid || check.Error == "" {
+		t.Fatalf("got %+v for a closed port", check)
+	}
+}
+
+func TestValidateReality(t *testing.T) {
+	server := startRealityDest(t, 0)
+	dest := server.Listener.Addr().String()
+	tests := []struct {
+		name      string
+		reality   string
+		wantErr   string
+		wantNames []string
+	}{
+		{
+			name:      "checks every name",
+			reality:   `{"dest": "` + dest + `", "serverNames": ["example.com", "", "www.example.org"]}`,
+			wantNames: []string{"example.com", "www.example.org"},
+		},
+		{name: "no server names", reality: `{"dest": "` + dest + `", "serverNames": []}`, wantErr: "reality has no serverNames"},
+		{name: "unix socket dest", reality: `{"dest": "@site", "serverNames": ["example.com"]}`, wantErr: "is a unix socket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			inbound := addTestInbound(t, 20001, "inbound-20001", true)
+			inbound.StreamSettings = `{"network": "tcp", "security": "reality", "realitySettings": ` + tt.reality + `}`
+			if err := database.GetDB().Save(inbound).Error; err != nil {
+				t.Fatal(err)
+			}
+			s := &XrayService{}
+			check, err := s.ValidateReality(inbound.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if check.Valid {
+				t.Fatalf("got a valid check %+v for a dest with a self-signed certificate", check)
+			}
+			if tt.wantErr != "" {
+				if !strings.Contains(check.Error, tt.wantErr) {
+					t.Fatalf("got error %q, want %q", check.Error, tt.wantErr)
+				}
+				return
+			}
+			var names []string
+			for _, name := range check.Names {
+				names = append(names, name.ServerName)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Fatalf("got names %v, want %v", names, tt.wantNames)
+			}
+			// The certificate covers the first name but is not in the system pool
+			if !strings.Contains(check.Names[0].Error, "certificate is not trusted") || !strings.Contains(check.Names[1].Error, "does not cover") {
+				t.Fatalf("got name checks %+v", check.Names)
+			}
+		})
+	}
+
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20002, "inbound-20002", true)
+	inbound.StreamSettings = `{"network": "tcp", "security": "tls"}`
+	if err := database.GetDB().Save(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	s := &XrayService{}
+	if _, err := s.ValidateReality(inbound.Id); err == nil || !strings.Contains(err.Error(), "does not use reality") {
+		t.Fatalf("got error %v for an inbound without reality", err)
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+const (
+	// Conflicting reality inbounds are logged
+	RealityConflictsWarn = "warn"
+	// Conflicting reality inbounds make the config generation fail
+	RealityConflictsError = "error"
+)
+
+// RealityConflict is a pair of reality inbounds with the same dest that accept the same short ids,
+// a handshake can then be taken by the wrong inbound
+type RealityConflict struct {
+	Dest     string   `json:"dest"`
+	Inbounds []string `json:"inbounds"`
+	ShortIds []string `json:"shortIds"`
+}
+
+func (c RealityConflict) String() string {
+	return "inbounds " + strings.Join(c.Inbounds, " and ") + " share dest " + c.Dest +
+		" with short ids [" + strings.Join(c.ShortIds, ", ") + "]"
+}
+
+// findRealityConflicts compares the short ids of every two reality inbounds with the same dest
+func findRealityConflicts(inbounds []xray.InboundConfig) []RealityConflict {
+	type realityInbound struct {
+		tag      string
+		shortIds map[string]bool
+	}
+	byDest := map[string][]realityInbound{}
+	var dests []string
+	for _, inbound := range inbounds {
+		var stream struct {
+			Security        string `json:"security"`
+			RealitySettings struct {
+				Dest     string   `json:"dest"`
+				Target   string   `json:"target"`
+				ShortIds []string `json:"shortIds"`
+			} `json:"realitySettings"`
+		}
+		if len(inbound.StreamSettings) == 0 || json.Unmarshal(inbound.StreamSettings, &stream) != nil {
+			continue
+		}
+		if stream.Security != "reality" {
+			continue
+		}
+		dest := stream.RealitySettings.Dest
+		if dest == "" {
+			dest = stream.RealitySettings.Target
+		}
+		shortIds := map[string]bool{}
+		for _, shortId := range stream.RealitySettings.ShortIds {
+			shortIds[strings.ToLower(shortId)] = true
+		}
+		if _, ok := byDest[dest]; !ok {
+			dests = append(dests, dest)
+		}
+		byDest[dest] = append(byDest[dest], realityInbound{inbound.Tag, shortIds})
+	}
+
+	var conflicts []RealityConflict
+	for _, dest := range dests {
+		group := byDest[dest]
+		for i := 0; i < len(group); i++ {
+			for j := i + 1; j < len(group); j++ {
+				var shared []string
+				for shortId := range group[i].shortIds {
+					if group[j].shortIds[shortId] {
+						shared = append(shared, shortId)
+					}
+				}
+				if len(shared) == 0 {
+					continue
+				}
+				sort.Strings(shared)
+				conflicts = append(conflicts, RealityConflict{
+					Dest:     dest,
+					Inbounds: []string{group[i].tag, group[j].tag},
+					ShortIds: shared,
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+// checkRealityConflicts logs the conflicts, or fails with them when the setting asks for errors
+func (s *XrayService) checkRealityConflicts(inbounds []xray.InboundConfig) error {
+	conflicts := findRealityConflicts(inbounds)
+	if len(conflicts) == 0 {
+		return nil
+	}
+	mode, err := s.settingService.GetRealityConflicts()
+	if err != nil {
+		return err
+	}
+	if mode == RealityConflictsError {
+		messages := make([]string, len(conflicts))
+		for i, conflict := range conflicts {
+			messages[i] = conflict.String()
+		}
+		return common.NewErrorf("reality conflicts: %s", strings.Join(messages, "; "))
+	}
+	for _, conflict := range conflicts {
+		logger.Warning("Reality conflict:", conflict)
+	}
+	return nil
+}
+
+func (s *XrayService) SetRealityConflicts(mode string) error {
+	if mode != RealityConflictsWarn && mode != RealityConflictsError {
+		return common.NewErrorf("reality conflicts must be %s or %s", RealityConflictsWarn, RealityConflictsError)
+	}
+	return s.settingService.SetRealityConflicts(mode)
+}
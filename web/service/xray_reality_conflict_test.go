@@ -0,0 +1,95 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func realityTestInbound(tag string, stream string) xray.InboundConfig {
+	return xray.InboundConfig{Tag: tag, Protocol: "vless", StreamSettings: []byte(stream)}
+}
+
+func TestFindRealityConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		inbounds []xray.InboundConfig
+		want     []RealityConflict
+	}{
+		{
+			name: "shared short id",
+			inbounds: []xray.InboundConfig{
+				realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["", "ab12"]}}`),
+				realityTestInbound("b", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["AB12", "cd34"]}}`),
+			},
+			want: []RealityConflict{{Dest: "example.com:443", Inbounds: []string{"a", "b"}, ShortIds: []string{"ab12"}}},
+		},
+		{
+			name: "target counts as dest",
+			inbounds: []xray.InboundConfig{
+				realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": [""]}}`),
+				realityTestInbound("b", `{"security": "reality", "realitySettings": {"target": "example.com:443", "shortIds": [""]}}`),
+			},
+			want: []RealityConflict{{Dest: "example.com:443", Inbounds: []string{"a", "b"}, ShortIds: []string{""}}},
+		},
+		{
+			name: "different dests",
+			inbounds: []xray.InboundConfig{
+				realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+				realityTestInbound("b", `{"security": "reality", "realitySettings": {"dest": "example.org:443", "shortIds": ["ab12"]}}`),
+			},
+		},
+		{
+			name: "different short ids",
+			inbounds: []xray.InboundConfig{
+				realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+				realityTestInbound("b", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["cd34"]}}`),
+			},
+		},
+		{
+			name: "tls inbound is ignored",
+			inbounds: []xray.InboundConfig{
+				realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+				realityTestInbound("b", `{"security": "tls", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+				{Tag: "c", Protocol: "vless"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findRealityConflicts(tt.inbounds); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRealityConflicts(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	conflicting := []xray.InboundConfig{
+		realityTestInbound("a", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+		realityTestInbound("b", `{"security": "reality", "realitySettings": {"dest": "example.com:443", "shortIds": ["ab12"]}}`),
+	}
+
+	// The default only warns
+	if err := s.checkRealityConflicts(conflicting); err != nil {
+		t.Fatalf("got error %v in warn mode", err)
+	}
+	if err := s.SetRealityConflicts("fail"); err == nil {
+		t.Fatal("got no error for an unknown mode")
+	}
+	if err := s.SetRealityConflicts(RealityConflictsError); err != nil {
+		t.Fatal(err)
+	}
+	err := s.checkRealityConflicts(conflicting)
+	if err == nil || !strings.Contains(err.Error(), "inbounds a and b share dest example.com:443 with short ids [ab12]") {
+		t.Fatalf("got error %v", err)
+	}
+	if err := s.checkRealityConflicts(conflicting[:1]); err != nil {
+		t.Fatalf("got error %v without conflicts", err)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"x-ui/database"
+)
+
+const realityTestStream = `{"network": "tcp", "security": "reality", "realitySettings": {
+	"dest": "example.com:443", "serverNames": ["example.com"],
+	"privateKey": "old-private-key", "shortIds": ["0a", "0b1c"], "settings": {"publicKey": "old-public-key"}}}`
+
+type realityTestSettings struct {
+	PrivateKey string   `json:"privateKey"`
+	ShortIds   []string `json:"shortIds"`
+	Settings   struct {
+		PublicKey string `json:"publicKey"`
+	} `json:"settings"`
+}
+
+func storedRealitySettings(t *testing.T, inboundId int) realityTestSettings {
+	t.Helper()
+	s := &InboundService{}
+	inbound, err := s.GetInbound(inboundId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream struct {
+		RealitySettings realityTestSettings `json:"realitySettings"`
+	}
+	if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+		t.Fatal(err)
+	}
+	return stream.RealitySettings
+}
+
+// checkRealityKeyPair fails unless publicKey belongs to privateKey
+func checkRealityKeyPair(t *testing.T, privateKey, publicKey string) {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		t.Fatalf("invalid private key: %v", err)
+	}
+	if got := base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()); got != publicKey {
+		t.Fatalf("public key %s does not belong to the private key, want %s", publicKey, got)
+	}
+}
+
+func TestRotateRealityKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		graceFor time.Duration
+		wantErr  string
+	}{
+		{name: "immediate switch", stream: realityTestStream},
+		{name: "grace period", stream: realityTestStream, graceFor: time.Hour},
+		{name: "negative grace period", stream: realityTestStream, graceFor: -time.Second, wantErr: "grace period cannot be negative"},
+		{name: "not a reality inbound", stream: `{"network": "tcp", "security": "tls"}`, wantErr: "does not use reality"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			inbound := addTestInbound(t, 20001, "inbound-20001", true)
+			inbound.StreamSettings = tt.stream
+			if err := database.GetDB().Save(inbound).Error; err != nil {
+				t.Fatal(err)
+			}
+			s := &XrayService{}
+			t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+
+			rotatedAt := time.Now()
+			keys, err := s.RotateRealityKeys(inbound.Id, tt.graceFor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey, shortIds := keys.PublicKey, keys.ShortIds
+			// The new key works from the end of the grace period on
+			if switchAt := time.UnixMilli(keys.SwitchAt); switchAt.Before(rotatedAt.Add(tt.graceFor).Add(-time.Second)) || switchAt.After(time.Now().Add(tt.graceFor)) {
+				t.Fatalf("got switch at %v, want %v after the rotation", switchAt, tt.graceFor)
+			}
+			if len(shortIds) != 8 {
+				t.Fatalf("got %d short ids, want 8", len(shortIds))
+			}
+			if !s.IsNeedRestartAndSetFalse() {
+				t.Fatal("rotation did not request a restart")
+			}
+
+			if tt.graceFor > 0 {
+				// Both sets of short ids are accepted, the old key keeps serving
+				reality := storedRealitySettings(t, inbound.Id)
+				if reality.PrivateKey != "old-private-key" || reality.Settings.PublicKey != "old-public-key" {
+					t.Fatalf("key switched during the grace period: %+v", reality)
+				}
+				want := append([]string{"0a", "0b1c"}, shortIds...)
+				if strings.Join(reality.ShortIds, ",") != strings.Join(want, ",") {
+					t.Fatalf("got short ids %v during the grace period, want %v", reality.ShortIds, want)
+				}
+
+				// Not due yet
+				if err := s.ApplyDueRealityRotations(); err != nil {
+					t.Fatal(err)
+				}
+				if got := storedRealitySettings(t, inbound.Id); got.PrivateKey != "old-private-key" {
+					t.Fatal("key switched before the grace period ended")
+				}
+
+				// The grace period ends
+				rotations, err := s.getRealityRotations()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rotations) != 1 {
+					t.Fatalf("got %d pending rotations, want 1", len(rotations))
+				}
+				for _, rotation := range rotations {
+					rotation.SwitchAt = time.Now().Add(-time.Second).Unix() * 1000
+				}
+				if err := s.saveRealityRotations(rotations); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.ApplyDueRealityRotations(); err != nil {
+					t.Fatal(err)
+				}
+				if rotations, _ := s.getRealityRotations(); len(rotations) != 0 {
+					t.Fatalf("applied rotation is still pending: %v", rotations)
+				}
+			}
+
+			reality := storedRealitySettings(t, inbound.Id)
+			if reality.Settings.PublicKey != publicKey {
+				t.Fatalf("got public key %s, want %s", reality.Settings.PublicKey, publicKey)
+			}
+			checkRealityKeyPair(t, reality.PrivateKey, publicKey)
+			if strings.Join(reality.ShortIds, ",") != strings.Join(shortIds, ",") {
+				t.Fatalf("got short ids %v, want only the new %v", reality.ShortIds, shortIds)
+			}
+		})
+	}
+}
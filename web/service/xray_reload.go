@@ -0,0 +1,80 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+)
+
+// configSettings are the settings genXrayConfig reads, changing one changes the generated config
+var configSettings = map[string]bool{
+	"xrayTemplateConfig":    true,
+	"xrayStandby":           true,
+	"timeLocation":          true,
+	"sockoptKeepAlive":      true,
+	"sockoptFastOpen":       true,
+	"inboundValidation":     true,
+	"tlsAlpn":               true,
+	"tlsFingerprint":        true,
+	"clientTimeWindows":     true,
+	"maxClientsPerInbound":  true,
+	"outboundChains":        true,
+	"clientOutbounds":       true,
+	"outboundDns":           true,
+	"domainOutbounds":       true,
+	"blockRules":            true,
+	"outboundHealthExclude": true,
+	"statsAutoInject":       true,
+	"configPatch":           true,
+	"balancers":             true,
+	"observatory":           true,
+	"dnsSettings":           true,
+	"freedomSettings":       true,
+	"reverseProxies":        true,
+	"policySettings":        true,
+	"xrayInstances":         true,
+	"outboundSendThrough":   true,
+	"clientGroups":          true,
+	"realityConflicts":      true,
+	"maintenanceMode":       true,
+	"inboundPolicies":       true,
+	"emptyInbounds":         true,
+	"warpMtu":               true,
+	"warpWorkers":           true,
+}
+
+// Saving several settings in a row, as the settings page does, asks for a single restart
+const settingsRestartDebounce = 2 * time.Second
+
+var settingsRestart struct {
+	sync.Mutex
+	timer *time.Timer
+}
+
+func init() {
+	OnSettingsChanged(func(key string) {
+		(&XrayService{}).onSettingChanged(key)
+	})
+}
+
+func (s *XrayService) onSettingChanged(key string) {
+	if strings.HasPrefix(key, "publicAddress") {
+		publicAddressLock.Lock()
+		publicAddressCache = ""
+		publicAddressExpires = time.Time{}
+		publicAddressLock.Unlock()
+	}
+	if !configSettings[key] {
+		return
+	}
+	logger.Debug("Config setting changed:", key)
+
+	settingsRestart.Lock()
+	defer settingsRestart.Unlock()
+	if settingsRestart.timer != nil {
+		settingsRestart.timer.Stop()
+	}
+	settingsRestart.timer = time.AfterFunc(settingsRestartDebounce, s.SetToNeedRestart)
+}
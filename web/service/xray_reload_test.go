@@ -0,0 +1,100 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOnSettingsChanged(t *testing.T) {
+	setupTestDB(t)
+	listeners := settingsListeners
+	t.Cleanup(func() {
+		settingsListenersLock.Lock()
+		settingsListeners = listeners
+		settingsListenersLock.Unlock()
+	})
+	var changed []string
+	OnSettingsChanged(func(key string) {
+		if key == "subTitle" {
+			changed = append(changed, key)
+		}
+	})
+
+	s := &SettingService{}
+	for _, value := range []string{"first", "first", "second"} {
+		if err := s.saveSetting("subTitle", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving the same value again is not a change
+	if want := []string{"subTitle", "subTitle"}; !reflect.DeepEqual(changed, want) {
+		t.Fatalf("got changes %v, want %v", changed, want)
+	}
+}
+
+func TestOnSettingChanged(t *testing.T) {
+	setupTestDB(t)
+	stopRestart := func() bool {
+		settingsRestart.Lock()
+		defer settingsRestart.Unlock()
+		pending := settingsRestart.timer != nil && settingsRestart.timer.Stop()
+		settingsRestart.timer = nil
+		return pending
+	}
+	stopRestart()
+	t.Cleanup(func() { stopRestart() })
+
+	tests := []struct {
+		name        string
+		key         string
+		value       string
+		wantRestart bool
+	}{
+		{"config setting", "sockoptKeepAlive", "30", true},
+		{"template", "xrayTemplateConfig", `{"log": {}}`, true},
+		{"panel setting", "webPort", "2099", false},
+		{"public address", "publicAddressEcho", "https://ip.example.com", false},
+	}
+	s := &SettingService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.saveSetting(tt.key, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if restart := stopRestart(); restart != tt.wantRestart {
+				t.Fatalf("restart scheduled %v, want %v", restart, tt.wantRestart)
+			}
+		})
+	}
+
+	// A public address setting drops the cached address
+	publicAddressLock.Lock()
+	publicAddressCache = "203.0.113.1"
+	publicAddressLock.Unlock()
+	if err := s.saveSetting("publicAddressMode", "stun"); err != nil {
+		t.Fatal(err)
+	}
+	publicAddressLock.Lock()
+	cached := publicAddressCache
+	publicAddressLock.Unlock()
+	if cached != "" {
+		t.Fatalf("public address %q is still cached", cached)
+	}
+
+	// A burst of changes leaves a single restart pending
+	if err := s.saveSetting("sockoptKeepAlive", "60"); err != nil {
+		t.Fatal(err)
+	}
+	settingsRestart.Lock()
+	first := settingsRestart.timer
+	settingsRestart.Unlock()
+	if err := s.saveSetting("tlsAlpn", "h2"); err != nil {
+		t.Fatal(err)
+	}
+	if first.Stop() {
+		t.Fatal("the first restart is still pending after a second change")
+	}
+	if !stopRestart() {
+		t.Fatal("no restart pending after the second change")
+	}
+}
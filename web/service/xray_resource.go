@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// Xray keeps running but refuses connections once it is out of file descriptors
+const openFilesWarnRatio = 0.8
+
+// procRoot is where the process information is read from
+var procRoot = "/proc"
+
+type ResourceStatus struct {
+	Pid          int     `json:"pid"`
+	OpenFiles    int     `json:"openFiles"`
+	MaxOpenFiles int     `json:"maxOpenFiles"`
+	Usage        float64 `json:"usage"`
+	NearLimit    bool    `json:"nearLimit"`
+}
+
+// ResourceStatus reports how many file descriptors Xray holds against its limit.
+// MaxOpenFiles is 0 when the limit is unlimited.
+func (s *XrayService) ResourceStatus() (ResourceStatus, error) {
+	if !s.IsXrayRunning() {
+		return ResourceStatus{}, common.NewError("xray is not running")
+	}
+	return readResourceStatus(mainXrayProcess().GetPid())
+}
+
+func readResourceStatus(pid int) (ResourceStatus, error) {
+	status := ResourceStatus{Pid: pid}
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return status, common.NewErrorf("failed to read open files of xray: %v", err)
+	}
+	status.OpenFiles = len(fds)
+	status.MaxOpenFiles, err = readOpenFilesLimit(filepath.Join(dir, "limits"))
+	if err != nil {
+		return status, err
+	}
+	if status.MaxOpenFiles > 0 {
+		status.Usage = float64(status.OpenFiles) / float64(status.MaxOpenFiles)
+		status.NearLimit = status.Usage >= openFilesWarnRatio
+	}
+	if status.NearLimit {
+		logger.Warningf("Xray holds %d of %d allowed open files", status.OpenFiles, status.MaxOpenFiles)
+	}
+	return status, nil
+}
+
+// XrayUsage is what Xray uses against its caps from the settings, a limit of 0 is no cap
+type XrayUsage struct {
+	Memory      uint64  `json:"memory"`      // resident bytes
+	MemoryLimit uint64  `json:"memoryLimit"` // bytes
+	CPU         float64 `json:"cpu"`         // percent of one core since the last read
+	CPULimit    int     `json:"cpuLimit"`    // percent of one core
+}
+
+// The CPU percent is measured between two reads of the same process
+var xrayUsage struct {
+	sync.Mutex
+	process *process.Process
+}
+
+// getResourceLimits returns the caps Xray is started with
+func (s *XrayService) getResourceLimits() xray.ResourceLimits {
+	var limits xray.ResourceLimits
+	var err error
+	if limits.CPU, err = s.settingService.GetXrayCpuLimit(); err != nil {
+		logger.Warning("Failed to read the xray cpu limit:", err)
+	}
+	if limits.Memory, err = s.settingService.GetXrayMemoryLimit(); err != nil {
+		logger.Warning("Failed to read the xray memory limit:", err)
+	}
+	return limits
+}
+
+// raiseOpenFiles tells whether Xray starts with the hard open files limit, off unless enabled
+func (s *XrayService) raiseOpenFiles() bool {
+	raise, err := s.settingService.GetRaiseOpenFiles()
+	if err != nil {
+		logger.Warning("Failed to read the xray open files setting:", err)
+		return false
+	}
+	return raise
+}
+
+func (s *XrayService) GetXrayUsage() (*XrayUsage, error) {
+	if !s.IsXrayRunning() {
+		return nil, common.NewError("xray is not running")
+	}
+	xrayProcess := mainXrayProcess()
+	limits := xrayProcess.GetLimits()
+	usage := &XrayUsage{
+		MemoryLimit: uint64(limits.Memory) << 20,
+		CPULimit:    limits.CPU,
+	}
+	pid := int32(xrayProcess.GetPid())
+
+	xrayUsage.Lock()
+	defer xrayUsage.Unlock()
+	if xrayUsage.process == nil || xrayUsage.process.Pid != pid {
+		proc, err := process.NewProcess(pid)
+		if err != nil {
+			return nil, err
+		}
+		xrayUsage.process = proc
+	}
+	memory, err := xrayUsage.process.MemoryInfo()
+	if err != nil {
+		return nil, err
+	}
+	usage.Memory = memory.RSS
+	usage.CPU, err = xrayUsage.process.Percent(0)
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// readOpenFilesLimit reads the soft limit from the "Max open files  soft  hard  files" line
+func readOpenFilesLimit(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, common.NewErrorf("failed to read limits of xray: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			break
+		}
+		if fields[0] == "unlimited" {
+			return 0, nil
+		}
+		limit, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, common.NewErrorf("invalid open files limit %q", fields[0])
+		}
+		return limit, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, common.NewError("no open files limit found for xray")
+}
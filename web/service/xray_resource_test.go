@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeProcFixture creates /proc/<pid> with the fds and the limits file under a temporary root
+func writeProcFixture(t *testing.T, pid int, fds int, limits string) {
+	t.Helper()
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fds; i++ {
+		if err := os.WriteFile(filepath.Join(dir, "fd", fmt.Sprint(i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limits != "" {
+		if err := os.WriteFile(filepath.Join(dir, "limits"), []byte(limits), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func procLimits(openFiles string) string {
+	return "Limit                     Soft Limit           Hard Limit           Units     \n" +
+		"Max cpu time              unlimited            unlimited            seconds   \n" +
+		"Max open files            " + openFiles + "                files     \n" +
+		"Max locked memory         8388608              8388608              bytes     \n"
+}
+
+func TestReadResourceStatus(t *testing.T) {
+	root := procRoot
+	procRoot = t.TempDir()
+	t.Cleanup(func() { procRoot = root })
+
+	tests := []struct {
+		name      string
+		fds       int
+		limits    string
+		want      ResourceStatus
+		wantErr   string
+		noProcess bool
+	}{
+		{name: "well under the limit", fds: 4, limits: procLimits("100 4096"), want: ResourceStatus{OpenFiles: 4, MaxOpenFiles: 100, Usage: 0.04}},
+		{name: "near the limit", fds: 8, limits: procLimits("10 4096"), want: ResourceStatus{OpenFiles: 8, MaxOpenFiles: 10, Usage: 0.8, NearLimit: true}},
+		{name: "unlimited", fds: 3, limits: procLimits("unlimited unlimited"), want: ResourceStatus{OpenFiles: 3}},
+		{name: "no open files line", fds: 1, limits: "Limit Soft Limit Hard Limit Units\n", wantErr: "no open files limit found"},
+		{name: "invalid limit", fds: 1, limits: procLimits("lots 4096"), wantErr: `invalid open files limit "lots"`},
+		{name: "no limits file", fds: 1, wantErr: "failed to read limits of xray"},
+		{name: "no process", noProcess: true, wantErr: "failed to read open files of xray"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := 1000 + i
+			if !tt.noProcess {
+				writeProcFixture(t, pid, tt.fds, tt.limits)
+			}
+			got, err := readResourceStatus(pid)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Pid = pid
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
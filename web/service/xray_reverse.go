@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+const (
+	ReverseBridge = "bridge"
+	ReversePortal = "portal"
+)
+
+// ReverseProxy is a bridge or a portal of the reverse section. The bridge dials the portal
+// through TunnelOutbound and sends the traffic coming back to LocalOutbound. The portal takes
+// the bridge's connection on TunnelInbound and sends the traffic of ExternalInbounds through it.
+// Both ends use the same Domain to tell the tunnel apart from other traffic.
+type ReverseProxy struct {
+	Type             string   `json:"type"`
+	Tag              string   `json:"tag"`
+	Domain           string   `json:"domain"`
+	TunnelOutbound   string   `json:"tunnelOutbound,omitempty"`
+	LocalOutbound    string   `json:"localOutbound,omitempty"`
+	TunnelInbound    string   `json:"tunnelInbound,omitempty"`
+	ExternalInbounds []string `json:"externalInbounds,omitempty"`
+}
+
+func (s *XrayService) GetReverseProxies() ([]ReverseProxy, error) {
+	proxies := []ReverseProxy{}
+	data, err := s.settingService.GetReverseProxies()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return proxies, nil
+	}
+	err = json.Unmarshal([]byte(data), &proxies)
+	if err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+func (s *XrayService) saveReverseProxies(proxies []ReverseProxy) error {
+	data, err := json.MarshalIndent(proxies, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetReverseProxies(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetReverseProxy adds or replaces the bridge or portal with the same tag
+func (s *XrayService) SetReverseProxy(proxy ReverseProxy) error {
+	if proxy.Tag == "" {
+		return common.NewError("reverse tag is empty")
+	}
+	if !plainDomainRegex.MatchString(proxy.Domain) {
+		return common.NewErrorf("invalid reverse domain %q", proxy.Domain)
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if tags[proxy.Tag] {
+		return common.NewErrorf("an outbound already has the tag %s", proxy.Tag)
+	}
+	switch proxy.Type {
+	case ReverseBridge:
+		if !tags[proxy.TunnelOutbound] {
+			return common.NewErrorf("outbound %s does not exist", proxy.TunnelOutbound)
+		}
+		if proxy.LocalOutbound == "" {
+			proxy.LocalOutbound = "direct"
+		}
+		if !tags[proxy.LocalOutbound] {
+			return common.NewErrorf("outbound %s does not exist", proxy.LocalOutbound)
+		}
+		proxy.TunnelInbound = ""
+		proxy.ExternalInbounds = nil
+	case ReversePortal:
+		if proxy.TunnelInbound == "" || len(proxy.ExternalInbounds) == 0 {
+			return common.NewError("a portal needs a tunnel inbound and at least one external inbound")
+		}
+		proxy.TunnelOutbound = ""
+		proxy.LocalOutbound = ""
+	default:
+		return common.NewErrorf("unsupported reverse type %q", proxy.Type)
+	}
+
+	proxies, err := s.GetReverseProxies()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range proxies {
+		if proxies[i].Tag == proxy.Tag {
+			proxies[i] = proxy
+			replaced = true
+		}
+	}
+	if !replaced {
+		proxies = append(proxies, proxy)
+	}
+	return s.saveReverseProxies(proxies)
+}
+
+func (s *XrayService) RemoveReverseProxy(tag string) error {
+	proxies, err := s.GetReverseProxies()
+	if err != nil {
+		return err
+	}
+	for i := range proxies {
+		if proxies[i].Tag == tag {
+			return s.saveReverseProxies(append(proxies[:i], proxies[i+1:]...))
+		}
+	}
+	return common.NewErrorf("reverse %s does not exist", tag)
+}
+
+// applyReverseProxies adds the bridges and portals to the reverse section, with the rules that
+// connect them. A proxy whose inbounds or outbounds are not in the config is skipped.
+func (s *XrayService) applyReverseProxies(xrayConfig *xray.Config) error {
+	proxies, err := s.GetReverseProxies()
+	if err != nil {
+		return err
+	}
+	if len(proxies) == 0 {
+		return nil
+	}
+	outboundTags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+	inboundTags := map[string]bool{}
+	for _, inbound := range xrayConfig.InboundConfigs {
+		inboundTags[inbound.Tag] = true
+	}
+	reverse := map[string]interface{}{}
+	if len(xrayConfig.Reverse) > 0 && string(xrayConfig.Reverse) != "null" {
+		if err := json.Unmarshal(xrayConfig.Reverse, &reverse); err != nil {
+			return err
+		}
+	}
+	reverseTags := map[string]bool{}
+	for _, key := range []string{"bridges", "portals"} {
+		items, _ := reverse[key].([]interface{})
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				tag, _ := m["tag"].(string)
+				reverseTags[tag] = true
+			}
+		}
+	}
+
+	var rules []interface{}
+	for _, proxy := range proxies {
+		if reverseTags[proxy.Tag] || outboundTags[proxy.Tag] {
+			logger.Warningf("Skip reverse %s, the template already uses this tag", proxy.Tag)
+			continue
+		}
+		domain := "full:" + proxy.Domain
+		switch proxy.Type {
+		case ReverseBridge:
+			if !outboundTags[proxy.TunnelOutbound] || !outboundTags[proxy.LocalOutbound] {
+				logger.Warningf("Skip bridge %s, its outbounds are missing", proxy.Tag)
+				continue
+			}
+			rules = append(rules, map[string]interface{}{
+				"type":        "field",
+				"inboundTag":  []string{proxy.Tag},
+				"domain":      []string{domain},
+				"outboundTag": proxy.TunnelOutbound,
+			}, map[string]interface{}{
+				"type":        "field",
+				"inboundTag":  []string{proxy.Tag},
+				"outboundTag": proxy.LocalOutbound,
+			})
+		case ReversePortal:
+			var external []string
+			for _, tag := range proxy.ExternalInbounds {
+				if inboundTags[tag] {
+					external = append(external, tag)
+				}
+			}
+			if !inboundTags[proxy.TunnelInbound] || len(external) == 0 {
+				logger.Warningf("Skip portal %s, its inbounds are missing", proxy.Tag)
+				continue
+			}
+			rules = append(rules, map[string]interface{}{
+				"type":        "field",
+				"inboundTag":  []string{proxy.TunnelInbound},
+				"domain":      []string{domain},
+				"outboundTag": proxy.Tag,
+			}, map[string]interface{}{
+				"type":        "field",
+				"inboundTag":  external,
+				"outboundTag": proxy.Tag,
+			})
+		default:
+			continue
+		}
+		key := proxy.Type + "s"
+		items, _ := reverse[key].([]interface{})
+		reverse[key] = append(items, map[string]interface{}{"tag": proxy.Tag, "domain": proxy.Domain})
+		reverseTags[proxy.Tag] = true
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	if xrayConfig.Reverse, err = json.MarshalIndent(reverse, "", "  "); err != nil {
+		return err
+	}
+	// Ahead of the template rules, the bridge reaches local services a private ip rule would block
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["rules"].([]interface{})
+	routing["rules"] = append(rules, existing...)
+	return setRouting(xrayConfig, routing)
+}
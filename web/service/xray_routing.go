@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+func getRouting(xrayConfig *xray.Config) (map[string]interface{}, error) {
+	routing := map[string]interface{}{}
+	if len(xrayConfig.RouterConfig) == 0 {
+		return routing, nil
+	}
+	err := json.Unmarshal(xrayConfig.RouterConfig, &routing)
+	if err != nil {
+		return nil, err
+	}
+	return routing, nil
+}
+
+func setRouting(xrayConfig *xray.Config, routing map[string]interface{}) error {
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
+
+// appendRoutingRules adds rules after the ones already in the config, so template rules keep precedence
+func appendRoutingRules(xrayConfig *xray.Config, rules []interface{}) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	existing, _ := routing["rules"].([]interface{})
+	routing["rules"] = append(existing, rules...)
+	return setRouting(xrayConfig, routing)
+}
+
+// getConfigOutboundTags returns the tags of the outbounds in a generated config
+func getConfigOutboundTags(xrayConfig *xray.Config) (map[string]bool, error) {
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool, len(outbounds))
+	for _, outbound := range outbounds {
+		if tag, ok := outbound["tag"].(string); ok && tag != "" {
+			tags[tag] = true
+		}
+	}
+	return tags, nil
+}
+
+var plainDomainRegex = regexp.MustCompile(`^(?i)[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*$`)
+
+// checkDomainMatcher validates a routing domain the way xray reads it: a plain domain or
+// one of the domain:, full:, keyword:, regexp:, geosite: and ext: forms
+func checkDomainMatcher(pattern string) error {
+	kind, value, found := strings.Cut(pattern, ":")
+	if !found {
+		kind, value = "domain", pattern
+	}
+	if value == "" {
+		return common.NewErrorf("invalid domain %q", pattern)
+	}
+	switch kind {
+	case "domain", "full":
+		if !plainDomainRegex.MatchString(value) {
+			return common.NewErrorf("invalid domain %q", pattern)
+		}
+	case "keyword", "geosite":
+	case "regexp":
+		if _, err := regexp.Compile(value); err != nil {
+			return common.NewErrorf("invalid domain regexp %q: %v", pattern, err)
+		}
+	case "ext":
+		if !strings.Contains(value, ":") {
+			return common.NewErrorf("invalid domain %q, expected ext:file:tag", pattern)
+		}
+	default:
+		return common.NewErrorf("invalid domain %q", pattern)
+	}
+	return nil
+}
+
+// GetClientOutbounds returns the configured client email -> outbound tag mappings
+func (s *XrayService) GetClientOutbounds() (map[string]string, error) {
+	mappings := map[string]string{}
+	data, err := s.settingService.GetClientOutbounds()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return mappings, nil
+	}
+	err = json.Unmarshal([]byte(data), &mappings)
+	if err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+func (s *XrayService) saveClientOutbounds(mappings map[string]string) error {
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetClientOutbounds(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// SetClientOutbound routes all traffic of the client through the given outbound
+func (s *XrayService) SetClientOutbound(email string, outboundTag string) error {
+	if email == "" || outboundTag == "" {
+		return common.NewError("client email and outbound tag are required")
+	}
+	if err := s.checkClientExists(email); err != nil {
+		return err
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[outboundTag] {
+		return common.NewErrorf("outbound %s does not exist", outboundTag)
+	}
+
+	mappings, err := s.GetClientOutbounds()
+	if err != nil {
+		return err
+	}
+	mappings[email] = outboundTag
+	return s.saveClientOutbounds(mappings)
+}
+
+// checkClientExists fails when no client has the email, GetClientTrafficByEmail returns no error then
+func (s *XrayService) checkClientExists(email string) error {
+	traffic, err := s.inboundService.GetClientTrafficByEmail(email)
+	if err != nil || traffic == nil {
+		return common.NewErrorf("client %s does not exist", email)
+	}
+	return nil
+}
+
+func (s *XrayService) RemoveClientOutbound(email string) error {
+	mappings, err := s.GetClientOutbounds()
+	if err != nil {
+		return err
+	}
+	if _, ok := mappings[email]; !ok {
+		return common.NewErrorf("client %s has no outbound mapping", email)
+	}
+	delete(mappings, email)
+	return s.saveClientOutbounds(mappings)
+}
+
+// applyClientOutbounds adds one user based routing rule per mapped outbound, clients of a group
+// with an outbound are mapped to it
+func (s *XrayService) applyClientOutbounds(xrayConfig *xray.Config) error {
+	mappings, err := s.GetClientOutbounds()
+	if err != nil {
+		return err
+	}
+	groups, err := s.GetClientGroups()
+	if err != nil {
+		return err
+	}
+	// The client's own mapping wins over the one of its group
+	_, groupOutbounds := resolveClientGroups(groups)
+	for email, outboundTag := range groupOutbounds {
+		if _, ok := mappings[email]; !ok {
+			mappings[email] = outboundTag
+		}
+	}
+	if len(mappings) == 0 {
+		return nil
+	}
+	tags, err := getConfigOutboundTags(xrayConfig)
+	if err != nil {
+		return err
+	}
+
+	users := map[string][]string{}
+	for email, outboundTag := range mappings {
+		if !tags[outboundTag] {
+			logger.Warningf("Skip routing client %s to missing outbound %s", email, outboundTag)
+			continue
+		}
+		users[outboundTag] = append(users[outboundTag], email)
+	}
+
+	outboundTags := make([]string, 0, len(users))
+	for outboundTag := range users {
+		outboundTags = append(outboundTags, outboundTag)
+	}
+	sort.Strings(outboundTags)
+
+	rules := make([]interface{}, 0, len(outboundTags))
+	for _, outboundTag := range outboundTags {
+		emails := users[outboundTag]
+		sort.Strings(emails)
+		rules = append(rules, map[string]interface{}{
+			"type":        "field",
+			"user":        emails,
+			"outboundTag": outboundTag,
+		})
+	}
+	return appendRoutingRules(xrayConfig, rules)
+}
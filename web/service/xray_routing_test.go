@@ -0,0 +1,102 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+// configRules returns the routing rules of a generated config
+func configRules(t *testing.T, xrayConfig *xray.Config) []map[string]interface{} {
+	t.Helper()
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []map[string]interface{}
+	items, _ := routing["rules"].([]interface{})
+	for _, item := range items {
+		rules = append(rules, item.(map[string]interface{}))
+	}
+	return rules
+}
+
+func TestClientOutbounds(t *testing.T) {
+	setChainTestTemplate(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestClient(t, inbound.Id, "alice")
+	addTestClient(t, inbound.Id, "bob")
+	addTestClient(t, inbound.Id, "carol")
+	s := &XrayService{}
+
+	tests := []struct {
+		name     string
+		email    string
+		outbound string
+		wantErr  string
+	}{
+		{"missing email", "", "warp", "required"},
+		{"unknown client", "mallory", "warp", "client mallory does not exist"},
+		{"unknown outbound", "alice", "nope", "outbound nope does not exist"},
+		{"alice through warp", "alice", "warp", ""},
+		{"bob through proxy", "bob", "proxy", ""},
+		{"carol through warp", "carol", "warp", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetClientOutbound(tt.email, tt.outbound)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	mappings, err := s.GetClientOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 3 || mappings["alice"] != "warp" || mappings["bob"] != "proxy" {
+		t.Fatalf("got mappings %v", mappings)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]string{}
+	for _, rule := range configRules(t, xrayConfig) {
+		emails, ok := rule["user"].([]interface{})
+		if !ok {
+			continue
+		}
+		var joined []string
+		for _, email := range emails {
+			joined = append(joined, email.(string))
+		}
+		users[rule["outboundTag"].(string)] = strings.Join(joined, ",")
+	}
+	if users["warp"] != "alice,carol" || users["proxy"] != "bob" || len(users) != 2 {
+		t.Fatalf("got user rules %v", users)
+	}
+
+	if err := s.RemoveClientOutbound("carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveClientOutbound("carol"); err == nil {
+		t.Fatal("removing a missing mapping succeeded")
+	}
+	mappings, err = s.GetClientOutbounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mappings["carol"]; ok || len(mappings) != 2 {
+		t.Fatalf("got mappings %v", mappings)
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/atomic"
+)
+
+// A scheduled restart is skipped when Xray was (re)started this recently
+const xrayRestartCooldown = 5 * time.Minute
+
+// The longest a scheduled restart waits for the clients to finish
+const maxXrayRestartDrain = time.Hour
+
+// How often the client counters are compared while draining
+const drainPollInterval = 5 * time.Second
+
+// Polls in a row without a client going idle before the drain gives up on the ones left
+const drainSettlePolls = 6
+
+var (
+	lastXrayRestart     atomic.Time
+	restartScheduleLock sync.Mutex
+	restartCron         *cron.Cron
+	restartEntry        cron.EntryID
+)
+
+// SetRestartSchedule stores and applies a standard cron spec ("0 4 * * *", "@daily", "@every 12h")
+// for automatic Xray restarts, an empty spec cancels the schedule
+func (s *XrayService) SetRestartSchedule(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec != "" {
+		if _, err := cron.ParseStandard(spec); err != nil {
+			return common.NewErrorf("invalid restart schedule %q: %v", spec, err)
+		}
+	}
+	err := s.settingService.SetXrayRestartSchedule(spec)
+	if err != nil {
+		return err
+	}
+	return s.applyRestartSchedule(spec)
+}
+
+// SetRestartDrain sets how many seconds a scheduled restart waits for the connected clients to go
+// idle, with no new connections accepted meanwhile. It stops waiting early once the clients left
+// keep transferring. 0 restarts right away.
+func (s *XrayService) SetRestartDrain(seconds int) error {
+	if seconds < 0 || time.Duration(seconds)*time.Second > maxXrayRestartDrain {
+		return common.NewErrorf("restart drain must be between 0 and %d seconds", int(maxXrayRestartDrain.Seconds()))
+	}
+	return s.settingService.SetXrayRestartDrain(seconds)
+}
+
+// StartRestartSchedule applies the stored restart schedule, called once on startup
+func (s *XrayService) StartRestartSchedule() error {
+	spec, err := s.settingService.GetXrayRestartSchedule()
+	if err != nil {
+		return err
+	}
+	return s.applyRestartSchedule(spec)
+}
+
+// StopRestartSchedule cancels pending scheduled restarts without touching the stored setting
+func (s *XrayService) StopRestartSchedule() {
+	restartScheduleLock.Lock()
+	defer restartScheduleLock.Unlock()
+	if restartCron != nil {
+		restartCron.Stop()
+		restartCron = nil
+	}
+}
+
+// NextScheduledRestart returns the time of the next scheduled restart, zero if there is none
+func (s *XrayService) NextScheduledRestart() time.Time {
+	restartScheduleLock.Lock()
+	defer restartScheduleLock.Unlock()
+	if restartCron == nil {
+		return time.Time{}
+	}
+	return restartCron.Entry(restartEntry).Next
+}
+
+func (s *XrayService) applyRestartSchedule(spec string) error {
+	s.StopRestartSchedule()
+	if spec == "" {
+		return nil
+	}
+
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+
+	restartScheduleLock.Lock()
+	defer restartScheduleLock.Unlock()
+	c := cron.New(cron.WithLocation(loc))
+	entry, err := c.AddFunc(spec, s.scheduledRestart)
+	if err != nil {
+		return err
+	}
+	c.Start()
+	restartCron = c
+	restartEntry = entry
+	logger.Infof("Scheduled Xray restarts at %s", spec)
+	return nil
+}
+
+func (s *XrayService) scheduledRestart() {
+	if configLocked.Load() {
+		logger.Info("Config is locked, queue the scheduled Xray restart until unlock")
+		isRestartQueued.Store(true)
+		return
+	}
+	if since := time.Since(lastXrayRestart.Load()); since < xrayRestartCooldown {
+		logger.Infof("Skip scheduled Xray restart, last restart was %v ago", since.Round(time.Second))
+		return
+	}
+	logger.Info("Scheduled Xray restart")
+	drained := s.drainXray()
+	// Force, otherwise an unchanged config makes the restart a no-op
+	if err := s.RestartXray(true); err != nil {
+		logger.Error("Scheduled Xray restart failed:", err)
+		s.undrainXray(drained)
+	}
+}
+
+// xrayDrain is a running Xray with its inbounds taken out for a restart
+type xrayDrain struct {
+	process  *xray.Process
+	inbounds []xray.InboundConfig
+}
+
+// drainXray takes the inbounds out of the running Xray through the API so no new connection comes
+// in, then waits until the connected clients went idle or the drain time is up. It returns what it
+// took out, nil when it drained nothing.
+func (s *XrayService) drainXray() *xrayDrain {
+	drain, err := s.settingService.GetXrayRestartDrain()
+	if err != nil || drain <= 0 {
+		return nil
+	}
+	lock.Lock()
+	process := mainXrayProcess()
+	if process == nil || !process.IsRunning() {
+		lock.Unlock()
+		return nil
+	}
+	var inbounds []xray.InboundConfig
+	for _, inbound := range process.GetConfig().InboundConfigs {
+		if inbound.Tag != statsAPITag {
+			inbounds = append(inbounds, inbound)
+		}
+	}
+	apiPort := process.GetAPIPort()
+	lock.Unlock()
+
+	// Apart from s.xrayAPI, the traffic job keeps using it meanwhile
+	var api xray.XrayAPI
+	if err := api.Init(apiPort); err != nil {
+		logger.Warning("Restart without draining, the xray api is not available:", err)
+		return nil
+	}
+	defer api.Close()
+	drained := &xrayDrain{process: process}
+	for _, inbound := range inbounds {
+		if err := api.DelInbound(inbound.Tag); err != nil {
+			logger.Debugf("Failed to remove inbound %s for draining: %v", inbound.Tag, err)
+			continue
+		}
+		drained.inbounds = append(drained.inbounds, inbound)
+	}
+
+	logger.Infof("Draining Xray for up to %ds before the scheduled restart", drain)
+	deadline := time.Now().Add(time.Duration(drain) * time.Second)
+	counters := map[string]int64{}
+	active, err := waitForDrain(func() (int, error) {
+		return activeClients(&api, counters)
+	}, deadline, drainPollInterval)
+	switch {
+	case err != nil:
+		logger.Warning("Stop draining, failed to read the client counters:", err)
+	case active > 0 && time.Now().Before(deadline):
+		logger.Infof("Stop draining, the %d clients still active keep transferring", active)
+	case active > 0:
+		logger.Infof("Drain time is up with %d clients still active", active)
+	default:
+		logger.Info("Xray drained, no client is active")
+	}
+	return drained
+}
+
+// waitForDrain polls active until no client is active, the deadline passes or no client went idle
+// for drainSettlePolls polls. The counters move with any traffic, so a client on an established
+// connection counts as active as long as it transfers, and a long download would otherwise hold the
+// restart until the deadline. It returns the last count.
+func waitForDrain(active func() (int, error), deadline time.Time, interval time.Duration) (int, error) {
+	count, err := active()
+	lowest, settled := count, 0
+	for err == nil && count > 0 && settled < drainSettlePolls && time.Now().Before(deadline) {
+		time.Sleep(min(interval, time.Until(deadline)))
+		count, err = active()
+		if count < lowest {
+			lowest, settled = count, 0
+		} else {
+			settled++
+		}
+	}
+	return count, err
+}
+
+// undrainXray adds the drained inbounds back when the restart failed and left the drained Xray running
+func (s *XrayService) undrainXray(drained *xrayDrain) {
+	if drained == nil || len(drained.inbounds) == 0 {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if mainXrayProcess() != drained.process || !drained.process.IsRunning() {
+		return
+	}
+	var api xray.XrayAPI
+	if err := api.Init(drained.process.GetAPIPort()); err != nil {
+		logger.Warning("Failed to add the drained inbounds back, the xray api is not available:", err)
+		s.SetToNeedRestart()
+		return
+	}
+	defer api.Close()
+	for _, inbound := range drained.inbounds {
+		data, err := json.Marshal(inbound)
+		if err == nil {
+			err = api.AddInbound(data)
+		}
+		if err != nil {
+			logger.Warningf("Failed to add the drained inbound %s back: %v", inbound.Tag, err)
+			s.SetToNeedRestart()
+		}
+	}
+}
+
+// activeClients counts the clients whose counters moved since the last look, which counters
+// holds by email. The first look counts every client with traffic.
+func activeClients(api *xray.XrayAPI, counters map[string]int64) (int, error) {
+	_, clientTraffics, err := api.QueryTraffic([]string{"user>>>"})
+	if err != nil {
+		return 0, err
+	}
+	active := 0
+	for _, traffic := range clientTraffics {
+		total := traffic.Up + traffic.Down
+		// The traffic job resets the counters meanwhile, any change is traffic
+		if counters[traffic.Email] != total {
+			active++
+		}
+		counters[traffic.Email] = total
+	}
+	return active, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"x-ui/xray"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetRestartSchedule(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	t.Cleanup(s.StopRestartSchedule)
+
+	tests := []struct {
+		name     string
+		spec     string
+		wantErr  string
+		wantNext bool
+	}{
+		{name: "cron spec", spec: "0 4 * * *", wantNext: true},
+		{name: "descriptor", spec: "@daily", wantNext: true},
+		{name: "interval", spec: " @every 12h ", wantNext: true},
+		{name: "empty cancels", spec: ""},
+		{name: "invalid spec", spec: "every night", wantErr: "invalid restart schedule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetRestartSchedule(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := s.settingService.GetXrayRestartSchedule()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != strings.TrimSpace(tt.spec) {
+				t.Fatalf("stored schedule %q, want %q", stored, strings.TrimSpace(tt.spec))
+			}
+			next := s.NextScheduledRestart()
+			if tt.wantNext != !next.IsZero() {
+				t.Fatalf("got next restart %v", next)
+			}
+			if tt.wantNext && !next.After(time.Now()) {
+				t.Fatalf("next restart %v is not in the future", next)
+			}
+		})
+	}
+}
+
+func TestScheduledRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastRestart time.Time
+		wantRestart bool
+	}{
+		{name: "restart fires", wantRestart: true},
+		{name: "within the cooldown", lastRestart: time.Now(), wantRestart: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			addTestInbound(t, 20001, "inbound-20001", true)
+			s := &XrayService{}
+			// Standby restarts validate and cache the config, which shows the restart ran
+			if err := s.SetStandby(true); err != nil {
+				t.Fatal(err)
+			}
+			oldLastRestart := lastXrayRestart.Load()
+			lastXrayRestart.Store(tt.lastRestart)
+			t.Cleanup(func() {
+				lastXrayRestart.Store(oldLastRestart)
+				standbyConfigLock.Lock()
+				standbyConfig = nil
+				standbyConfigLock.Unlock()
+				s.IsNeedRestartAndSetFalse()
+			})
+
+			if err := s.SetRestartSchedule("@every 1s"); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(s.StopRestartSchedule)
+			next := s.NextScheduledRestart()
+			if next.IsZero() {
+				t.Fatal("no restart scheduled")
+			}
+
+			deadline := time.Now().Add(3 * time.Second)
+			for s.GetStandbyConfig() == nil && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if restarted := s.GetStandbyConfig() != nil; restarted != tt.wantRestart {
+				t.Fatalf("restarted %v, want %v", restarted, tt.wantRestart)
+			}
+
+			s.StopRestartSchedule()
+			if !s.NextScheduledRestart().IsZero() {
+				t.Fatal("canceled schedule still has a next restart")
+			}
+		})
+	}
+}
+
+func TestWaitForDrain(t *testing.T) {
+	// Enough polls to settle with room to spare
+	long := make([]int, drainSettlePolls+10)
+	for i := range long {
+		long[i] = 1
+	}
+	long[0] = 3
+	tests := []struct {
+		name      string
+		counts    []int
+		err       error
+		deadline  time.Duration
+		want      int
+		wantPolls int
+	}{
+		{name: "idle at once", counts: []int{0}, deadline: time.Minute, want: 0, wantPolls: 1},
+		{name: "clients go idle", counts: []int{3, 2, 2, 1, 0}, deadline: time.Minute, want: 0, wantPolls: 5},
+		{name: "long download stops the wait", counts: long, deadline: time.Minute, want: 1, wantPolls: 2 + drainSettlePolls},
+		{name: "deadline passed", counts: []int{3, 2}, deadline: -time.Second, want: 3, wantPolls: 1},
+		{name: "counters fail", counts: []int{0}, err: errors.New("api is down"), deadline: time.Minute, wantPolls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			active := func() (int, error) {
+				if tt.err != nil {
+					polls++
+					return 0, tt.err
+				}
+				count := tt.counts[min(polls, len(tt.counts)-1)]
+				polls++
+				return count, nil
+			}
+			got, err := waitForDrain(active, time.Now().Add(tt.deadline), time.Millisecond)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want || polls != tt.wantPolls {
+				t.Fatalf("got %d active after %d polls, want %d after %d", got, polls, tt.want, tt.wantPolls)
+			}
+		})
+	}
+}
+
+func TestScheduledRestartUndrains(t *testing.T) {
+	setupTestDB(t)
+	// The config check fails, so the restart fails and leaves the drained xray running
+	setStubXray(t, "#!/bin/sh\nif [ \"$1\" = -version ]; then echo 'Xray 1.8.24'; exit 0; fi\n"+
+		"if [ \"$1\" = -test ]; then echo 'Failed to start: invalid config' >&2; exit 23; fi\nexec sleep 30\n")
+	addTestInbound(t, 20001, "inbound-20001", true)
+	resetXrayConfigCache(t)
+	s := &XrayService{}
+	if err := s.settingService.SetXrayConfigCheck(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRestartDrain(60); err != nil {
+		t.Fatal(err)
+	}
+	oldLastRestart := lastXrayRestart.Load()
+	lastXrayRestart.Store(time.Time{})
+	t.Cleanup(func() {
+		lastXrayRestart.Store(oldLastRestart)
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	var mu sync.Mutex
+	var calls []string
+	port := startStubAPI(t, func(method string, req []byte) ([]byte, error) {
+		mu.Lock()
+		calls = append(calls, method[strings.LastIndex(method, "/")+1:])
+		mu.Unlock()
+		if strings.HasSuffix(method, "/QueryStats") {
+			// No client counters, nothing to wait for
+			return proto.Marshal(&statsService.QueryStatsResponse{})
+		}
+		return nil, nil
+	})
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configInbound(xrayConfig, statsAPITag).Port = port
+	process := xray.NewProcess(xrayConfig)
+	setMainXrayProcess(t, process)
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { process.Stop() })
+
+	s.scheduledRestart()
+	if mainXrayProcess() != process || !process.IsRunning() {
+		t.Fatal("the failed restart replaced the drained xray")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"RemoveInbound", "QueryStats", "AddInbound"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got api calls %v, want %v", calls, want)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// GetSendThrough returns the source IPs outbounds egress from, by outbound tag
+func (s *XrayService) GetSendThrough() (map[string]string, error) {
+	sources := map[string]string{}
+	data, err := s.settingService.GetOutboundSendThrough()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return sources, nil
+	}
+	err = json.Unmarshal([]byte(data), &sources)
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+func (s *XrayService) saveSendThrough(sources map[string]string) error {
+	data, err := json.MarshalIndent(sources, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetOutboundSendThrough(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// isLocalAddress reports whether the IP is assigned to a local interface, true when they can not be listed
+func isLocalAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.Warning("Failed to list interface addresses:", err)
+		return true
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSendThrough makes the outbound egress from the given local source IP
+func (s *XrayService) SetSendThrough(outboundTag string, sourceIP string) error {
+	if outboundTag == "" || sourceIP == "" {
+		return common.NewError("outbound tag and source ip are required")
+	}
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return common.NewErrorf("invalid source ip %q", sourceIP)
+	}
+	if !isLocalAddress(ip) {
+		return common.NewErrorf("source ip %s is not assigned to any interface", sourceIP)
+	}
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if !tags[outboundTag] {
+		return common.NewErrorf("outbound %s does not exist", outboundTag)
+	}
+
+	sources, err := s.GetSendThrough()
+	if err != nil {
+		return err
+	}
+	sources[outboundTag] = ip.String()
+	return s.saveSendThrough(sources)
+}
+
+func (s *XrayService) RemoveSendThrough(outboundTag string) error {
+	sources, err := s.GetSendThrough()
+	if err != nil {
+		return err
+	}
+	if _, ok := sources[outboundTag]; !ok {
+		return common.NewErrorf("outbound %s has no source ip", outboundTag)
+	}
+	delete(sources, outboundTag)
+	return s.saveSendThrough(sources)
+}
+
+// applySendThrough sets sendThrough on every outbound with a source IP
+func (s *XrayService) applySendThrough(xrayConfig *xray.Config) error {
+	sources, err := s.GetSendThrough()
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	applied := map[string]bool{}
+	for _, outbound := range outbounds {
+		tag, _ := outbound["tag"].(string)
+		sourceIP, ok := sources[tag]
+		if !ok {
+			continue
+		}
+		// The address may have been removed since, xray then fails to dial through this outbound
+		if ip := net.ParseIP(sourceIP); ip == nil || !isLocalAddress(ip) {
+			logger.Warningf("Source ip %s of outbound %s is not assigned to any interface", sourceIP, tag)
+		}
+		outbound["sendThrough"] = sourceIP
+		applied[tag] = true
+	}
+	for tag := range sources {
+		if !applied[tag] {
+			logger.Warningf("Skip source ip of missing outbound %s", tag)
+		}
+	}
+	return setOutbounds(xrayConfig, outbounds)
+}
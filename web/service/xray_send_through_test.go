@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestIsLocalAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"192.0.2.123", false},
+		{"2001:db8::123", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isLocalAddress(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendThrough(t *testing.T) {
+	setChainTestTemplate(t)
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name     string
+		tag      string
+		sourceIP string
+		wantErr  string
+	}{
+		{name: "local address", tag: "proxy", sourceIP: "127.0.0.1"},
+		{name: "no tag", tag: "", sourceIP: "127.0.0.1", wantErr: "outbound tag and source ip are required"},
+		{name: "invalid ip", tag: "proxy", sourceIP: "127.0.0", wantErr: `invalid source ip "127.0.0"`},
+		{name: "foreign ip", tag: "proxy", sourceIP: "192.0.2.123", wantErr: "source ip 192.0.2.123 is not assigned to any interface"},
+		{name: "unknown outbound", tag: "missing", sourceIP: "127.0.0.1", wantErr: "outbound missing does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetSendThrough(tt.tag, tt.sourceIP)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// Only the targeted outbound egresses from the source ip
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, outbound := range outbounds {
+		want := ""
+		if outbound["tag"] == "proxy" {
+			want = "127.0.0.1"
+		}
+		if got, _ := outbound["sendThrough"].(string); got != want {
+			t.Fatalf("outbound %v got sendThrough %q, want %q", outbound["tag"], got, want)
+		}
+	}
+
+	if err := s.RemoveSendThrough("proxy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveSendThrough("proxy"); err == nil || !strings.Contains(err.Error(), "outbound proxy has no source ip") {
+		t.Fatalf("got error %v removing a missing source ip", err)
+	}
+	sources, err := s.GetSendThrough()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 0 {
+		t.Fatalf("got sources %v after removing", sources)
+	}
+}
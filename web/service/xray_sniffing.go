@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// GetSniffingExclusions returns the domains each inbound does not sniff, the domainsExcluded of its
+// sniffing settings, by inbound tag
+func (s *XrayService) GetSniffingExclusions() (map[string][]string, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	exclusions := map[string][]string{}
+	for _, inbound := range inbounds {
+		sniffing, err := inbound.GetSniffing()
+		if err != nil || sniffing == nil || len(sniffing.DomainsExcluded) == 0 {
+			continue
+		}
+		exclusions[inbound.Tag] = sniffing.DomainsExcluded
+	}
+	return exclusions, nil
+}
+
+// SetSniffingExclusions replaces the domains the inbound does not sniff, an empty list removes them
+func (s *XrayService) SetSniffingExclusions(tag string, domains []string) error {
+	if tag == "" {
+		return common.NewError("inbound tag is required")
+	}
+	cleaned := make([]string, 0, len(domains))
+	seen := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" || seen[domain] {
+			continue
+		}
+		if err := checkDomainMatcher(domain); err != nil {
+			return err
+		}
+		seen[domain] = true
+		cleaned = append(cleaned, domain)
+	}
+
+	var inbound model.Inbound
+	err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", tag).First(&inbound).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if len(cleaned) == 0 {
+			return nil
+		}
+		return common.NewErrorf("inbound %s does not exist", tag)
+	}
+	if err != nil {
+		return err
+	}
+	sniffing, err := inbound.GetSniffing()
+	if err != nil {
+		return common.NewErrorf("invalid sniffing of inbound %s: %v", tag, err)
+	}
+	if sniffing != nil && slices.Equal(sniffing.DomainsExcluded, cleaned) {
+		return nil
+	}
+	if err := setSniffingExclusions(&inbound, cleaned); err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// setSniffingExclusions stores domains as the domainsExcluded of the sniffing settings of the
+// inbound, keeping its other keys
+func setSniffingExclusions(inbound *model.Inbound, domains []string) error {
+	sniffing := map[string]interface{}{}
+	if inbound.Sniffing != "" && inbound.Sniffing != "null" {
+		if err := json.Unmarshal([]byte(inbound.Sniffing), &sniffing); err != nil {
+			return common.NewErrorf("invalid sniffing of inbound %s: %v", inbound.Tag, err)
+		}
+	}
+	if len(domains) == 0 {
+		delete(sniffing, "domainsExcluded")
+	} else {
+		sniffing["domainsExcluded"] = domains
+	}
+	data, err := json.MarshalIndent(sniffing, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Sniffing = string(data)
+	return database.GetDB().Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("sniffing", inbound.Sniffing).Error
+}
+
+// MigrationSniffingExclusions moves the sniffing exclusions the settings used to hold by inbound tag
+// into the domainsExcluded of the inbounds
+func (s *InboundService) MigrationSniffingExclusions() {
+	settingService := SettingService{}
+	data, err := settingService.GetSniffingExclusions()
+	if err != nil || data == "" {
+		return
+	}
+	var exclusions map[string][]string
+	if err := json.Unmarshal([]byte(data), &exclusions); err != nil {
+		logger.Warning("Drop the invalid sniffing exclusions setting:", err)
+		settingService.SetSniffingExclusions("")
+		return
+	}
+	for tag, domains := range exclusions {
+		var inbound model.Inbound
+		if err := database.GetDB().Model(model.Inbound{}).Where("tag = ?", tag).First(&inbound).Error; err != nil {
+			continue
+		}
+		sniffing, err := inbound.GetSniffing()
+		if err != nil {
+			logger.Warningf("Drop the sniffing exclusions of inbound %s: %v", tag, err)
+			continue
+		}
+		var merged []string
+		if sniffing != nil {
+			merged = sniffing.DomainsExcluded
+		}
+		for _, domain := range domains {
+			if !slices.Contains(merged, domain) {
+				merged = append(merged, domain)
+			}
+		}
+		if err := setSniffingExclusions(&inbound, merged); err != nil {
+			logger.Warningf("Failed to migrate the sniffing exclusions of inbound %s: %v", tag, err)
+			return
+		}
+	}
+	settingService.SetSniffingExclusions("")
+}
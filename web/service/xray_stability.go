@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// configDiff names the sections in which two configs differ
+func configDiff(a, b *xray.Config) []string {
+	var diff []string
+	if len(a.InboundConfigs) != len(b.InboundConfigs) {
+		diff = append(diff, "inbounds")
+	} else {
+		for i := range a.InboundConfigs {
+			if !a.InboundConfigs[i].Equals(&b.InboundConfigs[i]) {
+				diff = append(diff, fmt.Sprintf("inbounds[%s]", a.InboundConfigs[i].Tag))
+			}
+		}
+	}
+	sections := []struct {
+		name string
+		a, b []byte
+	}{
+		{"log", a.LogConfig, b.LogConfig},
+		{"routing", a.RouterConfig, b.RouterConfig},
+		{"dns", a.DNSConfig, b.DNSConfig},
+		{"outbounds", a.OutboundConfigs, b.OutboundConfigs},
+		{"transport", a.Transport, b.Transport},
+		{"policy", a.Policy, b.Policy},
+		{"api", a.API, b.API},
+		{"stats", a.Stats, b.Stats},
+		{"reverse", a.Reverse, b.Reverse},
+		{"fakedns", a.FakeDNS, b.FakeDNS},
+		{"observatory", a.Observatory, b.Observatory},
+		{"burstObservatory", a.BurstObservatory, b.BurstObservatory},
+	}
+	for _, section := range sections {
+		if !bytes.Equal(section.a, section.b) {
+			diff = append(diff, section.name)
+		}
+	}
+	return diff
+}
+
+// VerifyEqualsStability generates the config twice and checks Equals agrees they are the same.
+// A difference means RestartXray would restart on every call without any change.
+func (s *XrayService) VerifyEqualsStability() error {
+	// Generated directly, the cache would hand back the same config twice
+	enabled, err := s.mainInbounds()
+	if err != nil {
+		return err
+	}
+	first, err := s.genXrayConfig(enabled)
+	if err != nil {
+		return err
+	}
+	second, err := s.genXrayConfig(enabled)
+	if err != nil {
+		return err
+	}
+	if first.Equals(second) {
+		return nil
+	}
+	diff := configDiff(first, second)
+	return common.NewErrorf("config generation is not stable, it differs in: %s", strings.Join(diff, ", "))
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+// setConfigProcessors replaces the registered processors for the test
+func setConfigProcessors(t *testing.T, processors ...ConfigProcessor) {
+	t.Helper()
+	configProcessors.Lock()
+	old := configProcessors.list
+	configProcessors.list = processors
+	configProcessors.Unlock()
+	invalidateXrayConfigCache()
+	t.Cleanup(func() {
+		configProcessors.Lock()
+		configProcessors.list = old
+		configProcessors.Unlock()
+		invalidateXrayConfigCache()
+	})
+}
+
+func TestVerifyEqualsStability(t *testing.T) {
+	var counter atomic.Int64
+	tests := []struct {
+		name      string
+		processor ConfigProcessor
+		wantDiff  string
+	}{
+		{name: "deterministic generation"},
+		{
+			name: "changing stats block",
+			processor: func(xrayConfig *xray.Config) error {
+				xrayConfig.Stats = json_util.RawMessage(fmt.Sprintf(`{"generation": %d}`, counter.Add(1)))
+				return nil
+			},
+			wantDiff: "stats",
+		},
+		{
+			name: "changing inbound",
+			processor: func(xrayConfig *xray.Config) error {
+				for i := range xrayConfig.InboundConfigs {
+					if xrayConfig.InboundConfigs[i].Tag == "inbound-20001" {
+						xrayConfig.InboundConfigs[i].Sniffing = json_util.RawMessage(fmt.Sprintf(`{"enabled": true, "n": %d}`, counter.Add(1)))
+					}
+				}
+				return nil
+			},
+			wantDiff: "inbounds[inbound-20001]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			addTestInbound(t, 20001, "inbound-20001", true)
+			addTestInbound(t, 20002, "inbound-20002", true)
+			if tt.processor != nil {
+				setConfigProcessors(t, tt.processor)
+			} else {
+				setConfigProcessors(t)
+			}
+			s := &XrayService{}
+			err := s.VerifyEqualsStability()
+			if tt.wantDiff == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.HasSuffix(err.Error(), "differs in: "+tt.wantDiff) {
+				t.Fatalf("got error %v, want a difference in %s", err, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	base := func() *xray.Config {
+		return &xray.Config{
+			InboundConfigs:   []xray.InboundConfig{{Tag: "a", Port: 1}, {Tag: "b", Port: 2}},
+			RouterConfig:     json_util.RawMessage(`{"rules": []}`),
+			Observatory:      json_util.RawMessage(`{"subjectSelector": ["proxy"]}`),
+			BurstObservatory: json_util.RawMessage(`{}`),
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(c *xray.Config)
+		want   []string
+	}{
+		{name: "same", change: func(c *xray.Config) {}},
+		{name: "inbound", change: func(c *xray.Config) { c.InboundConfigs[1].Port = 3 }, want: []string{"inbounds[b]"}},
+		{name: "inbound count", change: func(c *xray.Config) { c.InboundConfigs = c.InboundConfigs[:1] }, want: []string{"inbounds"}},
+		{name: "routing", change: func(c *xray.Config) { c.RouterConfig = json_util.RawMessage(`{"rules": [{}]}`) }, want: []string{"routing"}},
+		{name: "observatory", change: func(c *xray.Config) { c.Observatory = nil }, want: []string{"observatory"}},
+		{name: "burst observatory", change: func(c *xray.Config) { c.BurstObservatory = json_util.RawMessage(`{"pingConfig": {}}`) }, want: []string{"burstObservatory"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := base(), base()
+			tt.change(b)
+			if got := configDiff(a, b); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got diff %v, want %v", got, tt.want)
+			}
+			if equal := a.Equals(b); equal != (len(tt.want) == 0) {
+				t.Fatalf("Equals is %v with diff %v", equal, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+var (
+	standbyConfig     *xray.Config
+	standbyConfigLock sync.RWMutex
+)
+
+// Status reports the state of the Xray core, standby when this panel only generates config
+func (s *XrayService) Status() ProcessState {
+	if s.isStandby() {
+		return Standby
+	}
+	if noInbounds.Load() {
+		return NoInbounds
+	}
+	if s.IsXrayRunning() {
+		return Running
+	}
+	if s.GetXrayErr() != nil {
+		return Error
+	}
+	return Stop
+}
+
+func (s *XrayService) isStandby() bool {
+	standby, err := s.settingService.GetXrayStandby()
+	if err != nil {
+		logger.Warning("get xray standby setting failed:", err)
+		return false
+	}
+	return standby
+}
+
+// SetStandby switches between active and standby, the change applies on the next restart
+func (s *XrayService) SetStandby(standby bool) error {
+	err := s.settingService.SetXrayStandby(standby)
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// GetStandbyConfig returns the last config validated while in standby mode
+func (s *XrayService) GetStandbyConfig() *xray.Config {
+	standbyConfigLock.RLock()
+	defer standbyConfigLock.RUnlock()
+	return standbyConfig
+}
+
+// validates and caches the candidate config without spawning a process, caller holds lock
+func (s *XrayService) restartStandby(xrayConfig *xray.Config) error {
+	err := validateXrayConfig(xrayConfig)
+	if err != nil {
+		return err
+	}
+	standbyConfigLock.Lock()
+	standbyConfig = xrayConfig
+	standbyConfigLock.Unlock()
+
+	// A node that was just demoted must not keep serving
+	if s.IsXrayRunning() {
+		logger.Info("Xray is in standby mode, stopping the running process")
+		if err := mainXrayProcess().Stop(); err != nil {
+			logger.Errorf("Error stopping Xray: %v", err)
+		}
+	}
+	logger.Debug("Xray is in standby mode; config validated but not started.")
+	return nil
+}
+
+func validateXrayConfig(xrayConfig *xray.Config) error {
+	_, err := json.Marshal(xrayConfig)
+	if err != nil {
+		return err
+	}
+	tags := make(map[string]bool, len(xrayConfig.InboundConfigs))
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag == "" {
+			continue
+		}
+		if tags[inbound.Tag] {
+			return common.NewErrorf("duplicate inbound tag: %s", inbound.Tag)
+		}
+		tags[inbound.Tag] = true
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"x-ui/xray"
+)
+
+func TestValidateXrayConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		wantErr string
+	}{
+		{name: "unique tags", tags: []string{"inbound-1", "inbound-2"}},
+		{name: "untagged inbounds", tags: []string{"", ""}},
+		{name: "duplicate tag", tags: []string{"inbound-1", "inbound-2", "inbound-1"}, wantErr: "duplicate inbound tag: inbound-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xrayConfig := &xray.Config{}
+			for _, tag := range tt.tags {
+				xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, xray.InboundConfig{Tag: tag})
+			}
+			err := validateXrayConfig(xrayConfig)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartXrayStandby(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	s := &XrayService{}
+	if err := s.SetStandby(true); err != nil {
+		t.Fatal(err)
+	}
+	setMainXrayProcess(t, nil)
+	t.Cleanup(func() {
+		standbyConfigLock.Lock()
+		standbyConfig = nil
+		standbyConfigLock.Unlock()
+		stagedXrayConfig.Lock()
+		stagedXrayConfig.config = nil
+		stagedXrayConfig.Unlock()
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	if err := s.RestartXray(false); err != nil {
+		t.Fatal(err)
+	}
+	if mainXrayProcess() != nil {
+		t.Fatal("standby restart started an xray process")
+	}
+	if got := s.Status(); got != Standby {
+		t.Fatalf("got status %q, want %q", got, Standby)
+	}
+	cached := s.GetStandbyConfig()
+	if cached == nil {
+		t.Fatal("standby restart did not cache the config")
+	}
+	if got := configInboundTags(cached); len(got) != 1 || got[0] != "inbound-20001" {
+		t.Fatalf("cached config has inbounds %v", got)
+	}
+
+	// An invalid candidate is still rejected and does not replace the cached config
+	if err := s.StageConfig(); err != nil {
+		t.Fatal(err)
+	}
+	stagedXrayConfig.Lock()
+	staged := stagedXrayConfig.config
+	staged.InboundConfigs = append(staged.InboundConfigs, xray.InboundConfig{Tag: "inbound-20001"})
+	stagedXrayConfig.Unlock()
+	err := s.RestartXray(false)
+	if err == nil || !strings.Contains(err.Error(), "duplicate inbound tag") {
+		t.Fatalf("got error %v, want a duplicate tag error", err)
+	}
+	if mainXrayProcess() != nil {
+		t.Fatal("standby restart started an xray process")
+	}
+	if s.GetStandbyConfig() != cached {
+		t.Fatal("invalid config replaced the cached standby config")
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+
+	"x-ui/logger"
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+// The api inbound the panel falls back to, the process looks the api port up by this tag
+const (
+	statsAPITag  = "api"
+	statsAPIPort = 62789
+)
+
+var (
+	statsAPIWarnings     []string
+	statsAPIWarningsLock sync.RWMutex
+)
+
+// GetStatsAPIWarnings returns what was missing from the api/stats setup of the last generated config
+func (s *XrayService) GetStatsAPIWarnings() []string {
+	statsAPIWarningsLock.RLock()
+	defer statsAPIWarningsLock.RUnlock()
+	return append([]string(nil), statsAPIWarnings...)
+}
+
+// ensureStatsAPI checks that the api, stats and policy blocks traffic stats depend on are present,
+// and fills in what is missing when the statsAutoInject setting is on
+func (s *XrayService) ensureStatsAPI(xrayConfig *xray.Config) error {
+	inject, err := s.settingService.GetStatsAutoInject()
+	if err != nil {
+		return err
+	}
+
+	var warnings []string
+	warn := func(msg string) {
+		if inject {
+			msg += ", injected the default"
+		} else {
+			msg += ", traffic statistics will not work"
+		}
+		warnings = append(warnings, msg)
+	}
+
+	// api block with the stats service
+	api := map[string]interface{}{}
+	if len(xrayConfig.API) > 0 {
+		if err := json.Unmarshal(xrayConfig.API, &api); err != nil {
+			return err
+		}
+	}
+	if len(api) == 0 {
+		warn("template has no api block")
+		api = map[string]interface{}{
+			"tag":      statsAPITag,
+			"services": []interface{}{"HandlerService", "LoggerService", "StatsService"},
+		}
+	} else {
+		if tag, _ := api["tag"].(string); tag == "" {
+			warn("api block has no tag")
+			api["tag"] = statsAPITag
+		}
+		services, _ := api["services"].([]interface{})
+		if !containsValue(services, "StatsService") {
+			warn("api block does not enable StatsService")
+			api["services"] = append(services, "StatsService")
+		}
+	}
+	apiTag := api["tag"].(string)
+
+	// stats block, its presence is what turns the counters on
+	if len(xrayConfig.Stats) == 0 {
+		warn("template has no stats block")
+	}
+
+	// policy counters for users, inbounds and outbounds
+	policy := map[string]interface{}{}
+	if len(xrayConfig.Policy) > 0 {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]interface{})
+	if levels == nil {
+		levels = map[string]interface{}{}
+	}
+	level0, _ := levels["0"].(map[string]interface{})
+	if level0 == nil {
+		level0 = map[string]interface{}{}
+	}
+	system, _ := policy["system"].(map[string]interface{})
+	if system == nil {
+		system = map[string]interface{}{}
+	}
+	for _, key := range []string{"statsUserUplink", "statsUserDownlink"} {
+		if enabled, _ := level0[key].(bool); !enabled {
+			warn("policy level 0 does not enable " + key)
+			level0[key] = true
+		}
+	}
+	for _, key := range []string{"statsInboundUplink", "statsInboundDownlink", "statsOutboundUplink", "statsOutboundDownlink"} {
+		if enabled, _ := system[key].(bool); !enabled {
+			warn("policy system does not enable " + key)
+			system[key] = true
+		}
+	}
+	levels["0"] = level0
+	policy["levels"] = levels
+	policy["system"] = system
+
+	// api inbound, the process finds the api port through it
+	hasInbound := false
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag == statsAPITag {
+			hasInbound = true
+			break
+		}
+	}
+	if !hasInbound {
+		warn("template has no api inbound")
+	}
+
+	// routing rule sending the api inbound to the api handler
+	routing, err := getRouting(xrayConfig)
+	if err != nil {
+		return err
+	}
+	rules, _ := routing["rules"].([]interface{})
+	hasRule := false
+	for _, rule := range rules {
+		r, ok := rule.(map[string]interface{})
+		if !ok || r["outboundTag"] != apiTag {
+			continue
+		}
+		inboundTags, _ := r["inboundTag"].([]interface{})
+		if containsValue(inboundTags, statsAPITag) {
+			hasRule = true
+			break
+		}
+	}
+	if !hasRule {
+		warn("routing has no rule for the api inbound")
+	}
+
+	statsAPIWarningsLock.Lock()
+	statsAPIWarnings = warnings
+	statsAPIWarningsLock.Unlock()
+	for _, warning := range warnings {
+		logger.Warning(warning)
+	}
+	if !inject || len(warnings) == 0 {
+		return nil
+	}
+
+	if xrayConfig.API, err = json.MarshalIndent(api, "", "  "); err != nil {
+		return err
+	}
+	if len(xrayConfig.Stats) == 0 {
+		xrayConfig.Stats = json_util.RawMessage("{}")
+	}
+	if xrayConfig.Policy, err = json.MarshalIndent(policy, "", "  "); err != nil {
+		return err
+	}
+	if !hasInbound {
+		xrayConfig.InboundConfigs = append([]xray.InboundConfig{{
+			Listen:   json_util.RawMessage(`"127.0.0.1"`),
+			Port:     statsAPIPort,
+			Protocol: "dokodemo-door",
+			Settings: json_util.RawMessage(`{"address": "127.0.0.1"}`),
+			Tag:      statsAPITag,
+		}}, xrayConfig.InboundConfigs...)
+	}
+	if !hasRule {
+		// First, so no other rule can catch the api traffic
+		routing["rules"] = append([]interface{}{map[string]interface{}{
+			"type":        "field",
+			"inboundTag":  []interface{}{statsAPITag},
+			"outboundTag": apiTag,
+		}}, rules...)
+		return setRouting(xrayConfig, routing)
+	}
+	return nil
+}
+
+func containsValue(values []interface{}, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"x-ui/util/json_util"
+	"x-ui/xray"
+)
+
+// statsTestConfig is a template with the complete api/stats setup, minus the parts in omit
+func statsTestConfig(omit ...string) *xray.Config {
+	skip := map[string]bool{}
+	for _, part := range omit {
+		skip[part] = true
+	}
+	xrayConfig := &xray.Config{}
+	if !skip["api"] {
+		xrayConfig.API = json_util.RawMessage(`{"tag": "api", "services": ["HandlerService", "StatsService"]}`)
+	}
+	if !skip["stats"] {
+		xrayConfig.Stats = json_util.RawMessage(`{}`)
+	}
+	if !skip["policy"] {
+		xrayConfig.Policy = json_util.RawMessage(`{
+			"levels": {"0": {"statsUserUplink": true, "statsUserDownlink": true}},
+			"system": {"statsInboundUplink": true, "statsInboundDownlink": true, "statsOutboundUplink": true, "statsOutboundDownlink": true}
+		}`)
+	}
+	if !skip["inbound"] {
+		xrayConfig.InboundConfigs = []xray.InboundConfig{{Tag: statsAPITag, Port: 62789, Protocol: "dokodemo-door"}}
+	}
+	if !skip["routing"] {
+		xrayConfig.RouterConfig = json_util.RawMessage(`{"rules": [{"type": "field", "inboundTag": ["api"], "outboundTag": "api"}]}`)
+	}
+	return xrayConfig
+}
+
+func TestEnsureStatsAPI(t *testing.T) {
+	tests := []struct {
+		name         string
+		omit         []string
+		inject       bool
+		wantWarnings []string
+	}{
+		{name: "complete template"},
+		{name: "complete template with injection", inject: true},
+		{
+			name:         "missing stats block",
+			omit:         []string{"stats"},
+			wantWarnings: []string{"template has no stats block, traffic statistics will not work"},
+		},
+		{
+			name:         "missing stats block injected",
+			omit:         []string{"stats"},
+			inject:       true,
+			wantWarnings: []string{"template has no stats block, injected the default"},
+		},
+		{
+			name:   "missing everything injected",
+			omit:   []string{"api", "stats", "policy", "inbound", "routing"},
+			inject: true,
+			wantWarnings: []string{
+				"template has no api block",
+				"template has no stats block",
+				"policy level 0 does not enable statsUserUplink",
+				"policy level 0 does not enable statsUserDownlink",
+				"policy system does not enable statsInboundUplink",
+				"policy system does not enable statsInboundDownlink",
+				"policy system does not enable statsOutboundUplink",
+				"policy system does not enable statsOutboundDownlink",
+				"template has no api inbound",
+				"routing has no rule for the api inbound",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			s := &XrayService{}
+			if err := s.settingService.SetStatsAutoInject(tt.inject); err != nil {
+				t.Fatal(err)
+			}
+			xrayConfig := statsTestConfig(tt.omit...)
+			original := statsTestConfig(tt.omit...)
+			if err := s.ensureStatsAPI(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			warnings := s.GetStatsAPIWarnings()
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("got warnings %q, want %q", warnings, tt.wantWarnings)
+			}
+			for i := range warnings {
+				if !strings.HasPrefix(warnings[i], tt.wantWarnings[i]) {
+					t.Fatalf("got warnings %q, want %q", warnings, tt.wantWarnings)
+				}
+			}
+
+			if !tt.inject || len(tt.wantWarnings) == 0 {
+				if !xrayConfig.Equals(original) {
+					t.Fatal("config was changed without injecting")
+				}
+				return
+			}
+			// What was injected leaves nothing to warn about
+			if err := s.ensureStatsAPI(xrayConfig); err != nil {
+				t.Fatal(err)
+			}
+			if warnings := s.GetStatsAPIWarnings(); len(warnings) != 0 {
+				t.Fatalf("injected config still warns %q", warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"strconv"
+
+	"x-ui/util/common"
+)
+
+const (
+	maxTcpKeepAliveInterval = 86400
+	maxTcpFastOpenQueue     = 65535
+)
+
+// sockoptDefaults are applied to the sockopt of every inbound stream that does not set them itself
+type sockoptDefaults struct {
+	tcpKeepAliveInterval int
+	// nil, a bool or a queue length, as xray accepts for tcpFastOpen
+	tcpFastOpen interface{}
+}
+
+// parseTcpFastOpen converts the setting to the type xray expects:
+// "" leaves the value untouched, "true"/"false" is a bool and a number is the queue length
+func parseTcpFastOpen(value string) (interface{}, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "true", "false":
+		return value == "true", nil
+	}
+	queue, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, common.NewErrorf("tcpFastOpen must be true, false or a queue length, got %q", value)
+	}
+	if queue <= 0 || queue > maxTcpFastOpenQueue {
+		return nil, common.NewErrorf("tcpFastOpen queue length must be between 1 and %d", maxTcpFastOpenQueue)
+	}
+	return queue, nil
+}
+
+func checkTcpKeepAliveInterval(interval int) error {
+	if interval < 0 || interval > maxTcpKeepAliveInterval {
+		return common.NewErrorf("tcpKeepAliveInterval must be between 0 and %d seconds", maxTcpKeepAliveInterval)
+	}
+	return nil
+}
+
+// SetSockoptDefaults validates and stores the sockopt values applied to generated inbounds.
+// An interval of 0 and an empty tcpFastOpen leave the inbound values untouched.
+func (s *XrayService) SetSockoptDefaults(tcpKeepAliveInterval int, tcpFastOpen string) error {
+	if err := checkTcpKeepAliveInterval(tcpKeepAliveInterval); err != nil {
+		return err
+	}
+	if _, err := parseTcpFastOpen(tcpFastOpen); err != nil {
+		return err
+	}
+	if err := s.settingService.SetSockoptKeepAlive(tcpKeepAliveInterval); err != nil {
+		return err
+	}
+	if err := s.settingService.SetSockoptFastOpen(tcpFastOpen); err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+func (s *XrayService) getSockoptDefaults() (*sockoptDefaults, error) {
+	interval, err := s.settingService.GetSockoptKeepAlive()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTcpKeepAliveInterval(interval); err != nil {
+		return nil, err
+	}
+	fastOpen, err := s.settingService.GetSockoptFastOpen()
+	if err != nil {
+		return nil, err
+	}
+	tcpFastOpen, err := parseTcpFastOpen(fastOpen)
+	if err != nil {
+		return nil, err
+	}
+	return &sockoptDefaults{
+		tcpKeepAliveInterval: interval,
+		tcpFastOpen:          tcpFastOpen,
+	}, nil
+}
+
+func (d *sockoptDefaults) apply(stream map[string]interface{}) {
+	if d.tcpKeepAliveInterval == 0 && d.tcpFastOpen == nil {
+		return
+	}
+	sockopt, ok := stream["sockopt"].(map[string]interface{})
+	if !ok {
+		sockopt = map[string]interface{}{}
+		stream["sockopt"] = sockopt
+	}
+	if _, ok := sockopt["tcpKeepAliveInterval"]; !ok && d.tcpKeepAliveInterval > 0 {
+		sockopt["tcpKeepAliveInterval"] = d.tcpKeepAliveInterval
+	}
+	if _, ok := sockopt["tcpFastOpen"]; !ok && d.tcpFastOpen != nil {
+		sockopt["tcpFastOpen"] = d.tcpFastOpen
+	}
+}
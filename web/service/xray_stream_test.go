@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestParseTcpFastOpen(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    interface{}
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"true", true, ""},
+		{"false", false, ""},
+		{"256", 256, ""},
+		{"65535", 65535, ""},
+		{"0", nil, "between 1 and 65535"},
+		{"65536", nil, "between 1 and 65535"},
+		{"-1", nil, "between 1 and 65535"},
+		{"yes", nil, "true, false or a queue length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTcpFastOpen(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSockoptDefaultsApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults sockoptDefaults
+		stream   string
+		want     string
+	}{
+		{"nothing set", sockoptDefaults{}, `{"network": "tcp"}`, `{"network":"tcp"}`},
+		{"bool fast open", sockoptDefaults{tcpKeepAliveInterval: 30, tcpFastOpen: true}, `{}`,
+			`{"sockopt":{"tcpFastOpen":true,"tcpKeepAliveInterval":30}}`},
+		{"queue length", sockoptDefaults{tcpFastOpen: 256}, `{}`, `{"sockopt":{"tcpFastOpen":256}}`},
+		{"inbound values win", sockoptDefaults{tcpKeepAliveInterval: 30, tcpFastOpen: 256},
+			`{"sockopt": {"tcpFastOpen": false, "tcpKeepAliveInterval": 5, "mark": 1}}`,
+			`{"sockopt":{"mark":1,"tcpFastOpen":false,"tcpKeepAliveInterval":5}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(tt.stream), &stream); err != nil {
+				t.Fatal(err)
+			}
+			tt.defaults.apply(stream)
+			got, err := json.Marshal(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+
+			// The generated sockopt must be accepted by xray's own schema
+			sockopt, ok := stream["sockopt"]
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(sockopt)
+			socketConfig := &conf.SocketConfig{}
+			if err := json.Unmarshal(data, socketConfig); err != nil {
+				t.Fatalf("xray rejects %s: %v", data, err)
+			}
+			if _, err := socketConfig.Build(); err != nil {
+				t.Fatalf("xray rejects %s: %v", data, err)
+			}
+		})
+	}
+}
+
+func TestSetSockoptDefaults(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	if err := s.SetSockoptDefaults(-1, ""); err == nil {
+		t.Fatal("negative keepalive interval accepted")
+	}
+	if err := s.SetSockoptDefaults(maxTcpKeepAliveInterval+1, ""); err == nil {
+		t.Fatal("keepalive interval above the maximum accepted")
+	}
+	if err := s.SetSockoptDefaults(10, "many"); err == nil {
+		t.Fatal("invalid tcpFastOpen accepted")
+	}
+	if err := s.SetSockoptDefaults(45, "512"); err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := s.getSockoptDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.tcpKeepAliveInterval != 45 || defaults.tcpFastOpen != 512 {
+		t.Fatalf("got %+v", defaults)
+	}
+}
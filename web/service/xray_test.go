@@ -0,0 +1,365 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"x-ui/xray"
+
+	statsService "github.com/xtls/xray-core/app/stats/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func configInboundTags(xrayConfig *xray.Config) []string {
+	var tags []string
+	for _, inbound := range xrayConfig.InboundConfigs {
+		if inbound.Tag != statsAPITag {
+			tags = append(tags, inbound.Tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func TestGetXrayConfigFor(t *testing.T) {
+	setupTestDB(t)
+	first := addTestInbound(t, 20001, "inbound-20001", true)
+	second := addTestInbound(t, 20002, "inbound-20002", true)
+	disabled := addTestInbound(t, 20003, "inbound-20003", false)
+
+	s := &XrayService{}
+	tests := []struct {
+		name string
+		ids  []int
+		want []string
+	}{
+		{"one inbound", []int{second.Id}, []string{"inbound-20002"}},
+		{"two inbounds", []int{first.Id, second.Id}, []string{"inbound-20001", "inbound-20002"}},
+		{"disabled inbound is included when asked for", []int{disabled.Id}, []string{"inbound-20003"}},
+		{"unknown id", []int{999}, nil},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xrayConfig, err := s.GetXrayConfigFor(tt.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := configInboundTags(xrayConfig)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got inbounds %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got inbounds %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// The subset does not change what the full config holds
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configInboundTags(xrayConfig); len(got) != 2 || got[0] != "inbound-20001" || got[1] != "inbound-20002" {
+		t.Fatalf("full config has inbounds %v", got)
+	}
+}
+
+// stubAPICodec passes the stub server messages through as raw bytes
+type stubAPICodec struct{}
+
+func (stubAPICodec) Marshal(v any) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (stubAPICodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (stubAPICodec) Name() string { return "proto" }
+
+// startStubAPI serves every gRPC method of the xray api from handle and returns its port
+func startStubAPI(t *testing.T, handle func(method string, req []byte) ([]byte, error)) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.ForceServerCodec(stubAPICodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		resp, err := handle(method, req)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg(&resp)
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// onlineIPList encodes GetStatsOnlineIpListResponse{name = 1, ips = 2 map<string, int64>}
+func onlineIPList(name string, ips ...string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, name)
+	for _, ip := range ips {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, ip)
+		entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, 1700000000)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+// startOnlineIPsServer serves GetStatsOnlineIpList from respond and returns its port
+func startOnlineIPsServer(t *testing.T, respond func(name string) ([]byte, error)) int {
+	t.Helper()
+	return startStubAPI(t, func(method string, data []byte) ([]byte, error) {
+		if method != "/xray.app.stats.command.StatsService/GetStatsOnlineIpList" {
+			return nil, status.Error(codes.Unimplemented, method)
+		}
+		var req statsService.GetStatsRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		return respond(req.Name)
+	})
+}
+
+func TestOnlineClients(t *testing.T) {
+	online := func(name string) ([]byte, error) {
+		switch name {
+		case "user>>>alice>>>online":
+			return onlineIPList(name, "10.0.0.2", "10.0.0.1"), nil
+		case "user>>>bob>>>online":
+			return nil, status.Error(codes.Unknown, "online map not found: "+name)
+		case "user>>>carol>>>online":
+			return nil, status.Error(codes.Internal, "broken counter")
+		}
+		return onlineIPList(name), nil
+	}
+	unsupported := func(name string) ([]byte, error) {
+		return nil, status.Error(codes.Unimplemented, "unknown method")
+	}
+
+	tests := []struct {
+		name    string
+		respond func(name string) ([]byte, error)
+		emails  []string
+		want    map[string][]string
+		wantErr error
+	}{
+		{
+			name:    "connected client",
+			respond: online,
+			emails:  []string{"alice"},
+			want:    map[string][]string{"alice": {"10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			name:    "client without connections",
+			respond: online,
+			emails:  []string{"bob", "dave"},
+			want:    map[string][]string{"bob": {}, "dave": {}},
+		},
+		{
+			name:    "failed client is skipped",
+			respond: online,
+			emails:  []string{"alice", "carol", "bob"},
+			want:    map[string][]string{"alice": {"10.0.0.1", "10.0.0.2"}, "bob": {}},
+		},
+		{
+			name:    "core without online stats",
+			respond: unsupported,
+			emails:  []string{"alice"},
+			wantErr: xray.ErrOnlineIPsUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var api xray.XrayAPI
+			if err := api.Init(startOnlineIPsServer(t, tt.respond)); err != nil {
+				t.Fatal(err)
+			}
+			defer api.Close()
+			got, err := onlineClients(&api, tt.emails)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for email, ips := range tt.want {
+				gotIPs, ok := got[email]
+				if !ok || strings.Join(gotIPs, ",") != strings.Join(ips, ",") {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// startStatsServer serves QueryStats from counters, a resetting query zeroes what it returns
+func startStatsServer(t *testing.T, counters map[string]int64) int {
+	t.Helper()
+	var mu sync.Mutex
+	return startStubAPI(t, func(method string, data []byte) ([]byte, error) {
+		if method != "/xray.app.stats.command.StatsService/QueryStats" {
+			return nil, status.Error(codes.Unimplemented, method)
+		}
+		var req statsService.QueryStatsRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		resp := &statsService.QueryStatsResponse{}
+		for name, value := range counters {
+			if !strings.Contains(name, req.Pattern) {
+				continue
+			}
+			resp.Stat = append(resp.Stat, &statsService.Stat{Name: name, Value: value})
+			if req.Reset_ {
+				counters[name] = 0
+			}
+		}
+		return proto.Marshal(resp)
+	})
+}
+
+func TestReadXrayTraffic(t *testing.T) {
+	tests := []struct {
+		name       string
+		reset      bool
+		wantSecond int64
+		wantLedger int64
+	}{
+		{name: "read only", reset: false, wantSecond: 300, wantLedger: 0},
+		{name: "resetting", reset: true, wantSecond: 0, wantLedger: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			addTestClient(t, 1, "alice")
+			t.Cleanup(func() {
+				trafficLedger.Lock()
+				trafficLedger.outbounds = map[string]*trafficLedgerEntry{}
+				trafficLedger.clients = map[string]*trafficLedgerEntry{}
+				trafficLedger.Unlock()
+			})
+			counters := map[string]int64{
+				"inbound>>>inbound-20001>>>traffic>>>uplink":   1000,
+				"inbound>>>inbound-20001>>>traffic>>>downlink": 2000,
+				"user>>>alice>>>traffic>>>uplink":              100,
+				"user>>>alice>>>traffic>>>downlink":            200,
+			}
+			var api xray.XrayAPI
+			if err := api.Init(startStatsServer(t, counters)); err != nil {
+				t.Fatal(err)
+			}
+			defer api.Close()
+
+			traffics, clientTraffics, err := readXrayTraffic(&api, tt.reset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(traffics) != 1 || traffics[0].Up != 1000 || traffics[0].Down != 2000 {
+				t.Fatalf("got traffics %+v", traffics)
+			}
+			if len(clientTraffics) != 1 || clientTraffics[0].Up+clientTraffics[0].Down != 300 {
+				t.Fatalf("got client traffics %+v", clientTraffics)
+			}
+
+			_, clientTraffics, err = readXrayTraffic(&api, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var second int64
+			for _, clientTraffic := range clientTraffics {
+				second += clientTraffic.Up + clientTraffic.Down
+			}
+			if second != tt.wantSecond {
+				t.Fatalf("second read got %d, want %d", second, tt.wantSecond)
+			}
+
+			// Only a resetting read takes traffic from Xray and goes to the ledger
+			trafficLedger.Lock()
+			var ledger int64
+			if entry, ok := trafficLedger.clients["alice"]; ok {
+				ledger = entry.read
+			}
+			trafficLedger.Unlock()
+			if ledger != tt.wantLedger {
+				t.Fatalf("ledger got %d, want %d", ledger, tt.wantLedger)
+			}
+		})
+	}
+}
+
+func TestReadXrayTrafficPartial(t *testing.T) {
+	setupTestDB(t)
+	counters := map[string]int64{
+		"inbound>>>inbound-20001>>>traffic>>>uplink":   1000,
+		"inbound>>>inbound-20001>>>traffic>>>downlink": 2000,
+		"user>>>alice>>>traffic>>>uplink":              100,
+	}
+	var mu sync.Mutex
+	clientQueries := 0
+	port := startStubAPI(t, func(method string, data []byte) ([]byte, error) {
+		var req statsService.QueryStatsRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Pattern == "user>>>" {
+			clientQueries++
+			return nil, status.Error(codes.Unavailable, "stats service went away")
+		}
+		resp := &statsService.QueryStatsResponse{}
+		for name, value := range counters {
+			if strings.Contains(name, req.Pattern) {
+				resp.Stat = append(resp.Stat, &statsService.Stat{Name: name, Value: value})
+				counters[name] = 0
+			}
+		}
+		return proto.Marshal(resp)
+	})
+	var api xray.XrayAPI
+	if err := api.Init(port); err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	traffics, clientTraffics, err := readXrayTraffic(&api, true)
+	var partial *xray.PartialTrafficError
+	if !errors.As(err, &partial) {
+		t.Fatalf("got error %v, want a partial traffic error", err)
+	}
+	if len(traffics) != 1 || traffics[0].Up != 1000 || traffics[0].Down != 2000 {
+		t.Fatalf("got traffics %+v, want the inbound counters read before the failure", traffics)
+	}
+	if len(clientTraffics) != 0 {
+		t.Fatalf("got client traffics %+v, want none", clientTraffics)
+	}
+	// Retrying would read the already reset inbound counters as zero
+	if clientQueries != 1 {
+		t.Fatalf("client stats were queried %d times, want 1", clientQueries)
+	}
+}
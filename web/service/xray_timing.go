@@ -0,0 +1,51 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// GenerationTiming is the per phase duration of the last successful config generation
+type GenerationTiming struct {
+	// Reading and parsing the template
+	Template time.Duration `json:"template"`
+	// Loading inbounds and generation settings from the database
+	Load time.Duration `json:"load"`
+	// Filtering clients and rewriting the settings of every inbound
+	Inbounds time.Duration `json:"inbounds"`
+	// Chains, client and domain routing, dns and the stats api check
+	PostProcess  time.Duration `json:"postProcess"`
+	Total        time.Duration `json:"total"`
+	InboundCount int           `json:"inboundCount"`
+	At           int64         `json:"at"`
+}
+
+var (
+	lastGenerationTiming     GenerationTiming
+	lastGenerationTimingLock sync.RWMutex
+)
+
+// generationReport is what a config generation reports besides the config itself
+type generationReport struct {
+	timing GenerationTiming
+	capped map[string][]string
+}
+
+// record makes the report the one of the last generated config
+func (r *generationReport) record() {
+	setLastGenerationTiming(r.timing)
+	setCappedClients(r.capped)
+}
+
+func setLastGenerationTiming(timing GenerationTiming) {
+	lastGenerationTimingLock.Lock()
+	lastGenerationTiming = timing
+	lastGenerationTimingLock.Unlock()
+}
+
+// LastGenerationTiming returns the timing of the last generated main config, zero before the first one
+func (s *XrayService) LastGenerationTiming() GenerationTiming {
+	lastGenerationTimingLock.RLock()
+	defer lastGenerationTimingLock.RUnlock()
+	return lastGenerationTiming
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"x-ui/database/model"
+)
+
+func TestLastGenerationTiming(t *testing.T) {
+	tests := []struct {
+		name     string
+		inbounds int
+	}{
+		{"no inbounds", 0},
+		{"one inbound", 1},
+		{"several inbounds", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			for i := 0; i < tt.inbounds; i++ {
+				port := 20001 + i
+				addTestInbound(t, port, fmt.Sprintf("inbound-%d", port), true)
+			}
+			setLastGenerationTiming(GenerationTiming{})
+			invalidateXrayConfigCache()
+			s := &XrayService{}
+			if timing := s.LastGenerationTiming(); timing.Total != 0 {
+				t.Fatalf("got timing %+v before generating", timing)
+			}
+
+			start := time.Now()
+			xrayConfig, err := s.GetXrayConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			timing := s.LastGenerationTiming()
+			for name, d := range map[string]time.Duration{
+				"template": timing.Template, "load": timing.Load, "inbounds": timing.Inbounds,
+				"post process": timing.PostProcess, "total": timing.Total,
+			} {
+				if d <= 0 {
+					t.Errorf("%s phase has no duration", name)
+				}
+			}
+			if sum := timing.Template + timing.Load + timing.Inbounds + timing.PostProcess; timing.Total < sum {
+				t.Errorf("total %v is less than the phases %v", timing.Total, sum)
+			}
+			if timing.Total > time.Since(start) {
+				t.Errorf("total %v is longer than the generation", timing.Total)
+			}
+			if timing.InboundCount != len(xrayConfig.InboundConfigs) {
+				t.Errorf("got %d inbounds, want %d", timing.InboundCount, len(xrayConfig.InboundConfigs))
+			}
+			if timing.At < start.Unix()*1000-1000 || timing.At > time.Now().Unix()*1000 {
+				t.Errorf("got generation time %d, want around %d", timing.At, start.Unix()*1000)
+			}
+		})
+	}
+}
+
+func TestOtherConfigsKeepLastGeneration(t *testing.T) {
+	setupTestDB(t)
+	resetXrayConfigCache(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestInbound(t, 20002, "inbound-20002", false)
+	s := &XrayService{}
+	t.Cleanup(func() {
+		setLastGenerationTiming(GenerationTiming{})
+		setCappedClients(map[string][]string{})
+	})
+	if _, err := s.GetXrayConfig(); err != nil {
+		t.Fatal(err)
+	}
+	main := s.LastGenerationTiming()
+	setCappedClients(map[string][]string{"inbound-20001": {"marker"}})
+
+	tests := []struct {
+		name     string
+		generate func() error
+	}{
+		{name: "chosen inbounds", generate: func() error {
+			_, err := s.GetXrayConfigFor([]int{inbound.Id, inbound.Id + 1})
+			return err
+		}},
+		{name: "all inbounds", generate: func() error {
+			_, err := s.genXrayConfig(func(*model.Inbound) bool { return true })
+			return err
+		}},
+		{name: "stability check", generate: s.VerifyEqualsStability},
+		{name: "staged config", generate: s.StageConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.generate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.LastGenerationTiming(); got != main {
+				t.Fatalf("got timing %+v, want the main one %+v", got, main)
+			}
+			if got := s.GetCappedClients(); !reflect.DeepEqual(got, map[string][]string{"inbound-20001": {"marker"}}) {
+				t.Fatalf("got capped clients %v", got)
+			}
+		})
+	}
+
+	// The staged config reports its generation once a restart uses it
+	if _, err := s.restartConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.LastGenerationTiming(); got == main {
+		t.Fatal("using the staged config did not record its timing")
+	}
+	if got := s.GetCappedClients(); len(got) != 0 {
+		t.Fatalf("got capped clients %v from the staged config", got)
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"strings"
+
+	"x-ui/util/common"
+)
+
+var (
+	validTlsAlpn        = map[string]bool{"h2": true, "http/1.1": true}
+	validTlsFingerprint = map[string]bool{
+		"chrome": true, "firefox": true, "safari": true, "ios": true, "android": true,
+		"edge": true, "360": true, "qq": true, "random": true, "randomized": true,
+	}
+)
+
+// TlsEnforcement overrides the ALPN and uTLS fingerprint of TLS inbounds, empty values leave them untouched
+type TlsEnforcement struct {
+	Alpn        []string
+	Fingerprint string
+}
+
+func parseTlsAlpn(value string) ([]string, error) {
+	var alpn []string
+	for _, a := range strings.Split(value, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !validTlsAlpn[a] {
+			return nil, common.NewErrorf("unsupported alpn %q, expected h2 or http/1.1", a)
+		}
+		alpn = append(alpn, a)
+	}
+	return alpn, nil
+}
+
+func checkTlsFingerprint(fingerprint string) error {
+	if fingerprint != "" && !validTlsFingerprint[fingerprint] {
+		return common.NewErrorf("unsupported fingerprint %q", fingerprint)
+	}
+	return nil
+}
+
+// SetTlsEnforcement validates and stores the ALPN list and fingerprint enforced on TLS inbounds
+func (s *XrayService) SetTlsEnforcement(alpn []string, fingerprint string) error {
+	value := strings.Join(alpn, ",")
+	if _, err := parseTlsAlpn(value); err != nil {
+		return err
+	}
+	if err := checkTlsFingerprint(fingerprint); err != nil {
+		return err
+	}
+	if err := s.settingService.SetTlsAlpn(value); err != nil {
+		return err
+	}
+	if err := s.settingService.SetTlsFingerprint(fingerprint); err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// GetTlsEnforcement is shared by config generation and the subscription links
+func (s *SettingService) GetTlsEnforcement() (*TlsEnforcement, error) {
+	value, err := s.GetTlsAlpn()
+	if err != nil {
+		return nil, err
+	}
+	alpn, err := parseTlsAlpn(value)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := s.GetTlsFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTlsFingerprint(fingerprint); err != nil {
+		return nil, err
+	}
+	return &TlsEnforcement{Alpn: alpn, Fingerprint: fingerprint}, nil
+}
+
+// Apply overrides the values in the stream settings of an inbound. The fingerprint lives in
+// the client only "settings" of tlsSettings and realitySettings, so it only reaches the share links.
+func (e *TlsEnforcement) Apply(stream map[string]interface{}) {
+	if len(e.Alpn) == 0 && e.Fingerprint == "" {
+		return
+	}
+	security, _ := stream["security"].(string)
+	var securitySettings map[string]interface{}
+	switch security {
+	case "tls":
+		securitySettings, _ = stream["tlsSettings"].(map[string]interface{})
+		if securitySettings != nil && len(e.Alpn) > 0 {
+			alpn := make([]interface{}, len(e.Alpn))
+			for i, a := range e.Alpn {
+				alpn[i] = a
+			}
+			securitySettings["alpn"] = alpn
+		}
+	case "reality":
+		securitySettings, _ = stream["realitySettings"].(map[string]interface{})
+	}
+	if securitySettings == nil || e.Fingerprint == "" {
+		return
+	}
+	settings, ok := securitySettings["settings"].(map[string]interface{})
+	if !ok {
+		settings = map[string]interface{}{}
+		securitySettings["settings"] = settings
+	}
+	settings["fingerprint"] = e.Fingerprint
+}
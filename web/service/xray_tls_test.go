@@ -0,0 +1,139 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+)
+
+func TestSetTlsEnforcement(t *testing.T) {
+	setupTestDB(t)
+	s := &XrayService{}
+	tests := []struct {
+		name        string
+		alpn        []string
+		fingerprint string
+		wantErr     string
+	}{
+		{name: "alpn and fingerprint", alpn: []string{"h2", "http/1.1"}, fingerprint: "chrome"},
+		{name: "alpn only", alpn: []string{"http/1.1"}},
+		{name: "nothing enforced"},
+		{name: "unsupported alpn", alpn: []string{"h3"}, wantErr: `unsupported alpn "h3"`},
+		{name: "unsupported fingerprint", fingerprint: "netscape", wantErr: `unsupported fingerprint "netscape"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetTlsEnforcement(tt.alpn, tt.fingerprint)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			enforcement, err := s.settingService.GetTlsEnforcement()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(enforcement.Alpn, ",") != strings.Join(tt.alpn, ",") || enforcement.Fingerprint != tt.fingerprint {
+				t.Fatalf("got %+v, want alpn %v and fingerprint %q", enforcement, tt.alpn, tt.fingerprint)
+			}
+		})
+	}
+}
+
+func TestTlsEnforcementApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		enforcement TlsEnforcement
+		stream      string
+		want        string
+	}{
+		{
+			name:        "tls alpn is replaced",
+			enforcement: TlsEnforcement{Alpn: []string{"h2"}},
+			stream:      `{"security": "tls", "tlsSettings": {"alpn": ["http/1.1"]}}`,
+			want:        `{"security": "tls", "tlsSettings": {"alpn": ["h2"]}}`,
+		},
+		{
+			name:        "tls fingerprint goes to the client settings",
+			enforcement: TlsEnforcement{Fingerprint: "firefox"},
+			stream:      `{"security": "tls", "tlsSettings": {"alpn": ["http/1.1"], "settings": {"fingerprint": "chrome"}}}`,
+			want:        `{"security": "tls", "tlsSettings": {"alpn": ["http/1.1"], "settings": {"fingerprint": "firefox"}}}`,
+		},
+		{
+			name:        "reality only gets the fingerprint",
+			enforcement: TlsEnforcement{Alpn: []string{"h2"}, Fingerprint: "safari"},
+			stream:      `{"security": "reality", "realitySettings": {"dest": "example.com:443"}}`,
+			want:        `{"security": "reality", "realitySettings": {"dest": "example.com:443", "settings": {"fingerprint": "safari"}}}`,
+		},
+		{
+			name:        "plain inbound is untouched",
+			enforcement: TlsEnforcement{Alpn: []string{"h2"}, Fingerprint: "safari"},
+			stream:      `{"security": "none"}`,
+			want:        `{"security": "none"}`,
+		},
+		{
+			name:        "nothing enforced",
+			enforcement: TlsEnforcement{},
+			stream:      `{"security": "tls", "tlsSettings": {"alpn": ["http/1.1"]}}`,
+			want:        `{"security": "tls", "tlsSettings": {"alpn": ["http/1.1"]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream, want map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.stream), &stream); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			tt.enforcement.Apply(stream)
+			if !reflect.DeepEqual(stream, want) {
+				t.Fatalf("got %v, want %v", stream, want)
+			}
+		})
+	}
+}
+
+func TestTlsEnforcementInGeneratedConfig(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	inbound.StreamSettings = `{"network": "tcp", "security": "tls", "tlsSettings": {"alpn": ["http/1.1"], "certificates": []}}`
+	if err := database.GetDB().Save(inbound).Error; err != nil {
+		t.Fatal(err)
+	}
+	s := &XrayService{}
+	if err := s.SetTlsEnforcement([]string{"h2", "http/1.1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inboundConfig := range xrayConfig.InboundConfigs {
+		if inboundConfig.Tag != "inbound-20001" {
+			continue
+		}
+		var stream struct {
+			TlsSettings struct {
+				Alpn []string `json:"alpn"`
+			} `json:"tlsSettings"`
+		}
+		if err := json.Unmarshal(inboundConfig.StreamSettings, &stream); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(stream.TlsSettings.Alpn, ","); got != "h2,http/1.1" {
+			t.Fatalf("got alpn %q, want the enforced h2,http/1.1", got)
+		}
+		return
+	}
+	t.Fatal("inbound missing from the generated config")
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// How long client traffic snapshots are kept
+const clientTrafficSnapshotRetention = 90 * 24 * time.Hour
+
+// Snapshots older than the age are thinned to one per bucket, the last one of the bucket stays
+var clientTrafficRollups = []struct {
+	age    time.Duration
+	bucket time.Duration
+}{
+	{30 * 24 * time.Hour, 24 * time.Hour},
+	{7 * 24 * time.Hour, time.Hour},
+}
+
+// TrafficPoint is the traffic of a client between the previous point and Time (unix milliseconds)
+type TrafficPoint struct {
+	Time int64 `json:"time"`
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+type InactiveClient struct {
+	Email      string `json:"email"`
+	LastActive int64  `json:"lastActive"` // unix milliseconds, 0 when unknown
+}
+
+// SnapshotClientTraffics stores the current totals of every client, with the traffic Xray counted
+// but the traffic job did not save yet, then rolls up and drops old snapshots
+func (s *XrayService) SnapshotClientTraffics() error {
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).Find(&traffics).Error
+	if err != nil {
+		return err
+	}
+	pending := map[string]*xray.ClientTraffic{}
+	if s.IsXrayRunning() {
+		_, clientTraffics, err := s.GetXrayTraffic(false)
+		if err != nil {
+			logger.Debug("Snapshot client traffics without pending traffic:", err)
+		}
+		for _, clientTraffic := range clientTraffics {
+			pending[clientTraffic.Email] = clientTraffic
+		}
+	}
+
+	now := time.Now().Unix() * 1000
+	snapshots := make([]*model.ClientTrafficSnapshot, 0, len(traffics))
+	for _, traffic := range traffics {
+		snapshot := &model.ClientTrafficSnapshot{
+			Email: traffic.Email,
+			Up:    traffic.Up,
+			Down:  traffic.Down,
+			Time:  now,
+		}
+		if p, ok := pending[traffic.Email]; ok {
+			snapshot.Up += p.Up
+			snapshot.Down += p.Down
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	tx := db.Begin()
+	if len(snapshots) > 0 {
+		err = tx.CreateInBatches(snapshots, 100).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	cutoff := now - clientTrafficSnapshotRetention.Milliseconds()
+	err = tx.Where("time < ?", cutoff).Delete(model.ClientTrafficSnapshot{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, rollup := range clientTrafficRollups {
+		before := now - rollup.age.Milliseconds()
+		// Ids grow with time, the largest id of a bucket is its last snapshot
+		err = tx.Where("time < ? AND id NOT IN (?)", before,
+			tx.Model(model.ClientTrafficSnapshot{}).Select("MAX(id)").Where("time < ?", before).
+				Group("email, time / "+strconv.FormatInt(rollup.bucket.Milliseconds(), 10)),
+		).Delete(model.ClientTrafficSnapshot{}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetClientTrafficHistory returns the traffic of the client between its snapshots in [from, to].
+// A total lower than the previous one means the traffic was reset, the whole total counts then.
+func (s *XrayService) GetClientTrafficHistory(email string, from, to time.Time) ([]TrafficPoint, error) {
+	var snapshots []*model.ClientTrafficSnapshot
+	err := database.GetDB().Model(model.ClientTrafficSnapshot{}).
+		Where("email = ? AND time <= ?", email, to.UnixMilli()).
+		Order("time").Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return trafficPoints(snapshots, from.UnixMilli()), nil
+}
+
+func trafficPoints(snapshots []*model.ClientTrafficSnapshot, from int64) []TrafficPoint {
+	points := []TrafficPoint{}
+	for i := 1; i < len(snapshots); i++ {
+		previous, current := snapshots[i-1], snapshots[i]
+		if current.Time < from {
+			continue
+		}
+		point := TrafficPoint{Time: current.Time, Up: current.Up, Down: current.Down}
+		if current.Up+current.Down >= previous.Up+previous.Down {
+			point.Up -= previous.Up
+			point.Down -= previous.Down
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// InactiveClientsSince returns the emails of clients whose traffic did not change during the last d
+func (s *XrayService) InactiveClientsSince(d time.Duration) ([]string, error) {
+	inactives, err := s.GetInactiveClients(d)
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]string, 0, len(inactives))
+	for _, inactive := range inactives {
+		emails = append(emails, inactive.Email)
+	}
+	return emails, nil
+}
+
+// GetInactiveClients is like InactiveClientsSince but also reports the last time each client was seen active.
+// Clients without a snapshot older than d are skipped, as there is not enough history to decide.
+func (s *XrayService) GetInactiveClients(d time.Duration) ([]InactiveClient, error) {
+	cutoff := time.Now().Add(-d).Unix() * 1000
+	inactives := make([]InactiveClient, 0)
+	// The window starts at the newest snapshot taken at or before the cutoff. A client is inactive
+	// when no snapshot from there on differs from its current total, a counter reset counts as a
+	// change. It was last active at the first snapshot after the last one with another total.
+	err := database.GetDB().Raw(`
+		SELECT c.email AS email,
+			COALESCE((SELECT MIN(n.time) FROM client_traffic_snapshots n
+				WHERE n.email = c.email AND n.time > (SELECT MAX(o.time) FROM client_traffic_snapshots o
+					WHERE o.email = c.email AND o.up + o.down != c.up + c.down)), 0) AS last_active
+		FROM client_traffics c
+		JOIN (SELECT email, MAX(time) AS time FROM client_traffic_snapshots
+			WHERE time <= ? GROUP BY email) base ON base.email = c.email
+		WHERE NOT EXISTS (SELECT 1 FROM client_traffic_snapshots w
+			WHERE w.email = c.email AND w.time >= base.time AND w.up + w.down != c.up + c.down)
+		ORDER BY c.id
+		`, cutoff).Scan(&inactives).Error
+	if err != nil {
+		return nil, err
+	}
+	return inactives, nil
+}
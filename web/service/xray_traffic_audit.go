@@ -0,0 +1,169 @@
+package service
+
+import (
+	"sort"
+	"sync"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// Allowed gap between the database and the counters, traffic read by a running job may not be saved yet
+const trafficAuditTolerance = 1 << 20
+
+// TrafficDiscrepancy is a total in the database that does not match the traffic read from Xray
+type TrafficDiscrepancy struct {
+	Kind       string `json:"kind"` // outbound or client
+	Key        string `json:"key"`  // outbound tag or client email
+	Database   int64  `json:"database"`
+	Expected   int64  `json:"expected"`
+	Difference int64  `json:"difference"`
+}
+
+// trafficLedgerEntry is the database total when the key was first read plus everything read since
+type trafficLedgerEntry struct {
+	base int64
+	read int64
+}
+
+// trafficLedger counts the traffic the resetting reads took from Xray since the panel started.
+// Resets in the panel drop the entries, the ledger starts over from the next read.
+var trafficLedger = struct {
+	sync.Mutex
+	outbounds map[string]*trafficLedgerEntry
+	clients   map[string]*trafficLedgerEntry
+}{
+	outbounds: map[string]*trafficLedgerEntry{},
+	clients:   map[string]*trafficLedgerEntry{},
+}
+
+// recordTrafficRead adds what a resetting read took from Xray, before the traffic job saves it
+func recordTrafficRead(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	trafficLedger.Lock()
+	defer trafficLedger.Unlock()
+	db := database.GetDB()
+	for _, traffic := range xray.TagTraffics(traffics) {
+		if !traffic.IsOutbound {
+			continue
+		}
+		entry, ok := trafficLedger.outbounds[traffic.Tag]
+		if !ok {
+			var totals []int64
+			err := db.Model(model.OutboundTraffics{}).Where("tag = ?", traffic.Tag).Pluck("total", &totals).Error
+			if err != nil {
+				logger.Debug("Failed to read outbound traffic for the ledger:", err)
+				continue
+			}
+			entry = &trafficLedgerEntry{}
+			if len(totals) > 0 {
+				entry.base = totals[0]
+			}
+			trafficLedger.outbounds[traffic.Tag] = entry
+		}
+		entry.read += traffic.Up + traffic.Down
+	}
+	for _, clientTraffic := range clientTraffics {
+		entry, ok := trafficLedger.clients[clientTraffic.Email]
+		if !ok {
+			var stored xray.ClientTraffic
+			// Clients without a row are not saved by the traffic job either
+			if db.Model(xray.ClientTraffic{}).Where("email = ?", clientTraffic.Email).First(&stored).Error != nil {
+				continue
+			}
+			entry = &trafficLedgerEntry{base: stored.Up + stored.Down}
+			trafficLedger.clients[clientTraffic.Email] = entry
+		}
+		entry.read += clientTraffic.Up + clientTraffic.Down
+	}
+}
+
+// forgetOutboundTrafficLedger drops the ledger of the outbound tags, of all outbounds without tags
+func forgetOutboundTrafficLedger(tags ...string) {
+	trafficLedger.Lock()
+	defer trafficLedger.Unlock()
+	if len(tags) == 0 {
+		trafficLedger.outbounds = map[string]*trafficLedgerEntry{}
+	}
+	for _, tag := range tags {
+		delete(trafficLedger.outbounds, tag)
+	}
+}
+
+// forgetClientTrafficLedger drops the ledger of the client emails, of all clients without emails
+func forgetClientTrafficLedger(emails ...string) {
+	trafficLedger.Lock()
+	defer trafficLedger.Unlock()
+	if len(emails) == 0 {
+		trafficLedger.clients = map[string]*trafficLedgerEntry{}
+	}
+	for _, email := range emails {
+		delete(trafficLedger.clients, email)
+	}
+}
+
+// compareTrafficLedger reports the entries whose database total is off by more than the tolerance
+func compareTrafficLedger(kind string, ledger map[string]*trafficLedgerEntry, totals map[string]int64) []TrafficDiscrepancy {
+	var discrepancies []TrafficDiscrepancy
+	for key, entry := range ledger {
+		total, ok := totals[key]
+		if !ok {
+			// Deleted since, nothing to compare against
+			continue
+		}
+		expected := entry.base + entry.read
+		difference := total - expected
+		if difference > trafficAuditTolerance || difference < -trafficAuditTolerance {
+			discrepancies = append(discrepancies, TrafficDiscrepancy{
+				Kind:       kind,
+				Key:        key,
+				Database:   total,
+				Expected:   expected,
+				Difference: difference,
+			})
+		}
+	}
+	return discrepancies
+}
+
+// AuditTrafficConsistency compares the outbound and client totals in the database with the traffic
+// read from Xray since the panel started or the totals were last reset
+func (s *XrayService) AuditTrafficConsistency() ([]TrafficDiscrepancy, error) {
+	db := database.GetDB()
+	var outboundTraffics []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).Find(&outboundTraffics).Error
+	if err != nil {
+		return nil, err
+	}
+	var clientTraffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Find(&clientTraffics).Error
+	if err != nil {
+		return nil, err
+	}
+	outboundTotals := make(map[string]int64, len(outboundTraffics))
+	for _, traffic := range outboundTraffics {
+		outboundTotals[traffic.Tag] = traffic.Total
+	}
+	clientTotals := make(map[string]int64, len(clientTraffics))
+	for _, traffic := range clientTraffics {
+		clientTotals[traffic.Email] = traffic.Up + traffic.Down
+	}
+
+	trafficLedger.Lock()
+	discrepancies := compareTrafficLedger("outbound", trafficLedger.outbounds, outboundTotals)
+	discrepancies = append(discrepancies, compareTrafficLedger("client", trafficLedger.clients, clientTotals)...)
+	trafficLedger.Unlock()
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].Kind != discrepancies[j].Kind {
+			return discrepancies[i].Kind < discrepancies[j].Kind
+		}
+		return discrepancies[i].Key < discrepancies[j].Key
+	})
+	for _, discrepancy := range discrepancies {
+		logger.Warningf("Traffic of %s %s is %d in the database, %d was read from xray",
+			discrepancy.Kind, discrepancy.Key, discrepancy.Database, discrepancy.Expected)
+	}
+	return discrepancies, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/xray"
+)
+
+func resetTrafficLedger(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() { forgetOutboundTrafficLedger(); forgetClientTrafficLedger() })
+}
+
+func TestCompareTrafficLedger(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry trafficLedgerEntry
+		total int64
+		saved bool
+		want  []TrafficDiscrepancy
+	}{
+		{name: "matching", entry: trafficLedgerEntry{base: 100, read: 50}, total: 150, saved: true},
+		{name: "within tolerance", entry: trafficLedgerEntry{base: 100, read: 50}, total: 150 + trafficAuditTolerance, saved: true},
+		{name: "deleted", entry: trafficLedgerEntry{base: 100, read: 50}},
+		{
+			name:  "lost traffic",
+			entry: trafficLedgerEntry{base: 100, read: 2 * trafficAuditTolerance},
+			total: 100, saved: true,
+			want: []TrafficDiscrepancy{{Kind: "client", Key: "alice", Database: 100, Expected: 100 + 2*trafficAuditTolerance, Difference: -2 * trafficAuditTolerance}},
+		},
+		{
+			name:  "counted twice",
+			entry: trafficLedgerEntry{base: 0, read: 10},
+			total: 10 + 2*trafficAuditTolerance, saved: true,
+			want: []TrafficDiscrepancy{{Kind: "client", Key: "alice", Database: 10 + 2*trafficAuditTolerance, Expected: 10, Difference: 2 * trafficAuditTolerance}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals := map[string]int64{}
+			if tt.saved {
+				totals["alice"] = tt.total
+			}
+			entry := tt.entry
+			got := compareTrafficLedger("client", map[string]*trafficLedgerEntry{"alice": &entry}, totals)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditTrafficConsistency(t *testing.T) {
+	setupTestDB(t)
+	resetTrafficLedger(t)
+	addTestClient(t, 1, "alice")
+	s := &XrayService{}
+
+	// A client without a traffic row is not saved, so it is not tracked either
+	read := []*xray.ClientTraffic{{Email: "alice", Up: trafficAuditTolerance, Down: trafficAuditTolerance}, {Email: "ghost", Up: 1}}
+	recordTrafficRead(nil, read)
+	discrepancies, err := s.AuditTrafficConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) != 1 || discrepancies[0].Key != "alice" || discrepancies[0].Expected != 2*trafficAuditTolerance {
+		t.Fatalf("got %+v, want alice missing the read traffic", discrepancies)
+	}
+
+	// Once the traffic job saved the read, the totals match
+	err = database.GetDB().Model(xray.ClientTraffic{}).Where("email = ?", "alice").
+		Updates(map[string]interface{}{"up": trafficAuditTolerance, "down": trafficAuditTolerance}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if discrepancies, err = s.AuditTrafficConsistency(); err != nil || len(discrepancies) != 0 {
+		t.Fatalf("got %+v and %v after saving", discrepancies, err)
+	}
+
+	// A reset in the panel starts the ledger over
+	recordTrafficRead(nil, read)
+	forgetClientTrafficLedger("alice")
+	if discrepancies, err = s.AuditTrafficConsistency(); err != nil || len(discrepancies) != 0 {
+		t.Fatalf("got %+v and %v after forgetting the client", discrepancies, err)
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestGetInactiveClients(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	now := time.Now()
+	ago := func(hours int) int64 {
+		return now.Add(-time.Duration(hours) * time.Hour).UnixMilli()
+	}
+
+	type snapshot struct {
+		hoursAgo int
+		total    int64
+	}
+	clients := []struct {
+		email      string
+		current    int64
+		snapshots  []snapshot
+		inactive   bool
+		lastActive int64
+	}{
+		{"unchanged", 100, []snapshot{{48, 100}, {12, 100}}, true, 0},
+		{"changed inside the window", 150, []snapshot{{48, 100}, {12, 150}}, false, 0},
+		{"changed after the last snapshot", 120, []snapshot{{48, 100}}, false, 0},
+		{"reset inside the window", 100, []snapshot{{48, 100}, {12, 0}}, false, 0},
+		{"too little history", 100, []snapshot{{12, 100}}, false, 0},
+		{"no snapshots", 100, nil, false, 0},
+		{"active before the window", 100, []snapshot{{72, 50}, {48, 100}, {30, 100}, {12, 100}}, true, ago(48)},
+		{"base is the newest before the cutoff", 100, []snapshot{{96, 10}, {72, 20}, {36, 100}}, true, ago(36)},
+	}
+	for _, client := range clients {
+		err := db.Create(&xray.ClientTraffic{Email: client.email, Up: client.current / 2, Down: client.current - client.current/2}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, point := range client.snapshots {
+			err = db.Create(&model.ClientTrafficSnapshot{Email: client.email, Up: point.total, Time: ago(point.hoursAgo)}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	s := &XrayService{}
+	inactives, err := s.GetInactiveClients(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, inactive := range inactives {
+		got[inactive.Email] = inactive.LastActive
+	}
+	for _, client := range clients {
+		t.Run(client.email, func(t *testing.T) {
+			lastActive, ok := got[client.email]
+			if ok != client.inactive {
+				t.Fatalf("inactive = %v, want %v", ok, client.inactive)
+			}
+			if ok && lastActive != client.lastActive {
+				t.Errorf("last active = %d, want %d", lastActive, client.lastActive)
+			}
+		})
+	}
+
+	emails, err := s.InactiveClientsSince(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != len(inactives) {
+		t.Errorf("InactiveClientsSince returned %v", emails)
+	}
+}
+
+func TestTrafficPoints(t *testing.T) {
+	snapshot := func(time, up, down int64) *model.ClientTrafficSnapshot {
+		return &model.ClientTrafficSnapshot{Time: time, Up: up, Down: down}
+	}
+	tests := []struct {
+		name      string
+		snapshots []*model.ClientTrafficSnapshot
+		from      int64
+		want      []TrafficPoint
+	}{
+		{name: "no snapshots", want: []TrafficPoint{}},
+		{name: "one snapshot", snapshots: []*model.ClientTrafficSnapshot{snapshot(10, 5, 5)}, want: []TrafficPoint{}},
+		{
+			name:      "growing totals",
+			snapshots: []*model.ClientTrafficSnapshot{snapshot(10, 5, 5), snapshot(20, 15, 25), snapshot(30, 15, 25)},
+			want:      []TrafficPoint{{20, 10, 20}, {30, 0, 0}},
+		},
+		{
+			name:      "reset between snapshots",
+			snapshots: []*model.ClientTrafficSnapshot{snapshot(10, 100, 100), snapshot(20, 3, 4), snapshot(30, 5, 8)},
+			want:      []TrafficPoint{{20, 3, 4}, {30, 2, 4}},
+		},
+		{
+			name:      "earlier snapshots are the base of the first point",
+			snapshots: []*model.ClientTrafficSnapshot{snapshot(10, 5, 5), snapshot(20, 10, 10), snapshot(30, 20, 20)},
+			from:      15,
+			want:      []TrafficPoint{{20, 5, 5}, {30, 10, 10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trafficPoints(tt.snapshots, tt.from); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetClientTrafficHistory(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i, total := range []int64{100, 150, 175, 20, 60} {
+		for _, email := range []string{"alice", "bob"} {
+			err := db.Create(&model.ClientTrafficSnapshot{Email: email, Up: total, Time: base.Add(time.Duration(i) * time.Hour).UnixMilli()}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	s := &XrayService{}
+	points, err := s.GetClientTrafficHistory("alice", base.Add(90*time.Minute), base.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TrafficPoint{
+		{Time: base.Add(2 * time.Hour).UnixMilli(), Up: 25},
+		{Time: base.Add(3 * time.Hour).UnixMilli(), Up: 20},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+}
+
+func TestSnapshotClientTrafficsRollup(t *testing.T) {
+	setupTestDB(t)
+	db := database.GetDB()
+	addTestClient(t, 1, "alice")
+	day := 24 * time.Hour
+	now := time.Now()
+	startOfDay := now.Add(-40 * day).Truncate(day)
+	startOfHour := now.Add(-10 * day).Truncate(time.Hour)
+	recent := now.Add(-2 * day).Truncate(time.Hour)
+
+	// Inserted in time order, the rollup keeps the largest id of a bucket
+	times := []struct {
+		at   time.Time
+		kept bool
+	}{
+		{now.Add(-100 * day), false},
+		{startOfDay.Add(1 * time.Hour), false},
+		{startOfDay.Add(5 * time.Hour), false},
+		{startOfDay.Add(20 * time.Hour), true},
+		{startOfHour.Add(5 * time.Minute), false},
+		{startOfHour.Add(30 * time.Minute), false},
+		{startOfHour.Add(50 * time.Minute), true},
+		{startOfHour.Add(70 * time.Minute), true},
+		{recent.Add(5 * time.Minute), true},
+		{recent.Add(10 * time.Minute), true},
+		{recent.Add(15 * time.Minute), true},
+	}
+	var want []int64
+	for _, snapshot := range times {
+		if err := db.Create(&model.ClientTrafficSnapshot{Email: "alice", Time: snapshot.at.UnixMilli()}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.kept {
+			want = append(want, snapshot.at.UnixMilli())
+		}
+	}
+
+	s := &XrayService{}
+	if err := s.SnapshotClientTraffics(); err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	if err := db.Model(model.ClientTrafficSnapshot{}).Where("email = ?", "alice").Order("time").Pluck("time", &got).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Plus the snapshot just taken
+	if len(got) != len(want)+1 || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Fatalf("got snapshots at %v, want %v and the new one", got, want)
+	}
+}
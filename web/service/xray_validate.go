@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"net"
+
+	"x-ui/database/model"
+	"x-ui/util/common"
+)
+
+const (
+	// Invalid inbounds are logged and kept in the generated config, Xray reports what it rejects
+	InboundValidationWarn = "warn"
+	// Invalid inbounds are left out of the generated config
+	InboundValidationSkip = "skip"
+	// Invalid inbounds make the config generation fail
+	InboundValidationError = "error"
+)
+
+// The mtu range of a wireguard interface, IPv6 needs at least 1280
+const (
+	minWireguardMTU = 1280
+	maxWireguardMTU = 65535
+)
+
+// Settings fields xray requires for each inbound protocol
+var requiredInboundSettings = map[model.Protocol][]string{
+	model.VMESS:       {"clients"},
+	model.VLESS:       {"clients", "decryption"},
+	model.Trojan:      {"clients"},
+	model.Shadowsocks: {"method"},
+	model.Socks:       {"auth"},
+	model.WireGuard:   {"secretKey", "peers"},
+}
+
+// validateInboundSettings checks that the settings have the fields the protocol needs
+func validateInboundSettings(inbound *model.Inbound, settings map[string]interface{}) error {
+	for _, field := range requiredInboundSettings[inbound.Protocol] {
+		value, ok := settings[field]
+		if !ok || value == nil {
+			return common.NewErrorf("inbound %s (%s): settings.%s is required", inbound.Tag, inbound.Protocol, field)
+		}
+		if field == "clients" || field == "peers" {
+			if _, ok := value.([]interface{}); !ok {
+				return common.NewErrorf("inbound %s (%s): settings.%s must be an array", inbound.Tag, inbound.Protocol, field)
+			}
+		}
+	}
+
+	switch inbound.Protocol {
+	case model.DOKODEMO:
+		// dokodemo-door needs a target unless it follows the redirected destination
+		follow, _ := settings["followRedirect"].(bool)
+		if address, _ := settings["address"].(string); address == "" && !follow {
+			return common.NewErrorf("inbound %s (%s): settings.address is required unless followRedirect is set", inbound.Tag, inbound.Protocol)
+		}
+	case model.Shadowsocks:
+		// Single user shadowsocks keeps its password at the top level
+		_, hasClients := settings["clients"].([]interface{})
+		if password, _ := settings["password"].(string); password == "" && !hasClients {
+			return common.NewErrorf("inbound %s (%s): settings.password or settings.clients is required", inbound.Tag, inbound.Protocol)
+		}
+	case model.WireGuard:
+		if err := validateWireguardSettings(settings); err != nil {
+			return common.NewErrorf("inbound %s (%s): %v", inbound.Tag, inbound.Protocol, err)
+		}
+	}
+	if err := validateSniffing(inbound); err != nil {
+		return common.NewErrorf("inbound %s (%s): %v", inbound.Tag, inbound.Protocol, err)
+	}
+	return nil
+}
+
+var sniffingDestOverrides = map[string]bool{
+	"http":           true,
+	"tls":            true,
+	"quic":           true,
+	"fakedns":        true,
+	"fakedns+others": true,
+}
+
+// validateSniffing checks the protocols sniffed and the domains excluded from sniffing
+func validateSniffing(inbound *model.Inbound) error {
+	sniffing, err := inbound.GetSniffing()
+	if err != nil {
+		return common.NewErrorf("invalid sniffing: %v", err)
+	}
+	if sniffing == nil {
+		return nil
+	}
+	for _, protocol := range sniffing.DestOverride {
+		if !sniffingDestOverrides[protocol] {
+			return common.NewErrorf("sniffing.destOverride has unknown protocol %q", protocol)
+		}
+	}
+	for _, domain := range sniffing.DomainsExcluded {
+		if err := checkDomainMatcher(domain); err != nil {
+			return common.NewErrorf("sniffing.domainsExcluded: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateWireguardSettings checks the keys, the mtu and the allowed IPs of the peers, xray
+// refuses to start on any of them being wrong
+func validateWireguardSettings(settings map[string]interface{}) error {
+	secretKey, _ := settings["secretKey"].(string)
+	if _, err := decodeWireGuardKey("settings.secretKey", secretKey); err != nil {
+		return err
+	}
+	if mtu, ok := settings["mtu"].(float64); ok && (mtu < minWireguardMTU || mtu > maxWireguardMTU) {
+		return common.NewErrorf("settings.mtu must be between %d and %d", minWireguardMTU, maxWireguardMTU)
+	}
+	peers, _ := settings["peers"].([]interface{})
+	for i, item := range peers {
+		peer, _ := item.(map[string]interface{})
+		publicKey, _ := peer["publicKey"].(string)
+		if _, err := decodeWireGuardKey(fmt.Sprintf("settings.peers[%d].publicKey", i), publicKey); err != nil {
+			return err
+		}
+		if psk, _ := peer["preSharedKey"].(string); psk != "" {
+			if _, err := decodeWireGuardKey(fmt.Sprintf("settings.peers[%d].preSharedKey", i), psk); err != nil {
+				return err
+			}
+		}
+		allowedIPs, _ := peer["allowedIPs"].([]interface{})
+		for _, value := range allowedIPs {
+			ip, _ := value.(string)
+			if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+				return common.NewErrorf("settings.peers[%d].allowedIPs: invalid address %q", i, ip)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *XrayService) SetInboundValidation(mode string) error {
+	if mode != InboundValidationWarn && mode != InboundValidationSkip && mode != InboundValidationError {
+		return common.NewErrorf("inbound validation must be %s, %s or %s", InboundValidationWarn, InboundValidationSkip, InboundValidationError)
+	}
+	return s.settingService.SetInboundValidation(mode)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"x-ui/database"
+	"x-ui/database/model"
+)
+
+func TestValidateInboundSettings(t *testing.T) {
+	priv, pub := newWireGuardKeypair(t)
+	wireguard := func(extra string) string {
+		return `{"secretKey": "` + priv + `", "peers": [{"publicKey": "` + pub + `", "allowedIPs": ["10.0.0.2/32"]}]` + extra + `}`
+	}
+
+	tests := []struct {
+		name     string
+		protocol model.Protocol
+		settings string
+		sniffing string
+		wantErr  string
+	}{
+		{"vmess", model.VMESS, `{"clients": []}`, "", ""},
+		{"vmess without clients", model.VMESS, `{}`, "", "settings.clients is required"},
+		{"vmess clients not an array", model.VMESS, `{"clients": {}}`, "", "settings.clients must be an array"},
+		{"vless", model.VLESS, `{"clients": [], "decryption": "none"}`, "", ""},
+		{"vless without decryption", model.VLESS, `{"clients": []}`, "", "settings.decryption is required"},
+		{"trojan", model.Trojan, `{"clients": []}`, "", ""},
+		{"trojan without clients", model.Trojan, `{"fallbacks": []}`, "", "settings.clients is required"},
+		{"shadowsocks multi user", model.Shadowsocks, `{"method": "aes-128-gcm", "clients": []}`, "", ""},
+		{"shadowsocks single user", model.Shadowsocks, `{"method": "aes-128-gcm", "password": "secret"}`, "", ""},
+		{"shadowsocks without method", model.Shadowsocks, `{"password": "secret"}`, "", "settings.method is required"},
+		{"shadowsocks without password", model.Shadowsocks, `{"method": "aes-128-gcm"}`, "", "settings.password or settings.clients"},
+		{"socks", model.Socks, `{"auth": "noauth"}`, "", ""},
+		{"socks without auth", model.Socks, `{}`, "", "settings.auth is required"},
+		{"dokodemo with address", model.DOKODEMO, `{"address": "1.1.1.1"}`, "", ""},
+		{"dokodemo following redirects", model.DOKODEMO, `{"followRedirect": true}`, "", ""},
+		{"dokodemo without target", model.DOKODEMO, `{}`, "", "settings.address is required"},
+		{"http has no required fields", model.HTTP, `{}`, "", ""},
+		{"wireguard", model.WireGuard, wireguard(""), "", ""},
+		{"wireguard without peers", model.WireGuard, `{"secretKey": "` + priv + `"}`, "", "settings.peers is required"},
+		{"wireguard bad secret key", model.WireGuard, `{"secretKey": "short", "peers": []}`, "", "settings.secretKey"},
+		{"wireguard mtu too small", model.WireGuard, wireguard(`, "mtu": 1000`), "", "settings.mtu"},
+		{"sniffing with unknown protocol", model.VMESS, `{"clients": []}`, `{"enabled": true, "destOverride": ["smtp"]}`, "unknown protocol"},
+		{"sniffing excluded domain", model.VMESS, `{"clients": []}`, `{"enabled": true, "destOverride": ["tls"], "domainsExcluded": ["courier.push.apple.com"]}`, ""},
+		{"sniffing bad excluded domain", model.VMESS, `{"clients": []}`, `{"enabled": true, "domainsExcluded": ["regexp:("]}`, "invalid domain regexp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inbound := &model.Inbound{Tag: "inbound-test", Protocol: tt.protocol, Settings: tt.settings, Sniffing: tt.sniffing}
+			settings := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(tt.settings), &settings); err != nil {
+				t.Fatal(err)
+			}
+			err := validateInboundSettings(inbound, settings)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), "inbound-test") {
+				t.Errorf("error %v does not name the inbound", err)
+			}
+		})
+	}
+}
+
+func TestInboundValidationModes(t *testing.T) {
+	setupTestDB(t)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	broken := &model.Inbound{Port: 20002, Tag: "inbound-20002", Enable: true, Protocol: model.VLESS, Settings: `{"clients": []}`}
+	if err := database.GetDB().Create(broken).Error; err != nil {
+		t.Fatal(err)
+	}
+	s := &XrayService{}
+
+	tests := []struct {
+		mode    string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{"inbound-20001", "inbound-20002"}, false},
+		{InboundValidationWarn, []string{"inbound-20001", "inbound-20002"}, false},
+		{InboundValidationSkip, []string{"inbound-20001"}, false},
+		{InboundValidationError, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			if tt.mode != "" {
+				if err := s.SetInboundValidation(tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			xrayConfig, err := s.GetXrayConfig()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "settings.decryption is required") {
+					t.Fatalf("got error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := configInboundTags(xrayConfig); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got inbounds %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := s.SetInboundValidation("ignore"); err == nil {
+		t.Fatal("unknown validation mode accepted")
+	}
+}
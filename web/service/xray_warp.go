@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+)
+
+// The tag the warp modal saves the Warp outbound under
+const warpOutboundTag = "warp"
+
+// ReloadWarpOutbound replaces the running Warp outbound with the one of the current config,
+// so new Warp credentials apply without dropping every other connection. When Xray is not
+// running, or the outbound can not be swapped through the API, Xray is restarted instead.
+func (s *XrayService) ReloadWarpOutbound() error {
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	var warpOutbound map[string]interface{}
+	for _, outbound := range outbounds {
+		if tag, _ := outbound["tag"].(string); tag == warpOutboundTag {
+			warpOutbound = outbound
+			break
+		}
+	}
+	if warpOutbound == nil {
+		return common.NewError("there is no warp outbound in the config")
+	}
+	// Checked before the running outbound is removed, a broken one would leave Warp down
+	if err := validateWarpOutbound(warpOutbound); err != nil {
+		return err
+	}
+	data, err := json.Marshal(warpOutbound)
+	if err != nil {
+		return err
+	}
+	if _, err := xray.BuildOutbound(data); err != nil {
+		return common.NewErrorf("invalid warp outbound: %v", err)
+	}
+
+	if err := s.swapWarpOutbound(xrayConfig, data); err != nil {
+		logger.Warning("Failed to reload the warp outbound by api, restarting xray:", err)
+		return s.RestartXray(true)
+	}
+	logger.Info("Warp outbound reloaded by api")
+	return nil
+}
+
+// swapWarpOutbound replaces the Warp outbound of the running Xray with data, the one of xrayConfig,
+// and records it in the config of the process as hotReload does
+func (s *XrayService) swapWarpOutbound(xrayConfig *xray.Config, data []byte) error {
+	lock.Lock()
+	defer lock.Unlock()
+	process := mainXrayProcess()
+	if process == nil || !process.IsRunning() {
+		return common.NewError("xray is not running")
+	}
+	running := cloneXrayConfig(process.GetConfig())
+	outbounds, err := getOutbounds(running)
+	if err != nil {
+		return err
+	}
+	// Xray adds an outbound last, the default one can not be swapped
+	if len(outbounds) > 0 && outbounds[0]["tag"] == warpOutboundTag {
+		return common.NewError("the warp outbound is the default outbound")
+	}
+	err = s.xrayAPI.Init(process.GetAPIPort())
+	if err != nil {
+		return err
+	}
+	defer s.xrayAPI.Close()
+	if err := replaceWarpOutbound(&s.xrayAPI, data); err != nil {
+		return err
+	}
+
+	var warpOutbound map[string]interface{}
+	if err := json.Unmarshal(data, &warpOutbound); err != nil {
+		return err
+	}
+	swapped := false
+	for i, outbound := range outbounds {
+		if outbound["tag"] == warpOutboundTag {
+			outbounds[i] = warpOutbound
+			swapped = true
+		}
+	}
+	if !swapped {
+		outbounds = append(outbounds, warpOutbound)
+	}
+	// Keep the encoding of the generated config when the outbounds match it, so the next restart
+	// check finds nothing to do
+	if generated, err := getOutbounds(xrayConfig); err == nil && reflect.DeepEqual(outbounds, generated) {
+		running.OutboundConfigs = bytes.Clone(xrayConfig.OutboundConfigs)
+	} else if err := setOutbounds(running, outbounds); err != nil {
+		return err
+	}
+	process.SetConfig(running)
+	return nil
+}
+
+func replaceWarpOutbound(api *xray.XrayAPI, data []byte) error {
+	// Missing when the running config has no Warp yet, adding it is enough then
+	if err := api.DelOutbound(warpOutboundTag); err != nil {
+		logger.Debug("Unable to delete the warp outbound by api:", err)
+	}
+	return api.AddOutbound(data)
+}
+
+// validateWarpOutbound checks the keys and peers xray-core would only reject once it dials
+func validateWarpOutbound(outbound map[string]interface{}) error {
+	if protocol, _ := outbound["protocol"].(string); protocol != "wireguard" {
+		return common.NewErrorf("warp outbound must be wireguard, not %q", protocol)
+	}
+	settings, _ := outbound["settings"].(map[string]interface{})
+	secretKey, _ := settings["secretKey"].(string)
+	if _, err := decodeWireGuardKey("warp secret key", secretKey); err != nil {
+		return err
+	}
+	peers, _ := settings["peers"].([]interface{})
+	if len(peers) == 0 {
+		return common.NewError("warp outbound has no peers")
+	}
+	for _, peer := range peers {
+		peer, _ := peer.(map[string]interface{})
+		publicKey, _ := peer["publicKey"].(string)
+		if _, err := decodeWireGuardKey("warp peer public key", publicKey); err != nil {
+			return err
+		}
+		if endpoint, _ := peer["endpoint"].(string); endpoint == "" {
+			return common.NewError("warp peer has no endpoint")
+		}
+	}
+	return nil
+}
+
+// applyWarpOverrides sets the MTU and workers of the Warp outbound the admin chose in the
+// settings, the outbound keeps its own values for the ones left at 0
+func (s *XrayService) applyWarpOverrides(xrayConfig *xray.Config) error {
+	mtu, err := s.settingService.GetWarpMtu()
+	if err != nil {
+		return err
+	}
+	workers, err := s.settingService.GetWarpWorkers()
+	if err != nil {
+		return err
+	}
+	if mtu <= 0 && workers <= 0 {
+		return nil
+	}
+	outbounds, err := getOutbounds(xrayConfig)
+	if err != nil {
+		return err
+	}
+	for _, outbound := range outbounds {
+		if tag, _ := outbound["tag"].(string); tag != warpOutboundTag {
+			continue
+		}
+		settings, _ := outbound["settings"].(map[string]interface{})
+		if settings == nil {
+			settings = map[string]interface{}{}
+			outbound["settings"] = settings
+		}
+		if mtu > 0 {
+			settings["mtu"] = mtu
+		}
+		if workers > 0 {
+			settings["workers"] = workers
+		}
+		return setOutbounds(xrayConfig, outbounds)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"x-ui/xray"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func warpTestOutbound(secretKey, publicKey string) string {
+	return fmt.Sprintf(`{"tag": "warp", "protocol": "wireguard", "settings": {
+		"secretKey": %q, "address": ["172.16.0.2/32"],
+		"peers": [{"publicKey": %q, "endpoint": "engage.cloudflareclient.com:2408"}]}}`, secretKey, publicKey)
+}
+
+func TestValidateWarpOutbound(t *testing.T) {
+	secretKey, publicKey := newWireGuardKeypair(t)
+	tests := []struct {
+		name     string
+		outbound string
+		wantErr  string
+	}{
+		{name: "valid", outbound: warpTestOutbound(secretKey, publicKey)},
+		{name: "not wireguard", outbound: `{"tag": "warp", "protocol": "freedom"}`, wantErr: `warp outbound must be wireguard, not "freedom"`},
+		{name: "bad secret key", outbound: warpTestOutbound("short", publicKey), wantErr: "warp secret key"},
+		{name: "bad peer key", outbound: warpTestOutbound(secretKey, ""), wantErr: "warp peer public key"},
+		{name: "no peers", outbound: fmt.Sprintf(`{"protocol": "wireguard", "settings": {"secretKey": %q}}`, secretKey), wantErr: "warp outbound has no peers"},
+		{
+			name:     "no endpoint",
+			outbound: fmt.Sprintf(`{"protocol": "wireguard", "settings": {"secretKey": %q, "peers": [{"publicKey": %q}]}}`, secretKey, publicKey),
+			wantErr:  "warp peer has no endpoint",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outbound map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.outbound), &outbound); err != nil {
+				t.Fatal(err)
+			}
+			err := validateWarpOutbound(outbound)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplaceWarpOutbound(t *testing.T) {
+	secretKey, publicKey := newWireGuardKeypair(t)
+	const (
+		remove = "/xray.app.proxyman.command.HandlerService/RemoveOutbound"
+		add    = "/xray.app.proxyman.command.HandlerService/AddOutbound"
+	)
+	tests := []struct {
+		name      string
+		removeErr error
+		addErr    error
+		wantErr   string
+	}{
+		{name: "running warp is replaced"},
+		{name: "no running warp", removeErr: status.Error(codes.Unknown, "not found: warp")},
+		{name: "add fails", addErr: status.Error(codes.Unknown, "existing tag found: warp"), wantErr: "existing tag found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			port := startStubAPI(t, func(method string, req []byte) ([]byte, error) {
+				mu.Lock()
+				calls = append(calls, method)
+				mu.Unlock()
+				switch method {
+				case remove:
+					return nil, tt.removeErr
+				case add:
+					return nil, tt.addErr
+				}
+				return nil, status.Error(codes.Unimplemented, method)
+			})
+			var api xray.XrayAPI
+			if err := api.Init(port); err != nil {
+				t.Fatal(err)
+			}
+			defer api.Close()
+
+			err := replaceWarpOutbound(&api, []byte(warpTestOutbound(secretKey, publicKey)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(calls, []string{remove, add}) {
+				t.Fatalf("got calls %v", calls)
+			}
+		})
+	}
+}
+
+func TestReloadWarpOutboundFallback(t *testing.T) {
+	secretKey, publicKey := newWireGuardKeypair(t)
+	tests := []struct {
+		name        string
+		outbound    string
+		wantErr     string
+		wantRestart bool
+	}{
+		{name: "restarts a stopped xray", outbound: warpTestOutbound(secretKey, publicKey), wantRestart: true},
+		{name: "no warp outbound", outbound: `{"tag": "proxy", "protocol": "freedom"}`, wantErr: "there is no warp outbound in the config"},
+		{name: "invalid warp outbound", outbound: warpTestOutbound(secretKey, "AAAA"), wantErr: "warp peer public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			addTestInbound(t, 20001, "inbound-20001", true)
+			s := &XrayService{}
+			template := `{"outbounds": [{"tag": "direct", "protocol": "freedom"}, ` + tt.outbound + `]}`
+			if err := s.settingService.saveSetting("xrayTemplateConfig", template); err != nil {
+				t.Fatal(err)
+			}
+			// In standby a restart only caches the config, which shows it ran
+			if err := s.SetStandby(true); err != nil {
+				t.Fatal(err)
+			}
+			setMainXrayProcess(t, nil)
+			t.Cleanup(func() {
+				standbyConfigLock.Lock()
+				standbyConfig = nil
+				standbyConfigLock.Unlock()
+				s.IsNeedRestartAndSetFalse()
+			})
+
+			err := s.ReloadWarpOutbound()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if restarted := s.GetStandbyConfig() != nil; restarted != tt.wantRestart {
+				t.Fatalf("restarted %v, want %v", restarted, tt.wantRestart)
+			}
+		})
+	}
+}
+
+func TestReloadWarpOutboundRecordsConfig(t *testing.T) {
+	setupTestDB(t)
+	setStubXray(t, runningStubXray)
+	addTestInbound(t, 20001, "inbound-20001", true)
+	resetXrayConfigCache(t)
+	s := &XrayService{}
+	var mu sync.Mutex
+	var calls []string
+	port := startStubAPI(t, func(method string, req []byte) ([]byte, error) {
+		mu.Lock()
+		calls = append(calls, method[strings.LastIndex(method, "/")+1:])
+		mu.Unlock()
+		return nil, nil
+	})
+	setTemplate := func(outbound string) {
+		t.Helper()
+		template := fmt.Sprintf(`{"inbounds": [{"tag": "api", "listen": "127.0.0.1", "port": %d, "protocol": "dokodemo-door", "settings": {"address": "127.0.0.1"}}],
+			"outbounds": [{"tag": "direct", "protocol": "freedom"}, %s]}`, port, outbound)
+		if err := s.settingService.saveSetting("xrayTemplateConfig", template); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldSecretKey, oldPublicKey := newWireGuardKeypair(t)
+	setTemplate(warpTestOutbound(oldSecretKey, oldPublicKey))
+
+	xrayConfig, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := xray.NewProcess(xrayConfig)
+	setMainXrayProcess(t, process)
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { process.Stop() })
+
+	secretKey, publicKey := newWireGuardKeypair(t)
+	setTemplate(warpTestOutbound(secretKey, publicKey))
+	if err := s.ReloadWarpOutbound(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if !reflect.DeepEqual(calls, []string{"RemoveOutbound", "AddOutbound"}) {
+		t.Fatalf("got api calls %v", calls)
+	}
+	mu.Unlock()
+	if mainXrayProcess() != process {
+		t.Fatal("the swap restarted xray")
+	}
+	generated, err := s.GetXrayConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(process.GetConfig().OutboundConfigs, generated.OutboundConfigs) {
+		t.Fatalf("the running config has outbounds %s, want %s", process.GetConfig().OutboundConfigs, generated.OutboundConfigs)
+	}
+}
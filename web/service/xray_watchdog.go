@@ -0,0 +1,117 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/xray"
+)
+
+// XrayCrash is one unexpected exit of the Xray process
+type XrayCrash struct {
+	Time     int64  `json:"time"`
+	Uptime   uint64 `json:"uptime"`
+	Error    string `json:"error"`
+	LastLine string `json:"lastLine"`
+	// How long the watchdog waits before the next restart, in seconds
+	Backoff int64 `json:"backoff"`
+}
+
+type XrayWatchdogStatus struct {
+	Failures    int         `json:"failures"`
+	NextRestart int64       `json:"nextRestart"`
+	Crashes     []XrayCrash `json:"crashes"`
+}
+
+const maxXrayCrashes = 50
+
+// A process that stays up this long resets the backoff
+const xrayStableUptime = 60
+
+// Waits grow from a few seconds to five minutes, a config that crashes at once is not restarted in a loop
+var xrayWatchdogRetry = RetryPolicy{
+	BaseBackoff: 2 * time.Second,
+	MaxBackoff:  5 * time.Minute,
+}
+
+var (
+	watchdogLock sync.Mutex
+	xrayCrashes  []XrayCrash
+)
+
+// SuperviseXray is the watchdog of the Xray processes. One that exited on its own is restarted
+// once its backoff has passed, a stop on purpose is left alone. The main Xray is also left alone
+// in standby mode and when it was refused for having no inbounds.
+func (s *XrayService) SuperviseXray() {
+	if !s.isStandby() && !s.refusesEmptyInbounds() {
+		s.superviseProcess(mainXray, func() error {
+			logger.Warning("Xray is not running, restarting it")
+			return s.RestartXray(true)
+		}, s.recordXrayCrash)
+	}
+	for _, name := range xrayProcesses.Names() {
+		if name == mainXray {
+			continue
+		}
+		s.superviseProcess(name, func() error {
+			return s.RestartXrayInstance(name)
+		}, func(process *xray.Process, backoff time.Duration) {
+			logger.Warningf("Xray instance %s exited: %s, restarting in %v", name, process.GetResult(), backoff)
+		})
+	}
+}
+
+// recordXrayCrash keeps an exit of the main process in the crash history
+func (s *XrayService) recordXrayCrash(process *xray.Process, backoff time.Duration) {
+	crash := XrayCrash{
+		Time:     time.Now().Unix(),
+		Uptime:   process.GetUptime(),
+		LastLine: process.GetResult(),
+		Backoff:  int64(backoff.Seconds()),
+	}
+	if err := process.GetErr(); err != nil {
+		crash.Error = err.Error()
+	}
+	watchdogLock.Lock()
+	xrayCrashes = append(xrayCrashes, crash)
+	if len(xrayCrashes) > maxXrayCrashes {
+		xrayCrashes = xrayCrashes[len(xrayCrashes)-maxXrayCrashes:]
+	}
+	watchdogLock.Unlock()
+	logger.Warningf("Xray exited unexpectedly after %ds: %s, restarting in %v", crash.Uptime, crash.LastLine, backoff)
+}
+
+// GetXrayWatchdog returns the crash history, newest first, and when the watchdog restarts Xray next
+func (s *XrayService) GetXrayWatchdog() XrayWatchdogStatus {
+	failures, nextRestart := xrayProcesses.watchdog(mainXray)
+	watchdogLock.Lock()
+	defer watchdogLock.Unlock()
+	status := XrayWatchdogStatus{
+		Failures: failures,
+		Crashes:  make([]XrayCrash, 0, len(xrayCrashes)),
+	}
+	if !nextRestart.IsZero() {
+		status.NextRestart = nextRestart.Unix()
+	}
+	for i := len(xrayCrashes) - 1; i >= 0; i-- {
+		status.Crashes = append(status.Crashes, xrayCrashes[i])
+	}
+	return status
+}
+
+// ClearXrayCrashes forgets the crash history, the backoff of a crashing process stays
+func (s *XrayService) ClearXrayCrashes() {
+	watchdogLock.Lock()
+	defer watchdogLock.Unlock()
+	xrayCrashes = nil
+}
+
+// refusesEmptyInbounds reports whether Xray was stopped because no inbound is enabled
+func (s *XrayService) refusesEmptyInbounds() bool {
+	if !noInbounds.Load() {
+		return false
+	}
+	mode, err := s.settingService.GetEmptyInbounds()
+	return err == nil && mode == EmptyInboundsRefuse
+}
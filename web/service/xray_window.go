@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+// ClientTimeWindow allows a client from Start to End ("15:04", panel time zone) on Days (0 is Sunday,
+// every day when empty). An End before Start runs past midnight.
+type ClientTimeWindow struct {
+	Days  []int  `json:"days,omitempty"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Clients outside their window at the last check, to spot window boundaries
+var lastOutsideWindows string
+
+func parseWindowTime(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, common.NewErrorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func checkTimeWindow(window ClientTimeWindow) error {
+	if _, err := parseWindowTime(window.Start); err != nil {
+		return err
+	}
+	if _, err := parseWindowTime(window.End); err != nil {
+		return err
+	}
+	for _, day := range window.Days {
+		if day < 0 || day > 6 {
+			return common.NewErrorf("invalid day %d, expected 0 (Sunday) to 6", day)
+		}
+	}
+	return nil
+}
+
+func windowHasDay(days []int, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, d := range days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// inTimeWindows reports whether now falls in any of the windows
+func inTimeWindows(windows []ClientTimeWindow, now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	for _, window := range windows {
+		start, err := parseWindowTime(window.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseWindowTime(window.End)
+		if err != nil {
+			continue
+		}
+		if start <= end {
+			if minute >= start && minute < end && windowHasDay(window.Days, now.Weekday()) {
+				return true
+			}
+			continue
+		}
+		// Past midnight, the part after midnight belongs to the previous day's window
+		if minute >= start && windowHasDay(window.Days, now.Weekday()) {
+			return true
+		}
+		if minute < end && windowHasDay(window.Days, now.AddDate(0, 0, -1).Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *XrayService) GetClientTimeWindows() (map[string][]ClientTimeWindow, error) {
+	windows := map[string][]ClientTimeWindow{}
+	data, err := s.settingService.GetClientTimeWindows()
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return windows, nil
+	}
+	err = json.Unmarshal([]byte(data), &windows)
+	if err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// SetClientTimeWindows limits the client to the given windows, none removes the limit
+func (s *XrayService) SetClientTimeWindows(email string, windows []ClientTimeWindow) error {
+	if email == "" {
+		return common.NewError("client email is required")
+	}
+	for _, window := range windows {
+		if err := checkTimeWindow(window); err != nil {
+			return err
+		}
+	}
+	all, err := s.GetClientTimeWindows()
+	if err != nil {
+		return err
+	}
+	if len(windows) == 0 {
+		delete(all, email)
+	} else {
+		if err := s.checkClientExists(email); err != nil {
+			return err
+		}
+		all[email] = windows
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.settingService.SetClientTimeWindows(string(data))
+	if err != nil {
+		return err
+	}
+	s.SetToNeedRestart()
+	return nil
+}
+
+// CheckClientTimeWindows requests a restart when a client crossed a window boundary since the last check
+func (s *XrayService) CheckClientTimeWindows() error {
+	windows, err := s.GetClientTimeWindows()
+	if err != nil {
+		return err
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+
+	var outside []string
+	for email, clientWindows := range windows {
+		if !inTimeWindows(clientWindows, now) {
+			outside = append(outside, email)
+		}
+	}
+	sort.Strings(outside)
+	state := strings.Join(outside, ",")
+	if state == lastOutsideWindows {
+		return nil
+	}
+	lastOutsideWindows = state
+	logger.Debug("Client time windows changed, outside now:", state)
+	s.SetToNeedRestart()
+	return nil
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"x-ui/database/model"
+)
+
+func TestInTimeWindows(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	office := []ClientTimeWindow{{Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "17:00"}}
+	night := []ClientTimeWindow{{Days: []int{1}, Start: "22:00", End: "02:00"}}
+	tests := []struct {
+		name    string
+		windows []ClientTimeWindow
+		now     time.Time
+		want    bool
+	}{
+		{"inside", office, at(1, "10:30"), true},
+		{"at the start", office, at(1, "09:00"), true},
+		{"at the end", office, at(1, "17:00"), false},
+		{"before", office, at(1, "08:59"), false},
+		{"other day", office, at(7, "10:30"), false},
+		{"every day", []ClientTimeWindow{{Start: "09:00", End: "17:00"}}, at(7, "10:30"), true},
+		{"past midnight, same day", night, at(1, "23:00"), true},
+		{"past midnight, next day", night, at(2, "01:00"), true},
+		{"past midnight, after the end", night, at(2, "03:00"), false},
+		{"past midnight, wrong day", night, at(1, "01:00"), false},
+		{"one of several", append(append([]ClientTimeWindow{}, office...), night...), at(1, "23:30"), true},
+		{"invalid window", []ClientTimeWindow{{Start: "9am", End: "17:00"}}, at(1, "10:30"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inTimeWindows(tt.windows, tt.now); got != tt.want {
+				t.Fatalf("got %v at %v, want %v", got, tt.now, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetClientTimeWindows(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestClient(t, inbound.Id, "alice")
+	s := &XrayService{}
+	t.Cleanup(func() { s.IsNeedRestartAndSetFalse() })
+	tests := []struct {
+		name    string
+		email   string
+		windows []ClientTimeWindow
+		wantErr string
+	}{
+		{name: "valid window", email: "alice", windows: []ClientTimeWindow{{Days: []int{0, 6}, Start: "08:00", End: "23:59"}}},
+		{name: "unknown client", email: "nobody", windows: []ClientTimeWindow{{Start: "08:00", End: "12:00"}}, wantErr: "client nobody does not exist"},
+		{name: "invalid time", email: "alice", windows: []ClientTimeWindow{{Start: "8", End: "12:00"}}, wantErr: `invalid time "8"`},
+		{name: "invalid day", email: "alice", windows: []ClientTimeWindow{{Days: []int{7}, Start: "08:00", End: "12:00"}}, wantErr: "invalid day 7"},
+		{name: "no email", windows: []ClientTimeWindow{{Start: "08:00", End: "12:00"}}, wantErr: "client email is required"},
+		{name: "removing the limit", email: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetClientTimeWindows(tt.email, tt.windows)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			windows, err := s.GetClientTimeWindows()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := windows[tt.email]; len(got) != len(tt.windows) {
+				t.Fatalf("got windows %+v, want %+v", got, tt.windows)
+			}
+		})
+	}
+}
+
+func TestFilterClientsByTimeWindow(t *testing.T) {
+	windows := map[string][]ClientTimeWindow{"alice": {{Start: "09:00", End: "17:00"}}}
+	tests := []struct {
+		name      string
+		now       string
+		wantAlice bool
+	}{
+		{"inside the window", "12:00", true},
+		{"outside the window", "20:00", false},
+		{"window reopens", "09:00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, _ := time.Parse("15:04", tt.now)
+			filter := &clientFilter{windows: windows, now: now, capped: map[string][]string{}}
+			clients := []interface{}{
+				map[string]interface{}{"email": "alice", "id": "a"},
+				map[string]interface{}{"email": "bob", "id": "b"},
+			}
+			active, filtered := filter.filterActiveClients(&model.Inbound{Tag: "inbound-20001"}, clients)
+
+			var emails []string
+			for _, client := range active {
+				emails = append(emails, client.(map[string]interface{})["email"].(string))
+			}
+			want := []string{"bob"}
+			if tt.wantAlice {
+				want = []string{"alice", "bob"}
+			}
+			if strings.Join(emails, ",") != strings.Join(want, ",") {
+				t.Fatalf("got active clients %v, want %v", emails, want)
+			}
+			if reason := filtered["alice"]; tt.wantAlice == (reason != "") {
+				t.Fatalf("got filter reason %q for alice", reason)
+			}
+		})
+	}
+}
+
+func TestCheckClientTimeWindows(t *testing.T) {
+	setupTestDB(t)
+	inbound := addTestInbound(t, 20001, "inbound-20001", true)
+	addTestClient(t, inbound.Id, "alice")
+	s := &XrayService{}
+	oldOutside := lastOutsideWindows
+	t.Cleanup(func() {
+		lastOutsideWindows = oldOutside
+		s.IsNeedRestartAndSetFalse()
+	})
+
+	// A window that never includes now: from the next minute to the one after
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(loc)
+	window := ClientTimeWindow{Start: now.Add(2 * time.Minute).Format("15:04"), End: now.Add(3 * time.Minute).Format("15:04")}
+	if err := s.SetClientTimeWindows("alice", []ClientTimeWindow{window}); err != nil {
+		t.Fatal(err)
+	}
+	s.IsNeedRestartAndSetFalse()
+	lastOutsideWindows = ""
+
+	if err := s.CheckClientTimeWindows(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsNeedRestartAndSetFalse() {
+		t.Fatal("no restart when the client left its window")
+	}
+	if err := s.CheckClientTimeWindows(); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsNeedRestartAndSetFalse() {
+		t.Fatal("restart without crossing a window boundary")
+	}
+}
@@ -241,7 +241,12 @@ func (s *Server) startTask() {
 	if err != nil {
 		logger.Warning("start xray failed:", err)
 	}
-	// Check whether xray is running every second
+	s.xrayService.RestartXrayInstances(true)
+	err = s.xrayService.StartRestartSchedule()
+	if err != nil {
+		logger.Warning("start xray restart schedule failed:", err)
+	}
+	// Check whether xray is running every second, a crashed process is restarted with backoff
 	s.cron.AddJob("@every 1s", job.NewCheckXrayRunningJob())
 
 	// Check if xray needs to be restarted every 30 seconds
@@ -251,6 +256,7 @@ func (s *Server) startTask() {
 			if err != nil {
 				logger.Error("restart xray failed:", err)
 			}
+			s.xrayService.RestartXrayInstances(false)
 		}
 	})
 
@@ -266,6 +272,40 @@ func (s *Server) startTask() {
 	// check client ips from log file every day
 	s.cron.AddJob("@daily", job.NewClearLogsJob())
 
+	// Snapshot client traffics for the usage history and to detect inactive clients
+	s.cron.AddJob("@every 5m", job.NewClientTrafficSnapshotJob())
+
+	// Switch reality keys whose rotation grace period ended
+	s.cron.AddJob("@every 1m", job.NewRealityRotationJob())
+
+	// Check the reality dests still pass for the sites they imitate, the first time right away
+	realityCheckJob := job.NewRealityCheckJob()
+	go realityCheckJob.Run()
+	s.cron.AddJob("@every 30m", realityCheckJob)
+
+	// Clients with time windows need the config regenerated when a window opens or closes
+	s.cron.AddJob("@every 1m", job.NewClientTimeWindowJob())
+
+	// Probe outbound servers for the health report and dead outbound exclusion
+	isOutboundProbeEnabled, err := s.settingService.GetOutboundHealthProbe()
+	if err == nil && isOutboundProbeEnabled {
+		s.cron.AddJob("@every 1m", job.NewOutboundHealthJob())
+	}
+
+	// Remove clients connected from more devices than their limit, and bring them back after the cooldown
+	s.cron.AddJob("@every 1m", job.NewDeviceLimitJob())
+
+	// Check the Warp token is still accepted, so a revoked one is noticed before the outbound fails
+	s.cron.AddJob("@every 1h", job.NewWarpCredentialsJob())
+
+	// Download new geoip and geosite files on the configured schedule
+	geoRuntime, err := s.settingService.GetGeoUpdateRuntime()
+	if err == nil && geoRuntime != "" {
+		if _, err = s.cron.AddJob(geoRuntime, job.NewGeoUpdateJob()); err != nil {
+			logger.Warning("Add NewGeoUpdateJob error", err)
+		}
+	}
+
 	// Make a traffic condition every day, 8:30
 	var entry cron.EntryID
 	isTgbotenabled, err := s.settingService.GetTgbotEnabled()
@@ -375,7 +415,9 @@ func (s *Server) Start() (err error) {
 
 func (s *Server) Stop() error {
 	s.cancel()
+	s.xrayService.StopRestartSchedule()
 	s.xrayService.StopXray()
+	s.xrayService.StopXrayInstances()
 	if s.cron != nil {
 		s.cron.Stop()
 	}
@@ -3,8 +3,10 @@ package xray
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"x-ui/logger"
@@ -14,6 +16,7 @@ import (
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/core"
 	"github.com/xtls/xray-core/infra/conf"
 	"github.com/xtls/xray-core/proxy/shadowsocks"
 	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
@@ -21,9 +24,30 @@ import (
 	"github.com/xtls/xray-core/proxy/vless"
 	"github.com/xtls/xray-core/proxy/vmess"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
+// ErrOnlineIPsUnsupported is returned when the running core has no online IP stats
+var ErrOnlineIPsUnsupported = errors.New("xray core does not support online ip stats")
+
+// PartialTrafficError is returned along with the traffic that was read before a later query failed.
+// The returned counters were reset when asked to, so they must be saved rather than dropped.
+type PartialTrafficError struct {
+	Err error
+}
+
+func (e *PartialTrafficError) Error() string {
+	return fmt.Sprintf("partial traffic: %v", e.Err)
+}
+
+func (e *PartialTrafficError) Unwrap() error {
+	return e.Err
+}
+
 type XrayAPI struct {
 	HandlerServiceClient *command.HandlerServiceClient
 	StatsServiceClient   *statsService.StatsServiceClient
@@ -64,6 +88,10 @@ func (x *XrayAPI) Close() {
 }
 
 func (x *XrayAPI) AddInbound(inbound []byte) error {
+	// Init fails while xray is stopped, importing inbounds then only needs a restart
+	if x.HandlerServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
 	client := *x.HandlerServiceClient
 
 	conf := new(conf.InboundDetourConfig)
@@ -92,6 +120,45 @@ func (x *XrayAPI) DelInbound(tag string) error {
 	return err
 }
 
+// BuildOutbound checks the outbound json builds into an xray-core outbound handler
+func BuildOutbound(outbound []byte) (*core.OutboundHandlerConfig, error) {
+	conf := new(conf.OutboundDetourConfig)
+	err := json.Unmarshal(outbound, conf)
+	if err != nil {
+		return nil, err
+	}
+	return conf.Build()
+}
+
+// BuildInbound checks the inbound json builds into an xray-core inbound handler
+func BuildInbound(inbound []byte) (*core.InboundHandlerConfig, error) {
+	conf := new(conf.InboundDetourConfig)
+	err := json.Unmarshal(inbound, conf)
+	if err != nil {
+		return nil, err
+	}
+	return conf.Build()
+}
+
+func (x *XrayAPI) AddOutbound(outbound []byte) error {
+	config, err := BuildOutbound(outbound)
+	if err != nil {
+		logger.Debug("Failed to build outbound:", err)
+		return err
+	}
+	client := *x.HandlerServiceClient
+	_, err = client.AddOutbound(context.Background(), &command.AddOutboundRequest{Outbound: config})
+	return err
+}
+
+func (x *XrayAPI) DelOutbound(tag string) error {
+	client := *x.HandlerServiceClient
+	_, err := client.RemoveOutbound(context.Background(), &command.RemoveOutboundRequest{
+		Tag: tag,
+	})
+	return err
+}
+
 func (x *XrayAPI) AddUser(Protocol string, inboundTag string, user map[string]interface{}) error {
 	var account *serial.TypedMessage
 	switch Protocol {
@@ -171,11 +238,22 @@ func (x *XrayAPI) RemoveUser(inboundTag, email string) error {
 }
 
 func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
+	return x.queryTraffic([]string{"bound>>>", "user>>>"}, reset)
+}
+
+// QueryTraffic reads the counters whose names contain one of the patterns, without resetting them
+func (x *XrayAPI) QueryTraffic(patterns []string) ([]*Traffic, []*ClientTraffic, error) {
+	return x.queryTraffic(patterns, false)
+}
+
+// queryTraffic runs a query for each pattern. A failure after the first query returns the traffic
+// read so far with a PartialTrafficError.
+func (x *XrayAPI) queryTraffic(patterns []string, reset bool) ([]*Traffic, []*ClientTraffic, error) {
 	if x.grpcClient == nil {
 		return nil, nil, common.NewError("xray api is not initialized")
 	}
 
-	trafficRegex := regexp.MustCompile(`(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
+	trafficRegex := regexp.MustCompile(`(inbound|outbound)>>>([^>]+)>>>traffic>>>(?:(tcp|udp)>>>)?(downlink|uplink)`)
 	clientTrafficRegex := regexp.MustCompile(`user>>>([^>]+)>>>traffic>>>(downlink|uplink)`)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -185,42 +263,98 @@ func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 		return nil, nil, common.NewError("xray StatusServiceClient is not initialized")
 	}
 
-	resp, err := (*x.StatsServiceClient).QueryStats(ctx, &statsService.QueryStatsRequest{Reset_: reset})
-	if err != nil {
-		logger.Debug("Failed to query Xray stats:", err)
-		return nil, nil, err
-	}
-
 	tagTrafficMap := make(map[string]*Traffic)
 	emailTrafficMap := make(map[string]*ClientTraffic)
+	// The patterns match substrings, a counter can come back from both queries
+	seen := map[string]bool{}
+	collect := func(stats []*statsService.Stat) {
+		for _, stat := range stats {
+			if seen[stat.Name] {
+				continue
+			}
+			seen[stat.Name] = true
+			if matches := trafficRegex.FindStringSubmatch(stat.Name); len(matches) == 5 {
+				processTraffic(matches, stat.Value, tagTrafficMap)
+			} else if matches := clientTrafficRegex.FindStringSubmatch(stat.Name); len(matches) == 3 {
+				processClientTraffic(matches, stat.Value, emailTrafficMap)
+			}
+		}
+	}
 
-	for _, stat := range resp.GetStat() {
-		if matches := trafficRegex.FindStringSubmatch(stat.Name); len(matches) == 4 {
-			processTraffic(matches, stat.Value, tagTrafficMap)
-		} else if matches := clientTrafficRegex.FindStringSubmatch(stat.Name); len(matches) == 3 {
-			processClientTraffic(matches, stat.Value, emailTrafficMap)
+	// Queried apart, so a failure on the client counters keeps the inbound and outbound ones
+	for i, pattern := range patterns {
+		resp, err := (*x.StatsServiceClient).QueryStats(ctx, &statsService.QueryStatsRequest{Pattern: pattern, Reset_: reset})
+		if err != nil {
+			logger.Debugf("Failed to query Xray stats %s: %v", pattern, err)
+			if i == 0 {
+				return nil, nil, err
+			}
+			return mapToSlice(tagTrafficMap), mapToSlice(emailTrafficMap), &PartialTrafficError{Err: err}
 		}
+		collect(resp.GetStat())
 	}
 	return mapToSlice(tagTrafficMap), mapToSlice(emailTrafficMap), nil
 }
 
+// SysStats is the runtime state of the Xray process
+type SysStats struct {
+	NumGoroutine uint32 `json:"numGoroutine"`
+	NumGC        uint32 `json:"numGC"`
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+	LiveObjects  uint64 `json:"liveObjects"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+	Uptime       uint32 `json:"uptime"`
+}
+
+func (x *XrayAPI) GetSysStats() (*SysStats, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	resp, err := (*x.StatsServiceClient).GetSysStats(ctx, &statsService.SysStatsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &SysStats{
+		NumGoroutine: resp.NumGoroutine,
+		NumGC:        resp.NumGC,
+		Alloc:        resp.Alloc,
+		TotalAlloc:   resp.TotalAlloc,
+		Sys:          resp.Sys,
+		Mallocs:      resp.Mallocs,
+		Frees:        resp.Frees,
+		LiveObjects:  resp.LiveObjects,
+		PauseTotalNs: resp.PauseTotalNs,
+		Uptime:       resp.Uptime,
+	}, nil
+}
+
 func processTraffic(matches []string, value int64, trafficMap map[string]*Traffic) {
 	isInbound := matches[1] == "inbound"
 	tag := matches[2]
-	isDown := matches[3] == "downlink"
+	protocol := matches[3]
+	isDown := matches[4] == "downlink"
 
 	if tag == "api" {
 		return
 	}
 
-	traffic, ok := trafficMap[tag]
+	// Protocol tagged counters are kept apart from the plain ones of the same tag
+	key := matches[1] + ">>>" + tag + ">>>" + protocol
+	traffic, ok := trafficMap[key]
 	if !ok {
 		traffic = &Traffic{
 			IsInbound:  isInbound,
 			IsOutbound: !isInbound,
 			Tag:        tag,
+			Protocol:   protocol,
 		}
-		trafficMap[tag] = traffic
+		trafficMap[key] = traffic
 	}
 
 	if isDown {
@@ -254,3 +388,102 @@ func mapToSlice[T any](m map[string]*T) []*T {
 	}
 	return result
 }
+
+// rawCodec passes encoded messages through, for methods the bundled xray-core does not know yet
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// GetOnlineIPs returns the source IPs the client is connected from, it needs statsUserOnline in the policy
+func (x *XrayAPI) GetOnlineIPs(email string) ([]string, error) {
+	if x.grpcClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := proto.Marshal(&statsService.GetStatsRequest{Name: "user>>>" + email + ">>>online"})
+	if err != nil {
+		return nil, err
+	}
+	var resp []byte
+	err = x.grpcClient.Invoke(ctx, "/xray.app.stats.command.StatsService/GetStatsOnlineIpList", &req, &resp, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return nil, ErrOnlineIPsUnsupported
+		}
+		// The counter only exists while the user has connections
+		if strings.Contains(err.Error(), "not found") {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return parseOnlineIPList(resp)
+}
+
+// parseOnlineIPList decodes GetStatsOnlineIpListResponse{name = 1, ips = 2 map<string, int64>}
+func parseOnlineIPList(data []byte) ([]string, error) {
+	ips := []string{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num != 2 || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		entry, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		for len(entry) > 0 {
+			num, typ, n := protowire.ConsumeTag(entry)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			entry = entry[n:]
+			if num == 1 && typ == protowire.BytesType {
+				ip, n := protowire.ConsumeString(entry)
+				if n < 0 {
+					return nil, protowire.ParseError(n)
+				}
+				ips = append(ips, ip)
+				entry = entry[n:]
+				continue
+			}
+			n = protowire.ConsumeFieldValue(num, typ, entry)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			entry = entry[n:]
+		}
+	}
+	return ips, nil
+}
@@ -2,6 +2,7 @@ package xray
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,12 +10,14 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"syscall"
 	"time"
 
 	"x-ui/config"
 	"x-ui/logger"
 	"x-ui/util/common"
+	"x-ui/util/sys"
 )
 
 func GetBinaryName() string {
@@ -29,6 +32,11 @@ func GetConfigPath() string {
 	return config.GetBinFolderPath() + "/config.json"
 }
 
+// GetInstanceConfigPath is the config file of an extra instance, next to the main one
+func GetInstanceConfigPath(name string) string {
+	return config.GetBinFolderPath() + "/config-" + name + ".json"
+}
+
 func GetGeositePath() string {
 	return config.GetBinFolderPath() + "/geosite.dat"
 }
@@ -57,6 +65,10 @@ func GetAccessPersistentPrevLogPath() string {
 	return config.GetLogFolder() + "/3xipl-ap.prev.log"
 }
 
+func GetInboundAccessLogFolder() string {
+	return config.GetLogFolder() + "/inbounds"
+}
+
 func GetAccessLogPath() (string, error) {
 	config, err := os.ReadFile(GetConfigPath())
 	if err != nil {
@@ -95,6 +107,53 @@ func NewProcess(xrayConfig *Config) *Process {
 	return p
 }
 
+// NewInstanceProcess is a process of an extra instance, it writes its config to its own file
+func NewInstanceProcess(name string, xrayConfig *Config) *Process {
+	p := NewProcess(xrayConfig)
+	p.name = name
+	p.configPath = GetInstanceConfigPath(name)
+	return p
+}
+
+// ResourceLimits caps the CPU and memory of the process, 0 leaves one uncapped
+type ResourceLimits struct {
+	CPU    int `json:"cpu"`    // percent of one core
+	Memory int `json:"memory"` // MB
+}
+
+// environ tells the Go runtime of xray about the caps. It collects garbage harder before the
+// cgroup kills it, and on its own keeps memory near the limit where cgroups are missing.
+func (l ResourceLimits) environ() []string {
+	var env []string
+	if l.Memory > 0 {
+		env = append(env, "GOMEMLIMIT="+strconv.Itoa(l.Memory*9/10)+"MiB")
+	}
+	if l.CPU > 0 {
+		env = append(env, "GOMAXPROCS="+strconv.Itoa((l.CPU+99)/100))
+	}
+	return env
+}
+
+// cgroupName is the name of the cgroup of the main process or of an instance
+func (p *process) cgroupName() string {
+	if p.name == "" {
+		return "xray"
+	}
+	return "xray-" + p.name
+}
+
+// applyLimits puts the running process under its caps, and changes the caps of a running one
+func (p *process) applyLimits(pid int) error {
+	if p.limits.CPU <= 0 && p.limits.Memory <= 0 && !p.limited {
+		return nil
+	}
+	err := sys.ApplyCgroupLimits(p.cgroupName(), pid, p.limits.CPU, int64(p.limits.Memory)<<20)
+	if err == nil {
+		p.limited = true
+	}
+	return err
+}
+
 type process struct {
 	cmd *exec.Cmd
 
@@ -103,7 +162,13 @@ type process struct {
 
 	onlineClients []string
 
-	config    *Config
+	// name of the instance, empty for the main process
+	name       string
+	config     *Config
+	configPath string
+	limits     ResourceLimits
+	// set once the process is in its cgroup, lifting the caps then has to reach the cgroup
+	limited   bool
 	logWriter *LogWriter
 	exitErr   error
 	startTime time.Time
@@ -111,10 +176,11 @@ type process struct {
 
 func newProcess(config *Config) *process {
 	return &process{
-		version:   "Unknown",
-		config:    config,
-		logWriter: NewLogWriter(),
-		startTime: time.Now(),
+		version:    "Unknown",
+		config:     config,
+		configPath: GetConfigPath(),
+		logWriter:  NewLogWriter(),
+		startTime:  time.Now(),
 	}
 }
 
@@ -143,6 +209,14 @@ func (p *process) GetVersion() string {
 	return p.version
 }
 
+// GetPid returns the pid of the xray-core process, 0 before it started
+func (p *process) GetPid() int {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
 func (p *Process) GetAPIPort() int {
 	return p.apiPort
 }
@@ -151,6 +225,11 @@ func (p *Process) GetConfig() *Config {
 	return p.config
 }
 
+// SetConfig records a config the running process was changed to through the API
+func (p *Process) SetConfig(config *Config) {
+	p.config = config
+}
+
 func (p *Process) GetOnlineClients() []string {
 	return p.onlineClients
 }
@@ -159,6 +238,19 @@ func (p *Process) SetOnlineClients(users []string) {
 	p.onlineClients = users
 }
 
+func (p *Process) GetLimits() ResourceLimits {
+	return p.limits
+}
+
+// SetLimits sets the caps the process starts with, a running process gets the cgroup ones at once
+func (p *Process) SetLimits(limits ResourceLimits) error {
+	p.limits = limits
+	if !p.IsRunning() {
+		return nil
+	}
+	return p.applyLimits(p.GetPid())
+}
+
 func (p *Process) GetUptime() uint64 {
 	return uint64(time.Since(p.startTime).Seconds())
 }
@@ -209,20 +301,28 @@ func (p *process) Start() (err error) {
 		logger.Warningf("Failed to create log folder: %s", err)
 	}
 
-	configPath := GetConfigPath()
+	configPath := p.configPath
 	err = os.WriteFile(configPath, data, fs.ModePerm)
 	if err != nil {
 		return common.NewErrorf("Failed to write configuration file: %v", err)
 	}
 
 	cmd := exec.Command(GetBinaryPath(), "-c", configPath)
+	cmd.Env = append(os.Environ(), p.limits.environ()...)
 	p.cmd = cmd
 
 	cmd.Stdout = p.logWriter
 	cmd.Stderr = p.logWriter
 
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	if err := p.applyLimits(cmd.Process.Pid); err != nil {
+		logger.Warning("Failed to put xray under its cgroup limits:", err)
+	}
 	go func() {
-		err := cmd.Run()
+		err := cmd.Wait()
 		if err != nil {
 			logger.Error("Failure in running xray-core:", err)
 			p.exitErr = err
@@ -235,6 +335,37 @@ func (p *process) Start() (err error) {
 	return nil
 }
 
+// TestConfig runs the config through xray -test, as it would be written for Start. The output is
+// what xray printed, err is set when xray rejected the config or could not run.
+func TestConfig(config *Config) (data []byte, output string, err error) {
+	data, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, "", common.NewErrorf("Failed to generate XRAY configuration files: %v", err)
+	}
+	file, err := os.CreateTemp("", "x-ui-config-test-*.json")
+	if err != nil {
+		return data, "", err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return data, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, GetBinaryPath(), "-test", "-c", file.Name())
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return data, buf.String(), common.NewError("xray config test timed out")
+	}
+	return data, buf.String(), err
+}
+
 func (p *process) Stop() error {
 	if !p.IsRunning() {
 		return errors.New("xray is not running")
@@ -1,9 +1,51 @@
 package xray
 
+import "strconv"
+
 type Traffic struct {
 	IsInbound  bool
 	IsOutbound bool
 	Tag        string
+	Protocol   string
 	Up         int64
 	Down       int64
 }
+
+// TagTraffics returns the traffic of every tag once. The plain counter of a tag is used when
+// Xray reports one, otherwise its protocol tagged counters are added up, so a tag with both is
+// not counted twice.
+func TagTraffics(traffics []*Traffic) []*Traffic {
+	type tagTraffic struct {
+		plain    *Traffic
+		protocol *Traffic
+	}
+	byTag := make(map[string]*tagTraffic)
+	var keys []string
+	for _, traffic := range traffics {
+		key := traffic.Tag + ">>>" + strconv.FormatBool(traffic.IsInbound)
+		t, ok := byTag[key]
+		if !ok {
+			t = &tagTraffic{}
+			byTag[key] = t
+			keys = append(keys, key)
+		}
+		sum := &t.plain
+		if traffic.Protocol != "" {
+			sum = &t.protocol
+		}
+		if *sum == nil {
+			*sum = &Traffic{IsInbound: traffic.IsInbound, IsOutbound: traffic.IsOutbound, Tag: traffic.Tag}
+		}
+		(*sum).Up += traffic.Up
+		(*sum).Down += traffic.Down
+	}
+	result := make([]*Traffic, 0, len(keys))
+	for _, key := range keys {
+		if t := byTag[key]; t.plain != nil {
+			result = append(result, t.plain)
+		} else {
+			result = append(result, t.protocol)
+		}
+	}
+	return result
+}
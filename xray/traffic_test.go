@@ -0,0 +1,118 @@
+package xray
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTagTraffics(t *testing.T) {
+	tests := []struct {
+		name     string
+		traffics []*Traffic
+		want     map[string][2]int64
+	}{
+		{
+			name: "plain counter only",
+			traffics: []*Traffic{
+				{IsOutbound: true, Tag: "direct", Up: 10, Down: 20},
+			},
+			want: map[string][2]int64{"direct": {10, 20}},
+		},
+		{
+			name: "protocol counters only are added up",
+			traffics: []*Traffic{
+				{IsOutbound: true, Tag: "warp", Protocol: "tcp", Up: 1, Down: 2},
+				{IsOutbound: true, Tag: "warp", Protocol: "udp", Up: 3, Down: 4},
+			},
+			want: map[string][2]int64{"warp": {4, 6}},
+		},
+		{
+			name: "plain counter wins over protocol counters",
+			traffics: []*Traffic{
+				{IsOutbound: true, Tag: "proxy", Protocol: "tcp", Up: 6, Down: 6},
+				{IsOutbound: true, Tag: "proxy", Up: 10, Down: 10},
+				{IsOutbound: true, Tag: "proxy", Protocol: "udp", Up: 4, Down: 4},
+			},
+			want: map[string][2]int64{"proxy": {10, 10}},
+		},
+		{
+			name: "plain counters of several processes are summed",
+			traffics: []*Traffic{
+				{IsOutbound: true, Tag: "direct", Up: 1, Down: 1},
+				{IsOutbound: true, Tag: "direct", Up: 2, Down: 3},
+			},
+			want: map[string][2]int64{"direct": {3, 4}},
+		},
+		{
+			name: "inbound and outbound with the same tag stay apart",
+			traffics: []*Traffic{
+				{IsInbound: true, Tag: "same", Up: 1, Down: 1},
+				{IsOutbound: true, Tag: "same", Up: 5, Down: 5},
+			},
+			want: map[string][2]int64{"in:same": {1, 1}, "same": {5, 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string][2]int64{}
+			for _, traffic := range TagTraffics(tt.traffics) {
+				if traffic.Protocol != "" {
+					t.Errorf("folded traffic of %s keeps protocol %s", traffic.Tag, traffic.Protocol)
+				}
+				key := traffic.Tag
+				if traffic.IsInbound {
+					key = "in:" + key
+				}
+				if _, ok := got[key]; ok {
+					t.Errorf("tag %s returned twice", key)
+				}
+				got[key] = [2]int64{traffic.Up, traffic.Down}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%s: got %v, want %v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessTraffic(t *testing.T) {
+	trafficMap := map[string]*Traffic{}
+	for _, stat := range []struct {
+		matches []string
+		value   int64
+	}{
+		{[]string{"", "outbound", "proxy", "", "uplink"}, 100},
+		{[]string{"", "outbound", "proxy", "", "downlink"}, 200},
+		{[]string{"", "outbound", "proxy", "udp", "uplink"}, 30},
+		{[]string{"", "outbound", "proxy", "tcp", "downlink"}, 150},
+		{[]string{"", "inbound", "api", "", "uplink"}, 999},
+	} {
+		processTraffic(stat.matches, stat.value, trafficMap)
+	}
+
+	var keys []string
+	for key := range trafficMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	want := []string{"outbound>>>proxy>>>", "outbound>>>proxy>>>tcp", "outbound>>>proxy>>>udp"}
+	if len(keys) != len(want) {
+		t.Fatalf("got keys %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("got keys %v, want %v", keys, want)
+		}
+	}
+	if plain := trafficMap["outbound>>>proxy>>>"]; plain.Up != 100 || plain.Down != 200 || plain.Protocol != "" {
+		t.Errorf("plain counter: %+v", plain)
+	}
+	if udp := trafficMap["outbound>>>proxy>>>udp"]; udp.Up != 30 || udp.Protocol != "udp" || !udp.IsOutbound {
+		t.Errorf("udp counter: %+v", udp)
+	}
+}